@@ -1,34 +1,57 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
+	"os"
 	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/FmTod/ghost-backup/internal/config"
 	"github.com/FmTod/ghost-backup/internal/git"
 	"github.com/FmTod/ghost-backup/internal/security"
+	"github.com/FmTod/ghost-backup/internal/state"
 	"github.com/spf13/cobra"
 )
 
 var (
-	backupPath string
+	backupPath    string
+	backupDryRun  bool
+	backupTrigger string
 )
 
 var backupCmd = &cobra.Command{
 	Use:   "backup",
 	Short: "Create a backup immediately",
 	Long: `Create a backup of the repository right now, without waiting for the scheduled interval.
-This will create a backup if there are uncommitted changes in the repository.`,
+This will create a backup if there are uncommitted changes in the repository.
+
+With --dry-run, only list the files the backup would include, after applying the
+include and exclude globs and .ghostbackupignore, without scanning or pushing.
+
+Git hooks should pass --trigger hook, so their snapshots can be told apart in
+'ghost-backup list' and 'ghost-backup inspect'.`,
 	RunE: runBackup,
 }
 
 func init() {
 	rootCmd.AddCommand(backupCmd)
+	snapshotCommand(backupCmd)
 
 	backupCmd.Flags().StringVarP(&backupPath, "path", "p", ".", "Path to the repository")
+	backupCmd.Flags().BoolVar(&backupDryRun, "dry-run", false, "List the files that would be backed up without pushing")
+	backupCmd.Flags().StringVar(&backupTrigger, "trigger", git.TriggerManual, "What triggered the backup, recorded in the snapshot metadata: manual or hook")
 }
 
-func runBackup(*cobra.Command, []string) error {
+func runBackup(*cobra.Command, []string) (err error) {
+	switch backupTrigger {
+	case git.TriggerManual, git.TriggerHook:
+	default:
+		return fmt.Errorf("invalid --trigger value %q (expected %q or %q)", backupTrigger, git.TriggerManual, git.TriggerHook)
+	}
+
 	// Get an absolute path
 	absPath, err := filepath.Abs(backupPath)
 	if err != nil {
@@ -47,14 +70,52 @@ func runBackup(*cobra.Command, []string) error {
 		return fmt.Errorf("not a git repository: %s", absPath)
 	}
 
+	// Record the outcome so 'ghost-backup status' reflects manual backups too
+	startedAt := time.Now()
+	var pushedHash, pushedRef string
+	var pushes []git.RemotePush
+	noChanges := false
+	defer func() {
+		if backupDryRun {
+			return
+		}
+		_ = state.Update(absPath, func(s *state.RepoState) {
+			for _, push := range pushes {
+				hash := ""
+				if len(push.Pushed) > 0 {
+					hash = push.Pushed[len(push.Pushed)-1].Hash
+				}
+				s.RecordPush(push.Remote, startedAt, hash, push.Err)
+			}
+			switch {
+			case err != nil:
+				s.RecordFailure(startedAt, err)
+			case noChanges:
+				s.RecordNoChanges(startedAt)
+			default:
+				s.RecordSuccess(startedAt, pushedHash, pushedRef)
+			}
+		})
+	}()
+
 	// Load local config
 	localConfig, err := config.LoadLocalConfig(absPath)
 	if err != nil {
 		fmt.Printf("Warning: Failed to load config, using defaults: %v\n", err)
 		localConfig = &config.LocalConfig{
-			Interval:    config.DefaultInterval,
-			ScanSecrets: config.DefaultScanSecrets,
-			OnlyStaged:  config.DefaultOnlyStaged,
+			Interval:         config.DefaultInterval,
+			ScanSecrets:      config.DefaultScanSecrets,
+			OnSecret:         config.DefaultOnSecret,
+			OnlyStaged:       config.DefaultOnlyStaged,
+			IncludeUntracked: config.DefaultIncludeUntracked,
+			Watch:            config.DefaultWatch,
+			WatchDebounce:    config.DefaultWatchDebounce,
+			WatchMinSpacing:  config.DefaultWatchMinSpacing,
+			MaxDiffSize:      config.DefaultMaxDiffSize,
+			OnLargeDiff:      config.DefaultOnLargeDiff,
+			MaxFileSize:      config.DefaultMaxFileSize,
+			MaxSnapshotSize:  config.DefaultMaxSnapshotSize,
+			OnOversize:       config.DefaultOnOversize,
 		}
 	}
 
@@ -66,40 +127,136 @@ func runBackup(*cobra.Command, []string) error {
 
 	if !hasChanges {
 		fmt.Println("✓ No uncommitted changes to backup")
+		noChanges = true
 		return nil
 	}
 
 	fmt.Println("Found uncommitted changes, creating backup...")
 
-	// Create stash
-	hash, err := repo.CreateStash(localConfig.OnlyStaged)
+	// Load global config to get git_user and exclude globs if configured
+	globalConfig, err := config.LoadGlobalConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load global config: %w", err)
+	}
+	filter, err := security.LoadPathFilter(absPath, globalConfig, localConfig)
+	if err != nil {
+		return err
+	}
+
+	// Create stash, including untracked files when configured (only_staged takes precedence)
+	hash, err := repo.CreateSnapshot(localConfig.OnlyStaged, localConfig.IncludeUntracked, filter.Keeps)
+	if errors.Is(err, git.ErrNoChangesLeft) {
+		fmt.Println("✓ Only excluded paths changed, nothing to backup")
+		noChanges = true
+		return nil
+	}
 	if err != nil {
 		return fmt.Errorf("failed to create stash: %w", err)
 	}
 
 	fmt.Printf("✓ Created stash: %s\n", hash)
 
+	// Sensitive and out of scope paths never leave the machine, whatever the scanners say
+	hash, excluded, err := security.ExcludePaths(repo, hash, filter)
+	if errors.Is(err, git.ErrNoChangesLeft) {
+		fmt.Println("✓ Only excluded paths changed, nothing to backup")
+		noChanges = true
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(excluded) > 0 {
+		fmt.Printf("✓ Excluded %d files:\n", len(excluded))
+		for _, f := range excluded {
+			fmt.Printf("  - %s (%s)\n", f.Path, f.Rules[0])
+		}
+		fmt.Printf("✓ Created filtered stash: %s\n", hash)
+	}
+
+	// Oversized files are dropped or abort the backup before anything is scanned or pushed
+	sizeCheck, err := security.CheckSnapshotSize(repo, hash, security.LoadSizeLimits(localConfig))
+	if sizeCheck != nil && sizeCheck.Exceeded {
+		fmt.Printf("⚠ Snapshot is %s, over the size limits; largest files:\n", security.FormatSize(sizeCheck.Size))
+		for _, f := range sizeCheck.Largest {
+			fmt.Printf("  %10s  %s\n", security.FormatSize(f.Size), f.Path)
+		}
+	}
+	if errors.Is(err, git.ErrNoChangesLeft) {
+		fmt.Println("✓ Only oversized files changed, nothing to backup")
+		noChanges = true
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(sizeCheck.Excluded) > 0 {
+		fmt.Printf("⚠ Excluded %d oversized files:\n", len(sizeCheck.Excluded))
+		for _, f := range sizeCheck.Excluded {
+			fmt.Printf("  - %s (%s)\n", f.Path, f.Rules[0])
+		}
+		hash = sizeCheck.Hash
+		fmt.Printf("✓ Created filtered stash: %s\n", hash)
+	}
+
+	if backupDryRun {
+		paths, err := repo.SnapshotPaths(hash)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Dry run, would back up %d files (%s):\n", len(paths), security.FormatSize(sizeCheck.Pushed))
+		for _, path := range paths {
+			fmt.Printf("  %s\n", path)
+		}
+		return nil
+	}
+
 	// If secret scanning is enabled, scan the diff
 	if localConfig.ScanSecrets {
-		if !security.IsGitleaksAvailable() {
-			fmt.Println("⚠ gitleaks not available, skipping secret scan")
-		} else {
-			fmt.Println("Scanning for secrets...")
-			diff, err := repo.GetDiff(hash)
-			if err != nil {
-				return fmt.Errorf("failed to get diff: %w", err)
-			}
+		fmt.Println("Scanning for secrets...")
+		opts, err := security.LoadScanOptions(absPath, localConfig)
+		if err != nil {
+			return err
+		}
+		if opts.GitleaksConfig != "" && security.IsGitleaksAvailable() {
+			fmt.Printf("Using gitleaks config: %s\n", opts.GitleaksConfig)
+		}
+
+		scan, err := security.ScanSnapshot(repo, hash, opts, localConfig.OnSecret == config.OnSecretExclude)
+		if err != nil {
+			return err
+		}
+		result := scan.Result
+		if result.Fallback {
+			fmt.Println("⚠ gitleaks not available, using built-in secret scanner")
+		}
+		if notice := security.LargeDiffNotice(result, opts); notice != "" {
+			fmt.Printf("⚠ %s\n", notice)
+		}
+		if len(result.Allowed) > 0 {
+			fmt.Printf("✓ Ignoring %d findings accepted by %s\n", len(result.Allowed), security.AllowlistFileName)
+		}
 
-			result, err := security.ScanDiff(diff)
-			if err != nil {
-				return fmt.Errorf("secret scan failed: %w", err)
+		if len(scan.Excluded) > 0 {
+			fmt.Printf("⚠ Secrets detected, excluding %d files from the backup:\n", len(scan.Excluded))
+			for _, f := range scan.Excluded {
+				fmt.Printf("  - %s (%s)\n", f.Path, strings.Join(f.Rules, ", "))
 			}
+			fmt.Printf("✓ Created filtered stash: %s\n", scan.Hash)
+			hash = scan.Hash
+		}
 
-			if result.HasSecrets {
-				fmt.Printf("⚠ WARNING: Secrets detected in uncommitted changes!\n")
-				fmt.Printf("Gitleaks output:\n%s\n", result.Output)
+		if result.HasSecrets {
+			fmt.Printf("⚠ WARNING: Secrets detected in uncommitted changes!\n")
+			fmt.Printf("Findings (%s):\n", result.Scanner)
+			printFindings(result)
+			if localConfig.OnSecret != config.OnSecretWarn {
 				return fmt.Errorf("backup aborted due to detected secrets")
 			}
+			fmt.Println("⚠ Pushing anyway (on_secret is set to warn)")
+		} else if len(scan.Excluded) > 0 {
+			fmt.Println("✓ No secrets detected in the remaining changes")
+		} else if result.LargeDiff != config.OnLargeDiffSkip {
 			fmt.Println("✓ No secrets detected")
 		}
 	}
@@ -113,13 +270,6 @@ func runBackup(*cobra.Command, []string) error {
 	// Get user name for identifier generation
 	userName, _ := repo.GetUserName()
 
-	// Load global config to get git_user if configured
-	globalConfig, err := config.LoadGlobalConfig()
-	if err != nil {
-		// Non-fatal, use empty config
-		globalConfig = &config.GlobalConfig{}
-	}
-
 	// Generate user identifier
 	userIdentifier := git.GenerateUserIdentifier(globalConfig.GitUser, userName, userEmail)
 
@@ -128,22 +278,71 @@ func runBackup(*cobra.Command, []string) error {
 		return fmt.Errorf("failed to get current branch: %w", err)
 	}
 
-	// Get remote
-	remote, err := repo.GetRemote()
+	// Empty unless backup refs are namespaced per machine
+	machine, err := globalConfig.Machine()
 	if err != nil {
-		return fmt.Errorf("failed to get remote: %w", err)
+		return err
 	}
 
-	// Push to backup ref
-	fmt.Println("Pushing backup to remote...")
-	err = repo.PushToBackupRef(hash, userIdentifier, branch, remote)
+	// Get the remotes to push to, primary first
+	remotes, err := repo.ResolveBackupRemotes(config.BackupRemotes(globalConfig, localConfig))
+	if err != nil {
+		return err
+	}
+
+	// Record where and why the snapshot was taken, for 'list' and 'inspect'
+	metadata, err := repo.CollectSnapshotMetadata(hash, backupTrigger)
+	if err != nil {
+		return err
+	}
+	hash, err = repo.AddSnapshotMetadata(hash, metadata)
+	if err != nil {
+		return err
+	}
+
+	// Queue the snapshot locally first so a failed push can be retried later
+	queued, err := repo.QueuePendingBackup(hash, userIdentifier, machine, branch, remotes)
 	if err != nil {
+		return fmt.Errorf("failed to queue backup: %w", err)
+	}
+
+	// Push to the backup ref on every remote, together with snapshots queued by earlier failed pushes
+	fmt.Println("Pushing backup to remote...")
+	pushes, err = repo.PushPendingBackups(remotes)
+	earlier := map[string]bool{}
+	for _, push := range pushes {
+		if push.CleanupErr != nil {
+			fmt.Printf("⚠ Pushed to %s but failed to dequeue: %v\n", push.Remote, push.CleanupErr)
+		}
+		if push.Err != nil {
+			fmt.Printf("⚠ Push to %s failed: %v\n", push.Remote, push.Err)
+			continue
+		}
+		if len(remotes) > 1 {
+			fmt.Printf("✓ Pushed to %s\n", push.Remote)
+		}
+		for _, p := range push.Pushed {
+			if p.Hash != hash {
+				earlier[p.Hash] = true
+			}
+		}
+	}
+	if len(git.PushedTo(pushes, hash)) == 0 {
+		fmt.Printf("⚠ Push failed; the snapshot is kept in %s\n", queued[0].Ref)
+		fmt.Println("  It will be pushed automatically by the service on its next run")
 		return fmt.Errorf("failed to push backup: %w", err)
 	}
+	if err != nil {
+		fmt.Println("⚠ The snapshot stays queued for the failed remotes and will be pushed by the service on its next run")
+	}
+	if len(earlier) > 0 {
+		fmt.Printf("✓ Pushed %d snapshots queued by earlier failed pushes\n", len(earlier))
+	}
+	pushedHash, pushedRef = hash, queued[0].BackupRef()
 
 	fmt.Printf("✓ Backup completed successfully!\n")
 	fmt.Printf("  Hash: %s\n", hash)
-	fmt.Printf("  Ref: refs/backups/%s/%s\n", userIdentifier, branch)
+	fmt.Printf("  Ref: %s\n", pushedRef)
 
 	// Show how to restore
 	fmt.Printf("\nTo restore this backup:\n")
@@ -151,3 +350,21 @@ func runBackup(*cobra.Command, []string) error {
 
 	return nil
 }
+
+// printFindings prints secret scan findings as a table
+func printFindings(result *security.ScanResult) {
+	// Scanners that reported secrets without details
+	for _, note := range result.Notes {
+		fmt.Println("  " + note)
+	}
+	if len(result.Findings) == 0 {
+		return
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "  FILE\tLINE\tRULE\tMATCH\tFINGERPRINT")
+	for _, f := range result.Findings {
+		_, _ = fmt.Fprintf(tw, "  %s\t%d\t%s\t%s\t%s\n", f.File, f.Line, f.RuleID, f.Match, f.Fingerprint)
+	}
+	_ = tw.Flush()
+}
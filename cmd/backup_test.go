@@ -1,7 +1,14 @@
 package cmd
 
 import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
+
+	"github.com/FmTod/ghost-backup/internal/git"
+	"github.com/FmTod/ghost-backup/internal/state"
 )
 
 func TestBackupCmd_Configuration(t *testing.T) {
@@ -36,3 +43,75 @@ func TestBackupCmd_PathFlag(t *testing.T) {
 		t.Errorf("path flag default = %s, want .", flag.DefValue)
 	}
 }
+
+func TestRunBackup_DryRun(t *testing.T) {
+	t.Setenv("STATE_DIRECTORY", t.TempDir())
+
+	repoDir := t.TempDir()
+	for _, args := range [][]string{
+		{"init"},
+		{"config", "user.name", "Test User"},
+		{"config", "user.email", "test@example.com"},
+		{"commit", "--allow-empty", "-m", "initial"},
+		{"remote", "add", "origin", filepath.Join(t.TempDir(), "missing.git")},
+	} {
+		if out, err := exec.Command("git", append([]string{"-C", repoDir}, args...)...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %v\n%s", args, err, out)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(repoDir, "notes.txt"), []byte("notes\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := exec.Command("git", "-C", repoDir, "add", "notes.txt").Run(); err != nil {
+		t.Fatalf("Failed to stage file: %v", err)
+	}
+
+	backupPath, backupDryRun = repoDir, true
+	defer func() { backupPath, backupDryRun = ".", false }()
+
+	if err := runBackup(nil, nil); err != nil {
+		t.Fatalf("runBackup() error = %v", err)
+	}
+
+	// Nothing is queued, pushed or recorded
+	refs, err := exec.Command("git", "-C", repoDir, "for-each-ref", "refs/ghost-backup/").Output()
+	if err != nil {
+		t.Fatalf("Failed to list refs: %v", err)
+	}
+	if len(refs) > 0 {
+		t.Errorf("dry run queued snapshots:\n%s", refs)
+	}
+	s, err := state.Load(repoDir)
+	if err != nil {
+		t.Fatalf("state.Load() error = %v", err)
+	}
+	if !s.LastRun.IsZero() {
+		t.Errorf("dry run recorded state %+v", s)
+	}
+}
+
+func TestBackupCmd_DryRunFlag(t *testing.T) {
+	flag := backupCmd.Flags().Lookup("dry-run")
+	if flag == nil {
+		t.Fatal("dry-run flag not found")
+	}
+	if flag.DefValue != "false" {
+		t.Errorf("dry-run flag default = %s, want false", flag.DefValue)
+	}
+}
+
+func TestBackupCmd_TriggerFlag(t *testing.T) {
+	flag := backupCmd.Flags().Lookup("trigger")
+	if flag == nil {
+		t.Fatal("trigger flag not found")
+	}
+	if flag.DefValue != git.TriggerManual {
+		t.Errorf("trigger flag default = %s, want %s", flag.DefValue, git.TriggerManual)
+	}
+
+	backupTrigger = "cron"
+	defer func() { backupTrigger = git.TriggerManual }()
+	if err := runBackup(nil, nil); err == nil || !strings.Contains(err.Error(), "invalid --trigger") {
+		t.Errorf("runBackup() error = %v, want an invalid --trigger error", err)
+	}
+}
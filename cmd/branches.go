@@ -11,7 +11,8 @@ import (
 )
 
 var (
-	branchesUser string
+	branchesUser    string
+	branchesMachine string
 )
 
 var branchesCmd = &cobra.Command{
@@ -24,10 +25,13 @@ Must be run from within a git repository.`,
 
 func init() {
 	rootCmd.AddCommand(branchesCmd)
+	snapshotCommand(branchesCmd)
 
 	// Hidden flag to view branches for a specific user
 	branchesCmd.Flags().StringVar(&branchesUser, "user", "", "List branches for a specific user (hidden)")
 	branchesCmd.Flags().MarkHidden("user")
+
+	branchesCmd.Flags().StringVar(&branchesMachine, "machine", "", "Only list branches with backups from this machine")
 }
 
 func runBranches(*cobra.Command, []string) error {
@@ -50,9 +54,9 @@ func runBranches(*cobra.Command, []string) error {
 	}
 
 	// Get remote
-	remote, err := repo.GetRemote()
+	remote, err := readRemote(repo, "")
 	if err != nil {
-		return fmt.Errorf("failed to get remote: %w", err)
+		return err
 	}
 
 	var branches []string
@@ -60,9 +64,9 @@ func runBranches(*cobra.Command, []string) error {
 
 	// If --user flag is provided, list branches for that specific user
 	if branchesUser != "" {
-		userIdentifier = git.SanitizeRefName(branchesUser)
+		userIdentifier = branchesUser
 		fmt.Printf("Fetching branches for user %s...\n\n", userIdentifier)
-		branches, err = repo.ListBackupBranchesForUser(remote, userIdentifier)
+		branches, err = repo.ListBackupBranchesForUser(remote, userIdentifier, branchesMachine)
 		if err != nil {
 			return fmt.Errorf("failed to list branches for user: %w", err)
 		}
@@ -87,7 +91,7 @@ func runBranches(*cobra.Command, []string) error {
 		userIdentifier = git.GenerateUserIdentifier(globalConfig.GitUser, userName, userEmail)
 
 		fmt.Printf("Fetching branches for %s...\n\n", userIdentifier)
-		branches, err = repo.ListBackupBranchesForUser(remote, userIdentifier)
+		branches, err = repo.ListBackupBranchesForUser(remote, userIdentifier, branchesMachine)
 		if err != nil {
 			return fmt.Errorf("failed to list branches: %w", err)
 		}
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
 
@@ -105,8 +106,27 @@ func runCheck(cmd *cobra.Command, args []string) error {
 		} else {
 			fmt.Printf("   [PASS] Configuration is valid\n")
 			fmt.Printf("     - Interval: %d minutes\n", cfg.Interval)
-			fmt.Printf("     - Scan secrets: %v\n", cfg.ScanSecrets)
+			fmt.Printf("     - Scan secrets: %v (on secret: %s)\n", cfg.ScanSecrets, cfg.OnSecret)
+			for _, scannerCfg := range cfg.Scanners {
+				scanner, err := security.NewScanner(scannerCfg, security.ScanOptions{})
+				if err != nil {
+					continue // Already rejected by LoadLocalConfig
+				}
+				fmt.Printf("     - Scanner: %s (%s, timeout %ds)\n", scanner.Name(), scannerCfg.Type, scannerTimeout(scannerCfg))
+				if scannerCfg.Type == config.ScannerCommand {
+					if _, err := exec.LookPath(scannerCfg.Command[0]); err != nil {
+						fmt.Printf("   [WARN] Scanner command not found: %s\n", scannerCfg.Command[0])
+						warnings = append(warnings, fmt.Sprintf("Scanner command not found: %s", scannerCfg.Command[0]))
+					}
+				}
+			}
 			fmt.Printf("     - Only staged: %v\n", cfg.OnlyStaged)
+			fmt.Printf("     - Include untracked: %v\n", cfg.IncludeUntracked)
+			if cfg.Watch {
+				fmt.Printf("     - Watch: enabled (debounce %ds, min spacing %ds)\n", cfg.WatchDebounce, cfg.WatchMinSpacing)
+			} else {
+				fmt.Printf("     - Watch: disabled\n")
+			}
 		}
 	}
 
@@ -159,13 +179,16 @@ func runCheck(cmd *cobra.Command, args []string) error {
 		}
 
 		// Check remote
-		remote, err := repo.GetRemote()
+		remotes, err := backupRemotes(repo)
 		if err != nil {
 			fmt.Printf("   [FAIL] No remote configured: %v\n", err)
-			fmt.Printf("   [INFO] Run 'git remote add origin <url>'\n")
+			fmt.Printf("   [INFO] Run 'git remote add origin <url>' or set backup_remote\n")
 			hasErrors = true
 		} else {
-			fmt.Printf("   [PASS] Remote configured: %s\n", remote)
+			fmt.Printf("   [PASS] Remote configured: %s\n", remotes[0])
+			for _, remote := range remotes[1:] {
+				fmt.Printf("   [PASS] Additional backup remote: %s\n", remote)
+			}
 		}
 
 		// Check current branch
@@ -191,15 +214,35 @@ func runCheck(cmd *cobra.Command, args []string) error {
 		if globalConfig.GitUser != "" {
 			fmt.Printf("     Source: global config (git_user)\n")
 		} else if userName != "" {
-			fmt.Printf("     Source: git username (sanitized from: %s)\n", userName)
+			fmt.Printf("     Source: git username\n")
 		} else {
-			fmt.Printf("     Source: sanitized email\n")
+			fmt.Printf("     Source: email\n")
 			fmt.Printf("   [TIP] Set a custom identifier for better team visibility:\n")
 			fmt.Printf("         ghost-backup config set-token --username yourname\n")
 		}
 
-		if branch != "" {
-			fmt.Printf("   [INFO] Backup ref: refs/backups/%s/%s\n", userIdentifier, branch)
+		machine, err := globalConfig.Machine()
+		if err != nil {
+			fmt.Printf("   [WARN] %v\n", err)
+			warnings = append(warnings, "Could not determine machine name for per-machine refs")
+		} else if machine != "" {
+			fmt.Printf("   [INFO] Machine: %s\n", machine)
+		}
+
+		if branch != "" && err == nil {
+			fmt.Printf("   [INFO] Backup ref: %s\n", git.BackupRefName(userIdentifier, machine, branch))
+		}
+
+		if opts := globalConfig.EncryptionOptions(); opts.Enabled() {
+			if err := opts.Check(); err != nil {
+				fmt.Printf("   [FAIL] Encryption: %v\n", err)
+				hasErrors = true
+			} else {
+				fmt.Printf("   [PASS] Snapshots are encrypted (%s)\n", opts.Scheme())
+			}
+			if !opts.CanDecrypt() {
+				fmt.Printf("   [INFO] No identity_file or passphrase: snapshots taken on other machines can't be restored here\n")
+			}
 		}
 	}
 
@@ -227,8 +270,8 @@ func runCheck(cmd *cobra.Command, args []string) error {
 	} else {
 		fmt.Printf("   [WARN] gitleaks not found in PATH\n")
 		fmt.Printf("   [INFO] Install from: https://github.com/gitleaks/gitleaks\n")
-		fmt.Printf("   Note: Secret scanning will be disabled without gitleaks\n")
-		warnings = append(warnings, "gitleaks not available for secret scanning")
+		fmt.Printf("   Note: The built-in secret scanner is used without gitleaks (smaller ruleset)\n")
+		warnings = append(warnings, "gitleaks not available, using built-in secret scanner")
 	}
 
 	// Summary
@@ -285,3 +328,11 @@ func getServiceStatusHelper() (int, error) {
 	}
 	return int(status), nil
 }
+
+// scannerTimeout returns the effective timeout of a scanner in seconds
+func scannerTimeout(cfg config.ScannerConfig) int {
+	if cfg.Timeout > 0 {
+		return cfg.Timeout
+	}
+	return config.DefaultScannerTimeout
+}
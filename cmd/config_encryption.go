@@ -0,0 +1,261 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/FmTod/ghost-backup/internal/config"
+	"github.com/FmTod/ghost-backup/internal/encryption"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+var generateKeyCmd = &cobra.Command{
+	Use:   "generate-key",
+	Short: "Generate a key pair and encrypt snapshots with it",
+	Long: `Generate an age X25519 key pair and turn on client-side encryption of snapshots.
+
+The private key is written to ~/.config/ghost-backup/identity.txt (or --output) with restricted
+permissions and set as identity_file in the global config. Keep a copy of it somewhere safe:
+snapshots can't be restored without it. Share the printed public key with anyone who should be
+able to restore your snapshots, so they can add it with add-recipient.
+
+Example:
+  ghost-backup config generate-key
+  ghost-backup config generate-key --output /secure/ghost-backup.key`,
+	RunE: runGenerateKey,
+}
+
+var addRecipientCmd = &cobra.Command{
+	Use:   "add-recipient <public key>",
+	Short: "Also encrypt snapshots to another public key",
+	Long: `Add an age public key (age1...) snapshots are encrypted to, e.g. a teammate's or an offline
+recovery key. Whoever holds the matching private key can restore your snapshots.
+
+Example:
+  ghost-backup config add-recipient age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p`,
+	Args: cobra.ExactArgs(1),
+	RunE: runAddRecipient,
+}
+
+var setPassphraseCmd = &cobra.Command{
+	Use:   "set-passphrase",
+	Short: "Encrypt snapshots with a key derived from a passphrase",
+	Long: `Turn on client-side encryption of snapshots with a key derived from a passphrase (age scrypt),
+instead of a key pair. Every machine restoring the snapshots needs the same passphrase.
+
+The passphrase is prompted for with hidden input, or read from the first line of stdin when it
+isn't a terminal, and stored in ~/.config/ghost-backup/config.json with restricted permissions.
+
+Example:
+  ghost-backup config set-passphrase
+  pass show ghost-backup | ghost-backup config set-passphrase`,
+	RunE: runSetPassphrase,
+}
+
+var getEncryptionCmd = &cobra.Command{
+	Use:   "get-encryption",
+	Short: "Display how snapshots are encrypted",
+	Long:  `Display the encryption scheme, recipients and identity file of snapshots (passphrase masked).`,
+	RunE:  runGetEncryption,
+}
+
+var keyOutput string
+
+func init() {
+	configCmd.AddCommand(generateKeyCmd)
+	configCmd.AddCommand(addRecipientCmd)
+	configCmd.AddCommand(setPassphraseCmd)
+	configCmd.AddCommand(getEncryptionCmd)
+
+	generateKeyCmd.Flags().StringVarP(&keyOutput, "output", "o", "", "Path of the private key file (default ~/.config/ghost-backup/identity.txt)")
+}
+
+// loadEncryptionConfig loads the global config with its encryption section, refusing to mix a
+// passphrase with keys, which age doesn't support
+func loadEncryptionConfig(withPassphrase bool) (*config.GlobalConfig, error) {
+	globalConfig, err := config.LoadGlobalConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load global config: %w", err)
+	}
+	if globalConfig.Encryption == nil {
+		globalConfig.Encryption = &config.EncryptionConfig{}
+	}
+
+	e := globalConfig.Encryption
+	if withPassphrase && (len(e.Recipients) > 0 || e.IdentityFile != "") {
+		return nil, fmt.Errorf("snapshots are encrypted with keys; remove recipients and identity_file from the global config to use a passphrase")
+	}
+	if !withPassphrase && e.Passphrase != "" {
+		return nil, fmt.Errorf("snapshots are encrypted with a passphrase; remove it from the global config to use keys")
+	}
+	return globalConfig, nil
+}
+
+func runGenerateKey(cmd *cobra.Command, args []string) error {
+	globalConfig, err := loadEncryptionConfig(false)
+	if err != nil {
+		return err
+	}
+	if globalConfig.Encryption.IdentityFile != "" {
+		return fmt.Errorf("an identity file is already configured: %s", globalConfig.Encryption.IdentityFile)
+	}
+
+	path := keyOutput
+	if path == "" {
+		if path, err = config.GetIdentityPath(); err != nil {
+			return err
+		}
+	}
+
+	recipient, err := encryption.GenerateIdentity(path)
+	if err != nil {
+		return err
+	}
+
+	globalConfig.Encryption.IdentityFile = path
+	if err := config.SaveGlobalConfig(globalConfig); err != nil {
+		return fmt.Errorf("failed to save global config: %w", err)
+	}
+
+	fmt.Printf("✓ Private key written to %s\n", path)
+	fmt.Printf("  Public key: %s\n", recipient)
+	fmt.Println("✓ Snapshots will be encrypted before being stored")
+	fmt.Println()
+	fmt.Println("Keep a copy of the private key somewhere safe: snapshots can't be restored without it.")
+	fmt.Println()
+	fmt.Println("Note: Restart the service for changes to take effect:")
+	fmt.Println("  ghost-backup service restart")
+
+	return nil
+}
+
+func runAddRecipient(cmd *cobra.Command, args []string) error {
+	recipient := strings.TrimSpace(args[0])
+	if err := (encryption.Options{Recipients: []string{recipient}}).Validate(); err != nil {
+		return err
+	}
+
+	globalConfig, err := loadEncryptionConfig(false)
+	if err != nil {
+		return err
+	}
+	if slices.Contains(globalConfig.Encryption.Recipients, recipient) {
+		fmt.Printf("%s is already a recipient\n", recipient)
+		return nil
+	}
+
+	globalConfig.Encryption.Recipients = append(globalConfig.Encryption.Recipients, recipient)
+	if err := config.SaveGlobalConfig(globalConfig); err != nil {
+		return fmt.Errorf("failed to save global config: %w", err)
+	}
+
+	fmt.Printf("✓ Snapshots will also be encrypted to %s\n", recipient)
+	if globalConfig.Encryption.IdentityFile == "" {
+		fmt.Println()
+		fmt.Println("Note: Without an identity file, this machine can't decrypt snapshots taken elsewhere.")
+		fmt.Println("  Run 'ghost-backup config generate-key' to create one")
+	}
+	fmt.Println()
+	fmt.Println("Note: Restart the service for changes to take effect:")
+	fmt.Println("  ghost-backup service restart")
+
+	return nil
+}
+
+func runSetPassphrase(cmd *cobra.Command, args []string) error {
+	globalConfig, err := loadEncryptionConfig(true)
+	if err != nil {
+		return err
+	}
+
+	// Never taken as an argument, which would leave it in the shell history and ps output
+	value, err := readPassphrase()
+	if err != nil {
+		return err
+	}
+
+	if strings.TrimSpace(value) == "" {
+		return fmt.Errorf("passphrase cannot be empty")
+	}
+
+	globalConfig.Encryption.Passphrase = value
+	if err := config.SaveGlobalConfig(globalConfig); err != nil {
+		return fmt.Errorf("failed to save global config: %w", err)
+	}
+
+	fmt.Println("✓ Snapshots will be encrypted with the passphrase before being stored")
+	fmt.Println()
+	fmt.Println("Note: Restart the service for changes to take effect:")
+	fmt.Println("  ghost-backup service restart")
+
+	return nil
+}
+
+// readPassphrase prompts for the passphrase twice with hidden input, or reads the first line of
+// stdin when it isn't a terminal
+func readPassphrase() (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return "", fmt.Errorf("failed to read passphrase: %w", err)
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+
+	fmt.Print("Enter passphrase: ")
+	first, err := term.ReadPassword(fd)
+	fmt.Println() // New line after hidden input
+	if err != nil {
+		return "", fmt.Errorf("failed to read passphrase: %w", err)
+	}
+	fmt.Print("Confirm passphrase: ")
+	second, err := term.ReadPassword(fd)
+	fmt.Println()
+	if err != nil {
+		return "", fmt.Errorf("failed to read passphrase: %w", err)
+	}
+	if string(first) != string(second) {
+		return "", fmt.Errorf("passphrases don't match")
+	}
+	return string(first), nil
+}
+
+func runGetEncryption(cmd *cobra.Command, args []string) error {
+	globalConfig, err := config.LoadGlobalConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load global config: %w", err)
+	}
+
+	opts := globalConfig.EncryptionOptions()
+	if !opts.Enabled() {
+		fmt.Println("Snapshots are not encrypted")
+		fmt.Println()
+		fmt.Println("To encrypt them, run:")
+		fmt.Println("  ghost-backup config generate-key")
+		fmt.Println("  ghost-backup config set-passphrase")
+		return nil
+	}
+
+	fmt.Printf("Encryption: %s\n", opts.Scheme())
+	if opts.Passphrase != "" {
+		fmt.Printf("Passphrase: %s\n", strings.Repeat("*", 8))
+	}
+	if opts.IdentityFile != "" {
+		fmt.Printf("Identity file: %s\n", opts.IdentityFile)
+	}
+	for _, recipient := range opts.Recipients {
+		fmt.Printf("Recipient: %s\n", recipient)
+	}
+	if err := opts.Check(); err != nil {
+		fmt.Printf("⚠ %v\n", err)
+	}
+
+	return nil
+}
@@ -213,3 +213,34 @@ func TestMaskToken(t *testing.T) {
 		})
 	}
 }
+
+func TestSetPassphraseCmd(t *testing.T) {
+	// The passphrase would end up in the shell history and ps output
+	if setPassphraseCmd.Flags().Lookup("passphrase") != nil {
+		t.Error("set-passphrase should not take the passphrase as a flag")
+	}
+
+	t.Setenv("HOME", t.TempDir())
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Failed to create pipe: %v", err)
+	}
+	originalStdin := os.Stdin
+	os.Stdin = r
+	defer func() { os.Stdin = originalStdin }()
+	if _, err := w.WriteString("correct horse\n"); err != nil {
+		t.Fatalf("Failed to write passphrase: %v", err)
+	}
+	_ = w.Close()
+
+	if err := runSetPassphrase(setPassphraseCmd, nil); err != nil {
+		t.Fatalf("runSetPassphrase() error = %v", err)
+	}
+	globalConfig, err := config.LoadGlobalConfig()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if globalConfig.Encryption == nil || globalConfig.Encryption.Passphrase != "correct horse" {
+		t.Errorf("Encryption = %+v, want the passphrase read from stdin", globalConfig.Encryption)
+	}
+}
@@ -17,7 +17,10 @@ var (
 	initPath        string
 	initInterval    int
 	initScanSecrets bool
+	initOnSecret    string
 	initOnlyStaged  bool
+	initUntracked   bool
+	initWatch       bool
 )
 
 var initCmd = &cobra.Command{
@@ -35,8 +38,11 @@ func init() {
 
 	initCmd.Flags().StringVarP(&initPath, "path", "p", ".", "Path to the repository")
 	initCmd.Flags().IntVarP(&initInterval, "interval", "i", config.DefaultInterval, "Backup interval in minutes")
-	initCmd.Flags().BoolVarP(&initScanSecrets, "scan-secrets", "s", config.DefaultScanSecrets, "Enable secret scanning with gitleaks")
+	initCmd.Flags().BoolVarP(&initScanSecrets, "scan-secrets", "s", config.DefaultScanSecrets, "Enable secret scanning (gitleaks, or the built-in scanner)")
+	initCmd.Flags().StringVar(&initOnSecret, "on-secret", config.DefaultOnSecret, "Action when secrets are detected: block, warn or exclude")
 	initCmd.Flags().BoolVarP(&initOnlyStaged, "only-staged", "o", config.DefaultOnlyStaged, "Backup only staged changes (exclude unstaged)")
+	initCmd.Flags().BoolVarP(&initUntracked, "include-untracked", "u", config.DefaultIncludeUntracked, "Include untracked files that are not ignored")
+	initCmd.Flags().BoolVarP(&initWatch, "watch", "w", config.DefaultWatch, "Also back up shortly after file changes settle")
 }
 
 func runInit(*cobra.Command, []string) error {
@@ -56,13 +62,29 @@ func runInit(*cobra.Command, []string) error {
 		return fmt.Errorf("not a git repository: %s", absPath)
 	}
 
+	switch initOnSecret {
+	case config.OnSecretBlock, config.OnSecretWarn, config.OnSecretExclude:
+	default:
+		return fmt.Errorf("invalid --on-secret value %q (expected %q, %q or %q)", initOnSecret, config.OnSecretBlock, config.OnSecretWarn, config.OnSecretExclude)
+	}
+
 	fmt.Printf("Initializing ghost-backup for repository: %s\n", absPath)
 
 	// Create/update local config
 	localConfig := &config.LocalConfig{
-		Interval:    initInterval,
-		ScanSecrets: initScanSecrets,
-		OnlyStaged:  initOnlyStaged,
+		Interval:         initInterval,
+		ScanSecrets:      initScanSecrets,
+		OnSecret:         initOnSecret,
+		OnlyStaged:       initOnlyStaged,
+		IncludeUntracked: initUntracked,
+		Watch:            initWatch,
+		WatchDebounce:    config.DefaultWatchDebounce,
+		WatchMinSpacing:  config.DefaultWatchMinSpacing,
+		MaxDiffSize:      config.DefaultMaxDiffSize,
+		OnLargeDiff:      config.DefaultOnLargeDiff,
+		MaxFileSize:      config.DefaultMaxFileSize,
+		MaxSnapshotSize:  config.DefaultMaxSnapshotSize,
+		OnOversize:       config.DefaultOnOversize,
 	}
 
 	if err := config.SaveLocalConfig(absPath, localConfig); err != nil {
@@ -71,8 +93,10 @@ func runInit(*cobra.Command, []string) error {
 
 	fmt.Printf("✓ Created local config: .ghost-backup.json\n")
 	fmt.Printf("  - Interval: %d minutes\n", initInterval)
-	fmt.Printf("  - Scan secrets: %v\n", initScanSecrets)
+	fmt.Printf("  - Scan secrets: %v (on secret: %s)\n", initScanSecrets, initOnSecret)
 	fmt.Printf("  - Only staged: %v\n", initOnlyStaged)
+	fmt.Printf("  - Include untracked: %v\n", initUntracked)
+	fmt.Printf("  - Watch for changes: %v\n", initWatch)
 
 	// Load registry
 	registry, err := config.LoadRegistry()
@@ -127,14 +151,14 @@ func runInit(*cobra.Command, []string) error {
 
 	fmt.Printf("✓ Service is running\n")
 
-	// Restart service to reload configuration
-	fmt.Printf("Restarting service to reload configuration...\n")
-	if err := service.RestartService(); err != nil {
+	// Reload the registry in the running service (restarts it if the control socket is unavailable)
+	fmt.Printf("Reloading service configuration...\n")
+	if err := reloadService(); err != nil {
 		// Non-fatal error, service might pick up changes anyway
-		fmt.Printf("Warning: Failed to restart service: %v\n", err)
+		fmt.Printf("Warning: Failed to reload service: %v\n", err)
 		fmt.Printf("You may need to restart the service manually.\n")
 	} else {
-		fmt.Printf("✓ Service restarted\n")
+		fmt.Printf("✓ Service reloaded\n")
 	}
 
 	fmt.Printf("\n✓ Initialization complete!\n")
@@ -41,4 +41,18 @@ func TestInitCmd_Flags(t *testing.T) {
 	if scanSecretsFlag == nil {
 		t.Error("scan-secrets flag not registered")
 	}
+
+	untrackedFlag := initCmd.Flags().Lookup("include-untracked")
+	if untrackedFlag == nil {
+		t.Error("include-untracked flag not registered")
+	} else if untrackedFlag.DefValue != "false" {
+		t.Errorf("include-untracked flag default = %s, want false", untrackedFlag.DefValue)
+	}
+
+	onSecretFlag := initCmd.Flags().Lookup("on-secret")
+	if onSecretFlag == nil {
+		t.Error("on-secret flag not registered")
+	} else if onSecretFlag.DefValue != "block" {
+		t.Errorf("on-secret flag default = %s, want block", onSecretFlag.DefValue)
+	}
 }
@@ -12,6 +12,8 @@ import (
 var (
 	inspectShowDiff bool
 	inspectUser     string
+	inspectMachine  string
+	inspectRemote   string
 )
 
 var inspectCmd = &cobra.Command{
@@ -26,8 +28,11 @@ Must be run from within a git repository.`,
 
 func init() {
 	rootCmd.AddCommand(inspectCmd)
+	snapshotCommand(inspectCmd)
 
 	inspectCmd.Flags().BoolVarP(&inspectShowDiff, "diff", "d", false, "Show the full diff")
+	inspectCmd.Flags().StringVar(&inspectMachine, "machine", "", "Only search the backups of this machine")
+	inspectCmd.Flags().StringVar(&inspectRemote, "remote", "", "Backup remote, URL, bundle:// directory or s3:// bucket to read the backup from")
 
 	// Hidden flag to view backups for a specific user
 	inspectCmd.Flags().StringVar(&inspectUser, "user", "", "Inspect backup for a specific user (hidden)")
@@ -57,9 +62,9 @@ func runInspect(_ *cobra.Command, args []string) error {
 
 	var userIdentifier string
 
-	// If --user flag is provided, use it directly (sanitized for ref safety)
+	// If --user flag is provided, use it directly
 	if inspectUser != "" {
-		userIdentifier = git.SanitizeRefName(inspectUser)
+		userIdentifier = inspectUser
 	} else {
 		// Get user email and branch for fetching
 		userEmail, err := repo.GetUserEmail()
@@ -87,20 +92,16 @@ func runInspect(_ *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to get current branch: %w", err)
 	}
 
-	remote, err := repo.GetRemote()
+	remote, err := readRemote(repo, inspectRemote)
 	if err != nil {
-		return fmt.Errorf("failed to get remote: %w", err)
+		return err
 	}
 
 	// Check if the object exists locally, fetch if not
 	if !repo.ObjectExists(hash) {
-		// Fetch the backup ref to ensure we have the object
-		// Branch name is not sanitized to preserve slashes in branch paths
-		refName := fmt.Sprintf("refs/backups/%s/%s", git.SanitizeRefName(userIdentifier), branch)
-		fmt.Printf("Fetching backup from %s...\n", refName)
-
-		if err := repo.FetchBackupRef(remote, refName); err != nil {
-			return fmt.Errorf("failed to fetch backup ref: %w", err)
+		// Fetch the backup refs to ensure we have the object
+		if err := fetchBackupRefs(repo, remote, userIdentifier, inspectMachine, branch, hash); err != nil {
+			return err
 		}
 	}
 
@@ -112,6 +113,16 @@ func runInspect(_ *cobra.Command, args []string) error {
 	}
 	fmt.Print(commitInfo)
 
+	// Metadata recorded when the snapshot was taken; older snapshots have none
+	metadata, err := repo.GetSnapshotMetadata(hash)
+	if err != nil {
+		return fmt.Errorf("failed to get snapshot metadata: %w", err)
+	}
+	if !metadata.IsZero() {
+		fmt.Printf("\n=== Snapshot Metadata ===\n\n")
+		printSnapshotMetadata("", metadata)
+	}
+
 	// Get files changed
 	fmt.Printf("\n=== Files Changed ===\n\n")
 	filesChanged, err := repo.GetFilesChanged(hash)
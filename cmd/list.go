@@ -3,6 +3,7 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/FmTod/ghost-backup/internal/config"
 	"github.com/FmTod/ghost-backup/internal/git"
@@ -10,9 +11,12 @@ import (
 )
 
 var (
-	listUser   string
-	listBranch string
-	listAll    bool
+	listUser    string
+	listBranch  string
+	listMachine string
+	listRemote  string
+	listAll     bool
+	listLimit   int
 )
 
 // truncateHash safely truncates a git hash to a specified length
@@ -27,12 +31,16 @@ var listCmd = &cobra.Command{
 	Use:   "list",
 	Short: "List available backups for the current repository",
 	Long: `List all available backup snapshots for the current repository.
-Must be run from within a git repository.`,
+Must be run from within a git repository.
+
+With per-machine backup refs, the backups of every machine are listed unless
+--machine is given.`,
 	RunE: runList,
 }
 
 func init() {
 	rootCmd.AddCommand(listCmd)
+	snapshotCommand(listCmd)
 
 	// Hidden flag to view backups for a specific user
 	listCmd.Flags().StringVar(&listUser, "user", "", "List backups for a specific user (hidden)")
@@ -41,9 +49,18 @@ func init() {
 	// Visible flag to specify branch
 	listCmd.Flags().StringVar(&listBranch, "branch", "", "List backups for a specific branch")
 
+	// Only list the backups of one machine when refs are namespaced per machine
+	listCmd.Flags().StringVar(&listMachine, "machine", "", "List backups of a specific machine")
+
+	// Read from a specific backup remote or bundle directory instead of the configured ones
+	listCmd.Flags().StringVar(&listRemote, "remote", "", "Backup remote, URL, bundle:// directory or s3:// bucket to list backups from")
+
 	// Hidden flag to list all backups for all users and branches
 	listCmd.Flags().BoolVar(&listAll, "all", false, "List all backups for all users and branches (hidden)")
 	listCmd.Flags().MarkHidden("all")
+
+	// Number of history entries to show per backup ref
+	listCmd.Flags().IntVarP(&listLimit, "limit", "n", 20, "Maximum number of snapshots to show per branch (0 for all)")
 }
 
 func runList(*cobra.Command, []string) error {
@@ -66,9 +83,15 @@ func runList(*cobra.Command, []string) error {
 	}
 
 	// Get remote
-	remote, err := repo.GetRemote()
+	remote, err := readRemote(repo, listRemote)
 	if err != nil {
-		return fmt.Errorf("failed to get remote: %w", err)
+		return err
+	}
+
+	// Backups written by older versions are not listed until they are migrated
+	if legacy, err := repo.ListLegacyBackupRefs(remote); err == nil && len(legacy) > 0 {
+		fmt.Printf("⚠ %d backup refs use the legacy ref layout and are not listed\n", len(legacy))
+		fmt.Printf("  Run 'ghost-backup migrate-refs' to move them\n\n")
 	}
 
 	// If --all flag is provided, list all backups for all users and branches
@@ -80,6 +103,7 @@ func runList(*cobra.Command, []string) error {
 		if err != nil {
 			return fmt.Errorf("failed to list all backups: %w", err)
 		}
+		refs = git.FilterBackupRefsByMachine(refs, listMachine)
 
 		if len(refs) == 0 {
 			fmt.Printf("No backups found.\n")
@@ -101,9 +125,9 @@ func runList(*cobra.Command, []string) error {
 
 	var userIdentifier string
 
-	// If --user flag is provided, use it directly (sanitized for ref safety)
+	// If --user flag is provided, use it directly
 	if listUser != "" {
-		userIdentifier = git.SanitizeRefName(listUser)
+		userIdentifier = listUser
 	} else {
 		// Get user email
 		userEmail, err := repo.GetUserEmail()
@@ -141,7 +165,7 @@ func runList(*cobra.Command, []string) error {
 	fmt.Printf("Fetching backups for %s on branch %s...\n\n", userIdentifier, branch)
 
 	// List backup refs
-	refs, err := repo.ListBackupRefs(remote, userIdentifier, branch)
+	refs, err := repo.ListBackupRefs(remote, userIdentifier, listMachine, branch)
 	if err != nil {
 		return fmt.Errorf("failed to list backups: %w", err)
 	}
@@ -152,13 +176,73 @@ func runList(*cobra.Command, []string) error {
 	}
 
 	fmt.Printf("Available backups:\n\n")
-	for i, ref := range refs {
-		fmt.Printf("%d. %s\n", i+1, truncateHash(ref.Hash, 12))
-		fmt.Printf("   Full hash: %s\n", ref.Hash)
-		fmt.Printf("   Ref: %s\n\n", ref.Ref)
+	for _, ref := range refs {
+		snapshots, err := repo.ListBackupHistory(remote, ref.Ref, listLimit)
+		if err != nil {
+			return fmt.Errorf("failed to read backup history: %w", err)
+		}
+
+		fmt.Printf("Ref: %s\n", ref.Ref)
+		if ref.Machine != "" {
+			fmt.Printf("Machine: %s\n", ref.Machine)
+		}
+		fmt.Println()
+		for i, snapshot := range snapshots {
+			if snapshot.DecryptErr != nil {
+				fmt.Printf("%d. (encrypted)  %s\n", i+1, formatSnapshotDate(snapshot.Date))
+				fmt.Printf("   ⚠ Could not decrypt: %v\n", snapshot.DecryptErr)
+				fmt.Println()
+				continue
+			}
+			fmt.Printf("%d. %s  %s\n", i+1, truncateHash(snapshot.Hash, 12), formatSnapshotDate(snapshot.Date))
+			fmt.Printf("   Full hash: %s\n", snapshot.Hash)
+			fmt.Printf("   Message: %s\n", snapshot.Message)
+			if snapshot.Encrypted {
+				fmt.Printf("   Encrypted: yes\n")
+			}
+			printSnapshotMetadata("   ", snapshot.Metadata)
+			fmt.Println()
+		}
 	}
 
 	fmt.Printf("To restore a backup, run: ghost-backup restore <hash>\n")
 
 	return nil
 }
+
+// formatSnapshotDate formats a snapshot date for display, tolerating unknown dates
+func formatSnapshotDate(date time.Time) string {
+	if date.IsZero() {
+		return "unknown date"
+	}
+	return date.Local().Format("2006-01-02 15:04:05")
+}
+
+// printSnapshotMetadata prints the metadata recorded on a snapshot, if any
+func printSnapshotMetadata(indent string, m git.SnapshotMetadata) {
+	if m.IsZero() {
+		return
+	}
+
+	if m.Host != "" || m.RepoPath != "" {
+		fmt.Printf("%sMachine: %s:%s\n", indent, m.Host, m.RepoPath)
+	}
+	if m.Base != "" {
+		base := truncateHash(m.Base, 12)
+		if m.Upstream != "" {
+			base += " (upstream " + m.Upstream + ")"
+		}
+		fmt.Printf("%sBase: %s\n", indent, base)
+	}
+	files := fmt.Sprintf("%d", m.Files)
+	if m.Excluded > 0 {
+		files += fmt.Sprintf(" (%d excluded)", m.Excluded)
+	}
+	fmt.Printf("%sFiles: %s\n", indent, files)
+	if m.Trigger != "" {
+		fmt.Printf("%sTrigger: %s\n", indent, m.Trigger)
+	}
+	if m.Version != "" {
+		fmt.Printf("%sVersion: %s\n", indent, m.Version)
+	}
+}
@@ -2,6 +2,9 @@ package cmd
 
 import (
 	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
 )
 
 func TestListCmd_Configuration(t *testing.T) {
@@ -117,3 +120,43 @@ func TestTruncateHash(t *testing.T) {
 	}
 }
 
+
+func TestListCmd_LimitFlag(t *testing.T) {
+	limitFlag := listCmd.Flags().Lookup("limit")
+	if limitFlag == nil {
+		t.Fatal("list command should have a --limit flag")
+	}
+
+	if limitFlag.Shorthand != "n" {
+		t.Errorf("--limit flag shorthand should be 'n', got %s", limitFlag.Shorthand)
+	}
+
+	if limitFlag.DefValue != "20" {
+		t.Errorf("--limit flag default value should be 20, got %s", limitFlag.DefValue)
+	}
+}
+
+func TestFormatSnapshotDate(t *testing.T) {
+	if got := formatSnapshotDate(time.Time{}); got != "unknown date" {
+		t.Errorf("formatSnapshotDate(zero) = %q, want 'unknown date'", got)
+	}
+
+	date := time.Date(2024, 1, 2, 3, 4, 5, 0, time.Local)
+	if got := formatSnapshotDate(date); got != "2024-01-02 03:04:05" {
+		t.Errorf("formatSnapshotDate() = %q, want '2024-01-02 03:04:05'", got)
+	}
+}
+
+func TestMachineFlags(t *testing.T) {
+	// Commands reading backups can be limited to one machine, defaulting to all of them
+	for _, cmd := range []*cobra.Command{listCmd, restoreCmd, inspectCmd, branchesCmd} {
+		machineFlag := cmd.Flags().Lookup("machine")
+		if machineFlag == nil {
+			t.Errorf("%s command should have a --machine flag", cmd.Name())
+			continue
+		}
+		if machineFlag.Hidden || machineFlag.DefValue != "" {
+			t.Errorf("%s --machine flag should be visible with an empty default", cmd.Name())
+		}
+	}
+}
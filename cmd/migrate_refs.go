@@ -0,0 +1,155 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/FmTod/ghost-backup/internal/config"
+	"github.com/FmTod/ghost-backup/internal/git"
+	"github.com/spf13/cobra"
+)
+
+var (
+	migrateRefsDryRun bool
+)
+
+var migrateRefsCmd = &cobra.Command{
+	Use:   "migrate-refs",
+	Short: "Move backups to the current ref layout",
+	Long: `Move the backup refs written by older versions (refs/backups/<user>/<branch>)
+to the current layout (refs/ghost-backup/backups/<user>/<branch>/@) on the remote,
+keeping their history. Must be run from within a git repository.
+
+Older versions replaced characters such as '@' and spaces in user and machine names,
+which can't be undone. Refs matching your own identifier and machine are moved to
+them; other users' refs keep the replaced names. A ref whose new name already
+exists, e.g. because a newer version already backed up the branch, is left alone.`,
+	RunE: runMigrateRefs,
+}
+
+func init() {
+	rootCmd.AddCommand(migrateRefsCmd)
+	snapshotCommand(migrateRefsCmd)
+
+	migrateRefsCmd.Flags().BoolVar(&migrateRefsDryRun, "dry-run", false, "Only show how the refs would be renamed")
+}
+
+func runMigrateRefs(*cobra.Command, []string) error {
+	// Get the current directory
+	cwd, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("failed to get current directory: %w", err)
+	}
+
+	// Create git repo instance
+	repo := git.NewGitRepo(cwd)
+
+	// Verify it's a git repository
+	isGitRepo, err := repo.IsGitRepo()
+	if err != nil {
+		return fmt.Errorf("git validation error: %w", err)
+	}
+	if !isGitRepo {
+		return fmt.Errorf("not a git repository: %s", cwd)
+	}
+
+	// Every backup remote keeps its own copy of the refs
+	remotes, err := backupRemotes(repo)
+	if err != nil {
+		return err
+	}
+
+	// The current identifier and machine, to give their legacy refs back their real names
+	userEmail, _ := repo.GetUserEmail()
+	userName, _ := repo.GetUserName()
+	globalConfig, err := config.LoadGlobalConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load global config: %w", err)
+	}
+	userIdentifier := git.GenerateUserIdentifier(globalConfig.GitUser, userName, userEmail)
+	machine, err := globalConfig.Machine()
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, remote := range remotes {
+		if len(remotes) > 1 {
+			fmt.Printf("Remote %s:\n", remote)
+		}
+		n, err := migrateRemoteRefs(repo, remote, userIdentifier, machine)
+		if err != nil {
+			return err
+		}
+		failed += n
+	}
+
+	if migrateRefsDryRun {
+		fmt.Printf("\nDry run, nothing was changed\n")
+		return nil
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to migrate %d backup refs", failed)
+	}
+	return nil
+}
+
+// migrateRemoteRefs moves the legacy backup refs of one remote and returns how many failed
+func migrateRemoteRefs(repo *git.GitRepo, remote, userIdentifier, machine string) (int, error) {
+	fmt.Printf("Fetching legacy backup refs from remote...\n\n")
+
+	refs, err := repo.ListLegacyBackupRefs(remote)
+	if err != nil {
+		return 0, err
+	}
+	if len(refs) == 0 {
+		fmt.Printf("✓ No backup refs to migrate\n")
+		return 0, nil
+	}
+
+	moved, failed := 0, 0
+	for _, ref := range refs {
+		newRefName := migratedRefName(ref, userIdentifier, machine)
+
+		existing, err := repo.GetRemoteRefHash(remote, newRefName)
+		if err != nil {
+			return failed, err
+		}
+		if existing != "" {
+			fmt.Printf("⚠ Skipping %s: %s already exists\n", ref.Ref, newRefName)
+			continue
+		}
+
+		if migrateRefsDryRun {
+			fmt.Printf("  %s -> %s\n", ref.Ref, newRefName)
+			continue
+		}
+
+		if err := repo.MoveBackupRef(remote, ref, newRefName); err != nil {
+			fmt.Printf("⚠ Failed to move %s: %v\n", ref.Ref, err)
+			failed++
+			continue
+		}
+		fmt.Printf("✓ Moved %s -> %s\n", ref.Ref, newRefName)
+		moved++
+	}
+
+	if !migrateRefsDryRun {
+		fmt.Printf("\n✓ Migrated %d of %d backup refs\n", moved, len(refs))
+	}
+	return failed, nil
+}
+
+// migratedRefName returns the current name of a legacy backup ref, mapping the sanitized names
+// of the current user and machine back to the real ones
+func migratedRefName(ref git.BackupRef, userIdentifier, machine string) string {
+	user := ref.UserIdentifier
+	if user == git.SanitizeRefName(userIdentifier) {
+		user = userIdentifier
+	}
+	refMachine := ref.Machine
+	if machine != "" && refMachine == git.SanitizeRefName(machine) {
+		refMachine = machine
+	}
+	return git.BackupRefName(user, refMachine, ref.Branch)
+}
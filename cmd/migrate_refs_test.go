@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/FmTod/ghost-backup/internal/git"
+)
+
+func TestMigrateRefsCmd_Configuration(t *testing.T) {
+	if migrateRefsCmd.Use != "migrate-refs" {
+		t.Errorf("migrateRefsCmd.Use = %s, want migrate-refs", migrateRefsCmd.Use)
+	}
+
+	if migrateRefsCmd.RunE == nil {
+		t.Error("migrateRefsCmd.RunE should not be nil")
+	}
+
+	dryRunFlag := migrateRefsCmd.Flags().Lookup("dry-run")
+	if dryRunFlag == nil {
+		t.Fatal("migrate-refs command should have a --dry-run flag")
+	}
+	if dryRunFlag.DefValue != "false" {
+		t.Errorf("--dry-run flag default value should be false, got %s", dryRunFlag.DefValue)
+	}
+}
+
+func TestMigratedRefName(t *testing.T) {
+	tests := []struct {
+		name string
+		ref  git.BackupRef
+		want string
+	}{
+		{
+			"current user gets the real identifier back",
+			git.BackupRef{UserIdentifier: "john_at_example.com", Branch: "main"},
+			"refs/ghost-backup/backups/john%40example.com/main/@",
+		},
+		{
+			"current machine gets the real name back",
+			git.BackupRef{UserIdentifier: "john_at_example.com", Machine: "dev_box", Branch: "feature/x"},
+			"refs/ghost-backup/backups/john%40example.com/@dev%20box/feature/x/@",
+		},
+		{
+			"other users keep the legacy name",
+			git.BackupRef{UserIdentifier: "jane_at_example.com", Machine: "laptop", Branch: "main"},
+			"refs/ghost-backup/backups/jane_at_example.com/@laptop/main/@",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := migratedRefName(tt.ref, "john@example.com", "dev box"); got != tt.want {
+				t.Errorf("migratedRefName() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/FmTod/ghost-backup/internal/config"
+	"github.com/FmTod/ghost-backup/internal/git"
+)
+
+// backupRemotes returns the remotes the repository is backed up to, primary first
+func backupRemotes(repo *git.GitRepo) ([]string, error) {
+	globalConfig, err := config.LoadGlobalConfig()
+	if err != nil {
+		// Non-fatal, use empty config
+		globalConfig = &config.GlobalConfig{}
+	}
+	localConfig, err := config.LoadLocalConfig(repo.Path)
+	if err != nil {
+		localConfig = nil
+	}
+
+	remotes, err := repo.ResolveBackupRemotes(config.BackupRemotes(globalConfig, localConfig))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get remote: %w", err)
+	}
+	return remotes, nil
+}
+
+// readRemote returns the backup remote to read backups from: the one given with --remote, or else
+// the primary one, or the next reachable one when it can't be contacted
+func readRemote(repo *git.GitRepo, override string) (string, error) {
+	if override != "" {
+		remotes, err := repo.ResolveBackupRemotes([]string{override})
+		if err != nil {
+			return "", err
+		}
+		return remotes[0], nil
+	}
+
+	remotes, err := backupRemotes(repo)
+	if err != nil {
+		return "", err
+	}
+
+	remote, err := repo.FirstReachableRemote(remotes)
+	if err != nil {
+		return "", err
+	}
+	if remote != remotes[0] {
+		fmt.Printf("⚠ %s is unreachable, using %s\n", remotes[0], remote)
+	}
+	return remote, nil
+}
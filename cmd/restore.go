@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"os"
 
@@ -10,7 +11,9 @@ import (
 )
 
 var (
-	restoreMethod string
+	restoreMethod  string
+	restoreMachine string
+	restoreRemote  string
 )
 
 var restoreCmd = &cobra.Command{
@@ -21,15 +24,21 @@ Must be run from within a git repository.
 
 Methods:
   - apply: Apply the stash to the working directory (default)
-  - cherry-pick: Cherry-pick the changes as a commit`,
+  - cherry-pick: Cherry-pick the changes as a commit
+
+With per-machine backup refs, the backups of every machine are searched unless
+--machine is given.`,
 	Args: cobra.ExactArgs(1),
 	RunE: runRestore,
 }
 
 func init() {
 	rootCmd.AddCommand(restoreCmd)
+	snapshotCommand(restoreCmd)
 
 	restoreCmd.Flags().StringVarP(&restoreMethod, "method", "m", "apply", "Restore method (apply, cherry-pick)")
+	restoreCmd.Flags().StringVar(&restoreMachine, "machine", "", "Only search the backups of this machine")
+	restoreCmd.Flags().StringVar(&restoreRemote, "remote", "", "Backup remote, URL, bundle:// directory or s3:// bucket to restore from")
 }
 
 func runRestore(_ *cobra.Command, args []string) error {
@@ -79,18 +88,15 @@ func runRestore(_ *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to get current branch: %w", err)
 	}
 
-	remote, err := repo.GetRemote()
+	remote, err := readRemote(repo, restoreRemote)
 	if err != nil {
-		return fmt.Errorf("failed to get remote: %w", err)
+		return err
 	}
 
-	// Fetch the backup ref to ensure we have the object
-	// Branch name is not sanitized to preserve slashes in branch paths
-	refName := fmt.Sprintf("refs/backups/%s/%s", git.SanitizeRefName(userIdentifier), branch)
-	fmt.Printf("Fetching backup from %s...\n", refName)
-
-	if err := repo.FetchBackupRef(remote, refName); err != nil {
-		return fmt.Errorf("failed to fetch backup ref: %w", err)
+	// Fetch the backup refs to ensure we have the object
+	// The refs carry the full backup history, so any earlier snapshot becomes available
+	if err := fetchBackupRefs(repo, remote, userIdentifier, restoreMachine, branch, hash); err != nil {
+		return err
 	}
 
 	// Restore based on method
@@ -116,3 +122,40 @@ func runRestore(_ *cobra.Command, args []string) error {
 
 	return nil
 }
+
+// fetchBackupRefs fetches the backup refs of a user's branch, from every machine unless one is given,
+// and decrypts their encrypted snapshots until the one with the given hash is found
+func fetchBackupRefs(repo *git.GitRepo, remote, userIdentifier, machine, branch, hash string) error {
+	refs, err := repo.ListBackupRefs(remote, userIdentifier, machine, branch)
+	if err != nil {
+		return fmt.Errorf("failed to list backups: %w", err)
+	}
+	if len(refs) == 0 {
+		return fmt.Errorf("no backups found for %s on branch %s", userIdentifier, branch)
+	}
+
+	for _, ref := range refs {
+		fmt.Printf("Fetching backup from %s...\n", ref.Ref)
+		if err := repo.FetchBackupRef(remote, ref.Ref); err != nil {
+			return fmt.Errorf("failed to fetch backup ref: %w", err)
+		}
+	}
+	if repo.ObjectExists(hash) {
+		return nil
+	}
+
+	var errs []error
+	for _, ref := range refs {
+		found, err := repo.FindEncryptedSnapshot(ref.Ref, hash)
+		if found {
+			return nil
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("snapshot %s not found, some encrypted snapshots could not be decrypted: %w", hash, err)
+	}
+	return nil
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/FmTod/ghost-backup/internal/config"
+	"github.com/FmTod/ghost-backup/internal/git"
 	"github.com/spf13/cobra"
 )
 
@@ -17,6 +19,42 @@ var rootCmd = &cobra.Command{
 	Long: `Ghost Backup is a background safety net that pushes "invisible" git snapshots
 (work-in-progress) to a backup server. It supports monitoring multiple repositories
 simultaneously, each with its own configuration.`,
+	PersistentPreRunE: setupCredentials,
+}
+
+// snapshotsAnnotation marks commands that store or read snapshots; they refuse to run without the
+// global config, whose encryption keys decide how snapshots are stored
+const snapshotsAnnotation = "ghost-backup/snapshots"
+
+// snapshotCommand marks a command as storing or reading snapshots
+func snapshotCommand(cmd *cobra.Command) {
+	if cmd.Annotations == nil {
+		cmd.Annotations = map[string]string{}
+	}
+	cmd.Annotations[snapshotsAnnotation] = "true"
+}
+
+// setupCredentials lets git subprocesses authenticate with the stored token, s3:// backup remotes
+// with the stored object storage credentials, and sets up the encryption keys of snapshots
+// Credentials from git's own credential helpers still take precedence
+func setupCredentials(cmd *cobra.Command, _ []string) error {
+	globalConfig, err := config.LoadGlobalConfig()
+	if err != nil {
+		// Going on without the config would store snapshots unencrypted
+		if cmd.Annotations[snapshotsAnnotation] != "" {
+			return fmt.Errorf("failed to load global config: %w", err)
+		}
+		return nil
+	}
+	git.SetupS3(globalConfig.S3Options())
+	git.SetupEncryption(globalConfig.EncryptionOptions())
+	if globalConfig.GitToken == "" {
+		return nil
+	}
+	if err := git.SetupGitCredentials(globalConfig.GitUser, globalConfig.GitToken); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+	return nil
 }
 
 // Execute runs the root command
@@ -29,4 +67,7 @@ func Execute() {
 
 func init() {
 	rootCmd.CompletionOptions.DisableDefaultCmd = true
+
+	// Snapshots record the version that took them
+	git.Version = version
 }
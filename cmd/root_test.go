@@ -1,7 +1,11 @@
 package cmd
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
+
+	"github.com/spf13/cobra"
 )
 
 func TestRootCmd_Configuration(t *testing.T) {
@@ -46,3 +50,29 @@ func TestExecute_Function(t *testing.T) {
 		t.Error("rootCmd should be initialized for Execute to work")
 	}
 }
+
+func TestSetupCredentials_BrokenGlobalConfig(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	configDir := filepath.Join(home, ".config", "ghost-backup")
+	if err := os.MkdirAll(configDir, 0700); err != nil {
+		t.Fatalf("Failed to create config dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(configDir, "config.json"), []byte(`{"encryption": `), 0600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	// Snapshots must not be stored or read without the encryption keys of the config
+	for _, cmd := range []*cobra.Command{backupCmd, listCmd, restoreCmd, inspectCmd, branchesCmd, usersCmd, migrateRefsCmd} {
+		if err := setupCredentials(cmd, nil); err == nil {
+			t.Errorf("setupCredentials(%s) should fail with a broken global config", cmd.Name())
+		}
+	}
+
+	// Commands that don't touch snapshots still run, e.g. to fix the config
+	for _, cmd := range []*cobra.Command{getTokenCmd, serviceStopCmd} {
+		if err := setupCredentials(cmd, nil); err != nil {
+			t.Errorf("setupCredentials(%s) error = %v", cmd.Name(), err)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"github.com/FmTod/ghost-backup/internal/config"
+	"github.com/FmTod/ghost-backup/internal/git"
+	"github.com/FmTod/ghost-backup/internal/security"
+	"github.com/spf13/cobra"
+)
+
+var (
+	secretsPath string
+)
+
+var secretsCmd = &cobra.Command{
+	Use:   "secrets",
+	Short: "Manage secret scanning for a repository",
+	Long: `Manage how secret scanning treats a repository's changes.
+
+Accepted findings are stored in .ghost-backup-allowlist.json beside .ghost-backup.json.
+Besides fingerprints recorded by 'secrets baseline', it can list path globs and rule IDs to ignore:
+
+  {
+    "fingerprints": ["testdata/keys.go:private-key:3f2a9c0d1e4b"],
+    "paths": ["testdata/", "*.fixture"],
+    "rules": ["generic-high-entropy-assignment"]
+  }`,
+}
+
+var secretsBaselineCmd = &cobra.Command{
+	Use:   "baseline",
+	Short: "Accept the secrets currently detected as false positives",
+	Long: `Scan the repository's uncommitted changes and record every finding in the allowlist,
+so later backups no longer block on them. New secrets are still detected.
+
+Review the findings before running this: an accepted finding is backed up as-is.`,
+	RunE: runSecretsBaseline,
+}
+
+func init() {
+	rootCmd.AddCommand(secretsCmd)
+	secretsCmd.AddCommand(secretsBaselineCmd)
+
+	secretsCmd.PersistentFlags().StringVarP(&secretsPath, "path", "p", ".", "Path to the repository")
+}
+
+func runSecretsBaseline(*cobra.Command, []string) error {
+	absPath, err := filepath.Abs(secretsPath)
+	if err != nil {
+		return fmt.Errorf("failed to get absolute path: %w", err)
+	}
+
+	repo := git.NewGitRepo(absPath)
+	isGitRepo, err := repo.IsGitRepo()
+	if err != nil {
+		return fmt.Errorf("git validation error: %w", err)
+	}
+	if !isGitRepo {
+		return fmt.Errorf("not a git repository: %s", absPath)
+	}
+
+	localConfig, err := config.LoadLocalConfig(absPath)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	hasChanges, err := repo.HasChanges()
+	if err != nil {
+		return fmt.Errorf("failed to check for changes: %w", err)
+	}
+	if !hasChanges {
+		fmt.Println("✓ No uncommitted changes to scan")
+		return nil
+	}
+
+	// Scan the same snapshot a backup would push
+	globalConfig, err := config.LoadGlobalConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load global config: %w", err)
+	}
+	filter, err := security.LoadPathFilter(absPath, globalConfig, localConfig)
+	if err != nil {
+		return err
+	}
+	hash, err := repo.CreateSnapshot(localConfig.OnlyStaged, localConfig.IncludeUntracked, filter.Keeps)
+	if err != nil && !errors.Is(err, git.ErrNoChangesLeft) {
+		return fmt.Errorf("failed to create stash: %w", err)
+	}
+	if err == nil {
+		hash, _, err = security.ExcludePaths(repo, hash, filter)
+	}
+	if errors.Is(err, git.ErrNoChangesLeft) {
+		fmt.Println("✓ Only excluded paths changed, nothing to scan")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	diff := func() (io.ReadCloser, error) {
+		return repo.OpenSnapshotDiff(hash)
+	}
+
+	opts, err := security.LoadScanOptions(absPath, localConfig)
+	if err != nil {
+		return err
+	}
+	allowlist := opts.Allowlist
+	opts.Allowlist = nil // Report every finding, including those already accepted
+
+	fmt.Println("Scanning for secrets...")
+	result, err := security.Scan(diff, opts)
+	if err != nil {
+		return fmt.Errorf("secret scan failed: %w", err)
+	}
+	if result.Fallback {
+		fmt.Println("⚠ gitleaks not available, using built-in secret scanner")
+	}
+	if notice := security.LargeDiffNotice(result, opts); notice != "" {
+		fmt.Printf("⚠ %s\n", notice)
+	}
+
+	if !result.HasSecrets {
+		fmt.Println("✓ No secrets detected, nothing to accept")
+		return nil
+	}
+	if len(result.Notes) > 0 {
+		printFindings(result)
+		return fmt.Errorf("some scanners reported secrets without details, they can't be accepted individually")
+	}
+
+	fmt.Printf("Findings (%s):\n", result.Scanner)
+	printFindings(result)
+
+	added := allowlist.AddFingerprints(result.Findings)
+	if added == 0 {
+		fmt.Println("✓ All findings are already accepted")
+		return nil
+	}
+	if err := security.SaveAllowlist(absPath, allowlist); err != nil {
+		return err
+	}
+
+	fmt.Printf("✓ Accepted %d new findings in %s\n", added, security.GetAllowlistPath(absPath))
+	return nil
+}
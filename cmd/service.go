@@ -4,9 +4,11 @@ import (
 	"bufio"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/FmTod/ghost-backup/internal/config"
+	"github.com/FmTod/ghost-backup/internal/control"
 	svc "github.com/FmTod/ghost-backup/internal/service"
 	"github.com/kardianos/service"
 	"github.com/spf13/cobra"
@@ -15,9 +17,15 @@ import (
 var serviceCmd = &cobra.Command{
 	Use:   "service",
 	Short: "Manage the ghost-backup service",
-	Long:  `Manage the ghost-backup background service (install, uninstall, start, stop, status).`,
+	Long: `Manage the ghost-backup background service (install, uninstall, start, stop, status).
+When the service is running, trigger, pause, resume and reload talk to it directly
+through its control socket.`,
 }
 
+var (
+	serviceRepoPath string
+)
+
 var serviceInstallCmd = &cobra.Command{
 	Use:   "install",
 	Short: "Install the user service",
@@ -119,6 +127,24 @@ var serviceStatusCmd = &cobra.Command{
 
 		fmt.Printf("Service Status: %s\n", getStatusString(status))
 
+		// Prefer live worker status from the daemon when it is reachable
+		if client, err := control.DefaultClient(); err == nil {
+			if resp, err := client.Status(); err == nil {
+				if resp.Paused {
+					fmt.Printf("Daemon: paused\n")
+				}
+				fmt.Printf("\nActive Workers: %d\n", len(resp.Workers))
+				for _, worker := range resp.Workers {
+					fmt.Printf("  - %s\n", worker)
+				}
+
+				if logPath, err := svc.GetLogFilePath(); err == nil {
+					fmt.Printf("\nLog file: %s\n", logPath)
+				}
+				return nil
+			}
+		}
+
 		// Show registry info
 		registry, err := config.LoadRegistry()
 		if err != nil {
@@ -141,6 +167,78 @@ var serviceStatusCmd = &cobra.Command{
 	},
 }
 
+var serviceTriggerCmd = &cobra.Command{
+	Use:   "trigger",
+	Short: "Ask the running service to back up a repository now",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, repoPath, err := controlTarget(true)
+		if err != nil {
+			return err
+		}
+		if err := client.Trigger(repoPath); err != nil {
+			return err
+		}
+		fmt.Printf("✓ Backup triggered for %s\n", repoPath)
+		return nil
+	},
+}
+
+var servicePauseCmd = &cobra.Command{
+	Use:   "pause",
+	Short: "Pause scheduled backups (all repositories, or one with --path)",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, repoPath, err := controlTarget(cmd.Flags().Changed("path"))
+		if err != nil {
+			return err
+		}
+		if err := client.Pause(repoPath); err != nil {
+			return err
+		}
+		if repoPath == "" {
+			fmt.Println("✓ All backups paused")
+		} else {
+			fmt.Printf("✓ Backups paused for %s\n", repoPath)
+		}
+		return nil
+	},
+}
+
+var serviceResumeCmd = &cobra.Command{
+	Use:   "resume",
+	Short: "Resume scheduled backups (all repositories, or one with --path)",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, repoPath, err := controlTarget(cmd.Flags().Changed("path"))
+		if err != nil {
+			return err
+		}
+		if err := client.Resume(repoPath); err != nil {
+			return err
+		}
+		if repoPath == "" {
+			fmt.Println("✓ All backups resumed")
+		} else {
+			fmt.Printf("✓ Backups resumed for %s\n", repoPath)
+		}
+		return nil
+	},
+}
+
+var serviceReloadCmd = &cobra.Command{
+	Use:   "reload",
+	Short: "Reload the repository registry without restarting the service",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, _, err := controlTarget(false)
+		if err != nil {
+			return err
+		}
+		if err := client.Reload(); err != nil {
+			return err
+		}
+		fmt.Println("✓ Registry reloaded")
+		return nil
+	},
+}
+
 var serviceRunCmd = &cobra.Command{
 	Use:   "run",
 	Short: "Run the service in foreground (for debugging)",
@@ -160,7 +258,48 @@ func init() {
 	serviceCmd.AddCommand(serviceStopCmd)
 	serviceCmd.AddCommand(serviceRestartCmd)
 	serviceCmd.AddCommand(serviceStatusCmd)
+	serviceCmd.AddCommand(serviceTriggerCmd)
+	serviceCmd.AddCommand(servicePauseCmd)
+	serviceCmd.AddCommand(serviceResumeCmd)
+	serviceCmd.AddCommand(serviceReloadCmd)
 	serviceCmd.AddCommand(serviceRunCmd)
+
+	serviceTriggerCmd.Flags().StringVarP(&serviceRepoPath, "path", "p", ".", "Path to the repository")
+	servicePauseCmd.Flags().StringVarP(&serviceRepoPath, "path", "p", ".", "Path to the repository (default: all repositories)")
+	serviceResumeCmd.Flags().StringVarP(&serviceRepoPath, "path", "p", ".", "Path to the repository (default: all repositories)")
+}
+
+// controlTarget returns a client for the running daemon and, if withRepo is set,
+// the absolute path of the repository given by --path
+func controlTarget(withRepo bool) (*control.Client, string, error) {
+	client, err := control.DefaultClient()
+	if err != nil {
+		return nil, "", err
+	}
+	if err := client.Ping(); err != nil {
+		return nil, "", fmt.Errorf("service is not running (%w); start it with: ghost-backup service start", err)
+	}
+
+	if !withRepo {
+		return client, "", nil
+	}
+
+	repoPath, err := filepath.Abs(serviceRepoPath)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get absolute path: %w", err)
+	}
+	return client, repoPath, nil
+}
+
+// reloadService asks the running daemon to reload the registry over its control socket,
+// falling back to a full service restart when the socket isn't available
+func reloadService() error {
+	if client, err := control.DefaultClient(); err == nil {
+		if err := client.Reload(); err == nil {
+			return nil
+		}
+	}
+	return svc.RestartService()
 }
 
 func getStatusString(status service.Status) string {
@@ -33,7 +33,7 @@ func TestServiceCmd_Subcommands(t *testing.T) {
 	}
 
 	// Expected subcommands
-	expectedCmds := []string{"install", "uninstall", "start", "stop", "restart", "status", "run"}
+	expectedCmds := []string{"install", "uninstall", "start", "stop", "restart", "status", "trigger", "pause", "resume", "reload", "run"}
 
 	foundCmds := make(map[string]bool)
 	for _, cmd := range commands {
@@ -0,0 +1,206 @@
+package cmd
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/FmTod/ghost-backup/internal/config"
+	"github.com/FmTod/ghost-backup/internal/control"
+	"github.com/FmTod/ghost-backup/internal/git"
+	"github.com/FmTod/ghost-backup/internal/service"
+	"github.com/FmTod/ghost-backup/internal/state"
+	"github.com/FmTod/ghost-backup/internal/worker"
+	"github.com/spf13/cobra"
+)
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show backup status for all monitored repositories",
+	Long: `Show the persisted backup state of every repository in the global registry:
+time since the last successful backup, the current failure streak, the last error,
+snapshots queued for retry and the next scheduled run. Live pause state is shown when the service is running.`,
+	RunE: runStatus,
+}
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+}
+
+func runStatus(*cobra.Command, []string) error {
+	registry, err := config.LoadRegistry()
+	if err != nil {
+		return fmt.Errorf("failed to load registry: %w", err)
+	}
+
+	repos := registry.GetRepositories()
+	if len(repos) == 0 {
+		fmt.Println("No repositories are monitored.")
+		fmt.Println("To start backing up a repository, run: ghost-backup init")
+		return nil
+	}
+
+	// Live worker state from the daemon, if it is reachable
+	var live *control.Response
+	if client, err := control.DefaultClient(); err == nil {
+		live, _ = client.Status()
+	}
+
+	if live == nil {
+		fmt.Printf("⚠ Service is not running; showing last recorded state\n")
+	} else if live.Paused {
+		fmt.Printf("⚠ Service is paused; scheduled backups are skipped\n")
+	}
+
+	now := time.Now()
+	fmt.Printf("Backup status for %d repositories:\n", len(repos))
+
+	for _, repoPath := range repos {
+		fmt.Printf("\n%s [%s]\n", repoPath, workerState(live, repoPath))
+
+		repoState, err := state.Load(repoPath)
+		if err != nil {
+			fmt.Printf("  ⚠ Failed to read state: %v\n", err)
+			continue
+		}
+
+		if repoState.LastSuccess.IsZero() {
+			fmt.Printf("  Last backup:  never\n")
+		} else {
+			fmt.Printf("  Last backup:  %s (%s)\n", formatRelative(repoState.LastSuccess, now), truncateHash(repoState.LastHash, 12))
+		}
+
+		if !repoState.LastRun.IsZero() {
+			fmt.Printf("  Last run:     %s, %s\n", formatRelative(repoState.LastRun, now), describeResult(repoState.LastResult))
+		}
+
+		if repoState.ConsecutiveFailures > 0 {
+			fmt.Printf("  ⚠ Failures:   %d in a row\n", repoState.ConsecutiveFailures)
+			fmt.Printf("  Last error:   %s\n", firstLine(repoState.LastError))
+		}
+
+		// Per-remote outcome, when snapshots are pushed to several remotes
+		if len(repoState.Remotes) > 1 {
+			remotes := make([]string, 0, len(repoState.Remotes))
+			for remote := range repoState.Remotes {
+				remotes = append(remotes, remote)
+			}
+			slices.Sort(remotes)
+			for _, remote := range remotes {
+				fmt.Printf("  Remote %s: %s\n", remote, describeRemote(repoState.Remotes[remote], now))
+			}
+		}
+
+		if pending, err := git.NewGitRepo(repoPath).ListPendingBackups(); err == nil && len(pending) > 0 {
+			fmt.Printf("  ⚠ Queued:     %d snapshots waiting to be pushed (oldest %s)\n", len(pending), formatRelative(pending[0].QueuedAt, now))
+			if live != nil && !repoState.NextRetry.IsZero() {
+				fmt.Printf("  Next retry:   %s (attempt %d)\n", formatRelative(repoState.NextRetry, now), repoState.RetryAttempts+1)
+			}
+		}
+
+		if live != nil && !repoState.NextRun.IsZero() {
+			fmt.Printf("  Next run:     %s\n", formatRelative(repoState.NextRun, now))
+		}
+	}
+
+	if logPath, err := service.GetLogFilePath(); err == nil {
+		fmt.Printf("\nFor details, see the log: %s\n", logPath)
+	}
+
+	return nil
+}
+
+// workerState describes the live state of a repository's worker
+func workerState(live *control.Response, repoPath string) string {
+	if live == nil {
+		return "service not running"
+	}
+
+	var status *worker.WorkerStatus
+	for i := range live.Workers {
+		if live.Workers[i].RepoPath == repoPath {
+			status = &live.Workers[i]
+			break
+		}
+	}
+
+	switch {
+	case status == nil:
+		return "not loaded"
+	case status.Paused:
+		return "paused"
+	default:
+		return "running"
+	}
+}
+
+// describeResult returns a readable description of a recorded backup result
+func describeResult(result string) string {
+	switch result {
+	case state.ResultSuccess:
+		return "backed up"
+	case state.ResultNoChanges:
+		return "no changes"
+	case state.ResultFailed:
+		return "failed"
+	default:
+		return "unknown"
+	}
+}
+
+// describeRemote summarizes the push state of one backup remote
+func describeRemote(r *state.RemoteState, now time.Time) string {
+	pushed := "never pushed"
+	if !r.LastSuccess.IsZero() {
+		pushed = fmt.Sprintf("last pushed %s (%s)", formatRelative(r.LastSuccess, now), truncateHash(r.LastHash, 12))
+	}
+	if r.ConsecutiveFailures == 0 {
+		return pushed
+	}
+	return fmt.Sprintf("%s, ⚠ %d failures in a row: %s", pushed, r.ConsecutiveFailures, firstLine(r.LastError))
+}
+
+// formatRelative formats a time relative to now, e.g. "5m ago" or "in 2h"
+func formatRelative(t, now time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+
+	d := now.Sub(t)
+	if d >= 0 {
+		if d < time.Minute {
+			return "just now"
+		}
+		return humanDuration(d) + " ago"
+	}
+	if -d < time.Minute {
+		return "in less than a minute"
+	}
+	return "in " + humanDuration(-d)
+}
+
+// humanDuration formats a duration with at most two units, e.g. "3d4h", "2h5m" or "45m"
+func humanDuration(d time.Duration) string {
+	d = d.Round(time.Minute)
+	days := int(d / (24 * time.Hour))
+	hours := int(d % (24 * time.Hour) / time.Hour)
+	minutes := int(d % time.Hour / time.Minute)
+
+	switch {
+	case days > 0:
+		return fmt.Sprintf("%dd%dh", days, hours)
+	case hours > 0:
+		return fmt.Sprintf("%dh%dm", hours, minutes)
+	default:
+		return fmt.Sprintf("%dm", minutes)
+	}
+}
+
+// firstLine returns the first line of a possibly multi-line message
+func firstLine(s string) string {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/FmTod/ghost-backup/internal/control"
+	"github.com/FmTod/ghost-backup/internal/state"
+	"github.com/FmTod/ghost-backup/internal/worker"
+)
+
+func TestStatusCmd_Configuration(t *testing.T) {
+	if statusCmd == nil {
+		t.Fatal("statusCmd is nil")
+	}
+
+	if statusCmd.Use != "status" {
+		t.Errorf("statusCmd.Use = %s, want status", statusCmd.Use)
+	}
+
+	if statusCmd.Short == "" {
+		t.Error("statusCmd.Short should not be empty")
+	}
+
+	if statusCmd.RunE == nil {
+		t.Error("statusCmd.RunE should not be nil")
+	}
+}
+
+func TestFormatRelative(t *testing.T) {
+	now := time.Date(2024, 1, 2, 15, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		t    time.Time
+		want string
+	}{
+		{"zero", time.Time{}, "never"},
+		{"seconds ago", now.Add(-20 * time.Second), "just now"},
+		{"minutes ago", now.Add(-5 * time.Minute), "5m ago"},
+		{"hours ago", now.Add(-(2*time.Hour + 5*time.Minute)), "2h5m ago"},
+		{"days ago", now.Add(-(3*24*time.Hour + 4*time.Hour)), "3d4h ago"},
+		{"soon", now.Add(20 * time.Second), "in less than a minute"},
+		{"future", now.Add(90 * time.Minute), "in 1h30m"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatRelative(tt.t, now); got != tt.want {
+				t.Errorf("formatRelative() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWorkerState(t *testing.T) {
+	live := &control.Response{
+		OK: true,
+		Workers: []worker.WorkerStatus{
+			{RepoPath: "/repo/a"},
+			{RepoPath: "/repo/b", Paused: true},
+		},
+	}
+
+	tests := []struct {
+		name string
+		live *control.Response
+		repo string
+		want string
+	}{
+		{"daemon down", nil, "/repo/a", "service not running"},
+		{"running", live, "/repo/a", "running"},
+		{"paused", live, "/repo/b", "paused"},
+		{"not loaded", live, "/repo/c", "not loaded"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := workerState(tt.live, tt.repo); got != tt.want {
+				t.Errorf("workerState() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDescribeRemote(t *testing.T) {
+	now := time.Date(2024, 1, 2, 15, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		remote state.RemoteState
+		want   string
+	}{
+		{"never pushed", state.RemoteState{}, "never pushed"},
+		{"healthy", state.RemoteState{LastSuccess: now.Add(-5 * time.Minute), LastHash: "abc123"}, "last pushed 5m ago (abc123)"},
+		{
+			"failing",
+			state.RemoteState{LastSuccess: now.Add(-5 * time.Minute), LastHash: "abc123", ConsecutiveFailures: 2, LastError: "unreachable\ndetails"},
+			"last pushed 5m ago (abc123), ⚠ 2 failures in a row: unreachable",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := describeRemote(&tt.remote, now); got != tt.want {
+				t.Errorf("describeRemote() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -6,7 +6,6 @@ import (
 	"path/filepath"
 
 	"github.com/FmTod/ghost-backup/internal/config"
-	"github.com/FmTod/ghost-backup/internal/service"
 	"github.com/spf13/cobra"
 )
 
@@ -18,7 +17,7 @@ var uninstallCmd = &cobra.Command{
 	Use:   "uninstall",
 	Short: "Uninstall ghost-backup from a repository",
 	Long: `Remove a repository from ghost-backup monitoring.
-This will remove the repository from the global registry and restart the service.`,
+This will remove the repository from the global registry and reload the service.`,
 	RunE: runUninstall,
 }
 
@@ -64,13 +63,13 @@ func runUninstall(*cobra.Command, []string) error {
 		}
 	}
 
-	// Restart service to reload configuration
-	fmt.Printf("Restarting service to reload configuration...\n")
-	if err := service.RestartService(); err != nil {
-		fmt.Printf("Warning: Failed to restart service: %v\n", err)
+	// Reload the registry in the running service (restarts it if the control socket is unavailable)
+	fmt.Printf("Reloading service configuration...\n")
+	if err := reloadService(); err != nil {
+		fmt.Printf("Warning: Failed to reload service: %v\n", err)
 		fmt.Printf("You may need to restart the service manually.\n")
 	} else {
-		fmt.Printf("✓ Service restarted\n")
+		fmt.Printf("✓ Service reloaded\n")
 	}
 
 	fmt.Printf("\n✓ Uninstallation complete!\n")
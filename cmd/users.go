@@ -9,6 +9,10 @@ import (
 	"github.com/spf13/cobra"
 )
 
+var (
+	usersMachine string
+)
+
 var usersCmd = &cobra.Command{
 	Use:    "users",
 	Hidden: true,
@@ -21,6 +25,9 @@ Must be run from within a git repository.`,
 
 func init() {
 	rootCmd.AddCommand(usersCmd)
+	snapshotCommand(usersCmd)
+
+	usersCmd.Flags().StringVar(&usersMachine, "machine", "", "Only list users with backups from this machine")
 }
 
 func runUsers(*cobra.Command, []string) error {
@@ -43,15 +50,15 @@ func runUsers(*cobra.Command, []string) error {
 	}
 
 	// Get remote
-	remote, err := repo.GetRemote()
+	remote, err := readRemote(repo, "")
 	if err != nil {
-		return fmt.Errorf("failed to get remote: %w", err)
+		return err
 	}
 
 	fmt.Printf("Fetching all backup users from remote...\n\n")
 
 	// List all backup users
-	users, err := repo.ListAllBackupUsers(remote)
+	users, err := repo.ListAllBackupUsers(remote, usersMachine)
 	if err != nil {
 		return fmt.Errorf("failed to list backup users: %w", err)
 	}
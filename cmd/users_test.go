@@ -30,9 +30,14 @@ func TestUsersCmd_Configuration(t *testing.T) {
 	}
 }
 
-func TestUsersCmd_NoFlags(t *testing.T) {
-	// users command should have no flags
-	if usersCmd.Flags().HasFlags() {
-		t.Errorf("users command should not have flags, but has: %v", usersCmd.Flags())
+func TestUsersCmd_MachineFlag(t *testing.T) {
+	// users command should only have the --machine filter
+	machineFlag := usersCmd.Flags().Lookup("machine")
+	if machineFlag == nil {
+		t.Fatal("users command should have a --machine flag")
+	}
+
+	if machineFlag.DefValue != "" {
+		t.Errorf("--machine flag default value should be empty, got %s", machineFlag.DefValue)
 	}
 }
@@ -124,11 +124,11 @@ jobs:
           CUTOFF_DATE=$(date -d "$RETENTION_DAYS days ago" +%%s)
           echo "Cutoff date: $(date -d "@$CUTOFF_DATE")"
           
-          # Fetch all backup refs
-          git fetch origin 'refs/backups/*:refs/backups/*' || true
+          # Fetch all backup refs, including the legacy refs/backups/ layout
+          git fetch origin 'refs/ghost-backup/backups/*:refs/ghost-backup/backups/*' 'refs/backups/*:refs/backups/*' || true
           
           # Count refs
-          TOTAL_REFS=$(git for-each-ref --format='%%(refname)' refs/backups/ | wc -l)
+          TOTAL_REFS=$(git for-each-ref --format='%%(refname)' refs/ghost-backup/backups/ refs/backups/ | wc -l)
           echo "Total backup refs: $TOTAL_REFS"
           
           if [ "$TOTAL_REFS" -eq 0 ]; then
@@ -139,16 +139,15 @@ jobs:
           # Find and delete old refs
           DELETED_COUNT=0
           
-          git for-each-ref --format='%%(refname) %%(creatordate:unix)' refs/backups/ | while read ref timestamp; do
+          git for-each-ref --format='%%(refname) %%(creatordate:unix)' refs/ghost-backup/backups/ refs/backups/ | while read ref timestamp; do
             if [ "$timestamp" -lt "$CUTOFF_DATE" ]; then
               echo "Deleting old ref: $ref ($(date -d "@$timestamp"))"
               
               # Delete locally
               git update-ref -d "$ref" || true
               
-              # Delete from remote
-              REMOTE_REF="${ref#refs/backups/}"
-              git push origin --delete "refs/backups/$REMOTE_REF" 2>/dev/null || echo "Warning: Failed to delete $ref from remote"
+              # Delete from remote, where the ref has the same name
+              git push origin --delete "$ref" 2>/dev/null || echo "Warning: Failed to delete $ref from remote"
               
               DELETED_COUNT=$((DELETED_COUNT + 1))
             else
@@ -3,6 +3,8 @@ module github.com/FmTod/ghost-backup
 go 1.24.0
 
 require (
+	filippo.io/age v1.2.1
+	github.com/fsnotify/fsnotify v1.10.1
 	github.com/kardianos/service v1.2.2
 	github.com/spf13/cobra v1.8.0
 	golang.org/x/term v0.38.0
@@ -11,5 +13,6 @@ require (
 require (
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
 	github.com/spf13/pflag v1.0.5 // indirect
+	golang.org/x/crypto v0.24.0 // indirect
 	golang.org/x/sys v0.39.0 // indirect
 )
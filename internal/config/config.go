@@ -5,7 +5,12 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
+
+	"github.com/FmTod/ghost-backup/internal/encryption"
+	"github.com/FmTod/ghost-backup/internal/s3"
 )
 
 // Registry holds the global list of repositories being monitored
@@ -18,21 +23,266 @@ type Registry struct {
 type GlobalConfig struct {
 	GitUser  string `json:"git_user,omitempty"`  // Git username for authentication
 	GitToken string `json:"git_token,omitempty"` // Git personal access token for authentication
+
+	// Path globs never backed up in any repository, on top of DefaultExcludes
+	Exclude []string `json:"exclude,omitempty"`
+
+	// Give each machine its own backup refs, so several machines backing up the same branch don't
+	// interfere; the machine is named by MachineID, or the hostname when it's empty
+	PerMachineRefs bool   `json:"per_machine_refs,omitempty"`
+	MachineID      string `json:"machine_id,omitempty"` // Setting it also enables per-machine refs
+
+	// Remotes backups are pushed to in every repository without its own; see BackupRemotes
+	BackupRemote  string   `json:"backup_remote,omitempty"`
+	BackupRemotes []string `json:"backup_remotes,omitempty"`
+
+	// Object storage used by s3://<bucket>[/<prefix>] backup remotes
+	S3 *S3Config `json:"s3,omitempty"`
+
+	// Client-side encryption of snapshots before they are stored in any backup remote
+	Encryption *EncryptionConfig `json:"encryption,omitempty"`
+}
+
+// S3Config holds the endpoint and credentials of S3-compatible object storage
+// Empty fields fall back to the standard AWS environment variables (AWS_ENDPOINT_URL, AWS_ACCESS_KEY_ID, ...)
+type S3Config struct {
+	Endpoint        string `json:"endpoint,omitempty"` // e.g. http://localhost:9000 for MinIO; defaults to AWS
+	Region          string `json:"region,omitempty"`   // Defaults to us-east-1
+	AccessKeyID     string `json:"access_key_id,omitempty"`
+	SecretAccessKey string `json:"secret_access_key,omitempty"`
+}
+
+// EncryptionConfig holds the age keys or passphrase snapshots are encrypted with
+// Use either keys (recipients and/or an identity file) or a passphrase
+type EncryptionConfig struct {
+	Recipients   []string `json:"recipients,omitempty"`    // Public keys (age1...) snapshots are encrypted to, e.g. teammates or a recovery key
+	IdentityFile string   `json:"identity_file,omitempty"` // Private key file used to decrypt; its public key is a recipient too
+	Passphrase   string   `json:"passphrase,omitempty"`    // Derive the key from a passphrase instead
 }
 
 // LocalConfig represents the per-repository configuration
 type LocalConfig struct {
-	Interval    int  `json:"interval"`     // Backup interval in minutes
-	ScanSecrets bool `json:"scan_secrets"` // Whether to scan for secrets using gitleaks
-	OnlyStaged  bool `json:"only_staged"`  // Whether to backup only staged changes
+	Interval         int    `json:"interval"`          // Backup interval in minutes
+	ScanSecrets      bool   `json:"scan_secrets"`      // Whether to scan for secrets (gitleaks, or the built-in scanner)
+	OnSecret         string `json:"on_secret"`         // What to do when secrets are detected: block, warn or exclude
+	OnlyStaged       bool   `json:"only_staged"`       // Whether to backup only staged changes
+	IncludeUntracked bool   `json:"include_untracked"` // Whether to include untracked (not ignored) files
+	Watch            bool   `json:"watch"`             // Whether to back up after filesystem changes settle
+	WatchDebounce    int    `json:"watch_debounce"`    // Quiet period in seconds after the last write before a watch backup
+	WatchMinSpacing  int    `json:"watch_min_spacing"` // Minimum seconds between watch-triggered backups
+	MaxDiffSize      int    `json:"max_diff_size"`     // Largest diff in MB streamed to the scanners, 0 for no limit
+	OnLargeDiff      string `json:"on_large_diff"`     // What to do with a larger diff: abort, skip or truncate
+	MaxFileSize      int    `json:"max_file_size"`     // Largest file in MB pushed in a snapshot, 0 for no limit
+	MaxSnapshotSize  int    `json:"max_snapshot_size"` // Largest snapshot in MB pushed, 0 for no limit
+	OnOversize       string `json:"on_oversize"`       // What to do past either limit: exclude or abort
+
+	// Scanners run in order on every snapshot; empty means gitleaks, or the built-in scanner without it
+	Scanners []ScannerConfig `json:"scanners,omitempty"`
+
+	// Path globs to back up, empty for the whole repository; useful to limit backups to parts of a monorepo
+	Include []string `json:"include,omitempty"`
+	// Path globs never backed up, on top of DefaultExcludes and the global ones; "!" re-includes a path
+	Exclude []string `json:"exclude,omitempty"`
+
+	// Remote name or URL backups are pushed to instead of the repository's default remote
+	BackupRemote string `json:"backup_remote,omitempty"`
+	// More remotes the same snapshots are pushed to, for redundancy
+	BackupRemotes []string `json:"backup_remotes,omitempty"`
 }
 
+// ScannerConfig configures one secret scanner of a repository's scanner chain
+type ScannerConfig struct {
+	Type    string `json:"type"`              // gitleaks, native or command
+	Name    string `json:"name,omitempty"`    // Label used in logs, defaults to the type or program name
+	Timeout int    `json:"timeout,omitempty"` // Seconds before the scan is aborted, 0 for DefaultScannerTimeout
+
+	// External command scanners only
+	Command           []string `json:"command,omitempty"`             // argv; "{diff}" is replaced by a file holding the diff, otherwise it's passed on stdin
+	FindingsExitCodes []int    `json:"findings_exit_codes,omitempty"` // Exit codes meaning secrets were found (default [1]); 0 means clean, others are errors
+	Format            string   `json:"format,omitempty"`              // Findings format on stdout: gitleaks or trufflehog; empty to rely on the exit code only
+}
+
+// Values for ScannerConfig.Type
 const (
-	DefaultInterval    = 60
-	DefaultScanSecrets = true
-	DefaultOnlyStaged  = false
+	ScannerGitleaks = "gitleaks"
+	ScannerNative   = "native"
+	ScannerCommand  = "command"
 )
 
+// Values for LocalConfig.OnLargeDiff
+const (
+	OnLargeDiffAbort    = "abort"    // Fail the backup
+	OnLargeDiffSkip     = "skip"     // Push without scanning
+	OnLargeDiffTruncate = "truncate" // Scan only the first max_diff_size MB
+)
+
+// Values for LocalConfig.OnOversize
+const (
+	OnOversizeExclude = "exclude" // Drop the largest files from the snapshot until it fits
+	OnOversizeAbort   = "abort"   // Fail the backup
+)
+
+// Values for ScannerConfig.Format
+const (
+	FindingsFormatNone       = ""
+	FindingsFormatGitleaks   = "gitleaks"   // JSON array as written by gitleaks --report-format json
+	FindingsFormatTrufflehog = "trufflehog" // JSON lines as written by trufflehog --json
+)
+
+// Values for LocalConfig.OnSecret
+const (
+	OnSecretBlock   = "block"   // Abort the backup (fail closed)
+	OnSecretWarn    = "warn"    // Log the findings and push anyway
+	OnSecretExclude = "exclude" // Drop the files with findings from the snapshot and push the rest
+)
+
+const (
+	DefaultInterval         = 60
+	DefaultScanSecrets      = true
+	DefaultOnSecret         = OnSecretBlock
+	DefaultOnlyStaged       = false
+	DefaultIncludeUntracked = false
+	DefaultWatch            = false
+	DefaultWatchDebounce    = 30
+	DefaultWatchMinSpacing  = 300
+	DefaultScannerTimeout   = 60  // Seconds
+	DefaultMaxDiffSize      = 100 // MB
+	DefaultOnLargeDiff      = OnLargeDiffAbort
+	DefaultMaxFileSize      = 100 // MB
+	DefaultMaxSnapshotSize  = 500 // MB
+	DefaultOnOversize       = OnOversizeExclude
+)
+
+// DefaultExcludes are the path globs never backed up, whatever the secret scanners say.
+// They use .gitignore syntax: a pattern without a slash matches the name at any depth.
+var DefaultExcludes = []string{
+	".env*",
+	"*.pem",
+	"*.key",
+	"*.p12",
+	"*.pfx",
+	"id_rsa*",
+	"id_dsa*",
+	"id_ecdsa*",
+	"id_ed25519*",
+	"*.kdbx",
+	"*.tfstate",
+	"*.tfstate.*",
+}
+
+// ExcludePatterns returns the exclude globs in effect for a repository, in order of precedence:
+// DefaultExcludes, then the global ones, then the repository's. A later "!" pattern re-includes
+// paths matched by an earlier one.
+func ExcludePatterns(global *GlobalConfig, local *LocalConfig) []string {
+	patterns := slices.Clone(DefaultExcludes)
+	if global != nil {
+		patterns = append(patterns, global.Exclude...)
+	}
+	if local != nil {
+		patterns = append(patterns, local.Exclude...)
+	}
+	return patterns
+}
+
+// BackupRemotes returns the remotes backups are pushed to, primary first: backup_remote followed by
+// backup_remotes, without duplicates. The local config's apply when it sets either, otherwise the
+// global config's. Empty means the repository's default remote.
+func BackupRemotes(global *GlobalConfig, local *LocalConfig) []string {
+	var remotes []string
+	switch {
+	case local != nil && (local.BackupRemote != "" || len(local.BackupRemotes) > 0):
+		remotes = append([]string{local.BackupRemote}, local.BackupRemotes...)
+	case global != nil:
+		remotes = append([]string{global.BackupRemote}, global.BackupRemotes...)
+	}
+
+	var unique []string
+	for _, remote := range remotes {
+		if remote != "" && !slices.Contains(unique, remote) {
+			unique = append(unique, remote)
+		}
+	}
+	return unique
+}
+
+// validateRemotes checks backup_remotes for empty entries
+func validateRemotes(remotes []string) error {
+	for i, remote := range remotes {
+		if strings.TrimSpace(remote) == "" {
+			return fmt.Errorf("invalid backup_remotes[%d]: empty remote", i)
+		}
+	}
+	return nil
+}
+
+// validatePaths checks include or exclude globs for empty patterns
+func validatePaths(field string, patterns []string) error {
+	for i, pattern := range patterns {
+		if strings.TrimPrefix(pattern, "!") == "" {
+			return fmt.Errorf("invalid %s[%d]: empty pattern", field, i)
+		}
+	}
+	return nil
+}
+
+// Machine returns the name of this machine in backup refs, or "" when backups are shared between
+// machines. It is MachineID if set, otherwise the short hostname when PerMachineRefs is enabled.
+func (c *GlobalConfig) Machine() (string, error) {
+	if c == nil {
+		return "", nil
+	}
+	if c.MachineID != "" {
+		return c.MachineID, nil
+	}
+	if !c.PerMachineRefs {
+		return "", nil
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", fmt.Errorf("failed to get hostname for per-machine refs, set machine_id instead: %w", err)
+	}
+	hostname, _, _ = strings.Cut(hostname, ".")
+	if hostname == "" {
+		return "", fmt.Errorf("empty hostname for per-machine refs, set machine_id instead")
+	}
+	return hostname, nil
+}
+
+// S3Options returns the options of the S3 client used by s3:// backup remotes
+func (c *GlobalConfig) S3Options() s3.Options {
+	if c == nil || c.S3 == nil {
+		return s3.Options{}
+	}
+	return s3.Options{
+		Endpoint:        c.S3.Endpoint,
+		Region:          c.S3.Region,
+		AccessKeyID:     c.S3.AccessKeyID,
+		SecretAccessKey: c.S3.SecretAccessKey,
+	}
+}
+
+// EncryptionOptions returns the options snapshots are encrypted with; zero when encryption is off
+func (c *GlobalConfig) EncryptionOptions() encryption.Options {
+	if c == nil || c.Encryption == nil {
+		return encryption.Options{}
+	}
+	return encryption.Options{
+		Recipients:   c.Encryption.Recipients,
+		IdentityFile: c.Encryption.IdentityFile,
+		Passphrase:   c.Encryption.Passphrase,
+	}
+}
+
+// GetIdentityPath returns the default path of the identity file created by "config generate-key"
+func GetIdentityPath() (string, error) {
+	configDir, err := GetConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, "identity.txt"), nil
+}
+
 // GetConfigDir returns the global config directory path
 func GetConfigDir() (string, error) {
 	homeDir, err := os.UserHomeDir()
@@ -42,6 +292,20 @@ func GetConfigDir() (string, error) {
 	return filepath.Join(homeDir, ".config", "ghost-backup"), nil
 }
 
+// GetStateDir returns the directory for runtime state (logs, control socket)
+// Uses $STATE_DIRECTORY when running as a systemd service, otherwise ~/.local/state/ghost-backup
+func GetStateDir() (string, error) {
+	if stateDir := os.Getenv("STATE_DIRECTORY"); stateDir != "" {
+		return stateDir, nil
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get home directory: %w", err)
+	}
+	return filepath.Join(homeDir, ".local", "state", "ghost-backup"), nil
+}
+
 // GetRegistryPath returns the path to the global registry file
 func GetRegistryPath() (string, error) {
 	configDir, err := GetConfigDir()
@@ -92,6 +356,12 @@ func LoadGlobalConfig() (*GlobalConfig, error) {
 	if err := json.Unmarshal(data, config); err != nil {
 		return nil, fmt.Errorf("failed to parse global config: %w", err)
 	}
+	if err := validatePaths("exclude", config.Exclude); err != nil {
+		return nil, fmt.Errorf("invalid global config: %w", err)
+	}
+	if err := validateRemotes(config.BackupRemotes); err != nil {
+		return nil, fmt.Errorf("invalid global config: %w", err)
+	}
 
 	return config, nil
 }
@@ -230,9 +500,19 @@ func LoadLocalConfig(repoPath string) (*LocalConfig, error) {
 
 	// Default config
 	config := &LocalConfig{
-		Interval:    DefaultInterval,
-		ScanSecrets: DefaultScanSecrets,
-		OnlyStaged:  DefaultOnlyStaged,
+		Interval:         DefaultInterval,
+		ScanSecrets:      DefaultScanSecrets,
+		OnSecret:         DefaultOnSecret,
+		OnlyStaged:       DefaultOnlyStaged,
+		IncludeUntracked: DefaultIncludeUntracked,
+		Watch:            DefaultWatch,
+		WatchDebounce:    DefaultWatchDebounce,
+		WatchMinSpacing:  DefaultWatchMinSpacing,
+		MaxDiffSize:      DefaultMaxDiffSize,
+		OnLargeDiff:      DefaultOnLargeDiff,
+		MaxFileSize:      DefaultMaxFileSize,
+		MaxSnapshotSize:  DefaultMaxSnapshotSize,
+		OnOversize:       DefaultOnOversize,
 	}
 
 	// If a config file doesn't exist, return default
@@ -249,19 +529,123 @@ func LoadLocalConfig(repoPath string) (*LocalConfig, error) {
 		return nil, fmt.Errorf("failed to parse local config: %w", err)
 	}
 
+	switch config.OnSecret {
+	case OnSecretBlock, OnSecretWarn, OnSecretExclude:
+	case "":
+		config.OnSecret = DefaultOnSecret
+	default:
+		return nil, fmt.Errorf("invalid on_secret value %q (expected %q, %q or %q)", config.OnSecret, OnSecretBlock, OnSecretWarn, OnSecretExclude)
+	}
+
+	switch config.OnLargeDiff {
+	case OnLargeDiffAbort, OnLargeDiffSkip, OnLargeDiffTruncate:
+	case "":
+		config.OnLargeDiff = DefaultOnLargeDiff
+	default:
+		return nil, fmt.Errorf("invalid on_large_diff value %q (expected %q, %q or %q)", config.OnLargeDiff, OnLargeDiffAbort, OnLargeDiffSkip, OnLargeDiffTruncate)
+	}
+	if config.MaxDiffSize < 0 {
+		return nil, fmt.Errorf("invalid max_diff_size %d (expected 0 for no limit or a size in MB)", config.MaxDiffSize)
+	}
+
+	switch config.OnOversize {
+	case OnOversizeExclude, OnOversizeAbort:
+	case "":
+		config.OnOversize = DefaultOnOversize
+	default:
+		return nil, fmt.Errorf("invalid on_oversize value %q (expected %q or %q)", config.OnOversize, OnOversizeExclude, OnOversizeAbort)
+	}
+	if config.MaxFileSize < 0 {
+		return nil, fmt.Errorf("invalid max_file_size %d (expected 0 for no limit or a size in MB)", config.MaxFileSize)
+	}
+	if config.MaxSnapshotSize < 0 {
+		return nil, fmt.Errorf("invalid max_snapshot_size %d (expected 0 for no limit or a size in MB)", config.MaxSnapshotSize)
+	}
+
+	for i, scanner := range config.Scanners {
+		if err := scanner.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid scanners[%d]: %w", i, err)
+		}
+	}
+	if err := validatePaths("include", config.Include); err != nil {
+		return nil, err
+	}
+	if err := validatePaths("exclude", config.Exclude); err != nil {
+		return nil, err
+	}
+	if err := validateRemotes(config.BackupRemotes); err != nil {
+		return nil, err
+	}
+
 	return config, nil
 }
 
+// Validate checks that a scanner is fully configured
+func (s ScannerConfig) Validate() error {
+	switch s.Type {
+	case ScannerGitleaks, ScannerNative:
+		if len(s.Command) > 0 || s.Format != FindingsFormatNone || len(s.FindingsExitCodes) > 0 {
+			return fmt.Errorf("command, format and findings_exit_codes only apply to %q scanners", ScannerCommand)
+		}
+	case ScannerCommand:
+		if len(s.Command) == 0 || s.Command[0] == "" {
+			return fmt.Errorf("command scanner needs a command")
+		}
+		switch s.Format {
+		case FindingsFormatNone, FindingsFormatGitleaks, FindingsFormatTrufflehog:
+		default:
+			return fmt.Errorf("invalid format %q (expected %q or %q)", s.Format, FindingsFormatGitleaks, FindingsFormatTrufflehog)
+		}
+		for _, code := range s.FindingsExitCodes {
+			if code <= 0 {
+				return fmt.Errorf("invalid findings exit code %d (0 always means clean)", code)
+			}
+		}
+	default:
+		return fmt.Errorf("invalid type %q (expected %q, %q or %q)", s.Type, ScannerGitleaks, ScannerNative, ScannerCommand)
+	}
+
+	if s.Timeout < 0 {
+		return fmt.Errorf("timeout must not be negative")
+	}
+	return nil
+}
+
 // SaveLocalConfig saves the local configuration to a repository
 func SaveLocalConfig(repoPath string, config *LocalConfig) error {
 	configPath := GetLocalConfigPath(repoPath)
 
 	// Create a map to include the $schema field
 	configWithSchema := map[string]interface{}{
-		"$schema":      "https://raw.githubusercontent.com/FmTod/ghost-backup/main/config.schema.json",
-		"interval":     config.Interval,
-		"scan_secrets": config.ScanSecrets,
-		"only_staged":  config.OnlyStaged,
+		"$schema":           "https://raw.githubusercontent.com/FmTod/ghost-backup/main/config.schema.json",
+		"interval":          config.Interval,
+		"scan_secrets":      config.ScanSecrets,
+		"on_secret":         config.OnSecret,
+		"only_staged":       config.OnlyStaged,
+		"include_untracked": config.IncludeUntracked,
+		"watch":             config.Watch,
+		"watch_debounce":    config.WatchDebounce,
+		"watch_min_spacing": config.WatchMinSpacing,
+		"max_diff_size":     config.MaxDiffSize,
+		"on_large_diff":     config.OnLargeDiff,
+		"max_file_size":     config.MaxFileSize,
+		"max_snapshot_size": config.MaxSnapshotSize,
+		"on_oversize":       config.OnOversize,
+	}
+	if len(config.Scanners) > 0 {
+		configWithSchema["scanners"] = config.Scanners
+	}
+	if len(config.Include) > 0 {
+		configWithSchema["include"] = config.Include
+	}
+	if len(config.Exclude) > 0 {
+		configWithSchema["exclude"] = config.Exclude
+	}
+	if config.BackupRemote != "" {
+		configWithSchema["backup_remote"] = config.BackupRemote
+	}
+	if len(config.BackupRemotes) > 0 {
+		configWithSchema["backup_remotes"] = config.BackupRemotes
 	}
 
 	data, err := json.MarshalIndent(configWithSchema, "", "  ")
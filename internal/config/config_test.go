@@ -4,7 +4,11 @@ import (
 	"encoding/json"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+
+	"github.com/FmTod/ghost-backup/internal/s3"
 )
 
 func TestGetConfigDir(t *testing.T) {
@@ -190,3 +194,314 @@ func TestSaveLocalConfig(t *testing.T) {
 		t.Errorf("Saved ScanSecrets = %v, want true", loadedConfig.ScanSecrets)
 	}
 }
+
+func TestSaveLocalConfig_IncludeUntracked(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	config := &LocalConfig{
+		Interval:         30,
+		ScanSecrets:      true,
+		IncludeUntracked: true,
+	}
+
+	if err := SaveLocalConfig(tmpDir, config); err != nil {
+		t.Fatalf("SaveLocalConfig() error = %v", err)
+	}
+
+	loadedConfig, err := LoadLocalConfig(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to load saved config: %v", err)
+	}
+
+	if !loadedConfig.IncludeUntracked {
+		t.Error("Saved IncludeUntracked = false, want true")
+	}
+
+	defaults, err := LoadLocalConfig(t.TempDir())
+	if err != nil {
+		t.Fatalf("LoadLocalConfig() error = %v", err)
+	}
+	if defaults.IncludeUntracked != DefaultIncludeUntracked {
+		t.Errorf("Default IncludeUntracked = %v, want %v", defaults.IncludeUntracked, DefaultIncludeUntracked)
+	}
+}
+
+func TestLoadLocalConfig_OnSecret(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+		wantErr bool
+	}{
+		{"missing defaults to block", `{"interval": 30}`, OnSecretBlock, false},
+		{"empty defaults to block", `{"on_secret": ""}`, OnSecretBlock, false},
+		{"warn", `{"on_secret": "warn"}`, OnSecretWarn, false},
+		{"exclude", `{"on_secret": "exclude"}`, OnSecretExclude, false},
+		{"invalid", `{"on_secret": "ignore"}`, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			if err := os.WriteFile(GetLocalConfigPath(tmpDir), []byte(tt.content), 0644); err != nil {
+				t.Fatalf("Failed to write config: %v", err)
+			}
+
+			cfg, err := LoadLocalConfig(tmpDir)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("LoadLocalConfig() should fail for an invalid on_secret value")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadLocalConfig() error = %v", err)
+			}
+			if cfg.OnSecret != tt.want {
+				t.Errorf("OnSecret = %s, want %s", cfg.OnSecret, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadLocalConfig_Scanners(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{"default chain", `{"interval": 30}`, false},
+		{"gitleaks and command", `{"scanners": [{"type": "gitleaks", "timeout": 120}, {"type": "command", "command": ["trufflehog", "filesystem", "{diff}", "--json"], "format": "trufflehog", "findings_exit_codes": [183]}]}`, false},
+		{"unknown type", `{"scanners": [{"type": "detect-secrets"}]}`, true},
+		{"command without argv", `{"scanners": [{"type": "command"}]}`, true},
+		{"unknown format", `{"scanners": [{"type": "command", "command": ["scan"], "format": "sarif"}]}`, true},
+		{"zero exit code", `{"scanners": [{"type": "command", "command": ["scan"], "findings_exit_codes": [0]}]}`, true},
+		{"command options on gitleaks", `{"scanners": [{"type": "gitleaks", "command": ["gitleaks"]}]}`, true},
+		{"negative timeout", `{"scanners": [{"type": "native", "timeout": -1}]}`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			if err := os.WriteFile(GetLocalConfigPath(tmpDir), []byte(tt.content), 0644); err != nil {
+				t.Fatalf("Failed to write config: %v", err)
+			}
+
+			_, err := LoadLocalConfig(tmpDir)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("LoadLocalConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadLocalConfig_LargeDiff(t *testing.T) {
+	tests := []struct {
+		name       string
+		content    string
+		wantSize   int
+		wantPolicy string
+		wantErr    bool
+	}{
+		{"defaults", `{"interval": 30}`, DefaultMaxDiffSize, OnLargeDiffAbort, false},
+		{"truncate", `{"max_diff_size": 10, "on_large_diff": "truncate"}`, 10, OnLargeDiffTruncate, false},
+		{"no limit", `{"max_diff_size": 0, "on_large_diff": "skip"}`, 0, OnLargeDiffSkip, false},
+		{"empty policy defaults to abort", `{"on_large_diff": ""}`, DefaultMaxDiffSize, OnLargeDiffAbort, false},
+		{"invalid policy", `{"on_large_diff": "split"}`, 0, "", true},
+		{"negative size", `{"max_diff_size": -1}`, 0, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			if err := os.WriteFile(GetLocalConfigPath(tmpDir), []byte(tt.content), 0644); err != nil {
+				t.Fatalf("Failed to write config: %v", err)
+			}
+
+			cfg, err := LoadLocalConfig(tmpDir)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("LoadLocalConfig() should fail for an invalid diff size policy")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadLocalConfig() error = %v", err)
+			}
+			if cfg.MaxDiffSize != tt.wantSize || cfg.OnLargeDiff != tt.wantPolicy {
+				t.Errorf("MaxDiffSize, OnLargeDiff = %d, %s, want %d, %s", cfg.MaxDiffSize, cfg.OnLargeDiff, tt.wantSize, tt.wantPolicy)
+			}
+		})
+	}
+}
+
+func TestLoadLocalConfig_SizeLimits(t *testing.T) {
+	tests := []struct {
+		name         string
+		content      string
+		wantFile     int
+		wantSnapshot int
+		wantPolicy   string
+		wantErr      bool
+	}{
+		{"defaults", `{"interval": 30}`, DefaultMaxFileSize, DefaultMaxSnapshotSize, OnOversizeExclude, false},
+		{"abort", `{"max_file_size": 5, "max_snapshot_size": 20, "on_oversize": "abort"}`, 5, 20, OnOversizeAbort, false},
+		{"no limits", `{"max_file_size": 0, "max_snapshot_size": 0}`, 0, 0, OnOversizeExclude, false},
+		{"empty policy defaults to exclude", `{"on_oversize": ""}`, DefaultMaxFileSize, DefaultMaxSnapshotSize, OnOversizeExclude, false},
+		{"invalid policy", `{"on_oversize": "skip"}`, 0, 0, "", true},
+		{"negative file size", `{"max_file_size": -1}`, 0, 0, "", true},
+		{"negative snapshot size", `{"max_snapshot_size": -1}`, 0, 0, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			if err := os.WriteFile(GetLocalConfigPath(tmpDir), []byte(tt.content), 0644); err != nil {
+				t.Fatalf("Failed to write config: %v", err)
+			}
+
+			cfg, err := LoadLocalConfig(tmpDir)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("LoadLocalConfig() should fail for invalid size limits")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadLocalConfig() error = %v", err)
+			}
+			if cfg.MaxFileSize != tt.wantFile || cfg.MaxSnapshotSize != tt.wantSnapshot || cfg.OnOversize != tt.wantPolicy {
+				t.Errorf("MaxFileSize, MaxSnapshotSize, OnOversize = %d, %d, %s, want %d, %d, %s",
+					cfg.MaxFileSize, cfg.MaxSnapshotSize, cfg.OnOversize, tt.wantFile, tt.wantSnapshot, tt.wantPolicy)
+			}
+		})
+	}
+}
+
+func TestGlobalConfig_Machine(t *testing.T) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Skipf("no hostname: %v", err)
+	}
+	shortHostname, _, _ := strings.Cut(hostname, ".")
+
+	tests := []struct {
+		name   string
+		config *GlobalConfig
+		want   string
+	}{
+		{"nil config", nil, ""},
+		{"shared refs", &GlobalConfig{}, ""},
+		{"hostname", &GlobalConfig{PerMachineRefs: true}, shortHostname},
+		{"machine id", &GlobalConfig{MachineID: "desktop"}, "desktop"},
+		{"machine id wins over hostname", &GlobalConfig{PerMachineRefs: true, MachineID: "desktop"}, "desktop"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.config.Machine()
+			if err != nil {
+				t.Fatalf("Machine() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Machine() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExcludePatterns(t *testing.T) {
+	global := &GlobalConfig{Exclude: []string{"*.sqlite"}}
+	local := &LocalConfig{Exclude: []string{"!.env.example"}}
+
+	patterns := ExcludePatterns(global, local)
+	if len(patterns) != len(DefaultExcludes)+2 {
+		t.Fatalf("ExcludePatterns() returned %d patterns, want %d", len(patterns), len(DefaultExcludes)+2)
+	}
+	if patterns[len(patterns)-2] != "*.sqlite" || patterns[len(patterns)-1] != "!.env.example" {
+		t.Errorf("ExcludePatterns() = %v, want defaults, then global, then local patterns", patterns)
+	}
+	if patterns[0] != DefaultExcludes[0] {
+		t.Errorf("ExcludePatterns() should start with the defaults, got %v", patterns)
+	}
+
+	tmpDir := t.TempDir()
+	if err := os.WriteFile(GetLocalConfigPath(tmpDir), []byte(`{"exclude": ["secrets/", "!"]}`), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	if _, err := LoadLocalConfig(tmpDir); err == nil {
+		t.Error("LoadLocalConfig() should fail for an empty exclude pattern")
+	}
+}
+
+func TestBackupRemotes(t *testing.T) {
+	tests := []struct {
+		name   string
+		global *GlobalConfig
+		local  *LocalConfig
+		want   []string
+	}{
+		{"none", &GlobalConfig{}, &LocalConfig{}, nil},
+		{"global", &GlobalConfig{BackupRemote: "backup"}, &LocalConfig{}, []string{"backup"}},
+		{
+			"local overrides global",
+			&GlobalConfig{BackupRemote: "backup"},
+			&LocalConfig{BackupRemotes: []string{"mirror"}},
+			[]string{"mirror"},
+		},
+		{
+			"primary first without duplicates",
+			nil,
+			&LocalConfig{BackupRemote: "origin", BackupRemotes: []string{"git@internal:team/repo.git", "origin"}},
+			[]string{"origin", "git@internal:team/repo.git"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := BackupRemotes(tt.global, tt.local); !slices.Equal(got, tt.want) {
+				t.Errorf("BackupRemotes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	tmpDir := t.TempDir()
+	if err := os.WriteFile(GetLocalConfigPath(tmpDir), []byte(`{"backup_remotes": ["mirror", " "]}`), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	if _, err := LoadLocalConfig(tmpDir); err == nil {
+		t.Error("LoadLocalConfig() should fail for an empty backup remote")
+	}
+}
+
+func TestGlobalConfig_S3Options(t *testing.T) {
+	var config GlobalConfig
+	data := `{"s3": {"endpoint": "http://localhost:9000", "access_key_id": "key", "secret_access_key": "secret"}}`
+	if err := json.Unmarshal([]byte(data), &config); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+
+	want := s3.Options{Endpoint: "http://localhost:9000", AccessKeyID: "key", SecretAccessKey: "secret"}
+	if got := config.S3Options(); got != want {
+		t.Errorf("S3Options() = %+v, want %+v", got, want)
+	}
+	if got := (&GlobalConfig{}).S3Options(); got != (s3.Options{}) {
+		t.Errorf("S3Options() without s3 = %+v, want none", got)
+	}
+}
+
+func TestGlobalConfig_EncryptionOptions(t *testing.T) {
+	var config GlobalConfig
+	data := `{"encryption": {"recipients": ["age1abc"], "identity_file": "/home/dev/identity.txt"}}`
+	if err := json.Unmarshal([]byte(data), &config); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+
+	got := config.EncryptionOptions()
+	if !got.Enabled() || got.IdentityFile != "/home/dev/identity.txt" || len(got.Recipients) != 1 || got.Recipients[0] != "age1abc" {
+		t.Errorf("EncryptionOptions() = %+v, want the recipient and identity file", got)
+	}
+	if got := (&GlobalConfig{}).EncryptionOptions(); got.Enabled() {
+		t.Errorf("EncryptionOptions() without encryption = %+v, want disabled", got)
+	}
+}
@@ -0,0 +1,281 @@
+package control
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/FmTod/ghost-backup/internal/config"
+	"github.com/FmTod/ghost-backup/internal/worker"
+)
+
+// Actions understood by the control socket
+const (
+	ActionStatus  = "status"
+	ActionTrigger = "trigger"
+	ActionPause   = "pause"
+	ActionResume  = "resume"
+	ActionReload  = "reload"
+)
+
+const (
+	// socketFileName is the name of the control socket inside the state directory
+	socketFileName = "ghost-backup.sock"
+	// requestTimeout bounds how long a single request may take on either side
+	requestTimeout = 5 * time.Second
+)
+
+// Request is a single command sent to the daemon
+// Repo is the absolute repository path; an empty Repo targets the whole daemon for pause/resume
+type Request struct {
+	Action string `json:"action"`
+	Repo   string `json:"repo,omitempty"`
+}
+
+// Response is the daemon's answer to a Request
+type Response struct {
+	OK      bool                  `json:"ok"`
+	Error   string                `json:"error,omitempty"`
+	Paused  bool                  `json:"paused"`
+	Workers []worker.WorkerStatus `json:"workers,omitempty"`
+}
+
+// GetSocketPath returns the path of the daemon control socket
+func GetSocketPath() (string, error) {
+	stateDir, err := config.GetStateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(stateDir, socketFileName), nil
+}
+
+// Server exposes a worker.Manager over a Unix socket
+type Server struct {
+	manager  *worker.Manager
+	logger   *log.Logger
+	listener net.Listener
+	wg       sync.WaitGroup
+}
+
+// NewServer creates a control server for a worker manager
+func NewServer(manager *worker.Manager, logger *log.Logger) *Server {
+	return &Server{
+		manager: manager,
+		logger:  logger,
+	}
+}
+
+// Start listens on the socket path and serves requests in the background
+// A stale socket left behind by a previous daemon is removed first
+func (s *Server) Start(socketPath string) error {
+	if err := os.MkdirAll(filepath.Dir(socketPath), 0755); err != nil {
+		return fmt.Errorf("failed to create socket directory: %w", err)
+	}
+
+	if _, err := os.Stat(socketPath); err == nil {
+		if NewClient(socketPath).Ping() == nil {
+			return fmt.Errorf("another daemon is already listening on %s", socketPath)
+		}
+		if err := os.Remove(socketPath); err != nil {
+			return fmt.Errorf("failed to remove stale socket: %w", err)
+		}
+	}
+
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return fmt.Errorf("failed to listen on control socket: %w", err)
+	}
+
+	// Only the owning user may control the daemon
+	if err := os.Chmod(socketPath, 0600); err != nil {
+		_ = listener.Close()
+		return fmt.Errorf("failed to set socket permissions: %w", err)
+	}
+
+	s.listener = listener
+	s.wg.Add(1)
+	go s.serve()
+
+	return nil
+}
+
+// Stop closes the listener and waits for in-flight requests to finish
+func (s *Server) Stop() {
+	if s.listener == nil {
+		return
+	}
+	_ = s.listener.Close()
+	s.wg.Wait()
+}
+
+// serve accepts connections until the listener is closed
+func (s *Server) serve() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				s.logger.Printf("Control socket accept failed: %v\n", err)
+			}
+			return
+		}
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handleConn(conn)
+		}()
+	}
+}
+
+// handleConn reads one request, executes it and writes the response
+func (s *Server) handleConn(conn net.Conn) {
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(requestTimeout))
+
+	var req Request
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		_ = json.NewEncoder(conn).Encode(Response{Error: fmt.Sprintf("invalid request: %v", err)})
+		return
+	}
+
+	resp := s.handle(req)
+	if err := json.NewEncoder(conn).Encode(resp); err != nil {
+		s.logger.Printf("Control socket write failed: %v\n", err)
+	}
+}
+
+// handle dispatches a request to the worker manager
+func (s *Server) handle(req Request) Response {
+	var err error
+
+	switch req.Action {
+	case ActionStatus:
+		// Nothing to do, status is always included
+
+	case ActionTrigger:
+		err = s.manager.TriggerBackup(req.Repo)
+
+	case ActionPause:
+		if req.Repo == "" {
+			s.manager.PauseAll()
+		} else {
+			err = s.manager.PauseWorker(req.Repo)
+		}
+
+	case ActionResume:
+		if req.Repo == "" {
+			s.manager.ResumeAll()
+		} else {
+			err = s.manager.ResumeWorker(req.Repo)
+		}
+
+	case ActionReload:
+		var registry *config.Registry
+		registry, err = config.LoadRegistry()
+		if err == nil {
+			s.logger.Printf("Reloading registry via control socket\n")
+			err = s.manager.ReloadWorkers(registry)
+		}
+
+	default:
+		err = fmt.Errorf("unknown action: %s", req.Action)
+	}
+
+	if err != nil {
+		return Response{Error: err.Error()}
+	}
+
+	return Response{
+		OK:      true,
+		Paused:  s.manager.IsPaused(),
+		Workers: s.manager.GetWorkerStatus(),
+	}
+}
+
+// Client talks to a running daemon over its control socket
+type Client struct {
+	socketPath string
+}
+
+// NewClient creates a client for the socket at socketPath
+func NewClient(socketPath string) *Client {
+	return &Client{socketPath: socketPath}
+}
+
+// DefaultClient returns a client for the default socket path
+func DefaultClient() (*Client, error) {
+	socketPath, err := GetSocketPath()
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(socketPath), nil
+}
+
+// Send sends a request to the daemon and returns its response
+// A response with OK=false is returned as an error
+func (c *Client) Send(req Request) (*Response, error) {
+	conn, err := net.DialTimeout("unix", c.socketPath, requestTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("daemon not reachable: %w", err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(requestTimeout))
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+
+	var resp Response
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if !resp.OK {
+		return &resp, fmt.Errorf("daemon error: %s", resp.Error)
+	}
+
+	return &resp, nil
+}
+
+// Ping checks whether the daemon answers on the socket
+func (c *Client) Ping() error {
+	_, err := c.Send(Request{Action: ActionStatus})
+	return err
+}
+
+// Status returns the live status of the daemon's workers
+func (c *Client) Status() (*Response, error) {
+	return c.Send(Request{Action: ActionStatus})
+}
+
+// Trigger requests an immediate backup for a repository
+func (c *Client) Trigger(repoPath string) error {
+	_, err := c.Send(Request{Action: ActionTrigger, Repo: repoPath})
+	return err
+}
+
+// Pause pauses a repository, or the whole daemon when repoPath is empty
+func (c *Client) Pause(repoPath string) error {
+	_, err := c.Send(Request{Action: ActionPause, Repo: repoPath})
+	return err
+}
+
+// Resume resumes a repository, or the whole daemon when repoPath is empty
+func (c *Client) Resume(repoPath string) error {
+	_, err := c.Send(Request{Action: ActionResume, Repo: repoPath})
+	return err
+}
+
+// Reload asks the daemon to re-read the registry
+func (c *Client) Reload() error {
+	_, err := c.Send(Request{Action: ActionReload})
+	return err
+}
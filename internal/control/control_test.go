@@ -0,0 +1,183 @@
+package control
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/FmTod/ghost-backup/internal/config"
+	"github.com/FmTod/ghost-backup/internal/worker"
+)
+
+// startTestServer starts a control server for a manager with the given (unstarted) repositories
+func startTestServer(t *testing.T, repos ...string) (*worker.Manager, *Client) {
+	t.Setenv("STATE_DIRECTORY", t.TempDir())
+	logger := log.New(os.Stdout, "", log.LstdFlags)
+	manager := worker.NewManager(logger)
+
+	// Paused before starting so the workers never run a real backup
+	manager.PauseAll()
+	if err := manager.StartWorkers(&config.Registry{Repositories: repos}); err != nil {
+		t.Fatalf("StartWorkers() error = %v", err)
+	}
+	t.Cleanup(manager.StopWorkers)
+
+	socketPath := filepath.Join(t.TempDir(), "test.sock")
+	server := NewServer(manager, logger)
+	if err := server.Start(socketPath); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	t.Cleanup(server.Stop)
+
+	return manager, NewClient(socketPath)
+}
+
+func TestGetSocketPath(t *testing.T) {
+	t.Setenv("STATE_DIRECTORY", "/tmp/ghost-state")
+
+	path, err := GetSocketPath()
+	if err != nil {
+		t.Fatalf("GetSocketPath() error = %v", err)
+	}
+
+	if path != "/tmp/ghost-state/ghost-backup.sock" {
+		t.Errorf("GetSocketPath() = %s, want /tmp/ghost-state/ghost-backup.sock", path)
+	}
+}
+
+func TestClient_Status(t *testing.T) {
+	repo := t.TempDir()
+	_, client := startTestServer(t, repo)
+
+	resp, err := client.Status()
+	if err != nil {
+		t.Fatalf("Status() error = %v", err)
+	}
+
+	if !resp.Paused {
+		t.Error("Status() Paused = false, want true")
+	}
+
+	if len(resp.Workers) != 1 || resp.Workers[0].RepoPath != repo {
+		t.Errorf("Status() Workers = %v, want one worker for %s", resp.Workers, repo)
+	}
+}
+
+func TestClient_PauseResume(t *testing.T) {
+	repo := t.TempDir()
+	manager, client := startTestServer(t, repo)
+
+	if err := client.Resume(""); err != nil {
+		t.Fatalf("Resume(all) error = %v", err)
+	}
+	if manager.IsPaused() {
+		t.Error("daemon should not be paused after Resume(all)")
+	}
+
+	if err := client.Pause(repo); err != nil {
+		t.Fatalf("Pause(repo) error = %v", err)
+	}
+
+	resp, err := client.Status()
+	if err != nil {
+		t.Fatalf("Status() error = %v", err)
+	}
+	if resp.Paused {
+		t.Error("pausing one repository should not pause the daemon")
+	}
+	if len(resp.Workers) != 1 || !resp.Workers[0].Paused {
+		t.Errorf("worker should be paused, got %v", resp.Workers)
+	}
+
+	if err := client.Resume(repo); err != nil {
+		t.Fatalf("Resume(repo) error = %v", err)
+	}
+	resp, _ = client.Status()
+	if resp.Workers[0].Paused {
+		t.Error("worker should be running after Resume(repo)")
+	}
+
+	// Keep the worker from running a real backup before cleanup
+	manager.PauseAll()
+}
+
+func TestClient_UnknownRepo(t *testing.T) {
+	_, client := startTestServer(t)
+
+	err := client.Trigger("/not/monitored")
+	if err == nil {
+		t.Fatal("Trigger() should fail for a repository that is not monitored")
+	}
+	if !strings.Contains(err.Error(), "not monitored") {
+		t.Errorf("Trigger() error = %v, want 'not monitored'", err)
+	}
+}
+
+func TestClient_UnknownAction(t *testing.T) {
+	_, client := startTestServer(t)
+
+	if _, err := client.Send(Request{Action: "explode"}); err == nil {
+		t.Error("Send() should fail for an unknown action")
+	}
+}
+
+func TestClient_Reload(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	repo := t.TempDir()
+	registry, err := config.LoadRegistry()
+	if err != nil {
+		t.Fatalf("LoadRegistry() error = %v", err)
+	}
+	_ = registry.AddRepository(repo)
+	if err := registry.Save(); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	manager, client := startTestServer(t)
+
+	if err := client.Reload(); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+
+	if manager.GetWorkerCount() != 1 {
+		t.Errorf("GetWorkerCount() = %d after reload, want 1", manager.GetWorkerCount())
+	}
+}
+
+func TestServer_StaleSocket(t *testing.T) {
+	logger := log.New(os.Stdout, "", log.LstdFlags)
+	socketPath := filepath.Join(t.TempDir(), "stale.sock")
+
+	// A leftover file from a crashed daemon must not block startup
+	if err := os.WriteFile(socketPath, nil, 0600); err != nil {
+		t.Fatalf("Failed to create stale socket: %v", err)
+	}
+
+	server := NewServer(worker.NewManager(logger), logger)
+	if err := server.Start(socketPath); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer server.Stop()
+
+	if err := NewClient(socketPath).Ping(); err != nil {
+		t.Errorf("Ping() error = %v", err)
+	}
+
+	// A second daemon must refuse to take over a live socket
+	second := NewServer(worker.NewManager(logger), logger)
+	if err := second.Start(socketPath); err == nil {
+		second.Stop()
+		t.Error("Start() should fail when another daemon is listening")
+	}
+}
+
+func TestClient_DaemonNotRunning(t *testing.T) {
+	client := NewClient(filepath.Join(t.TempDir(), "missing.sock"))
+
+	if err := client.Ping(); err == nil {
+		t.Error("Ping() should fail when no daemon is listening")
+	}
+}
@@ -0,0 +1,181 @@
+// Package encryption encrypts snapshot payloads on the client with age (https://age-encryption.org),
+// either to X25519 recipients or with a key derived from a passphrase (scrypt)
+package encryption
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"filippo.io/age"
+)
+
+// ErrNoIdentity is returned when decrypting without an identity file or passphrase configured
+var ErrNoIdentity = errors.New("no decryption key configured, set identity_file or passphrase in the encryption section of the global config")
+
+// Options configures the encryption of snapshots; the zero value disables it
+type Options struct {
+	Recipients   []string // age public keys (age1...) snapshots are encrypted to
+	IdentityFile string   // age identity file (AGE-SECRET-KEY-1...) used to decrypt; its public keys are recipients too
+	Passphrase   string   // Passphrase the key is derived from, instead of keys
+}
+
+// Enabled reports whether snapshots are encrypted
+func (o Options) Enabled() bool {
+	return len(o.Recipients) > 0 || o.IdentityFile != "" || o.Passphrase != ""
+}
+
+// Validate checks that the options can be used together
+// age only allows a passphrase as the single recipient, so it can't be combined with keys
+func (o Options) Validate() error {
+	if o.Passphrase != "" && (len(o.Recipients) > 0 || o.IdentityFile != "") {
+		return fmt.Errorf("a passphrase can't be combined with recipients or an identity file")
+	}
+	for i, recipient := range o.Recipients {
+		if _, err := age.ParseX25519Recipient(recipient); err != nil {
+			return fmt.Errorf("invalid recipients[%d]: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// Check verifies that snapshots can be encrypted, reading the identity file
+func (o Options) Check() error {
+	_, err := o.recipients()
+	return err
+}
+
+// CanDecrypt reports whether a key to decrypt snapshots is configured
+func (o Options) CanDecrypt() bool {
+	return o.IdentityFile != "" || o.Passphrase != ""
+}
+
+// Scheme returns the name of the scheme snapshots are encrypted with, recorded next to them
+func (o Options) Scheme() string {
+	if o.Passphrase != "" {
+		return "age-scrypt"
+	}
+	return "age-x25519"
+}
+
+// identities reads the identities of the identity file
+func (o Options) identities() ([]age.Identity, error) {
+	if o.Passphrase != "" {
+		identity, err := age.NewScryptIdentity(o.Passphrase)
+		if err != nil {
+			return nil, err
+		}
+		return []age.Identity{identity}, nil
+	}
+	if o.IdentityFile == "" {
+		return nil, nil
+	}
+
+	file, err := os.Open(o.IdentityFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read identity file: %w", err)
+	}
+	defer file.Close()
+
+	identities, err := age.ParseIdentities(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse identity file %s: %w", o.IdentityFile, err)
+	}
+	return identities, nil
+}
+
+// recipients returns who snapshots are encrypted to: the recipients and the public keys of the
+// identity file, or the passphrase
+func (o Options) recipients() ([]age.Recipient, error) {
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+	if o.Passphrase != "" {
+		recipient, err := age.NewScryptRecipient(o.Passphrase)
+		if err != nil {
+			return nil, err
+		}
+		return []age.Recipient{recipient}, nil
+	}
+
+	var recipients []age.Recipient
+	for _, key := range o.Recipients {
+		recipient, err := age.ParseX25519Recipient(key)
+		if err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, recipient)
+	}
+
+	identities, err := o.identities()
+	if err != nil {
+		return nil, err
+	}
+	for _, identity := range identities {
+		if x25519, ok := identity.(*age.X25519Identity); ok {
+			recipients = append(recipients, x25519.Recipient())
+		}
+	}
+
+	if len(recipients) == 0 {
+		return nil, fmt.Errorf("no encryption recipients configured")
+	}
+	return recipients, nil
+}
+
+// Encrypt returns a writer encrypting everything written to it into w
+// It must be closed to write the end of the payload
+func (o Options) Encrypt(w io.Writer) (io.WriteCloser, error) {
+	recipients, err := o.recipients()
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := age.Encrypt(w, recipients...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt: %w", err)
+	}
+	return encrypted, nil
+}
+
+// Decrypt returns a reader decrypting r with the identity file or passphrase
+func (o Options) Decrypt(r io.Reader) (io.Reader, error) {
+	identities, err := o.identities()
+	if err != nil {
+		return nil, err
+	}
+	if len(identities) == 0 {
+		return nil, ErrNoIdentity
+	}
+
+	decrypted, err := age.Decrypt(r, identities...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt: %w", err)
+	}
+	return decrypted, nil
+}
+
+// GenerateIdentity writes a new X25519 identity to a file readable only by its owner and returns
+// its public key; an existing file is never overwritten
+func GenerateIdentity(path string) (string, error) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate identity: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", fmt.Errorf("failed to create identity directory: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", fmt.Errorf("failed to create identity file: %w", err)
+	}
+	defer file.Close()
+
+	recipient := identity.Recipient().String()
+	if _, err := fmt.Fprintf(file, "# public key: %s\n%s\n", recipient, identity); err != nil {
+		return "", fmt.Errorf("failed to write identity file: %w", err)
+	}
+	return recipient, file.Close()
+}
@@ -0,0 +1,104 @@
+package encryption
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// roundTrip encrypts a payload with one set of options and decrypts it with another
+func roundTrip(t *testing.T, encrypt, decrypt Options, payload string) (string, error) {
+	t.Helper()
+
+	var ciphertext bytes.Buffer
+	w, err := encrypt.Encrypt(&ciphertext)
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+	if _, err := io.WriteString(w, payload); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if strings.Contains(ciphertext.String(), payload) {
+		t.Fatal("Ciphertext contains the payload")
+	}
+
+	r, err := decrypt.Decrypt(&ciphertext)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := io.ReadAll(r)
+	return string(plaintext), err
+}
+
+func TestOptions_Keys(t *testing.T) {
+	dir := t.TempDir()
+	own := filepath.Join(dir, "own.txt")
+	other := filepath.Join(dir, "other.txt")
+	if _, err := GenerateIdentity(own); err != nil {
+		t.Fatalf("GenerateIdentity() error = %v", err)
+	}
+	teammate, err := GenerateIdentity(other)
+	if err != nil {
+		t.Fatalf("GenerateIdentity() error = %v", err)
+	}
+	if _, err := GenerateIdentity(own); err == nil {
+		t.Error("GenerateIdentity() should not overwrite an identity file")
+	}
+	if info, err := os.Stat(own); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Identity file mode = %v, %v, want 0600", info.Mode().Perm(), err)
+	}
+
+	// Snapshots are encrypted to the identity file's own key and to the recipients
+	opts := Options{IdentityFile: own, Recipients: []string{teammate}}
+	for name, decrypt := range map[string]Options{"own key": {IdentityFile: own}, "recipient": {IdentityFile: other}} {
+		got, err := roundTrip(t, opts, decrypt, "secret work")
+		if err != nil || got != "secret work" {
+			t.Errorf("%s: decrypted %q, %v, want %q", name, got, err, "secret work")
+		}
+	}
+
+	// A machine with only recipients can encrypt but not decrypt
+	if _, err := roundTrip(t, Options{Recipients: []string{teammate}}, Options{Recipients: []string{teammate}}, "secret"); !errors.Is(err, ErrNoIdentity) {
+		t.Errorf("Decrypt() without identity error = %v, want ErrNoIdentity", err)
+	}
+}
+
+func TestOptions_Passphrase(t *testing.T) {
+	opts := Options{Passphrase: "correct horse"}
+	got, err := roundTrip(t, opts, opts, "secret work")
+	if err != nil || got != "secret work" {
+		t.Errorf("Decrypted %q, %v, want %q", got, err, "secret work")
+	}
+	if _, err := roundTrip(t, opts, Options{Passphrase: "wrong"}, "secret work"); err == nil {
+		t.Error("Decrypt() with the wrong passphrase should fail")
+	}
+}
+
+func TestOptions_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    Options
+		wantErr bool
+	}{
+		{name: "disabled", opts: Options{}},
+		{name: "recipient", opts: Options{Recipients: []string{"age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p"}}},
+		{name: "invalid recipient", opts: Options{Recipients: []string{"ssh-ed25519 AAAA"}}, wantErr: true},
+		{name: "passphrase", opts: Options{Passphrase: "secret"}},
+		{name: "passphrase and keys", opts: Options{Passphrase: "secret", IdentityFile: "/key.txt"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.opts.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package git
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Environment variables passed to git subprocesses so the ghost-backup binary can act as their askpass helper
+const (
+	askpassEnv         = "GHOST_BACKUP_ASKPASS"
+	askpassUsernameEnv = "GHOST_BACKUP_ASKPASS_USERNAME"
+	askpassPasswordEnv = "GHOST_BACKUP_ASKPASS_PASSWORD"
+)
+
+// gitCredentials holds the credentials answered by the askpass helper
+// They are only handed to git subprocesses through execGitCommand, never to the process environment
+var gitCredentials struct {
+	username string
+	token    string
+	program  string // Path of the ghost-backup executable used as GIT_ASKPASS
+	mu       sync.RWMutex
+}
+
+// SetupGitCredentials configures the credentials used for non-interactive authentication
+// git is pointed at this executable as its askpass helper, which answers the username and password prompts
+func SetupGitCredentials(username, token string) error {
+	gitCredentials.mu.Lock()
+	defer gitCredentials.mu.Unlock()
+
+	if token == "" {
+		gitCredentials.username, gitCredentials.token, gitCredentials.program = "", "", ""
+		return nil
+	}
+
+	program, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to locate askpass helper: %w", err)
+	}
+
+	// Use provided username or fall back to token for both username and password
+	if username == "" {
+		username = token
+	}
+
+	gitCredentials.username = username
+	gitCredentials.token = token
+	gitCredentials.program = program
+	return nil
+}
+
+// credentialEnv returns the environment that makes git use the askpass helper, or nil if no credentials are configured
+func credentialEnv() []string {
+	gitCredentials.mu.RLock()
+	defer gitCredentials.mu.RUnlock()
+
+	if gitCredentials.token == "" {
+		return nil
+	}
+
+	return []string{
+		"GIT_ASKPASS=" + gitCredentials.program,
+		askpassEnv + "=1",
+		askpassUsernameEnv + "=" + gitCredentials.username,
+		askpassPasswordEnv + "=" + gitCredentials.token,
+	}
+}
+
+// IsAskpassInvocation reports whether git started this process as its askpass helper
+func IsAskpassInvocation() bool {
+	return os.Getenv(askpassEnv) == "1"
+}
+
+// RunAskpass answers the credential prompt git passes as arguments and returns the exit code
+// Unknown prompts (e.g. SSH passphrases) fail instead of answering with a wrong credential
+func RunAskpass(args []string, stdout io.Writer) int {
+	prompt := strings.Join(args, " ")
+
+	answer, ok := askpassAnswer(prompt, os.Getenv(askpassUsernameEnv), os.Getenv(askpassPasswordEnv))
+	if !ok {
+		_, _ = fmt.Fprintf(os.Stderr, "ghost-backup: no stored credential for prompt %q\n", prompt)
+		return 1
+	}
+
+	_, _ = fmt.Fprintln(stdout, answer)
+	return 0
+}
+
+// askpassAnswer picks the credential for a git prompt such as "Username for 'https://github.com': "
+func askpassAnswer(prompt, username, password string) (string, bool) {
+	prompt = strings.ToLower(strings.TrimSpace(prompt))
+
+	switch {
+	case strings.HasPrefix(prompt, "username"):
+		return username, username != ""
+	case strings.HasPrefix(prompt, "password"):
+		return password, password != ""
+	default:
+		return "", false
+	}
+}
@@ -0,0 +1,152 @@
+package git
+
+import (
+	"net/http"
+	"net/http/cgi"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestMain lets the test binary act as the askpass helper, as the real binary does in main
+func TestMain(m *testing.M) {
+	if IsAskpassInvocation() {
+		os.Exit(RunAskpass(os.Args[1:], os.Stdout))
+	}
+	os.Exit(m.Run())
+}
+
+func TestAskpassAnswer(t *testing.T) {
+	tests := []struct {
+		name   string
+		prompt string
+		want   string
+		ok     bool
+	}{
+		{"username", "Username for 'https://github.com': ", "user", true},
+		{"password", "Password for 'https://user@github.com': ", "token", true},
+		{"ssh passphrase", "Enter passphrase for key '/home/user/.ssh/id_ed25519': ", "", false},
+		{"empty", "", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := askpassAnswer(tt.prompt, "user", "token")
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("askpassAnswer(%q) = %q, %v, want %q, %v", tt.prompt, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestSetupGitCredentials_ScopedToGitCommands(t *testing.T) {
+	t.Cleanup(func() { _ = SetupGitCredentials("", "") })
+
+	if err := SetupGitCredentials("user", "secret-token"); err != nil {
+		t.Fatalf("SetupGitCredentials() error = %v", err)
+	}
+
+	if os.Getenv("GIT_ASKPASS") != "" || os.Getenv(askpassPasswordEnv) != "" {
+		t.Error("SetupGitCredentials() should not modify the process environment")
+	}
+
+	env := strings.Join(NewGitRepo(t.TempDir()).execGitCommand("ls-remote").Env, "\n")
+	for _, want := range []string{askpassEnv + "=1", askpassUsernameEnv + "=user", askpassPasswordEnv + "=secret-token", "GIT_ASKPASS="} {
+		if !strings.Contains(env, want) {
+			t.Errorf("execGitCommand() env missing %s", want)
+		}
+	}
+
+	// Username falls back to the token
+	if err := SetupGitCredentials("", "secret-token"); err != nil {
+		t.Fatalf("SetupGitCredentials() error = %v", err)
+	}
+	env = strings.Join(NewGitRepo(t.TempDir()).execGitCommand("ls-remote").Env, "\n")
+	if !strings.Contains(env, askpassUsernameEnv+"=secret-token") {
+		t.Error("SetupGitCredentials() should use the token as username when none is set")
+	}
+
+	// Clearing the token removes the helper
+	if err := SetupGitCredentials("", ""); err != nil {
+		t.Fatalf("SetupGitCredentials() error = %v", err)
+	}
+	env = strings.Join(NewGitRepo(t.TempDir()).execGitCommand("ls-remote").Env, "\n")
+	if strings.Contains(env, askpassEnv) {
+		t.Error("execGitCommand() should not use the askpass helper without credentials")
+	}
+}
+
+func TestGitRepo_PushToBackupRef_AuthenticatesWithToken(t *testing.T) {
+	gitPath, err := exec.LookPath("git")
+	if err != nil {
+		t.Skip("git not available")
+	}
+
+	// Serve a bare repository over smart HTTP behind basic auth
+	projectRoot := t.TempDir()
+	bare := filepath.Join(projectRoot, "remote.git")
+	for _, args := range [][]string{{"init", "--bare", bare}, {"-C", bare, "config", "http.receivepack", "true"}} {
+		if output, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %v\n%s", args, err, output)
+		}
+	}
+
+	backend := &cgi.Handler{
+		Path: gitPath,
+		Args: []string{"http-backend"},
+		Env:  []string{"GIT_PROJECT_ROOT=" + projectRoot, "GIT_HTTP_EXPORT_ALL=1"},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "ghost" || pass != "secret-token" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="git"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		r.Header.Set("REMOTE_USER", "ghost")
+		backend.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	tmpDir := setupTestRepo(t)
+	repo := NewGitRepo(tmpDir)
+	if err := os.WriteFile(filepath.Join(tmpDir, "test.txt"), []byte("initial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{{"add", "."}, {"commit", "-m", "Initial commit"}, {"config", "credential.helper", ""}} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = tmpDir
+		if output, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %v\n%s", args, err, output)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "test.txt"), []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	hash, err := repo.CreateStash(false)
+	if err != nil {
+		t.Fatalf("CreateStash() error = %v", err)
+	}
+
+	remote := server.URL + "/remote.git"
+
+	t.Cleanup(func() { _ = SetupGitCredentials("", "") })
+	_ = SetupGitCredentials("", "")
+	if err := repo.PushToBackupRef(hash, BackupRefName("test@example.com", "", "main"), remote); err == nil {
+		t.Fatal("PushToBackupRef() without credentials should fail")
+	}
+
+	if err := SetupGitCredentials("ghost", "secret-token"); err != nil {
+		t.Fatalf("SetupGitCredentials() error = %v", err)
+	}
+	if err := repo.PushToBackupRef(hash, BackupRefName("test@example.com", "", "main"), remote); err != nil {
+		t.Fatalf("PushToBackupRef() with credentials error = %v", err)
+	}
+
+	tip, err := repo.GetRemoteRefHash(remote, BackupRefName("test@example.com", "", "main"))
+	if err != nil || tip == "" {
+		t.Errorf("GetRemoteRefHash() = %q, %v, want pushed backup ref", tip, err)
+	}
+}
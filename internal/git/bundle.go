@@ -0,0 +1,410 @@
+package git
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Bundle directories
+// A backup remote of the form bundle://<absolute path> is a directory (a NAS mount, external disk
+// or synced folder) rather than a git remote. Each snapshot is written there as an incremental git
+// bundle, next to a manifest of the backup ref it belongs to:
+//
+//	<dir>/<repository>/<backup ref path>/manifest.json
+//	<dir>/<repository>/<backup ref path>/000001-<commit>.bundle
+//
+// The repository is identified by its root commit, so every clone of it shares the same backups,
+// and the backup ref path is the one of BackupRefName. The first bundle of a backup ref holds the
+// whole history of its snapshot; later ones leave out the commits of remote-tracking branches, which
+// are already safe on a remote, so restoring from them may need those to be fetched first.
+const (
+	// bundleScheme prefixes a backup remote that is a bundle directory
+	bundleScheme = "bundle://"
+	// bundleManifestFile lists the bundles of a backup ref, oldest first
+	bundleManifestFile = "manifest.json"
+	// bundleLockFile is held in a backup ref directory while its manifest is updated
+	bundleLockFile = "manifest.json.lock"
+	// bundleLockTimeout is how old a lock file gets before it is considered left over by a crash
+	bundleLockTimeout = 10 * time.Minute
+)
+
+// bundleManifest is the manifest of a backup ref in a bundle directory
+type bundleManifest struct {
+	Ref            string        `json:"ref"`               // Backup ref, as named by BackupRefName
+	UserIdentifier string        `json:"user"`              // User the backups belong to
+	Machine        string        `json:"machine,omitempty"` // Empty when backup refs are shared between machines
+	Branch         string        `json:"branch"`            // Branch the snapshots were taken on
+	Tip            string        `json:"tip"`               // Newest history commit
+	Updated        time.Time     `json:"updated"`           // When the last bundle was written
+	Bundles        []bundleEntry `json:"bundles"`           // Oldest first; each needs the previous one
+}
+
+// bundleEntry is a bundle holding one snapshot
+type bundleEntry struct {
+	File     string    `json:"file"`     // File name, relative to the manifest's directory
+	Commit   string    `json:"commit"`   // History commit the bundle ends at
+	Snapshot string    `json:"snapshot"` // Stash commit that can be restored
+	Created  time.Time `json:"created"`
+}
+
+// BundleRemote returns the backup remote for a bundle directory
+func BundleRemote(dir string) string {
+	return bundleScheme + dir
+}
+
+// bundleDirectory returns the directory of a bundle backup remote
+func bundleDirectory(remote string) (string, bool) {
+	return strings.CutPrefix(remote, bundleScheme)
+}
+
+// record appends the bundle of a new tip to the manifest of a backup ref
+func (m *bundleManifest) record(refName, file, commit, snapshot string) {
+	userIdentifier, machine, branch, _ := ParseBackupRefName(refName)
+	now := time.Now()
+	m.Ref = refName
+	m.UserIdentifier, m.Machine, m.Branch = userIdentifier, machine, branch
+	m.Tip = commit
+	m.Updated = now
+	m.Bundles = append(m.Bundles, bundleEntry{
+		File:     file,
+		Commit:   commit,
+		Snapshot: snapshot,
+		Created:  now,
+	})
+}
+
+// backupRef returns the backup ref a manifest describes
+func (m *bundleManifest) backupRef() BackupRef {
+	return BackupRef{
+		Hash:           m.Tip,
+		Ref:            m.Ref,
+		UserIdentifier: m.UserIdentifier,
+		Machine:        m.Machine,
+		Branch:         m.Branch,
+	}
+}
+
+// rootCommit returns the root commit identifying the repository in bundle directories and buckets,
+// the smallest one when the history has several
+func (g *GitRepo) rootCommit() (string, error) {
+	output, err := g.gitOutput("rev-list", "--max-parents=0", "HEAD")
+	if err != nil {
+		return "", fmt.Errorf("failed to find the root commit: %w", err)
+	}
+	roots := strings.Fields(output)
+	if len(roots) == 0 {
+		return "", fmt.Errorf("failed to find the root commit")
+	}
+	return slices.Min(roots), nil
+}
+
+// createBundle writes the history of a backup ref from parent (exclusive) to commit into a bundle
+// file, leaving out commits already on remote-tracking branches. Without a parent, the bundle is the
+// first of the ref and holds the whole history, so a restore always has something to start from
+func (g *GitRepo) createBundle(path, refName, commit, parent string) error {
+	// The bundle records the backup ref, so the local mirror of it is moved to the new commit
+	if _, err := g.gitOutput("update-ref", refName, commit); err != nil {
+		return fmt.Errorf("failed to update backup ref: %w", err)
+	}
+
+	args := []string{"bundle", "create", "-q", path, refName}
+	if parent != "" {
+		args = append(args, "^"+parent, "--not", "--remotes")
+	}
+	if _, err := g.gitOutput(args...); err != nil {
+		return fmt.Errorf("failed to create bundle: %w", err)
+	}
+	return nil
+}
+
+// missingBundles returns the bundles of a manifest that still have to be unpacked, oldest first
+// Each bundle needs the previous one, so they start after the newest one already in the repository
+func (g *GitRepo) missingBundles(m *bundleManifest) []bundleEntry {
+	if m.Tip == "" || g.ObjectExists(m.Tip) {
+		return nil
+	}
+	for i := len(m.Bundles) - 1; i >= 0; i-- {
+		if g.ObjectExists(m.Bundles[i].Commit) {
+			return m.Bundles[i+1:]
+		}
+	}
+	return m.Bundles
+}
+
+// unbundle unpacks the objects of a bundle file into the repository
+// The bundle is verified first, so commits it builds on that the repository lacks are named
+func (g *GitRepo) unbundle(path string) error {
+	cmd := exec.Command("git", "bundle", "verify", path)
+	cmd.Dir = g.Path
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if missing := bundlePrerequisites(stderr.String()); len(missing) > 0 {
+			return fmt.Errorf("the repository lacks commits %s the bundle builds on, fetch the branches holding them first", strings.Join(missing, ", "))
+		}
+		return fmt.Errorf("failed to verify bundle: %w, stderr: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	_, err := g.gitOutput("bundle", "unbundle", path)
+	return err
+}
+
+// bundlePrerequisites returns the abbreviated hashes of the missing prerequisite commits listed in
+// the output of git bundle verify
+func bundlePrerequisites(output string) []string {
+	var missing []string
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(strings.TrimPrefix(line, "error: "))
+		if len(fields) == 0 || len(fields[0]) < 40 {
+			continue
+		}
+		if _, err := hex.DecodeString(fields[0]); err == nil {
+			missing = append(missing, shortHash(fields[0]))
+		}
+	}
+	return missing
+}
+
+// bundleDestination is a Destination storing backups as git bundles in a directory
+type bundleDestination struct {
+	repo *GitRepo
+	dir  string
+}
+
+func (d *bundleDestination) Name() string {
+	return BundleRemote(d.dir)
+}
+
+// root returns the directory holding the repository's backups, failing when the bundle
+// directory itself isn't available, e.g. because a disk isn't mounted
+func (d *bundleDestination) root() (string, error) {
+	info, err := os.Stat(d.dir)
+	if err != nil {
+		return "", fmt.Errorf("bundle directory is not available: %w", err)
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("bundle directory %s is not a directory", d.dir)
+	}
+
+	root, err := d.repo.rootCommit()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(d.dir, root), nil
+}
+
+// refDir returns the directory of a backup ref
+func (d *bundleDestination) refDir(refName string) (string, error) {
+	path, ok := strings.CutPrefix(refName, backupRefPrefix)
+	if !ok {
+		return "", fmt.Errorf("backup ref %s can't be stored in a bundle directory", refName)
+	}
+	root, err := d.root()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(root, filepath.FromSlash(path)), nil
+}
+
+// readBundleManifest reads the manifest in a backup ref directory; it is empty when there is none yet
+func readBundleManifest(refDir string) (*bundleManifest, error) {
+	data, err := os.ReadFile(filepath.Join(refDir, bundleManifestFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return &bundleManifest{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bundle manifest: %w", err)
+	}
+
+	var manifest bundleManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse bundle manifest %s: %w", filepath.Join(refDir, bundleManifestFile), err)
+	}
+	return &manifest, nil
+}
+
+// writeBundleManifest replaces the manifest in a backup ref directory
+// It is written to a temporary file first so readers never see a partial manifest
+func writeBundleManifest(refDir string, manifest *bundleManifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode bundle manifest: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(refDir, bundleManifestFile+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write bundle manifest: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(refDir, bundleManifestFile))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write bundle manifest: %w", err)
+	}
+	return nil
+}
+
+// lockBundleRef takes the lock of a backup ref directory, so only one machine at a time reads,
+// checks and replaces its manifest. A lock older than bundleLockTimeout is taken over
+func lockBundleRef(refDir, refName string) (unlock func(), err error) {
+	path := filepath.Join(refDir, bundleLockFile)
+	for attempt := 0; ; attempt++ {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			_ = f.Close()
+			return func() { _ = os.Remove(path) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("failed to lock backup ref %s: %w", refName, err)
+		}
+
+		info, statErr := os.Stat(path)
+		if attempt > 0 || statErr != nil || time.Since(info.ModTime()) < bundleLockTimeout {
+			return nil, fmt.Errorf("backup ref %s is being updated in the bundle directory by another machine (remove %s if it is left over)", refName, path)
+		}
+		_ = os.Remove(path)
+	}
+}
+
+// List lists the backup refs of the repository from their manifests
+func (d *bundleDestination) List(pattern string) ([]BackupRef, error) {
+	root, err := d.root()
+	if err != nil {
+		return nil, err
+	}
+
+	var refs []BackupRef
+	err = filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if errors.Is(err, os.ErrNotExist) && path == root {
+			// Nothing backed up yet
+			return filepath.SkipDir
+		}
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || entry.Name() != bundleManifestFile {
+			return nil
+		}
+
+		manifest, err := readBundleManifest(filepath.Dir(path))
+		if err != nil {
+			return err
+		}
+		if manifest.Tip != "" && matchRefPattern(pattern, manifest.Ref) {
+			refs = append(refs, manifest.backupRef())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list bundle directory: %w", err)
+	}
+
+	slices.SortFunc(refs, func(a, b BackupRef) int { return strings.Compare(a.Ref, b.Ref) })
+	return refs, nil
+}
+
+// Store writes the history commit of a snapshot as an incremental bundle on top of the previous
+// tip, then points the manifest of the backup ref at it
+func (d *bundleDestination) Store(snapshot, refName string) error {
+	commit, parent, err := d.repo.chainSnapshot(d, snapshot, refName)
+	if err != nil {
+		return err
+	}
+
+	refDir, err := d.refDir(refName)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(refDir, 0755); err != nil {
+		return fmt.Errorf("failed to create bundle directory: %w", err)
+	}
+	unlock, err := lockBundleRef(refDir, refName)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// Checked under the lock, so a snapshot stored meanwhile by another machine is never dropped
+	manifest, err := readBundleManifest(refDir)
+	if err != nil {
+		return err
+	}
+	if manifest.Tip != parent {
+		return fmt.Errorf("backup ref %s changed in the bundle directory while pushing", refName)
+	}
+
+	file := fmt.Sprintf("%06d-%s.bundle", len(manifest.Bundles)+1, commit[:12])
+	if err := d.repo.createBundle(filepath.Join(refDir, file), refName, commit, parent); err != nil {
+		return err
+	}
+	manifest.record(refName, file, commit, snapshot)
+	return writeBundleManifest(refDir, manifest)
+}
+
+// Fetch unpacks the bundles of a backup ref that aren't in the repository yet, oldest first, and
+// points the local copy of the ref at the tip
+func (d *bundleDestination) Fetch(refName string) error {
+	refDir, err := d.refDir(refName)
+	if err != nil {
+		return err
+	}
+	manifest, err := readBundleManifest(refDir)
+	if err != nil {
+		return err
+	}
+	if manifest.Tip == "" {
+		return fmt.Errorf("failed to fetch backup ref: %s not found in %s", refName, d.dir)
+	}
+
+	for _, entry := range d.repo.missingBundles(manifest) {
+		if err := d.repo.unbundle(filepath.Join(refDir, entry.File)); err != nil {
+			return fmt.Errorf("failed to unpack bundle %s: %w", entry.File, err)
+		}
+	}
+
+	if _, err := d.repo.gitOutput("update-ref", refName, manifest.Tip); err != nil {
+		return fmt.Errorf("failed to fetch backup ref: %w", err)
+	}
+	return nil
+}
+
+// Delete removes the manifest and bundles of a backup ref
+// Backup refs of branches nested below it live in sibling directories and are kept
+func (d *bundleDestination) Delete(refName string) error {
+	refDir, err := d.refDir(refName)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(refDir); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	unlock, err := lockBundleRef(refDir, refName)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := os.RemoveAll(refDir); err != nil {
+		return fmt.Errorf("failed to delete backup ref: %w", err)
+	}
+	return nil
+}
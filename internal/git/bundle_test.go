@@ -0,0 +1,239 @@
+package git
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMatchRefPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		ref     string
+		want    bool
+	}{
+		{"refs/ghost-backup/backups/*", "refs/ghost-backup/backups/user/main/@", true},
+		{"refs/ghost-backup/backups/user/*", "refs/ghost-backup/backups/user2/main/@", false},
+		{"refs/ghost-backup/backups/user/main/@", "refs/ghost-backup/backups/user/main/@", true},
+		{"refs/ghost-backup/backups/user/main/@", "refs/ghost-backup/backups/user/main2/@", false},
+		{"HEAD", "refs/ghost-backup/backups/user/main/@", false},
+	}
+
+	for _, tt := range tests {
+		if got := matchRefPattern(tt.pattern, tt.ref); got != tt.want {
+			t.Errorf("matchRefPattern(%q, %q) = %v, want %v", tt.pattern, tt.ref, got, tt.want)
+		}
+	}
+}
+
+func TestGitRepo_BundleDirectory(t *testing.T) {
+	tmpDir := setupTestRepoWithRemote(t)
+	repo := NewGitRepo(tmpDir)
+	testFile := filepath.Join(tmpDir, "test.txt")
+	bundleDir := t.TempDir()
+	remote := BundleRemote(bundleDir)
+	refName := BackupRefName("test@example.com", "", "main")
+
+	// Nothing backed up yet
+	refs, err := repo.ListAllBackupRefs(remote)
+	if err != nil {
+		t.Fatalf("ListAllBackupRefs() error = %v", err)
+	}
+	if len(refs) != 0 {
+		t.Fatalf("ListAllBackupRefs() = %+v, want none", refs)
+	}
+
+	var stashes []string
+	for _, content := range []string{"first", "second"} {
+		if err := os.WriteFile(testFile, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to modify test file: %v", err)
+		}
+		hash, err := repo.CreateStash(false)
+		if err != nil {
+			t.Fatalf("CreateStash() error = %v", err)
+		}
+		if err := repo.PushToBackupRef(hash, refName, remote); err != nil {
+			t.Fatalf("PushToBackupRef() error = %v", err)
+		}
+		stashes = append(stashes, hash)
+	}
+
+	bundles, err := filepath.Glob(filepath.Join(bundleDir, "*", "test%40example.com", "main", "@", "*.bundle"))
+	if err != nil || len(bundles) != 2 {
+		t.Fatalf("Bundle directory holds %v, want 2 bundles", bundles)
+	}
+
+	// A fresh clone reads the backups back from the bundles alone
+	clone := filepath.Join(t.TempDir(), "clone")
+	if output, err := exec.Command("git", "clone", "-q", tmpDir, clone).CombinedOutput(); err != nil {
+		t.Fatalf("Failed to clone: %v, %s", err, output)
+	}
+	cloneRepo := NewGitRepo(clone)
+
+	refs, err = cloneRepo.ListAllBackupRefs(remote)
+	if err != nil {
+		t.Fatalf("ListAllBackupRefs() error = %v", err)
+	}
+	if len(refs) != 1 || refs[0].Ref != refName || refs[0].UserIdentifier != "test@example.com" {
+		t.Fatalf("ListAllBackupRefs() = %+v, want %s", refs, refName)
+	}
+
+	snapshots, err := cloneRepo.ListBackupHistory(remote, refName, 0)
+	if err != nil {
+		t.Fatalf("ListBackupHistory() error = %v", err)
+	}
+	if len(snapshots) != 2 || snapshots[0].Hash != stashes[1] || snapshots[1].Hash != stashes[0] {
+		t.Errorf("ListBackupHistory() = %+v, want %v newest first", snapshots, stashes)
+	}
+}
+
+func TestGitRepo_BundleDirectory_Unavailable(t *testing.T) {
+	repo := NewGitRepo(setupTestRepoWithRemote(t))
+	remote := BundleRemote(filepath.Join(t.TempDir(), "unmounted"))
+
+	if _, err := repo.ListAllBackupRefs(remote); err == nil {
+		t.Error("ListAllBackupRefs() should fail when the bundle directory is missing")
+	}
+	if _, err := repo.FirstReachableRemote([]string{remote, "origin"}); err != nil {
+		t.Errorf("FirstReachableRemote() error = %v, want a failover to origin", err)
+	}
+}
+
+func TestGitRepo_BundleDirectory_Lock(t *testing.T) {
+	tmpDir := setupTestRepoWithRemote(t)
+	repo := NewGitRepo(tmpDir)
+	remote := BundleRemote(t.TempDir())
+	refName := BackupRefName("test@example.com", "", "main")
+
+	d, err := repo.OpenDestination(remote)
+	if err != nil {
+		t.Fatalf("OpenDestination() error = %v", err)
+	}
+	refDir, err := d.(*bundleDestination).refDir(refName)
+	if err != nil {
+		t.Fatalf("refDir() error = %v", err)
+	}
+	if err := os.MkdirAll(refDir, 0755); err != nil {
+		t.Fatalf("Failed to create ref directory: %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(tmpDir, "test.txt"), []byte("locked"), 0644); err != nil {
+		t.Fatalf("Failed to modify test file: %v", err)
+	}
+	hash, err := repo.CreateStash(false)
+	if err != nil {
+		t.Fatalf("CreateStash() error = %v", err)
+	}
+
+	// Another machine is updating the manifest
+	lock := filepath.Join(refDir, bundleLockFile)
+	if err := os.WriteFile(lock, nil, 0644); err != nil {
+		t.Fatalf("Failed to create lock: %v", err)
+	}
+	if err := d.Store(hash, refName); err == nil {
+		t.Fatal("Store() should fail while the backup ref is locked")
+	}
+	if _, err := os.Stat(filepath.Join(refDir, bundleManifestFile)); !os.IsNotExist(err) {
+		t.Errorf("Store() wrote a manifest while the backup ref was locked")
+	}
+
+	// A lock left over by a crash is taken over
+	old := time.Now().Add(-2 * bundleLockTimeout)
+	if err := os.Chtimes(lock, old, old); err != nil {
+		t.Fatalf("Failed to age lock: %v", err)
+	}
+	if err := d.Store(hash, refName); err != nil {
+		t.Fatalf("Store() error = %v", err)
+	}
+	if _, err := os.Stat(lock); !os.IsNotExist(err) {
+		t.Error("Store() left the lock behind")
+	}
+	manifest, err := readBundleManifest(refDir)
+	if err != nil {
+		t.Fatalf("readBundleManifest() error = %v", err)
+	}
+	if len(manifest.Bundles) != 1 || manifest.Bundles[0].Snapshot != hash {
+		t.Errorf("Manifest = %+v, want the snapshot", manifest)
+	}
+}
+
+func TestGitRepo_BundleDirectory_Prerequisites(t *testing.T) {
+	tmpDir := setupTestRepoWithRemote(t)
+	repo := NewGitRepo(tmpDir)
+	testFile := filepath.Join(tmpDir, "test.txt")
+	bundleDir := t.TempDir()
+	remote := BundleRemote(bundleDir)
+	refName := BackupRefName("test@example.com", "", "main")
+
+	if _, err := repo.gitOutput("push", "-q", "origin", "HEAD"); err != nil {
+		t.Fatalf("Failed to push branch: %v", err)
+	}
+	remoteDir, err := repo.gitOutput("remote", "get-url", "origin")
+	if err != nil {
+		t.Fatalf("Failed to get remote: %v", err)
+	}
+	clone := filepath.Join(t.TempDir(), "clone")
+	if output, err := exec.Command("git", "clone", "-q", remoteDir, clone).CombinedOutput(); err != nil {
+		t.Fatalf("Failed to clone: %v, %s", err, output)
+	}
+	cloneRepo := NewGitRepo(clone)
+
+	// The first bundle holds the commits of the pushed branch too
+	if err := os.WriteFile(testFile, []byte("first"), 0644); err != nil {
+		t.Fatalf("Failed to modify test file: %v", err)
+	}
+	first, err := repo.CreateStash(false)
+	if err != nil {
+		t.Fatalf("CreateStash() error = %v", err)
+	}
+	if err := repo.PushToBackupRef(first, refName, remote); err != nil {
+		t.Fatalf("PushToBackupRef() error = %v", err)
+	}
+	bundles, err := filepath.Glob(filepath.Join(bundleDir, "*", "test%40example.com", "main", "@", "*.bundle"))
+	if err != nil || len(bundles) != 1 {
+		t.Fatalf("Bundle directory holds %v, want 1 bundle", bundles)
+	}
+	empty := NewGitRepo(t.TempDir())
+	if _, err := empty.gitOutput("init", "-q"); err != nil {
+		t.Fatalf("Failed to init repository: %v", err)
+	}
+	if err := empty.unbundle(bundles[0]); err != nil {
+		t.Fatalf("unbundle() of the first bundle into an empty repository error = %v", err)
+	}
+
+	// Later bundles leave out a commit that only reached the remote after the clone
+	if _, err := repo.gitOutput("commit", "-q", "-am", "Pushed later"); err != nil {
+		t.Fatalf("Failed to commit: %v", err)
+	}
+	if _, err := repo.gitOutput("push", "-q", "origin", "HEAD"); err != nil {
+		t.Fatalf("Failed to push branch: %v", err)
+	}
+	head, err := repo.gitOutput("rev-parse", "HEAD")
+	if err != nil {
+		t.Fatalf("Failed to read HEAD: %v", err)
+	}
+	if err := os.WriteFile(testFile, []byte("second"), 0644); err != nil {
+		t.Fatalf("Failed to modify test file: %v", err)
+	}
+	second, err := repo.CreateStash(false)
+	if err != nil {
+		t.Fatalf("CreateStash() error = %v", err)
+	}
+	if err := repo.PushToBackupRef(second, refName, remote); err != nil {
+		t.Fatalf("PushToBackupRef() error = %v", err)
+	}
+
+	err = cloneRepo.FetchBackupRef(remote, refName)
+	if err == nil || !strings.Contains(err.Error(), shortHash(head)) {
+		t.Errorf("FetchBackupRef() error = %v, want the missing commit %s named", err, shortHash(head))
+	}
+	if _, err := cloneRepo.gitOutput("fetch", "-q", "origin"); err != nil {
+		t.Fatalf("Failed to fetch: %v", err)
+	}
+	if err := cloneRepo.FetchBackupRef(remote, refName); err != nil {
+		t.Errorf("FetchBackupRef() after git fetch error = %v", err)
+	}
+}
@@ -0,0 +1,92 @@
+package git
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Destination is where backups are stored: per backup ref, a chain of history commits whose second
+// parents are the snapshots (see CreateBackupCommit). The type of destination is chosen by the form
+// of the backup remote it is opened from, see OpenDestination.
+type Destination interface {
+	// Name returns the backup remote the destination was opened from, as used in config, pending refs and state
+	Name() string
+	// Store appends a snapshot to the history of a backup ref
+	Store(hash, refName string) error
+	// List lists the backup refs matching a pattern: a full ref name, or a prefix followed by "*"
+	List(pattern string) ([]BackupRef, error)
+	// Fetch copies the history of a backup ref into the local repository, under the same ref name
+	Fetch(refName string) error
+	// Delete removes a backup ref and its history
+	Delete(refName string) error
+}
+
+// DestinationFactory opens the destination of a backup remote for a repository, reporting false
+// for remotes it doesn't handle, which are then opened as usual
+type DestinationFactory func(g *GitRepo, remote string) (Destination, bool)
+
+// destinationSettings holds the factory set up with SetDestinationFactory
+var destinationSettings struct {
+	factory DestinationFactory
+	mu      sync.RWMutex
+}
+
+// SetDestinationFactory makes OpenDestination ask factory first, so tests can store backups
+// somewhere else than the built-in destinations (see MemoryDestination); nil removes it
+func SetDestinationFactory(factory DestinationFactory) {
+	destinationSettings.mu.Lock()
+	defer destinationSettings.mu.Unlock()
+	destinationSettings.factory = factory
+}
+
+// OpenDestination returns the destination for a backup remote:
+//   - bundle://<absolute path>: a directory of git bundles (see BundleRemote)
+//   - s3://<bucket>[/<prefix>]: git bundles in S3-compatible object storage (see S3Remote)
+//   - anything else: a git remote name or URL
+//
+// Nothing is contacted until the destination is used.
+func (g *GitRepo) OpenDestination(remote string) (Destination, error) {
+	destinationSettings.mu.RLock()
+	factory := destinationSettings.factory
+	destinationSettings.mu.RUnlock()
+	if factory != nil {
+		if d, ok := factory(g, remote); ok {
+			return d, nil
+		}
+	}
+
+	if dir, ok := bundleDirectory(remote); ok {
+		if !filepath.IsAbs(dir) {
+			return nil, fmt.Errorf("bundle directory %q must be an absolute path", dir)
+		}
+		return &bundleDestination{repo: g, dir: dir}, nil
+	}
+	if strings.HasPrefix(remote, s3Scheme) {
+		return g.openS3Destination(remote)
+	}
+	if remote == "" {
+		return nil, fmt.Errorf("empty backup remote")
+	}
+	return &gitRemote{repo: g, remote: remote}, nil
+}
+
+// currentBackupRefs keeps the backup refs in the current layout
+func currentBackupRefs(refs []BackupRef) []BackupRef {
+	var current []BackupRef
+	for _, ref := range refs {
+		if _, _, _, ok := ParseBackupRefName(ref.Ref); ok {
+			current = append(current, ref)
+		}
+	}
+	return current
+}
+
+// matchRefPattern reports whether a ref matches a Destination.List pattern
+func matchRefPattern(pattern, refName string) bool {
+	if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+		return strings.HasPrefix(refName, prefix)
+	}
+	return refName == pattern
+}
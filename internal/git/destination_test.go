@@ -0,0 +1,142 @@
+package git
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGitRepo_OpenDestination(t *testing.T) {
+	repo := NewGitRepo(t.TempDir())
+	setupS3(t)
+
+	tests := []struct {
+		remote  string
+		want    string // Type of destination, empty for an error
+		wantErr bool
+	}{
+		{remote: "origin", want: "*git.gitRemote"},
+		{remote: "git@example.com:backups.git", want: "*git.gitRemote"},
+		{remote: "bundle:///mnt/nas", want: "*git.bundleDestination"},
+		{remote: "bundle://relative", wantErr: true},
+		{remote: "s3://backups/wip", want: "*git.s3Destination"},
+		{remote: "s3://", wantErr: true},
+		{remote: "memory://" + t.Name(), want: "*git.gitRemote"},
+		{remote: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.remote, func(t *testing.T) {
+			d, err := repo.OpenDestination(tt.remote)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("OpenDestination(%q) error = %v, wantErr %v", tt.remote, err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := fmt.Sprintf("%T", d); got != tt.want {
+				t.Errorf("OpenDestination(%q) = %s, want %s", tt.remote, got, tt.want)
+			}
+			if d.Name() != tt.remote {
+				t.Errorf("Name() = %s, want %s", d.Name(), tt.remote)
+			}
+		})
+	}
+
+	// A destination factory is asked first, and only for the remotes it handles
+	memory := NewMemoryDestination(t.Name())
+	setupDestinations(t, memory)
+	for remote, want := range map[string]string{memory.Remote(): "*git.memoryDestination", "origin": "*git.gitRemote"} {
+		d, err := repo.OpenDestination(remote)
+		if err != nil {
+			t.Fatalf("OpenDestination(%q) error = %v", remote, err)
+		}
+		if got := fmt.Sprintf("%T", d); got != want {
+			t.Errorf("OpenDestination(%q) with a factory = %s, want %s", remote, got, want)
+		}
+	}
+}
+
+// setupDestinations opens the given in-memory destinations by their backup remote
+func setupDestinations(t *testing.T, destinations ...*MemoryDestination) {
+	t.Helper()
+	SetDestinationFactory(MemoryDestinations(destinations...))
+	t.Cleanup(func() { SetDestinationFactory(nil) })
+}
+
+// TestDestinations runs the same scenario against every kind of destination
+func TestDestinations(t *testing.T) {
+	destinations := map[string]func(t *testing.T) string{
+		"git":    func(t *testing.T) string { return "origin" },
+		"bundle": func(t *testing.T) string { return BundleRemote(t.TempDir()) },
+		"memory": func(t *testing.T) string {
+			m := NewMemoryDestination(t.Name())
+			setupDestinations(t, m)
+			return m.Remote()
+		},
+		"s3": func(t *testing.T) string {
+			setupS3(t, "backups")
+			return S3Remote("backups", "wip")
+		},
+	}
+
+	for name, remote := range destinations {
+		t.Run(name, func(t *testing.T) {
+			tmpDir := setupTestRepoWithRemote(t)
+			repo := NewGitRepo(tmpDir)
+			d, err := repo.OpenDestination(remote(t))
+			if err != nil {
+				t.Fatalf("OpenDestination() error = %v", err)
+			}
+			refName := BackupRefName("test@example.com", "", "main")
+			nested := BackupRefName("test@example.com", "", "main/nested")
+
+			var stashes []string
+			for _, content := range []string{"first", "second"} {
+				if err := os.WriteFile(filepath.Join(tmpDir, "test.txt"), []byte(content), 0644); err != nil {
+					t.Fatalf("Failed to modify test file: %v", err)
+				}
+				hash, err := repo.CreateStash(false)
+				if err != nil {
+					t.Fatalf("CreateStash() error = %v", err)
+				}
+				if err := d.Store(hash, refName); err != nil {
+					t.Fatalf("Store() error = %v", err)
+				}
+				stashes = append(stashes, hash)
+			}
+			if err := d.Store(stashes[0], nested); err != nil {
+				t.Fatalf("Store() error = %v", err)
+			}
+
+			refs, err := d.List(backupRefPrefix + "*")
+			if err != nil {
+				t.Fatalf("List() error = %v", err)
+			}
+			if len(refs) != 2 || refs[0].Ref != refName || refs[0].Branch != "main" || refs[1].Ref != nested {
+				t.Fatalf("List() = %+v, want %s and %s", refs, refName, nested)
+			}
+
+			snapshots, err := repo.ListBackupHistory(d.Name(), refName, 0)
+			if err != nil {
+				t.Fatalf("ListBackupHistory() error = %v", err)
+			}
+			if len(snapshots) != 2 || snapshots[0].Hash != stashes[1] || snapshots[1].Hash != stashes[0] {
+				t.Errorf("ListBackupHistory() = %+v, want %v newest first", snapshots, stashes)
+			}
+
+			// Deleting a branch's backups keeps those of the branches below it
+			if err := d.Delete(refName); err != nil {
+				t.Fatalf("Delete() error = %v", err)
+			}
+			refs, err = d.List(backupRefPrefix + "*")
+			if err != nil {
+				t.Fatalf("List() error = %v", err)
+			}
+			if len(refs) != 1 || refs[0].Ref != nested {
+				t.Errorf("List() after Delete() = %+v, want only %s", refs, nested)
+			}
+		})
+	}
+}
@@ -0,0 +1,296 @@
+package git
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/FmTod/ghost-backup/internal/encryption"
+)
+
+// Encrypted snapshots
+// With encryption set up (SetupEncryption), backup refs don't reference snapshots directly: each
+// snapshot is packaged as a git bundle, encrypted with age and stored as the only blob of a history
+// commit, chained onto the previous tip like a plain one. Only the ref name and the snapshot time
+// stay readable. Snapshots are decrypted when they are listed or looked up, unpacked and recorded
+// under a local ref, so they are only decrypted once and stay reachable:
+//
+//	refs/ghost-backup/decrypted/<encrypted history commit> -> snapshot
+const (
+	// DecryptedRefPrefix is the local namespace mapping encrypted history commits to their snapshot
+	DecryptedRefPrefix = "refs/ghost-backup/decrypted/"
+	// encryptedSubject is the subject of encrypted history commits
+	encryptedSubject = backupCommitPrefix + "encrypted snapshot"
+	// encryptionTrailer records the scheme a history commit is encrypted with
+	encryptionTrailer = "Ghost-Backup-Encryption"
+	// encryptedBlobName is the name of the encrypted bundle in the tree of a history commit
+	encryptedBlobName = "snapshot.bundle.age"
+)
+
+// ErrEncryptionRequired is returned when storing a snapshot unencrypted onto a backup ref that
+// holds encrypted snapshots
+var ErrEncryptionRequired = errors.New("backup ref holds encrypted snapshots, set up encryption to store more")
+
+// encryptionSettings holds the keys snapshots are encrypted and decrypted with
+var encryptionSettings struct {
+	opts encryption.Options
+	mu   sync.RWMutex
+}
+
+// SetupEncryption configures the encryption of snapshots; zero options store them unencrypted
+func SetupEncryption(opts encryption.Options) {
+	encryptionSettings.mu.Lock()
+	defer encryptionSettings.mu.Unlock()
+	encryptionSettings.opts = opts
+}
+
+func currentEncryption() encryption.Options {
+	encryptionSettings.mu.RLock()
+	defer encryptionSettings.mu.RUnlock()
+	return encryptionSettings.opts
+}
+
+// CreateEncryptedBackupCommit creates a history commit holding a snapshot encrypted as a bundle,
+// chained onto the previous backup tip; parentHash may be empty for the first backup on a ref.
+// Commits of remote-tracking branches are left out of the bundle, as they are already on a remote.
+func (g *GitRepo) CreateEncryptedBackupCommit(stashHash, parentHash string, opts encryption.Options) (string, error) {
+	date, err := g.gitOutput("log", "-1", "--format=%cI", stashHash)
+	if err != nil {
+		return "", fmt.Errorf("failed to read stash date: %w", err)
+	}
+
+	blob, err := g.encryptSnapshot(stashHash, opts)
+	if err != nil {
+		return "", err
+	}
+
+	cmd := exec.Command("git", "mktree")
+	cmd.Dir = g.Path
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("100644 blob %s\t%s\n", blob, encryptedBlobName))
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to create encrypted tree: %w", err)
+	}
+	tree := strings.TrimSpace(string(output))
+
+	message := fmt.Sprintf("%s\n\n%s: %s", encryptedSubject, encryptionTrailer, opts.Scheme())
+	args := []string{"commit-tree", tree, "-m", message}
+	if parentHash != "" {
+		args = append(args, "-p", parentHash)
+	}
+
+	cmd = exec.Command("git", args...)
+	cmd.Dir = g.Path
+	// Keep the time the snapshot was taken, even when it is pushed later from the pending queue
+	cmd.Env = append(os.Environ(), "GIT_COMMITTER_DATE="+date, "GIT_AUTHOR_DATE="+date)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	output, err = cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to create backup commit: %w, stderr: %s", err, stderr.String())
+	}
+	commit := strings.TrimSpace(string(output))
+
+	// This machine already has the snapshot, so it never needs to decrypt it
+	if _, err := g.gitOutput("update-ref", DecryptedRefPrefix+commit, stashHash); err != nil {
+		return "", fmt.Errorf("failed to record encrypted snapshot: %w", err)
+	}
+	return commit, nil
+}
+
+// encryptSnapshot bundles a snapshot, encrypts the bundle and writes it as a blob, returning its hash
+func (g *GitRepo) encryptSnapshot(stashHash string, opts encryption.Options) (string, error) {
+	// Bundles are made of refs, so the snapshot gets a temporary one
+	tmpRef := "refs/ghost-backup/encrypting/" + stashHash
+	if _, err := g.gitOutput("update-ref", tmpRef, stashHash); err != nil {
+		return "", fmt.Errorf("failed to bundle snapshot: %w", err)
+	}
+	defer func() { _, _ = g.gitOutput("update-ref", "-d", tmpRef) }()
+
+	cmd := exec.Command("git", "bundle", "create", "-q", "-", tmpRef, "--not", "--remotes")
+	cmd.Dir = g.Path
+	bundle, err := cmd.StdoutPipe()
+	if err != nil {
+		return "", fmt.Errorf("failed to bundle snapshot: %w", err)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	// The encrypted bundle is streamed into the object database
+	hashCmd := exec.Command("git", "hash-object", "-w", "--stdin")
+	hashCmd.Dir = g.Path
+	pipe, err := hashCmd.StdinPipe()
+	if err != nil {
+		return "", fmt.Errorf("failed to store encrypted snapshot: %w", err)
+	}
+	var blob bytes.Buffer
+	hashCmd.Stdout = &blob
+
+	if err := hashCmd.Start(); err != nil {
+		return "", fmt.Errorf("failed to store encrypted snapshot: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		_ = pipe.Close()
+		_ = hashCmd.Wait()
+		return "", fmt.Errorf("failed to bundle snapshot: %w", err)
+	}
+
+	encryptErr := func() error {
+		encrypted, err := opts.Encrypt(pipe)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(encrypted, bundle); err != nil {
+			return fmt.Errorf("failed to encrypt snapshot: %w", err)
+		}
+		return encrypted.Close()
+	}()
+	_, _ = io.Copy(io.Discard, bundle)
+	bundleErr := cmd.Wait()
+	_ = pipe.Close()
+	hashErr := hashCmd.Wait()
+
+	switch {
+	case encryptErr != nil:
+		return "", encryptErr
+	case bundleErr != nil:
+		return "", fmt.Errorf("failed to bundle snapshot: %w, stderr: %s", bundleErr, stderr.String())
+	case hashErr != nil:
+		return "", fmt.Errorf("failed to store encrypted snapshot: %w", hashErr)
+	}
+	return strings.TrimSpace(blob.String()), nil
+}
+
+// isEncryptedCommit reports whether a history commit, given its subject and trailers, holds an
+// encrypted snapshot
+func isEncryptedCommit(subject, trailers string) bool {
+	return subject == encryptedSubject && strings.Contains(trailers, encryptionTrailer+":")
+}
+
+// isEncryptedBackupCommit reports whether a history commit holds an encrypted snapshot
+func (g *GitRepo) isEncryptedBackupCommit(commit string) (bool, error) {
+	output, err := g.gitOutput("log", "-1", "--format=%s%x1f%(trailers:only,unfold,separator=%x1d)", commit)
+	if err != nil {
+		return false, fmt.Errorf("failed to read backup commit %s: %w", shortHash(commit), err)
+	}
+	subject, trailers, _ := strings.Cut(output, "\x1f")
+	return isEncryptedCommit(subject, trailers), nil
+}
+
+// decryptedSnapshot returns the snapshot already decrypted from an encrypted history commit, or ""
+func (g *GitRepo) decryptedSnapshot(commit string) string {
+	hash, err := g.gitOutput("rev-parse", "--verify", "-q", DecryptedRefPrefix+commit)
+	if err != nil {
+		return ""
+	}
+	return hash
+}
+
+// DecryptSnapshot returns the snapshot held by an encrypted history commit, decrypting the bundle
+// and unpacking it into the repository the first time
+func (g *GitRepo) DecryptSnapshot(commit string) (string, error) {
+	if hash := g.decryptedSnapshot(commit); hash != "" {
+		return hash, nil
+	}
+
+	tmp, err := os.CreateTemp("", "ghost-backup-*.bundle")
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt snapshot: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	err = g.decryptBlob(commit+":"+encryptedBlobName, tmp)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt snapshot %s: %w", shortHash(commit), err)
+	}
+
+	heads, err := g.gitOutput("bundle", "list-heads", tmp.Name())
+	if err != nil {
+		return "", fmt.Errorf("failed to read decrypted snapshot %s: %w", shortHash(commit), err)
+	}
+	hash, _, _ := strings.Cut(heads, " ")
+	if err := g.unbundle(tmp.Name()); err != nil {
+		return "", fmt.Errorf("failed to unpack decrypted snapshot %s: %w", shortHash(commit), err)
+	}
+
+	if _, err := g.gitOutput("update-ref", DecryptedRefPrefix+commit, hash); err != nil {
+		return "", fmt.Errorf("failed to record decrypted snapshot: %w", err)
+	}
+	return hash, nil
+}
+
+// decryptBlob writes the decrypted content of a blob to w
+func (g *GitRepo) decryptBlob(object string, w io.Writer) error {
+	cmd := exec.Command("git", "cat-file", "blob", object)
+	cmd.Dir = g.Path
+	blob, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	err = func() error {
+		decrypted, err := currentEncryption().Decrypt(blob)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(w, decrypted)
+		return err
+	}()
+	_, _ = io.Copy(io.Discard, blob)
+	if waitErr := cmd.Wait(); waitErr != nil && err == nil {
+		err = fmt.Errorf("failed to read %s: %w, stderr: %s", object, waitErr, stderr.String())
+	}
+	return err
+}
+
+// FindEncryptedSnapshot decrypts the encrypted snapshots in the local copy of a backup ref, newest
+// first, until the object hash exists. Snapshots that can't be decrypted are skipped; their errors
+// are only returned when the object isn't found
+func (g *GitRepo) FindEncryptedSnapshot(refName, hash string) (bool, error) {
+	output, err := g.gitOutput("log", "--first-parent", "--format=%H%x1f%s%x1f%(trailers:only,unfold,separator=%x1d)", refName)
+	if err != nil {
+		return false, fmt.Errorf("failed to read backup history: %w", err)
+	}
+
+	var errs []error
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.SplitN(line, "\x1f", 3)
+		if len(fields) < 3 || !isEncryptedCommit(fields[1], fields[2]) {
+			continue
+		}
+		if _, err := g.DecryptSnapshot(fields[0]); err != nil {
+			if errors.Is(err, encryption.ErrNoIdentity) {
+				return false, fmt.Errorf("backup ref %s holds encrypted snapshots: %w", refName, err)
+			}
+			errs = append(errs, err)
+			continue
+		}
+		if g.ObjectExists(hash) {
+			return true, nil
+		}
+	}
+	return false, errors.Join(errs...)
+}
+
+// shortHash abbreviates a hash for messages
+func shortHash(hash string) string {
+	if len(hash) > 12 {
+		return hash[:12]
+	}
+	return hash
+}
@@ -0,0 +1,256 @@
+package git
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/FmTod/ghost-backup/internal/encryption"
+)
+
+// setupEncryption encrypts snapshots with a new key pair for the rest of the test
+func setupEncryption(t *testing.T) encryption.Options {
+	t.Helper()
+	identityFile := filepath.Join(t.TempDir(), "identity.txt")
+	if _, err := encryption.GenerateIdentity(identityFile); err != nil {
+		t.Fatalf("GenerateIdentity() error = %v", err)
+	}
+	opts := encryption.Options{IdentityFile: identityFile}
+	SetupEncryption(opts)
+	t.Cleanup(func() { SetupEncryption(encryption.Options{}) })
+	return opts
+}
+
+func TestGitRepo_EncryptedBackups(t *testing.T) {
+	tmpDir := setupTestRepoWithRemote(t)
+	repo := NewGitRepo(tmpDir)
+	refName := BackupRefName("test@example.com", "", "main")
+
+	// The branch is on the remote, so the bundles leave its commits out
+	if _, err := repo.gitOutput("push", "-q", "origin", "HEAD"); err != nil {
+		t.Fatalf("Failed to push branch: %v", err)
+	}
+
+	// Encryption is turned on after a plain snapshot; both stay in the history
+	var stashes []string
+	for i, content := range []string{"plain", "secret-content-1", "secret-content-2"} {
+		if i == 1 {
+			setupEncryption(t)
+		}
+		if err := os.WriteFile(filepath.Join(tmpDir, "test.txt"), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to modify test file: %v", err)
+		}
+		hash, err := repo.CreateStash(false)
+		if err != nil {
+			t.Fatalf("CreateStash() error = %v", err)
+		}
+		if err := repo.PushToBackupRef(hash, refName, "origin"); err != nil {
+			t.Fatalf("PushToBackupRef() error = %v", err)
+		}
+		stashes = append(stashes, hash)
+	}
+
+	// The remote only holds opaque blobs for the encrypted snapshots
+	remoteDir, err := repo.gitOutput("remote", "get-url", "origin")
+	if err != nil {
+		t.Fatalf("Failed to get remote: %v", err)
+	}
+	remote := NewGitRepo(remoteDir)
+	tip, err := remote.gitOutput("rev-parse", refName)
+	if err != nil {
+		t.Fatalf("Failed to read backup ref: %v", err)
+	}
+	if parents, _ := remote.gitOutput("log", "-1", "--format=%P", tip); len(strings.Fields(parents)) != 1 {
+		t.Errorf("Encrypted history commit has parents %q, want only the previous tip", parents)
+	}
+	if tree, _ := remote.gitOutput("ls-tree", "--name-only", tip); tree != encryptedBlobName {
+		t.Errorf("Encrypted history commit tree = %q, want only %s", tree, encryptedBlobName)
+	}
+	for _, stash := range stashes[1:] {
+		if remote.ObjectExists(stash) {
+			t.Errorf("Remote has the encrypted snapshot %s in the clear", stash)
+		}
+	}
+
+	// A clone with the key decrypts the snapshots transparently
+	clone := filepath.Join(t.TempDir(), "clone")
+	if output, err := exec.Command("git", "clone", "-q", remoteDir, clone).CombinedOutput(); err != nil {
+		t.Fatalf("Failed to clone: %v, %s", err, output)
+	}
+	cloneRepo := NewGitRepo(clone)
+
+	snapshots, err := cloneRepo.ListBackupHistory("origin", refName, 0)
+	if err != nil {
+		t.Fatalf("ListBackupHistory() error = %v", err)
+	}
+	if len(snapshots) != 3 {
+		t.Fatalf("ListBackupHistory() = %+v, want 3 snapshots", snapshots)
+	}
+	for i, snapshot := range snapshots {
+		want := stashes[len(stashes)-1-i]
+		if snapshot.Hash != want || snapshot.Encrypted != (i < 2) || !strings.HasPrefix(snapshot.Message, "WIP on") {
+			t.Errorf("Snapshot %d = %+v, want %s (encrypted %v)", i, snapshot, want, i < 2)
+		}
+	}
+
+	if err := cloneRepo.ApplyStash(stashes[2]); err != nil {
+		t.Fatalf("ApplyStash() error = %v", err)
+	}
+	if content, _ := os.ReadFile(filepath.Join(clone, "test.txt")); string(content) != "secret-content-2" {
+		t.Errorf("Restored content = %q, want %q", content, "secret-content-2")
+	}
+
+	// Without the key, the history can't be read
+	SetupEncryption(encryption.Options{})
+	other := filepath.Join(t.TempDir(), "other")
+	if output, err := exec.Command("git", "clone", "-q", remoteDir, other).CombinedOutput(); err != nil {
+		t.Fatalf("Failed to clone: %v, %s", err, output)
+	}
+	if _, err := NewGitRepo(other).ListBackupHistory("origin", refName, 0); !errors.Is(err, encryption.ErrNoIdentity) {
+		t.Errorf("ListBackupHistory() without a key error = %v, want ErrNoIdentity", err)
+	}
+}
+
+// TestGitRepo_EncryptedBackups_Bundle checks encrypted snapshots through a destination that isn't a git remote
+func TestGitRepo_EncryptedBackups_Bundle(t *testing.T) {
+	setupEncryption(t)
+	tmpDir := setupTestRepoWithRemote(t)
+	repo := NewGitRepo(tmpDir)
+	remote := BundleRemote(t.TempDir())
+	refName := BackupRefName("test@example.com", "", "main")
+
+	if err := os.WriteFile(filepath.Join(tmpDir, "test.txt"), []byte("secret"), 0644); err != nil {
+		t.Fatalf("Failed to modify test file: %v", err)
+	}
+	hash, err := repo.CreateStash(false)
+	if err != nil {
+		t.Fatalf("CreateStash() error = %v", err)
+	}
+	if err := repo.PushToBackupRef(hash, refName, remote); err != nil {
+		t.Fatalf("PushToBackupRef() error = %v", err)
+	}
+
+	clone := filepath.Join(t.TempDir(), "clone")
+	if output, err := exec.Command("git", "clone", "-q", tmpDir, clone).CombinedOutput(); err != nil {
+		t.Fatalf("Failed to clone: %v, %s", err, output)
+	}
+	snapshots, err := NewGitRepo(clone).ListBackupHistory(remote, refName, 0)
+	if err != nil {
+		t.Fatalf("ListBackupHistory() error = %v", err)
+	}
+	if len(snapshots) != 1 || snapshots[0].Hash != hash || !snapshots[0].Encrypted {
+		t.Errorf("ListBackupHistory() = %+v, want encrypted %s", snapshots, hash)
+	}
+}
+
+// TestGitRepo_EncryptedBackups_NoDowngrade checks that a plain snapshot is never chained onto an
+// encrypted history, e.g. after the encryption config was lost
+func TestGitRepo_EncryptedBackups_NoDowngrade(t *testing.T) {
+	setupEncryption(t)
+	tmpDir := setupTestRepoWithRemote(t)
+	repo := NewGitRepo(tmpDir)
+	refName := BackupRefName("test@example.com", "", "main")
+
+	var stashes []string
+	for _, content := range []string{"secret-content-1", "secret-content-2"} {
+		if err := os.WriteFile(filepath.Join(tmpDir, "test.txt"), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to modify test file: %v", err)
+		}
+		hash, err := repo.CreateStash(false)
+		if err != nil {
+			t.Fatalf("CreateStash() error = %v", err)
+		}
+		stashes = append(stashes, hash)
+	}
+
+	if err := repo.PushToBackupRef(stashes[0], refName, "origin"); err != nil {
+		t.Fatalf("PushToBackupRef() error = %v", err)
+	}
+	remoteDir, err := repo.gitOutput("remote", "get-url", "origin")
+	if err != nil {
+		t.Fatalf("Failed to get remote: %v", err)
+	}
+	remote := NewGitRepo(remoteDir)
+	tip, err := remote.gitOutput("rev-parse", refName)
+	if err != nil {
+		t.Fatalf("Failed to read backup ref: %v", err)
+	}
+
+	SetupEncryption(encryption.Options{})
+	if err := repo.PushToBackupRef(stashes[1], refName, "origin"); !errors.Is(err, ErrEncryptionRequired) {
+		t.Errorf("PushToBackupRef() without encryption error = %v, want ErrEncryptionRequired", err)
+	}
+	if after, _ := remote.gitOutput("rev-parse", refName); after != tip {
+		t.Errorf("Backup ref moved to %s, want it left at %s", after, tip)
+	}
+	if remote.ObjectExists(stashes[1]) {
+		t.Error("Remote has the snapshot in the clear")
+	}
+}
+
+// TestGitRepo_EncryptedBackups_UndecryptableSnapshot checks that a snapshot encrypted with another
+// key is reported on its own, without hiding the rest of the history
+func TestGitRepo_EncryptedBackups_UndecryptableSnapshot(t *testing.T) {
+	tmpDir := setupTestRepoWithRemote(t)
+	repo := NewGitRepo(tmpDir)
+	refName := BackupRefName("test@example.com", "", "main")
+
+	// The key is rotated between the two snapshots
+	var stashes []string
+	for _, content := range []string{"old-key", "new-key"} {
+		setupEncryption(t)
+		if err := os.WriteFile(filepath.Join(tmpDir, "test.txt"), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to modify test file: %v", err)
+		}
+		hash, err := repo.CreateStash(false)
+		if err != nil {
+			t.Fatalf("CreateStash() error = %v", err)
+		}
+		if err := repo.PushToBackupRef(hash, refName, "origin"); err != nil {
+			t.Fatalf("PushToBackupRef() error = %v", err)
+		}
+		stashes = append(stashes, hash)
+	}
+
+	remoteDir, err := repo.gitOutput("remote", "get-url", "origin")
+	if err != nil {
+		t.Fatalf("Failed to get remote: %v", err)
+	}
+	clone := filepath.Join(t.TempDir(), "clone")
+	if output, err := exec.Command("git", "clone", "-q", remoteDir, clone).CombinedOutput(); err != nil {
+		t.Fatalf("Failed to clone: %v, %s", err, output)
+	}
+	cloneRepo := NewGitRepo(clone)
+
+	// Fetching leaves the snapshots encrypted
+	if err := cloneRepo.FetchBackupRef("origin", refName); err != nil {
+		t.Fatalf("FetchBackupRef() error = %v", err)
+	}
+	if cloneRepo.ObjectExists(stashes[1]) {
+		t.Error("FetchBackupRef() decrypted the snapshots")
+	}
+
+	snapshots, err := cloneRepo.ListBackupHistory("origin", refName, 0)
+	if err != nil {
+		t.Fatalf("ListBackupHistory() error = %v", err)
+	}
+	if len(snapshots) != 2 {
+		t.Fatalf("ListBackupHistory() = %+v, want 2 snapshots", snapshots)
+	}
+	if snapshots[0].Hash != stashes[1] || snapshots[0].DecryptErr != nil {
+		t.Errorf("Newest snapshot = %+v, want %s decrypted", snapshots[0], stashes[1])
+	}
+	if snapshots[1].Hash != "" || snapshots[1].DecryptErr == nil {
+		t.Errorf("Oldest snapshot = %+v, want a decryption error", snapshots[1])
+	}
+
+	if found, err := cloneRepo.FindEncryptedSnapshot(refName, stashes[1]); !found || err != nil {
+		t.Errorf("FindEncryptedSnapshot(newest) = %v, %v, want found", found, err)
+	}
+	if found, err := cloneRepo.FindEncryptedSnapshot(refName, stashes[0]); found || err == nil {
+		t.Errorf("FindEncryptedSnapshot(oldest) = %v, %v, want a decryption error", found, err)
+	}
+}
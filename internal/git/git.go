@@ -2,25 +2,24 @@ package git
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
-)
+	"time"
 
-// Backup ref path structure constants
-// Backup refs follow the format: refs/backups/<user>/<branch>
-const (
-	// userIdentifierIndex is the index of the user identifier in a backup ref path
-	userIdentifierIndex = 2
-	// branchNameIndex is the index of the branch name in a backup ref path
-	branchNameIndex = 3
-	// minRefPartsForUser is the minimum number of parts needed to extract user identifier
-	minRefPartsForUser = 3
-	// minRefPartsForBranch is the minimum number of parts needed to extract branch name
-	minRefPartsForBranch = 4
+	"github.com/FmTod/ghost-backup/internal/encryption"
 )
 
+// backupCommitPrefix marks the history commits that chain snapshots together on a backup ref.
+// Each history commit has the previous backup tip as its first parent and the stash as its last parent.
+const backupCommitPrefix = "ghost-backup: "
+
+// ErrNoChangesLeft is returned when excluding paths from a stash leaves nothing to back up
+var ErrNoChangesLeft = errors.New("no changes left")
+
 // GitRepo represents a git repository
 //
 //goland:noinspection GoNameStartsWithPackageName
@@ -33,25 +32,6 @@ func NewGitRepo(path string) *GitRepo {
 	return &GitRepo{Path: path}
 }
 
-// SetupGitCredentials configures git to use the provided credentials for authentication
-// This sets up environment variables for non-interactive authentication
-func SetupGitCredentials(username, token string) {
-	if token == "" {
-		return
-	}
-
-	// Use provided username or fall back to token for both username and password
-	if username == "" {
-		username = token
-	}
-
-	// Set GIT_ASKPASS to echo the token
-	// This allows git to use the credentials for HTTPS authentication without prompting
-	os.Setenv("GIT_ASKPASS", "echo")
-	os.Setenv("GIT_USERNAME", username)
-	os.Setenv("GIT_PASSWORD", token)
-}
-
 // execGitCommand executes a git command with proper credential handling
 func (g *GitRepo) execGitCommand(args ...string) *exec.Cmd {
 	cmd := exec.Command("git", args...)
@@ -62,6 +42,9 @@ func (g *GitRepo) execGitCommand(args ...string) *exec.Cmd {
 		"GIT_TERMINAL_PROMPT=0", // Disable prompting
 	)
 
+	// Answer credential prompts with the stored token through the askpass helper
+	cmd.Env = append(cmd.Env, credentialEnv()...)
+
 	return cmd
 }
 
@@ -133,6 +116,15 @@ func (g *GitRepo) HasChanges() (bool, error) {
 	return len(strings.TrimSpace(string(output))) > 0, nil
 }
 
+// CreateSnapshot creates the stash backed up for a repository's settings
+// onlyStaged takes precedence over includeUntracked; keepUntracked, if set, selects the untracked files to add
+func (g *GitRepo) CreateSnapshot(onlyStaged, includeUntracked bool, keepUntracked func(path string) bool) (string, error) {
+	if includeUntracked && !onlyStaged {
+		return g.CreateStashWithUntracked(keepUntracked)
+	}
+	return g.CreateStash(onlyStaged)
+}
+
 // CreateStash creates a stash and returns the hash
 // If onlyStaged is true, only staged changes will be stashed
 func (g *GitRepo) CreateStash(onlyStaged bool) (string, error) {
@@ -159,6 +151,340 @@ func (g *GitRepo) CreateStash(onlyStaged bool) (string, error) {
 	return hash, nil
 }
 
+// CreateStashWithUntracked creates a stash that also contains untracked (but not ignored) files
+// The snapshot is built from a temporary index (GIT_INDEX_FILE), so the real index and working
+// tree are never touched. The result has the same shape as a 'git stash create' commit
+// (parents: HEAD, index commit), with untracked files added to the working tree, so it can be
+// restored with 'git stash apply'.
+// When keep is set, only the untracked files it accepts are added, so skipped ones are never even hashed.
+func (g *GitRepo) CreateStashWithUntracked(keep func(path string) bool) (string, error) {
+	head, err := g.revParse("HEAD")
+	if err != nil {
+		return "", fmt.Errorf("failed to create stash: %w", err)
+	}
+	headTree, err := g.revParse("HEAD^{tree}")
+	if err != nil {
+		return "", fmt.Errorf("failed to create stash: %w", err)
+	}
+
+	tmpDir, err := os.MkdirTemp("", "ghost-backup-index-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary index directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	indexFile, err := g.copyIndex(tmpDir)
+	if err != nil {
+		return "", err
+	}
+
+	// Tree of the staged changes, taken from the copy of the real index
+	indexTree, err := g.indexGit(indexFile, "write-tree")
+	if err != nil {
+		return "", fmt.Errorf("failed to write index tree: %w", err)
+	}
+
+	// Add tracked changes and untracked files that are not ignored
+	if err := g.addWorkingTree(indexFile, keep); err != nil {
+		return "", fmt.Errorf("failed to add working tree to temporary index: %w", err)
+	}
+	workTree, err := g.indexGit(indexFile, "write-tree")
+	if err != nil {
+		return "", fmt.Errorf("failed to write working tree: %w", err)
+	}
+
+	if indexTree == headTree && workTree == headTree {
+		if keep != nil {
+			// Changes may only have been skipped
+			return "", fmt.Errorf("%w to stash", ErrNoChangesLeft)
+		}
+		return "", fmt.Errorf("no changes to stash")
+	}
+
+	// Mirror the messages git stash uses
+	branch, err := g.GetCurrentBranch()
+	if err != nil || branch == "HEAD" {
+		branch = "(no branch)"
+	}
+	headSummary, err := g.gitOutput("log", "-1", "--format=%h %s", "HEAD")
+	if err != nil {
+		return "", fmt.Errorf("failed to read HEAD summary: %w", err)
+	}
+
+	indexCommit, err := g.gitOutput("commit-tree", indexTree, "-p", head,
+		"-m", fmt.Sprintf("index on %s: %s", branch, headSummary))
+	if err != nil {
+		return "", fmt.Errorf("failed to create index commit: %w", err)
+	}
+
+	hash, err := g.gitOutput("commit-tree", workTree, "-p", head, "-p", indexCommit,
+		"-m", fmt.Sprintf("WIP on %s: %s", branch, headSummary))
+	if err != nil {
+		return "", fmt.Errorf("failed to create stash commit: %w", err)
+	}
+
+	return hash, nil
+}
+
+// ExcludeFromStash returns a copy of a stash in which the given paths are reset to their HEAD
+// version (or dropped if HEAD doesn't have them), in both the index and working tree commits.
+// Restoring the copy leaves those files untouched. Trailers are appended to the stash message
+// so the exclusion is recorded in the snapshot.
+func (g *GitRepo) ExcludeFromStash(stashHash string, paths []string, trailers []string) (string, error) {
+	head, err := g.revParse(stashHash + "^1")
+	if err != nil {
+		return "", fmt.Errorf("failed to read stash parent: %w", err)
+	}
+	indexCommit, err := g.revParse(stashHash + "^2")
+	if err != nil {
+		return "", fmt.Errorf("failed to read stash index commit: %w", err)
+	}
+	headTree, err := g.revParse(head + "^{tree}")
+	if err != nil {
+		return "", fmt.Errorf("failed to read HEAD tree: %w", err)
+	}
+
+	// Index entries restoring each path to HEAD; mode 0 removes paths HEAD doesn't have
+	lsTree, err := g.gitOutput(append([]string{"ls-tree", "-z", head, "--"}, paths...)...)
+	if err != nil {
+		return "", fmt.Errorf("failed to read HEAD entries: %w", err)
+	}
+	headEntries := make(map[string]string)
+	for _, entry := range strings.Split(lsTree, "\x00") {
+		info, path, ok := strings.Cut(entry, "\t")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(info) // <mode> <type> <hash>
+		if len(fields) == 3 && fields[1] == "blob" {
+			headEntries[path] = fields[0] + " " + fields[2]
+		}
+	}
+	var indexInfo strings.Builder
+	for _, path := range paths {
+		entry, ok := headEntries[path]
+		if !ok {
+			entry = "0 0000000000000000000000000000000000000000"
+		}
+		indexInfo.WriteString(entry + "\t" + path + "\x00")
+	}
+
+	tmpDir, err := os.MkdirTemp("", "ghost-backup-index-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary index directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	indexFile := filepath.Join(tmpDir, "index")
+
+	filterTree := func(commit string) (string, error) {
+		if _, err := g.indexGit(indexFile, "read-tree", commit+"^{tree}"); err != nil {
+			return "", err
+		}
+		if _, err := g.indexGitInput(indexFile, indexInfo.String(), "update-index", "-z", "--index-info"); err != nil {
+			return "", err
+		}
+		return g.indexGit(indexFile, "write-tree")
+	}
+
+	indexTree, err := filterTree(indexCommit)
+	if err != nil {
+		return "", fmt.Errorf("failed to filter index tree: %w", err)
+	}
+	workTree, err := filterTree(stashHash)
+	if err != nil {
+		return "", fmt.Errorf("failed to filter working tree: %w", err)
+	}
+	if indexTree == headTree && workTree == headTree {
+		return "", fmt.Errorf("%w after excluding %d paths", ErrNoChangesLeft, len(paths))
+	}
+
+	indexMessage, err := g.gitOutput("log", "-1", "--format=%B", indexCommit)
+	if err != nil {
+		return "", fmt.Errorf("failed to read index commit message: %w", err)
+	}
+	message, err := g.gitOutput("log", "-1", "--format=%B", stashHash)
+	if err != nil {
+		return "", fmt.Errorf("failed to read stash message: %w", err)
+	}
+	message = appendTrailers(message, trailers)
+
+	newIndexCommit, err := g.gitOutput("commit-tree", indexTree, "-p", head, "-m", indexMessage)
+	if err != nil {
+		return "", fmt.Errorf("failed to create index commit: %w", err)
+	}
+
+	hash, err := g.gitOutput("commit-tree", workTree, "-p", head, "-p", newIndexCommit, "-m", message)
+	if err != nil {
+		return "", fmt.Errorf("failed to create stash commit: %w", err)
+	}
+
+	return hash, nil
+}
+
+// copyIndex copies the repository's index into dir and returns the path of the copy
+// If the repository has no index yet, the returned path doesn't exist and git treats it as empty
+func (g *GitRepo) copyIndex(dir string) (string, error) {
+	indexPath, err := g.gitOutput("rev-parse", "--git-path", "index")
+	if err != nil {
+		return "", fmt.Errorf("failed to locate index: %w", err)
+	}
+	if !filepath.IsAbs(indexPath) {
+		indexPath = filepath.Join(g.Path, indexPath)
+	}
+
+	tmpIndex := filepath.Join(dir, "index")
+	data, err := os.ReadFile(indexPath)
+	if os.IsNotExist(err) {
+		return tmpIndex, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read index: %w", err)
+	}
+
+	if err := os.WriteFile(tmpIndex, data, 0600); err != nil {
+		return "", fmt.Errorf("failed to write temporary index: %w", err)
+	}
+
+	return tmpIndex, nil
+}
+
+// addWorkingTree adds tracked changes and the untracked files accepted by keep (all if nil) to an index
+func (g *GitRepo) addWorkingTree(indexFile string, keep func(path string) bool) error {
+	if keep == nil {
+		_, err := g.indexGit(indexFile, "add", "-A")
+		return err
+	}
+
+	if _, err := g.indexGit(indexFile, "add", "-u"); err != nil {
+		return err
+	}
+	untracked, err := g.indexGit(indexFile, "ls-files", "-z", "--others", "--exclude-standard")
+	if err != nil {
+		return err
+	}
+	var kept strings.Builder
+	for _, path := range strings.Split(untracked, "\x00") {
+		if path != "" && keep(path) {
+			kept.WriteString(path + "\x00")
+		}
+	}
+	if kept.Len() == 0 {
+		return nil
+	}
+	_, err = g.indexGitInput(indexFile, kept.String(), "--literal-pathspecs", "add", "--pathspec-from-file=-", "--pathspec-file-nul")
+	return err
+}
+
+// indexGit runs a git command against an alternate index file and returns its trimmed output
+func (g *GitRepo) indexGit(indexFile string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = g.Path
+	cmd.Env = append(os.Environ(), "GIT_INDEX_FILE="+indexFile)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%w, stderr: %s", err, stderr.String())
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// indexGitInput runs a git command against an alternate index file, feeding input on stdin
+func (g *GitRepo) indexGitInput(indexFile, input string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = g.Path
+	cmd.Env = append(os.Environ(), "GIT_INDEX_FILE="+indexFile)
+	cmd.Stdin = strings.NewReader(input)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%w, stderr: %s", err, stderr.String())
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// revParse resolves a revision to a full object hash
+func (g *GitRepo) revParse(rev string) (string, error) {
+	return g.gitOutput("rev-parse", "--verify", rev)
+}
+
+// gitOutput runs a git command in the repository and returns its trimmed output
+func (g *GitRepo) gitOutput(args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = g.Path
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%w, stderr: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// ListIgnoredDirectories returns the untracked directories excluded by .gitignore, relative to the repo root
+// When paths are given, only the directories below them are listed
+func (g *GitRepo) ListIgnoredDirectories(paths ...string) ([]string, error) {
+	args := []string{"ls-files", "--others", "--ignored", "--exclude-standard", "--directory", "-z"}
+	if len(paths) > 0 {
+		args = append(append(args, "--"), paths...)
+	}
+	cmd := exec.Command("git", args...)
+	cmd.Dir = g.Path
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list ignored directories: %w", err)
+	}
+
+	var dirs []string
+	for _, entry := range strings.Split(string(output), "\x00") {
+		if strings.HasSuffix(entry, "/") {
+			dirs = append(dirs, strings.TrimSuffix(entry, "/"))
+		}
+	}
+	return dirs, nil
+}
+
+// FilterIgnoredPaths returns the paths (relative to the repo root) that are not ignored by .gitignore
+func (g *GitRepo) FilterIgnoredPaths(paths []string) ([]string, error) {
+	if len(paths) == 0 {
+		return nil, nil
+	}
+
+	cmd := exec.Command("git", "check-ignore", "--stdin", "-z")
+	cmd.Dir = g.Path
+	cmd.Stdin = strings.NewReader(strings.Join(paths, "\x00") + "\x00")
+	output, err := cmd.Output()
+	if err != nil {
+		// Exit code 1 means none of the paths are ignored
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) || exitErr.ExitCode() != 1 {
+			return nil, fmt.Errorf("failed to check ignored paths: %w", err)
+		}
+	}
+
+	ignored := make(map[string]struct{})
+	for _, entry := range strings.Split(string(output), "\x00") {
+		if entry != "" {
+			ignored[entry] = struct{}{}
+		}
+	}
+
+	var kept []string
+	for _, path := range paths {
+		if _, ok := ignored[path]; !ok {
+			kept = append(kept, path)
+		}
+	}
+	return kept, nil
+}
+
 // GetDiff returns the diff for a specific commit/stash hash
 func (g *GitRepo) GetDiff(hash string) (string, error) {
 	cmd := exec.Command("git", "show", hash, "-p")
@@ -201,24 +527,110 @@ func (g *GitRepo) GetFilesChanged(hash string) (string, error) {
 	return string(output), nil
 }
 
-// PushToBackupRef pushes a hash to a backup reference
-func (g *GitRepo) PushToBackupRef(hash, userIdentifier, branch, remote string) error {
-	// Create ref name: refs/backups/<user_identifier>/<branch_name>
-	// Branch name is not sanitized to preserve slashes in branch paths
-	refName := fmt.Sprintf("refs/backups/%s/%s", SanitizeRefName(userIdentifier), branch)
+// GetRemoteRefHash returns the hash a ref points to on the remote, or an empty string if it doesn't exist
+func (g *GitRepo) GetRemoteRefHash(remote, refName string) (string, error) {
+	d, err := g.OpenDestination(remote)
+	if err != nil {
+		return "", err
+	}
+	return refHash(d, refName)
+}
 
-	// Push the hash to the remote ref with --force since each stash is independent
-	// Format: git push --force <remote> <hash>:<ref>
-	cmd := g.execGitCommand("push", "--force", remote, fmt.Sprintf("%s:%s", hash, refName))
+// refHash returns the hash a ref points to in a destination, or an empty string if it doesn't exist
+func refHash(d Destination, refName string) (string, error) {
+	refs, err := d.List(refName)
+	if err != nil {
+		return "", fmt.Errorf("failed to query remote ref: %w", err)
+	}
+
+	for _, ref := range refs {
+		if ref.Ref == refName {
+			return ref.Hash, nil
+		}
+	}
+
+	return "", nil
+}
+
+// CreateBackupCommit creates a history commit that chains a stash onto the previous backup tip.
+// The commit reuses the stash tree and date; parentHash may be empty for the first backup on a ref.
+func (g *GitRepo) CreateBackupCommit(stashHash, parentHash string) (string, error) {
+	cmd := exec.Command("git", "log", "-1", "--format=%cI%n%B", stashHash)
+	cmd.Dir = g.Path
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to read stash message: %w", err)
+	}
+	// The full stash message is kept so trailers recorded on the snapshot carry over
+	date, stashMessage, _ := strings.Cut(strings.TrimSpace(string(output)), "\n")
+	message := backupCommitPrefix + stashMessage
+
+	args := []string{"commit-tree", stashHash + "^{tree}", "-m", message}
+	if parentHash != "" {
+		args = append(args, "-p", parentHash)
+	}
+	args = append(args, "-p", stashHash)
+
+	cmd = exec.Command("git", args...)
+	cmd.Dir = g.Path
+	// Keep the time the snapshot was taken, even when it is pushed later from the pending queue
+	cmd.Env = append(os.Environ(), "GIT_COMMITTER_DATE="+date, "GIT_AUTHOR_DATE="+date)
 
 	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
 
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("failed to push to backup ref: %w, stderr: %s", err, stderr.String())
+	output, err = cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to create backup commit: %w, stderr: %s", err, stderr.String())
 	}
 
-	return nil
+	return strings.TrimSpace(string(output)), nil
+}
+
+// PushToBackupRef pushes a hash to a backup reference, as named by BackupRefName
+// The stash is chained onto the existing backup history so earlier snapshots stay reachable
+func (g *GitRepo) PushToBackupRef(hash, refName, remote string) error {
+	d, err := g.OpenDestination(remote)
+	if err != nil {
+		return err
+	}
+	return d.Store(hash, refName)
+}
+
+// chainSnapshot creates the history commit that appends a snapshot to a backup ref of a destination
+// The current tip is fetched first so the new commit can build on it; it is returned as the parent
+func (g *GitRepo) chainSnapshot(d Destination, hash, refName string) (commit, parent string, err error) {
+	parent, err = refHash(d, refName)
+	if err != nil {
+		return "", "", err
+	}
+	if parent != "" {
+		if err := d.Fetch(refName); err != nil {
+			return "", "", err
+		}
+	}
+
+	opts := currentEncryption()
+	if parent != "" && !opts.Enabled() {
+		// A lost config or key must not quietly downgrade an encrypted history to plaintext
+		encrypted, err := g.isEncryptedBackupCommit(parent)
+		if err != nil {
+			return "", "", err
+		}
+		if encrypted {
+			return "", "", fmt.Errorf("%w: %s", ErrEncryptionRequired, refName)
+		}
+	}
+
+	if opts.Enabled() {
+		commit, err = g.CreateEncryptedBackupCommit(hash, parent, opts)
+	} else {
+		commit, err = g.CreateBackupCommit(hash, parent)
+	}
+	if err != nil {
+		return "", "", err
+	}
+	return commit, parent, nil
 }
 
 // parseBackupRefs parses git ls-remote output into BackupRef structs
@@ -231,62 +643,46 @@ func parseBackupRefs(output string) []BackupRef {
 		}
 		parts := strings.Fields(line)
 		if len(parts) >= 2 {
-			refs = append(refs, BackupRef{
+			ref := BackupRef{
 				Hash: parts[0],
 				Ref:  parts[1],
-			})
+			}
+			ref.UserIdentifier, ref.Machine, ref.Branch, _ = ParseBackupRefName(ref.Ref)
+			refs = append(refs, ref)
 		}
 	}
 	return refs
 }
 
-// ListBackupRefs lists all backup references for the current user and branch
-func (g *GitRepo) ListBackupRefs(remote, userIdentifier, branch string) ([]BackupRef, error) {
-	// Branch name is not sanitized to preserve slashes in branch paths
-	refPattern := fmt.Sprintf("refs/backups/%s/%s", SanitizeRefName(userIdentifier), branch)
-
-	// Fetch refs from remote
-	cmd := exec.Command("git", "ls-remote", remote, refPattern)
-	cmd.Dir = g.Path
-	output, err := cmd.Output()
+// ListBackupRefs lists the backup references of a user's branch, on the given machine or on any
+// machine (including refs shared between machines) when machine is empty
+func (g *GitRepo) ListBackupRefs(remote, userIdentifier, machine, branch string) ([]BackupRef, error) {
+	refs, err := g.ListAllBackupRefsForUser(remote, userIdentifier)
 	if err != nil {
-		return nil, fmt.Errorf("failed to list backup refs: %w", err)
+		return nil, err
 	}
 
-	return parseBackupRefs(string(output)), nil
+	var matching []BackupRef
+	for _, ref := range FilterBackupRefsByMachine(refs, machine) {
+		if ref.Branch == branch {
+			matching = append(matching, ref)
+		}
+	}
+	return matching, nil
 }
 
-// ListAllBackupUsers lists all users who have backups in the remote repository
-func (g *GitRepo) ListAllBackupUsers(remote string) ([]string, error) {
-	// Fetch all refs under refs/backups/*/*
-	// Pattern matches refs/backups/<user>/<branch>
-	cmd := exec.Command("git", "ls-remote", remote, "refs/backups/*/*")
-	cmd.Dir = g.Path
-	output, err := cmd.Output()
+// ListAllBackupUsers lists all users who have backups in the remote repository,
+// only counting the backups of the given machine unless machine is empty
+func (g *GitRepo) ListAllBackupUsers(remote, machine string) ([]string, error) {
+	refs, err := g.ListAllBackupRefs(remote)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list backup users: %w", err)
 	}
 
-	// Parse output and extract unique user identifiers
+	// Extract unique user identifiers
 	userSet := make(map[string]struct{})
-	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
-	for _, line := range lines {
-		if line == "" {
-			continue
-		}
-		parts := strings.Fields(line)
-		if len(parts) >= 2 {
-			// Extract user identifier from ref path
-			// Format: refs/backups/<user_identifier>/<branch>
-			ref := parts[1]
-			if strings.HasPrefix(ref, "refs/backups/") {
-				refParts := strings.Split(ref, "/")
-				if len(refParts) >= minRefPartsForUser {
-					userIdentifier := refParts[userIdentifierIndex]
-					userSet[userIdentifier] = struct{}{}
-				}
-			}
-		}
+	for _, ref := range FilterBackupRefsByMachine(refs, machine) {
+		userSet[ref.UserIdentifier] = struct{}{}
 	}
 
 	// Convert set to slice
@@ -298,44 +694,40 @@ func (g *GitRepo) ListAllBackupUsers(remote string) ([]string, error) {
 	return users, nil
 }
 
-// ListAllBackupRefsForUser lists all backup references for a specific user across all branches
+// ListAllBackupRefsForUser lists all backup references for a specific user across all branches and machines
 func (g *GitRepo) ListAllBackupRefsForUser(remote, userIdentifier string) ([]BackupRef, error) {
-	refPattern := fmt.Sprintf("refs/backups/%s/*", SanitizeRefName(userIdentifier))
+	refPattern := fmt.Sprintf("%s%s/*", backupRefPrefix, encodeRefComponent(userIdentifier))
 
 	// Fetch refs from remote
-	cmd := exec.Command("git", "ls-remote", remote, refPattern)
-	cmd.Dir = g.Path
-	output, err := cmd.Output()
+	all, err := g.listBackupRefs(remote, refPattern)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list backup refs for user: %w", err)
 	}
 
-	return parseBackupRefs(string(output)), nil
+	var refs []BackupRef
+	for _, ref := range currentBackupRefs(all) {
+		if ref.UserIdentifier == userIdentifier {
+			refs = append(refs, ref)
+		}
+	}
+	return refs, nil
 }
 
-// extractBranchNamesFromRefs parses git ls-remote output and extracts unique branch names
-// from backup refs with format refs/backups/<user>/<branch>
-func extractBranchNamesFromRefs(output string) []string {
+// listBackupRefs lists the refs of a backup remote matching a Destination.List pattern
+func (g *GitRepo) listBackupRefs(remote, pattern string) ([]BackupRef, error) {
+	d, err := g.OpenDestination(remote)
+	if err != nil {
+		return nil, err
+	}
+	return d.List(pattern)
+}
+
+// backupBranchNames extracts unique branch names from backup refs in the current layout, only
+// keeping the refs of the given machine unless machine is empty
+func backupBranchNames(refs []BackupRef, machine string) []string {
 	branchSet := make(map[string]struct{})
-	lines := strings.Split(strings.TrimSpace(output), "\n")
-	for _, line := range lines {
-		if line == "" {
-			continue
-		}
-		parts := strings.Fields(line)
-		if len(parts) >= 2 {
-			// Extract branch name from ref path
-			// Format: refs/backups/<user_identifier>/<branch>
-			// Branch may contain slashes, so join all parts from index 3 onwards
-			ref := parts[1]
-			if strings.HasPrefix(ref, "refs/backups/") {
-				refParts := strings.Split(ref, "/")
-				if len(refParts) >= minRefPartsForBranch {
-					branchName := strings.Join(refParts[branchNameIndex:], "/")
-					branchSet[branchName] = struct{}{}
-				}
-			}
-		}
+	for _, ref := range FilterBackupRefsByMachine(currentBackupRefs(refs), machine) {
+		branchSet[ref.Branch] = struct{}{}
 	}
 
 	// Convert set to slice
@@ -347,57 +739,195 @@ func extractBranchNamesFromRefs(output string) []string {
 	return branches
 }
 
-// ListAllBackupBranches lists all branches that have backups in the remote repository
-func (g *GitRepo) ListAllBackupBranches(remote string) ([]string, error) {
-	// Fetch all refs under refs/backups/*/*
-	// Pattern matches refs/backups/<user>/<branch>
-	cmd := exec.Command("git", "ls-remote", remote, "refs/backups/*/*")
-	cmd.Dir = g.Path
-	output, err := cmd.Output()
+// FilterBackupRefsByMachine keeps the backup refs of a machine, or all of them when machine is empty
+func FilterBackupRefsByMachine(refs []BackupRef, machine string) []BackupRef {
+	if machine == "" {
+		return refs
+	}
+	var matching []BackupRef
+	for _, ref := range refs {
+		if ref.Machine == machine {
+			matching = append(matching, ref)
+		}
+	}
+	return matching
+}
+
+// ListAllBackupBranches lists all branches that have backups in the remote repository,
+// on the given machine unless machine is empty
+func (g *GitRepo) ListAllBackupBranches(remote, machine string) ([]string, error) {
+	// Fetch all refs under refs/ghost-backup/backups/
+	refs, err := g.listBackupRefs(remote, backupRefPrefix+"*")
 	if err != nil {
 		return nil, fmt.Errorf("failed to list backup branches: %w", err)
 	}
 
-	return extractBranchNamesFromRefs(string(output)), nil
+	return backupBranchNames(refs, machine), nil
 }
 
-// ListBackupBranchesForUser lists all branches that have backups for a specific user
-func (g *GitRepo) ListBackupBranchesForUser(remote, userIdentifier string) ([]string, error) {
-	refPattern := fmt.Sprintf("refs/backups/%s/*", SanitizeRefName(userIdentifier))
-
-	// Fetch refs from remote
-	cmd := exec.Command("git", "ls-remote", remote, refPattern)
-	cmd.Dir = g.Path
-	output, err := cmd.Output()
+// ListBackupBranchesForUser lists all branches that have backups for a specific user,
+// on the given machine unless machine is empty
+func (g *GitRepo) ListBackupBranchesForUser(remote, userIdentifier, machine string) ([]string, error) {
+	refs, err := g.ListAllBackupRefsForUser(remote, userIdentifier)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list backup branches for user: %w", err)
 	}
 
-	return extractBranchNamesFromRefs(string(output)), nil
+	branchSet := make(map[string]struct{})
+	for _, ref := range FilterBackupRefsByMachine(refs, machine) {
+		branchSet[ref.Branch] = struct{}{}
+	}
+
+	// Convert set to slice
+	branches := make([]string, 0, len(branchSet))
+	for branch := range branchSet {
+		branches = append(branches, branch)
+	}
+
+	return branches, nil
 }
 
-// ListAllBackupRefs lists all backup references across all users and branches
+// ListAllBackupRefs lists all backup references across all users, machines and branches
 func (g *GitRepo) ListAllBackupRefs(remote string) ([]BackupRef, error) {
-	// Fetch all refs under refs/backups/*/*
-	// Pattern matches refs/backups/<user>/<branch>
-	cmd := exec.Command("git", "ls-remote", remote, "refs/backups/*/*")
-	cmd.Dir = g.Path
-	output, err := cmd.Output()
+	// Fetch all refs under refs/ghost-backup/backups/
+	refs, err := g.listBackupRefs(remote, backupRefPrefix+"*")
 	if err != nil {
 		return nil, fmt.Errorf("failed to list all backup refs: %w", err)
 	}
 
-	return parseBackupRefs(string(output)), nil
+	return currentBackupRefs(refs), nil
 }
 
 // FetchBackupRef fetches a specific backup reference
+// The local copy is a mirror of the remote ref, so it is force-updated. Encrypted snapshots in its
+// history are left encrypted, see ListBackupHistory and FindEncryptedSnapshot
 func (g *GitRepo) FetchBackupRef(remote, refName string) error {
-	cmd := exec.Command("git", "fetch", remote, fmt.Sprintf("%s:%s", refName, refName))
+	d, err := g.OpenDestination(remote)
+	if err != nil {
+		return err
+	}
+	return d.Fetch(refName)
+}
+
+// BackupSnapshot represents a single snapshot in the history of a backup ref
+type BackupSnapshot struct {
+	Hash       string    // Stash commit that can be restored
+	Commit     string    // History commit on the backup ref
+	Date       time.Time // When the snapshot was pushed
+	Message    string    // Stash message (e.g. "WIP on main: ...")
+	Encrypted  bool      // Stored encrypted on the backup ref; Hash is the decrypted stash
+	DecryptErr error     // Why an encrypted snapshot couldn't be decrypted; Hash is empty then
+
+	Metadata SnapshotMetadata // Recorded by ghost-backup when the snapshot was taken; zero for older snapshots
+}
+
+// parseBackupHistory parses first-parent git log output of a backup ref into snapshots
+// Each line has the format: <commit>\x1f<parents>\x1f<committer date>\x1f<subject>[\x1f<trailers>]
+// with trailers separated by \x1d
+func parseBackupHistory(output string) []BackupSnapshot {
+	var snapshots []BackupSnapshot
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	for _, line := range lines {
+		if line == "" {
+			continue
+		}
+		fields := strings.SplitN(line, "\x1f", 5)
+		if len(fields) < 4 {
+			continue
+		}
+		var metadata SnapshotMetadata
+		if len(fields) == 5 {
+			metadata = ParseSnapshotMetadata(strings.Split(fields[4], "\x1d"))
+		}
+
+		date, _ := time.Parse(time.RFC3339, fields[2])
+		parents := strings.Fields(fields[1])
+
+		// Encrypted snapshots only become known once decrypted, see ListBackupHistory
+		if len(fields) == 5 && isEncryptedCommit(fields[3], fields[4]) {
+			snapshots = append(snapshots, BackupSnapshot{
+				Commit:    fields[0],
+				Date:      date,
+				Encrypted: true,
+			})
+			if len(parents) == 0 {
+				break
+			}
+			continue
+		}
+
+		// Snapshots pushed before history was kept are plain stash commits; the chain ends there
+		if !strings.HasPrefix(fields[3], backupCommitPrefix) || len(parents) == 0 {
+			snapshots = append(snapshots, BackupSnapshot{
+				Hash:     fields[0],
+				Commit:   fields[0],
+				Date:     date,
+				Message:  fields[3],
+				Metadata: metadata,
+			})
+			break
+		}
+
+		snapshots = append(snapshots, BackupSnapshot{
+			Hash:     parents[len(parents)-1],
+			Commit:   fields[0],
+			Date:     date,
+			Message:  strings.TrimPrefix(fields[3], backupCommitPrefix),
+			Metadata: metadata,
+		})
+
+		// The first history commit only has the stash as parent
+		if len(parents) == 1 {
+			break
+		}
+	}
+	return snapshots
+}
+
+// ListBackupHistory fetches a backup ref and returns its snapshots, newest first
+// A limit of zero or less returns the full history. Only the encrypted snapshots returned are
+// decrypted; one that can't be is returned with DecryptErr set
+func (g *GitRepo) ListBackupHistory(remote, refName string, limit int) ([]BackupSnapshot, error) {
+	if err := g.FetchBackupRef(remote, refName); err != nil {
+		return nil, err
+	}
+
+	args := []string{"log", "--first-parent", "--format=%H%x1f%P%x1f%cI%x1f%s%x1f%(trailers:only,unfold,separator=%x1d)"}
+	if limit > 0 {
+		args = append(args, "-n", fmt.Sprintf("%d", limit))
+	}
+	args = append(args, refName)
+
+	cmd := exec.Command("git", args...)
 	cmd.Dir = g.Path
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("failed to fetch backup ref: %w", err)
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup history: %w", err)
 	}
-	return nil
+
+	snapshots := parseBackupHistory(string(output))
+	for i, snapshot := range snapshots {
+		if !snapshot.Encrypted {
+			continue
+		}
+		// Only the snapshots listed are decrypted; one that fails doesn't hide the others
+		hash, err := g.DecryptSnapshot(snapshot.Commit)
+		if errors.Is(err, encryption.ErrNoIdentity) {
+			return nil, fmt.Errorf("backup ref %s holds encrypted snapshots: %w", refName, err)
+		}
+		if err != nil {
+			snapshots[i].DecryptErr = err
+			continue
+		}
+		snapshots[i].Hash = hash
+		if snapshots[i].Message, err = g.gitOutput("log", "-1", "--format=%s", snapshots[i].Hash); err != nil {
+			return nil, fmt.Errorf("failed to read backup history: %w", err)
+		}
+		if snapshots[i].Metadata, err = g.GetSnapshotMetadata(snapshots[i].Hash); err != nil {
+			return nil, err
+		}
+	}
+	return snapshots, nil
 }
 
 // ApplyStash applies a stash by hash
@@ -460,9 +990,15 @@ func (g *GitRepo) GetRemote() (string, error) {
 type BackupRef struct {
 	Hash string
 	Ref  string
+
+	// Parsed from Ref; Machine is empty for refs shared between machines
+	UserIdentifier string
+	Machine        string
+	Branch         string
 }
 
-// SanitizeRefName sanitizes a string to be used in a git ref name
+// SanitizeRefName sanitizes a string the way user identifiers and machine names were put in
+// legacy backup refs (refs/backups/...). The mapping is lossy; current refs use BackupRefName
 func SanitizeRefName(s string) string {
 	// Replace characters that are not allowed in git ref names
 	replacer := strings.NewReplacer(
@@ -481,18 +1017,19 @@ func SanitizeRefName(s string) string {
 }
 
 // GenerateUserIdentifier creates a user identifier for backup refs
-// Priority: 1) git_user from global config, 2) git username (sanitized), 3) sanitized email
+// Priority: 1) git_user from global config, 2) git username, 3) email
+// The identifier is kept as is; BackupRefName encodes it for the ref
 func GenerateUserIdentifier(gitUser, gitName, email string) string {
 	// Priority 1: Use git_user from global config if set
 	if gitUser != "" {
-		return SanitizeRefName(gitUser)
+		return gitUser
 	}
 
-	// Priority 2: Use git username (sanitized)
+	// Priority 2: Use git username
 	if gitName != "" {
-		return SanitizeRefName(gitName)
+		return gitName
 	}
 
-	// Priority 3: Use sanitized email
-	return SanitizeRefName(email)
+	// Priority 3: Use email
+	return email
 }
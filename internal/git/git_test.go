@@ -1,9 +1,12 @@
 package git
 
 import (
+	"errors"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -324,7 +327,7 @@ func TestGitRepo_ListBackupRefs_NoRefs(t *testing.T) {
 
 	// This will likely fail because the remote doesn't exist
 	// but we're testing the function behavior
-	refs, err := repo.ListBackupRefs("origin", "test@example.com", "main")
+	refs, err := repo.ListBackupRefs("origin", "test@example.com", "", "main")
 
 	// Error is expected since remote doesn't actually exist
 	if err == nil && len(refs) > 0 {
@@ -352,32 +355,59 @@ func TestGitRepo_CherryPick_InvalidHash(t *testing.T) {
 	}
 }
 
-func TestExtractBranchNamesFromRefs(t *testing.T) {
+func TestBackupBranchNames(t *testing.T) {
 	tests := []struct {
 		name     string
 		input    string
+		machine  string
 		expected []string
 	}{
 		{
 			name:     "simple branch names",
-			input:    "abc123\trefs/backups/user/main\ndef456\trefs/backups/user/develop",
+			input:    "abc123\trefs/ghost-backup/backups/user/main/@\ndef456\trefs/ghost-backup/backups/user/develop/@",
 			expected: []string{"main", "develop"},
 		},
 		{
 			name:     "branch names with slashes",
-			input:    "abc123\trefs/backups/viicslen/refactor/promotions-form-store\ndef456\trefs/backups/viicslen/feature/new-ui",
+			input:    "abc123\trefs/ghost-backup/backups/viicslen/refactor/promotions-form-store/@\ndef456\trefs/ghost-backup/backups/viicslen/feature/new-ui/@",
 			expected: []string{"refactor/promotions-form-store", "feature/new-ui"},
 		},
 		{
 			name:     "mixed branch names",
-			input:    "abc123\trefs/backups/user/main\ndef456\trefs/backups/user/feature/branch-1\nghi789\trefs/backups/user/hotfix/bug-fix",
+			input:    "abc123\trefs/ghost-backup/backups/user/main/@\ndef456\trefs/ghost-backup/backups/user/feature/branch-1/@\nghi789\trefs/ghost-backup/backups/user/hotfix/bug-fix/@",
 			expected: []string{"main", "feature/branch-1", "hotfix/bug-fix"},
 		},
 		{
 			name:     "deeply nested branch names",
-			input:    "abc123\trefs/backups/user/team/feature/sub/deep-branch",
+			input:    "abc123\trefs/ghost-backup/backups/user/team/feature/sub/deep-branch/@",
 			expected: []string{"team/feature/sub/deep-branch"},
 		},
+		{
+			name:     "per-machine refs",
+			input:    "abc123\trefs/ghost-backup/backups/user/@laptop/main/@\ndef456\trefs/ghost-backup/backups/user/@desktop/feature/x/@\nghi789\trefs/ghost-backup/backups/user/develop/@",
+			expected: []string{"main", "feature/x", "develop"},
+		},
+		{
+			name:     "filtered by machine",
+			input:    "abc123\trefs/ghost-backup/backups/user/@laptop/main/@\ndef456\trefs/ghost-backup/backups/user/@desktop/feature/x/@\nghi789\trefs/ghost-backup/backups/user/develop/@",
+			machine:  "laptop",
+			expected: []string{"main"},
+		},
+		{
+			name:     "branch and its sub-branch",
+			input:    "abc123\trefs/ghost-backup/backups/user/foo/@\ndef456\trefs/ghost-backup/backups/user/foo/bar/@",
+			expected: []string{"foo", "foo/bar"},
+		},
+		{
+			name:     "encoded branch names",
+			input:    "abc123\trefs/ghost-backup/backups/user/fix/%40mention%25/@",
+			expected: []string{"fix/@mention%"},
+		},
+		{
+			name:     "legacy refs are skipped",
+			input:    "abc123\trefs/backups/user/main\ndef456\trefs/ghost-backup/backups/user/develop/@",
+			expected: []string{"develop"},
+		},
 		{
 			name:     "empty input",
 			input:    "",
@@ -387,11 +417,11 @@ func TestExtractBranchNamesFromRefs(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := extractBranchNamesFromRefs(tt.input)
+			result := backupBranchNames(parseBackupRefs(tt.input), tt.machine)
 			
 			// Sort both slices for comparison
 			if len(result) != len(tt.expected) {
-				t.Errorf("extractBranchNamesFromRefs() returned %d branches, want %d", len(result), len(tt.expected))
+				t.Errorf("backupBranchNames() returned %d branches, want %d", len(result), len(tt.expected))
 				t.Errorf("Got: %v, Want: %v", result, tt.expected)
 				return
 			}
@@ -404,9 +434,512 @@ func TestExtractBranchNamesFromRefs(t *testing.T) {
 
 			for _, exp := range tt.expected {
 				if !resultMap[exp] {
-					t.Errorf("extractBranchNamesFromRefs() missing expected branch %q. Got: %v", exp, result)
+					t.Errorf("backupBranchNames() missing expected branch %q. Got: %v", exp, result)
 				}
 			}
 		})
 	}
 }
+
+// setupTestRepoWithRemote creates a test repo with an initial commit and a bare "origin" remote
+func setupTestRepoWithRemote(t *testing.T) string {
+	tmpDir := setupTestRepo(t)
+	remoteDir := t.TempDir()
+
+	cmds := [][]string{
+		{"git", "init", "--bare", remoteDir},
+		{"git", "remote", "add", "origin", remoteDir},
+	}
+	for _, cmdArgs := range cmds {
+		cmd := exec.Command(cmdArgs[0], cmdArgs[1:]...)
+		cmd.Dir = tmpDir
+		if err := cmd.Run(); err != nil {
+			t.Fatalf("Failed to run %v: %v", cmdArgs, err)
+		}
+	}
+
+	testFile := filepath.Join(tmpDir, "test.txt")
+	if err := os.WriteFile(testFile, []byte("initial"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	for _, cmdArgs := range [][]string{{"git", "add", "."}, {"git", "commit", "-m", "Initial commit"}} {
+		cmd := exec.Command(cmdArgs[0], cmdArgs[1:]...)
+		cmd.Dir = tmpDir
+		if err := cmd.Run(); err != nil {
+			t.Fatalf("Failed to run %v: %v", cmdArgs, err)
+		}
+	}
+
+	return tmpDir
+}
+
+func TestGitRepo_PushToBackupRef_KeepsHistory(t *testing.T) {
+	tmpDir := setupTestRepoWithRemote(t)
+	repo := NewGitRepo(tmpDir)
+	testFile := filepath.Join(tmpDir, "test.txt")
+
+	var stashes []string
+	for _, content := range []string{"first", "second", "third"} {
+		if err := os.WriteFile(testFile, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to modify test file: %v", err)
+		}
+
+		hash, err := repo.CreateStash(false)
+		if err != nil {
+			t.Fatalf("CreateStash() error = %v", err)
+		}
+
+		if err := repo.PushToBackupRef(hash, BackupRefName("test@example.com", "", "main"), "origin"); err != nil {
+			t.Fatalf("PushToBackupRef() error = %v", err)
+		}
+		stashes = append(stashes, hash)
+	}
+
+	refName := BackupRefName("test@example.com", "", "main")
+	snapshots, err := repo.ListBackupHistory("origin", refName, 0)
+	if err != nil {
+		t.Fatalf("ListBackupHistory() error = %v", err)
+	}
+
+	if len(snapshots) != len(stashes) {
+		t.Fatalf("ListBackupHistory() returned %d snapshots, want %d", len(snapshots), len(stashes))
+	}
+
+	// Newest first
+	for i, snapshot := range snapshots {
+		want := stashes[len(stashes)-1-i]
+		if snapshot.Hash != want {
+			t.Errorf("snapshot[%d].Hash = %s, want %s", i, snapshot.Hash, want)
+		}
+		if !strings.HasPrefix(snapshot.Message, "WIP on") {
+			t.Errorf("snapshot[%d].Message = %q, want stash message", i, snapshot.Message)
+		}
+	}
+
+	limited, err := repo.ListBackupHistory("origin", refName, 2)
+	if err != nil {
+		t.Fatalf("ListBackupHistory() with limit error = %v", err)
+	}
+	if len(limited) != 2 {
+		t.Errorf("ListBackupHistory() with limit returned %d snapshots, want 2", len(limited))
+	}
+}
+
+func TestBackupRefName(t *testing.T) {
+	got := BackupRefName("user@example.com", "", "feature/new-ui")
+	want := "refs/ghost-backup/backups/user%40example.com/feature/new-ui/@"
+	if got != want {
+		t.Errorf("BackupRefName() = %s, want %s", got, want)
+	}
+}
+
+func TestParseBackupHistory(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		hashes []string
+	}{
+		{
+			name: "chained history",
+			input: "c3\x1fc2 s3\x1f2024-01-03T10:00:00Z\x1fghost-backup: WIP on main: three\n" +
+				"c2\x1fc1 s2\x1f2024-01-02T10:00:00Z\x1fghost-backup: WIP on main: two\n" +
+				"c1\x1fs1\x1f2024-01-01T10:00:00Z\x1fghost-backup: WIP on main: one\n" +
+				"s1\x1fhead idx\x1f2024-01-01T10:00:00Z\x1fWIP on main: one\n",
+			hashes: []string{"s3", "s2", "s1"},
+		},
+		{
+			name: "history on top of a legacy stash",
+			input: "c1\x1flegacy s1\x1f2024-01-02T10:00:00Z\x1fghost-backup: WIP on main: new\n" +
+				"legacy\x1fhead idx\x1f2024-01-01T10:00:00Z\x1fWIP on main: old\n" +
+				"head\x1fparent\x1f2023-12-31T10:00:00Z\x1fregular commit\n",
+			hashes: []string{"s1", "legacy"},
+		},
+		{
+			// Encrypted snapshots are resolved once decrypted, by ListBackupHistory
+			name: "encrypted history on top of a plain one",
+			input: "e2\x1fe1\x1f2024-01-03T10:00:00Z\x1fghost-backup: encrypted snapshot\x1fGhost-Backup-Encryption: age-x25519\n" +
+				"e1\x1fc1\x1f2024-01-02T10:00:00Z\x1fghost-backup: encrypted snapshot\x1fGhost-Backup-Encryption: age-x25519\n" +
+				"c1\x1fs1\x1f2024-01-01T10:00:00Z\x1fghost-backup: WIP on main: one\n",
+			hashes: []string{"", "", "s1"},
+		},
+		{
+			name:   "empty input",
+			input:  "",
+			hashes: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			snapshots := parseBackupHistory(tt.input)
+			if len(snapshots) != len(tt.hashes) {
+				t.Fatalf("parseBackupHistory() returned %d snapshots, want %d: %+v", len(snapshots), len(tt.hashes), snapshots)
+			}
+			for i, hash := range tt.hashes {
+				if snapshots[i].Hash != hash {
+					t.Errorf("snapshot[%d].Hash = %s, want %s", i, snapshots[i].Hash, hash)
+				}
+				if snapshots[i].Date.IsZero() {
+					t.Errorf("snapshot[%d].Date was not parsed", i)
+				}
+			}
+		})
+	}
+}
+
+func TestGitRepo_CreateStashWithUntracked(t *testing.T) {
+	tmpDir := setupTestRepoWithRemote(t)
+	repo := NewGitRepo(tmpDir)
+
+	// Ignored files must never be captured
+	if err := os.WriteFile(filepath.Join(tmpDir, ".gitignore"), []byte("ignored.txt\n"), 0644); err != nil {
+		t.Fatalf("Failed to write .gitignore: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "ignored.txt"), []byte("ignored"), 0644); err != nil {
+		t.Fatalf("Failed to write ignored file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "new.txt"), []byte("untracked"), 0644); err != nil {
+		t.Fatalf("Failed to write untracked file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "test.txt"), []byte("modified"), 0644); err != nil {
+		t.Fatalf("Failed to modify test file: %v", err)
+	}
+
+	statusBefore, err := exec.Command("git", "-C", tmpDir, "status", "--porcelain").Output()
+	if err != nil {
+		t.Fatalf("Failed to get status: %v", err)
+	}
+
+	hash, err := repo.CreateStashWithUntracked(nil)
+	if err != nil {
+		t.Fatalf("CreateStashWithUntracked() error = %v", err)
+	}
+	if len(hash) != gitSHALength {
+		t.Fatalf("CreateStashWithUntracked() hash length = %d, want %d", len(hash), gitSHALength)
+	}
+
+	// The real index and working tree must be untouched
+	statusAfter, err := exec.Command("git", "-C", tmpDir, "status", "--porcelain").Output()
+	if err != nil {
+		t.Fatalf("Failed to get status: %v", err)
+	}
+	if string(statusBefore) != string(statusAfter) {
+		t.Errorf("Working tree status changed:\nbefore:\n%s\nafter:\n%s", statusBefore, statusAfter)
+	}
+
+	files, err := exec.Command("git", "-C", tmpDir, "ls-tree", "--name-only", hash).Output()
+	if err != nil {
+		t.Fatalf("Failed to list snapshot tree: %v", err)
+	}
+	if !strings.Contains(string(files), "new.txt") {
+		t.Errorf("Snapshot should contain untracked file, got:\n%s", files)
+	}
+	if strings.Contains(string(files), "ignored.txt") {
+		t.Errorf("Snapshot should not contain ignored file, got:\n%s", files)
+	}
+
+	// Restoring the snapshot brings the untracked file back
+	for _, cmdArgs := range [][]string{{"git", "checkout", "--", "test.txt"}, {"git", "clean", "-fq"}} {
+		cmd := exec.Command(cmdArgs[0], cmdArgs[1:]...)
+		cmd.Dir = tmpDir
+		if err := cmd.Run(); err != nil {
+			t.Fatalf("Failed to run %v: %v", cmdArgs, err)
+		}
+	}
+
+	if err := repo.ApplyStash(hash); err != nil {
+		t.Fatalf("ApplyStash() error = %v", err)
+	}
+
+	content, err := os.ReadFile(filepath.Join(tmpDir, "new.txt"))
+	if err != nil {
+		t.Fatalf("Untracked file was not restored: %v", err)
+	}
+	if string(content) != "untracked" {
+		t.Errorf("Restored content = %q, want %q", content, "untracked")
+	}
+}
+
+func TestGitRepo_CreateStashWithUntracked_NoChanges(t *testing.T) {
+	tmpDir := setupTestRepoWithRemote(t)
+	repo := NewGitRepo(tmpDir)
+
+	if _, err := repo.CreateStashWithUntracked(nil); err == nil {
+		t.Error("CreateStashWithUntracked() should return error when there are no changes")
+	}
+}
+
+func TestGitRepo_ExcludeFromStash(t *testing.T) {
+	tmpDir := setupTestRepoWithRemote(t)
+	repo := NewGitRepo(tmpDir)
+
+	// A tracked file with a secret, a new staged file with a secret and a harmless change
+	files := map[string]string{
+		"test.txt":   "password=hunter2",
+		"secret.env": "TOKEN=abc",
+		"notes.txt":  "harmless",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	if err := exec.Command("git", "-C", tmpDir, "add", "secret.env", "notes.txt").Run(); err != nil {
+		t.Fatalf("Failed to stage files: %v", err)
+	}
+
+	hash, err := repo.CreateStash(false)
+	if err != nil {
+		t.Fatalf("CreateStash() error = %v", err)
+	}
+
+	trailer := "Ghost-Backup-Excluded: secret.env (generic)"
+	filtered, err := repo.ExcludeFromStash(hash, []string{"test.txt", "secret.env"}, []string{trailer})
+	if err != nil {
+		t.Fatalf("ExcludeFromStash() error = %v", err)
+	}
+
+	// Both the working tree and the index commit drop the excluded changes
+	for _, rev := range []string{filtered, filtered + "^2"} {
+		names, err := exec.Command("git", "-C", tmpDir, "diff", "--name-only", filtered+"^1", rev).Output()
+		if err != nil {
+			t.Fatalf("Failed to diff %s: %v", rev, err)
+		}
+		if got := strings.TrimSpace(string(names)); got != "notes.txt" {
+			t.Errorf("Changed files in %s = %q, want %q", rev, got, "notes.txt")
+		}
+	}
+
+	message, err := exec.Command("git", "-C", tmpDir, "log", "-1", "--format=%B", filtered).Output()
+	if err != nil {
+		t.Fatalf("Failed to read message: %v", err)
+	}
+	if !strings.Contains(string(message), trailer) {
+		t.Errorf("Stash message should record the exclusion, got:\n%s", message)
+	}
+
+	// Nothing left when every changed file is excluded
+	if _, err := repo.ExcludeFromStash(hash, []string{"test.txt", "secret.env", "notes.txt"}, nil); err == nil {
+		t.Error("ExcludeFromStash() should fail when no changes are left")
+	}
+}
+
+func TestGitRepo_OpenSnapshotDiff_IncludesStagedOnlyChanges(t *testing.T) {
+	tmpDir := setupTestRepoWithRemote(t)
+	repo := NewGitRepo(tmpDir)
+
+	// Stage a line, then remove it again in the working tree only
+	if err := os.WriteFile(filepath.Join(tmpDir, "test.txt"), []byte("staged secret\n"), 0644); err != nil {
+		t.Fatalf("Failed to modify test file: %v", err)
+	}
+	if err := exec.Command("git", "-C", tmpDir, "add", "test.txt").Run(); err != nil {
+		t.Fatalf("Failed to stage test file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "new.txt"), []byte("new file\n"), 0644); err != nil {
+		t.Fatalf("Failed to write new file: %v", err)
+	}
+	if err := exec.Command("git", "-C", tmpDir, "add", "new.txt").Run(); err != nil {
+		t.Fatalf("Failed to stage new file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "test.txt"), []byte("working tree\n"), 0644); err != nil {
+		t.Fatalf("Failed to modify test file: %v", err)
+	}
+
+	hash, err := repo.CreateStash(false)
+	if err != nil {
+		t.Fatalf("CreateStash() error = %v", err)
+	}
+
+	stream, err := repo.OpenSnapshotDiff(hash)
+	if err != nil {
+		t.Fatalf("OpenSnapshotDiff() error = %v", err)
+	}
+	defer stream.Close()
+
+	diff, err := io.ReadAll(stream)
+	if err != nil {
+		t.Fatalf("Failed to read snapshot diff: %v", err)
+	}
+	for _, want := range []string{"+working tree", "+new file", "+staged secret"} {
+		if !strings.Contains(string(diff), want) {
+			t.Errorf("OpenSnapshotDiff() should contain %q, got:\n%s", want, diff)
+		}
+	}
+}
+
+func TestGitRepo_OpenSnapshotDelta(t *testing.T) {
+	tmpDir := setupTestRepoWithRemote(t)
+	repo := NewGitRepo(tmpDir)
+
+	if err := os.WriteFile(filepath.Join(tmpDir, "test.txt"), []byte("first change\n"), 0644); err != nil {
+		t.Fatalf("Failed to modify test file: %v", err)
+	}
+	base, err := repo.CreateStash(false)
+	if err != nil {
+		t.Fatalf("CreateStash() error = %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(tmpDir, "other.txt"), []byte("second change\n"), 0644); err != nil {
+		t.Fatalf("Failed to write new file: %v", err)
+	}
+	if err := exec.Command("git", "-C", tmpDir, "add", "other.txt").Run(); err != nil {
+		t.Fatalf("Failed to stage new file: %v", err)
+	}
+	hash, err := repo.CreateStash(false)
+	if err != nil {
+		t.Fatalf("CreateStash() error = %v", err)
+	}
+
+	stream, err := repo.OpenSnapshotDelta(base, hash)
+	if err != nil {
+		t.Fatalf("OpenSnapshotDelta() error = %v", err)
+	}
+	diff, err := io.ReadAll(stream)
+	stream.Close()
+	if err != nil {
+		t.Fatalf("Failed to read snapshot delta: %v", err)
+	}
+	if !strings.Contains(string(diff), "+second change") {
+		t.Errorf("OpenSnapshotDelta() should contain the new change, got:\n%s", diff)
+	}
+	if strings.Contains(string(diff), "first change") {
+		t.Errorf("OpenSnapshotDelta() should leave out changes already in the base, got:\n%s", diff)
+	}
+
+	// Committing moves HEAD, so the base no longer applies
+	if err := exec.Command("git", "-C", tmpDir, "commit", "-q", "-m", "second").Run(); err != nil {
+		t.Fatalf("Failed to commit: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "other.txt"), []byte("third change\n"), 0644); err != nil {
+		t.Fatalf("Failed to modify new file: %v", err)
+	}
+	moved, err := repo.CreateStash(false)
+	if err != nil {
+		t.Fatalf("CreateStash() error = %v", err)
+	}
+	if _, err := repo.OpenSnapshotDelta(base, moved); err == nil {
+		t.Error("OpenSnapshotDelta() should fail once HEAD moved")
+	}
+	if _, err := repo.OpenSnapshotDelta("0000000000000000000000000000000000000000", moved); err == nil {
+		t.Error("OpenSnapshotDelta() should fail for a missing base")
+	}
+}
+
+func TestGitRepo_SnapshotPaths(t *testing.T) {
+	tmpDir := setupTestRepoWithRemote(t)
+	repo := NewGitRepo(tmpDir)
+
+	if err := os.WriteFile(filepath.Join(tmpDir, "test.txt"), []byte("changed\n"), 0644); err != nil {
+		t.Fatalf("Failed to modify test file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, ".env"), []byte("TOKEN=x\n"), 0644); err != nil {
+		t.Fatalf("Failed to write new file: %v", err)
+	}
+	if err := exec.Command("git", "-C", tmpDir, "add", ".env").Run(); err != nil {
+		t.Fatalf("Failed to stage new file: %v", err)
+	}
+
+	hash, err := repo.CreateStash(false)
+	if err != nil {
+		t.Fatalf("CreateStash() error = %v", err)
+	}
+	paths, err := repo.SnapshotPaths(hash)
+	if err != nil {
+		t.Fatalf("SnapshotPaths() error = %v", err)
+	}
+	if want := []string{".env", "test.txt"}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("SnapshotPaths() = %v, want %v", paths, want)
+	}
+
+	filtered, err := repo.ExcludeFromStash(hash, []string{".env", "test.txt"}, nil)
+	if !errors.Is(err, ErrNoChangesLeft) {
+		t.Errorf("ExcludeFromStash() = %s, %v, want ErrNoChangesLeft", filtered, err)
+	}
+}
+
+func TestGitRepo_CreateStashWithUntracked_Filtered(t *testing.T) {
+	tmpDir := setupTestRepoWithRemote(t)
+	repo := NewGitRepo(tmpDir)
+
+	if err := os.MkdirAll(filepath.Join(tmpDir, "vendor"), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "vendor", "lib.go"), []byte("vendored"), 0644); err != nil {
+		t.Fatalf("Failed to write untracked file: %v", err)
+	}
+	keep := func(path string) bool { return !strings.HasPrefix(path, "vendor/") }
+
+	// Only skipped files changed
+	if _, err := repo.CreateStashWithUntracked(keep); !errors.Is(err, ErrNoChangesLeft) {
+		t.Fatalf("CreateStashWithUntracked() error = %v, want ErrNoChangesLeft", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(tmpDir, "new.txt"), []byte("untracked"), 0644); err != nil {
+		t.Fatalf("Failed to write untracked file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "test.txt"), []byte("modified"), 0644); err != nil {
+		t.Fatalf("Failed to modify test file: %v", err)
+	}
+
+	hash, err := repo.CreateStashWithUntracked(keep)
+	if err != nil {
+		t.Fatalf("CreateStashWithUntracked() error = %v", err)
+	}
+	paths, err := repo.SnapshotPaths(hash)
+	if err != nil {
+		t.Fatalf("SnapshotPaths() error = %v", err)
+	}
+	if want := []string{"new.txt", "test.txt"}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("SnapshotPaths() = %v, want %v", paths, want)
+	}
+}
+
+func TestGitRepo_SnapshotFileSizes(t *testing.T) {
+	tmpDir := setupTestRepoWithRemote(t)
+	repo := NewGitRepo(tmpDir)
+
+	// Staged and working tree versions differ, so both are pushed
+	if err := os.WriteFile(filepath.Join(tmpDir, "test.txt"), []byte("staged\n"), 0644); err != nil {
+		t.Fatalf("Failed to modify test file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "big.bin"), make([]byte, 1000), 0644); err != nil {
+		t.Fatalf("Failed to write new file: %v", err)
+	}
+	if err := exec.Command("git", "-C", tmpDir, "add", "test.txt", "big.bin").Run(); err != nil {
+		t.Fatalf("Failed to stage files: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "test.txt"), []byte("working tree\n"), 0644); err != nil {
+		t.Fatalf("Failed to modify test file: %v", err)
+	}
+
+	hash, err := repo.CreateStash(false)
+	if err != nil {
+		t.Fatalf("CreateStash() error = %v", err)
+	}
+	sizes, err := repo.SnapshotFileSizes(hash)
+	if err != nil {
+		t.Fatalf("SnapshotFileSizes() error = %v", err)
+	}
+	want := map[string]int64{"big.bin": 1000, "test.txt": int64(len("staged\n") + len("working tree\n"))}
+	if !reflect.DeepEqual(sizes, want) {
+		t.Errorf("SnapshotFileSizes() = %v, want %v", sizes, want)
+	}
+}
+
+func TestParseBackupHistory_Metadata(t *testing.T) {
+	input := "c1\x1fs1\x1f2024-01-01T10:00:00Z\x1fghost-backup: WIP on main: one\x1f" +
+		"Ghost-Backup-Host: laptop\x1dGhost-Backup-Files: 2\x1dGhost-Backup-Trigger: hook\n"
+
+	snapshots := parseBackupHistory(input)
+	if len(snapshots) != 1 {
+		t.Fatalf("parseBackupHistory() returned %d snapshots, want 1", len(snapshots))
+	}
+	want := SnapshotMetadata{Host: "laptop", Files: 2, Trigger: TriggerHook}
+	if snapshots[0].Metadata != want {
+		t.Errorf("Metadata = %+v, want %+v", snapshots[0].Metadata, want)
+	}
+	if snapshots[0].Message != "WIP on main: one" {
+		t.Errorf("Message = %q, want the subject without trailers", snapshots[0].Message)
+	}
+}
@@ -0,0 +1,144 @@
+package git
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// memoryScheme prefixes the backup remote of a MemoryDestination
+const memoryScheme = "memory://"
+
+// MemoryDestination keeps backup refs in memory, so code pushing backups can be tested without a
+// remote. It is used through its backup remote, memory://<name>, like any other destination once
+// MemoryDestinations is set up as the destination factory; the history commits themselves stay in
+// the repository that stored them.
+type MemoryDestination struct {
+	name string
+
+	mu   sync.Mutex
+	refs map[string]string // Backup ref -> tip
+	err  error             // Returned by every operation while set
+}
+
+// NewMemoryDestination creates an empty in-memory destination with the backup remote memory://<name>
+func NewMemoryDestination(name string) *MemoryDestination {
+	return &MemoryDestination{name: name, refs: make(map[string]string)}
+}
+
+// MemoryDestinations returns a destination factory opening the given in-memory destinations by
+// their backup remote, for SetDestinationFactory
+func MemoryDestinations(destinations ...*MemoryDestination) DestinationFactory {
+	return func(g *GitRepo, remote string) (Destination, bool) {
+		for _, m := range destinations {
+			if m.Remote() == remote {
+				return &memoryDestination{repo: g, store: m}, true
+			}
+		}
+		return nil, false
+	}
+}
+
+// Remote returns the backup remote to configure to use the destination
+func (m *MemoryDestination) Remote() string {
+	return memoryScheme + m.name
+}
+
+// SetError makes every operation fail with err, as if the destination were unreachable, until it
+// is called again with nil
+func (m *MemoryDestination) SetError(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.err = err
+}
+
+// Refs returns a copy of the stored backup refs and their tips
+func (m *MemoryDestination) Refs() map[string]string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	refs := make(map[string]string, len(m.refs))
+	for ref, tip := range m.refs {
+		refs[ref] = tip
+	}
+	return refs
+}
+
+// memoryDestination is a MemoryDestination opened for a repository
+type memoryDestination struct {
+	repo  *GitRepo
+	store *MemoryDestination
+}
+
+func (d *memoryDestination) Name() string {
+	return d.store.Remote()
+}
+
+func (d *memoryDestination) Store(hash, refName string) error {
+	commit, parent, err := d.repo.chainSnapshot(d, hash, refName)
+	if err != nil {
+		return err
+	}
+
+	m := d.store
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return m.err
+	}
+	if m.refs[refName] != parent {
+		return fmt.Errorf("failed to push to backup ref: %s is not a fast-forward", refName)
+	}
+	m.refs[refName] = commit
+	return nil
+}
+
+func (d *memoryDestination) List(pattern string) ([]BackupRef, error) {
+	m := d.store
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return nil, m.err
+	}
+
+	var refs []BackupRef
+	for ref, tip := range m.refs {
+		if matchRefPattern(pattern, ref) {
+			backupRef := BackupRef{Hash: tip, Ref: ref}
+			backupRef.UserIdentifier, backupRef.Machine, backupRef.Branch, _ = ParseBackupRefName(ref)
+			refs = append(refs, backupRef)
+		}
+	}
+	slices.SortFunc(refs, func(a, b BackupRef) int { return strings.Compare(a.Ref, b.Ref) })
+	return refs, nil
+}
+
+func (d *memoryDestination) Fetch(refName string) error {
+	m := d.store
+	m.mu.Lock()
+	tip, err := m.refs[refName], m.err
+	m.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if tip == "" {
+		return fmt.Errorf("failed to fetch backup ref: %s not found", refName)
+	}
+
+	if _, err := d.repo.gitOutput("update-ref", refName, tip); err != nil {
+		return fmt.Errorf("failed to fetch backup ref: %w", err)
+	}
+	return nil
+}
+
+func (d *memoryDestination) Delete(refName string) error {
+	m := d.store
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return m.err
+	}
+	delete(m.refs, refName)
+	return nil
+}
@@ -0,0 +1,205 @@
+package git
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Version is the ghost-backup version recorded in snapshot metadata, set by the cmd package
+var Version = "dev"
+
+// Values for SnapshotMetadata.Trigger
+const (
+	TriggerStartup  = "startup"  // First backup after the service starts
+	TriggerInterval = "interval" // Backup interval elapsed
+	TriggerWatch    = "watch"    // Filesystem changes settled
+	TriggerManual   = "manual"   // 'ghost-backup backup' or 'ghost-backup service trigger'
+	TriggerHook     = "hook"     // 'ghost-backup backup --trigger hook' from a git hook
+)
+
+// Commit trailers holding snapshot metadata
+const (
+	trailerHost     = "Ghost-Backup-Host"
+	trailerPath     = "Ghost-Backup-Path"
+	trailerBase     = "Ghost-Backup-Base"
+	trailerUpstream = "Ghost-Backup-Upstream"
+	trailerFiles    = "Ghost-Backup-Files"
+	trailerTrigger  = "Ghost-Backup-Trigger"
+	trailerVersion  = "Ghost-Backup-Version"
+	trailerExcluded = "Ghost-Backup-Excluded" // Written by the security package for each file left out
+)
+
+// trailerLine matches a "Key: value" commit trailer
+var trailerLine = regexp.MustCompile(`^[A-Za-z0-9-]+: `)
+
+// SnapshotMetadata describes where and why a snapshot was taken
+// It is recorded as Ghost-Backup-* trailers on the stash commit, which the backup commit carries over
+type SnapshotMetadata struct {
+	Host     string // Hostname of the machine
+	RepoPath string // Absolute path of the repository or worktree
+	Base     string // HEAD commit the snapshot was taken on
+	Upstream string // Upstream of the branch, e.g. origin/main; empty without one
+	Files    int    // Files changed by the snapshot
+	Excluded int    // Files left out of the snapshot
+	Trigger  string // Trigger* value
+	Version  string // ghost-backup version
+}
+
+// Trailers renders the metadata as commit trailers, leaving out unknown values
+// Excluded files are recorded by their own trailers and not rendered here
+func (m SnapshotMetadata) Trailers() []string {
+	var trailers []string
+	add := func(key, value string) {
+		if value != "" {
+			trailers = append(trailers, key+": "+value)
+		}
+	}
+	add(trailerHost, m.Host)
+	add(trailerPath, m.RepoPath)
+	add(trailerBase, m.Base)
+	add(trailerUpstream, m.Upstream)
+	add(trailerFiles, strconv.Itoa(m.Files))
+	add(trailerTrigger, m.Trigger)
+	add(trailerVersion, m.Version)
+	return trailers
+}
+
+// IsZero reports whether no metadata was recorded, as for snapshots taken by older versions
+func (m SnapshotMetadata) IsZero() bool {
+	return m == SnapshotMetadata{}
+}
+
+// ParseSnapshotMetadata reads snapshot metadata from commit trailers ("Key: value")
+func ParseSnapshotMetadata(trailers []string) SnapshotMetadata {
+	var m SnapshotMetadata
+	for _, trailer := range trailers {
+		key, value, ok := strings.Cut(trailer, ": ")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch key {
+		case trailerHost:
+			m.Host = value
+		case trailerPath:
+			m.RepoPath = value
+		case trailerBase:
+			m.Base = value
+		case trailerUpstream:
+			m.Upstream = value
+		case trailerFiles:
+			m.Files, _ = strconv.Atoi(value)
+		case trailerTrigger:
+			m.Trigger = value
+		case trailerVersion:
+			m.Version = value
+		case trailerExcluded:
+			m.Excluded++
+		}
+	}
+	return m
+}
+
+// CollectSnapshotMetadata gathers the metadata of a snapshot about to be pushed
+func (g *GitRepo) CollectSnapshotMetadata(stashHash, trigger string) (SnapshotMetadata, error) {
+	paths, err := g.SnapshotPaths(stashHash)
+	if err != nil {
+		return SnapshotMetadata{}, err
+	}
+	base, err := g.revParse(stashHash + "^1")
+	if err != nil {
+		return SnapshotMetadata{}, fmt.Errorf("failed to read stash parent: %w", err)
+	}
+	excluded, err := g.trailers(stashHash)
+	if err != nil {
+		return SnapshotMetadata{}, err
+	}
+
+	host, _ := os.Hostname()
+	// No upstream is not an error
+	upstream, _ := g.gitOutput("rev-parse", "--abbrev-ref", "--symbolic-full-name", "@{upstream}")
+	return SnapshotMetadata{
+		Host:     host,
+		RepoPath: g.Path,
+		Base:     base,
+		Upstream: upstream,
+		Files:    len(paths),
+		Excluded: ParseSnapshotMetadata(excluded).Excluded,
+		Trigger:  trigger,
+		Version:  Version,
+	}, nil
+}
+
+// AddSnapshotMetadata returns a copy of a stash with the metadata appended to its trailers
+// The copy keeps the stash's tree, parents and date, so it restores exactly like the original
+func (g *GitRepo) AddSnapshotMetadata(stashHash string, meta SnapshotMetadata) (string, error) {
+	info, err := g.gitOutput("log", "-1", "--format=%T %P%n%cI%n%B", stashHash)
+	if err != nil {
+		return "", fmt.Errorf("failed to read stash: %w", err)
+	}
+	lines := strings.SplitN(info, "\n", 3)
+	if len(lines) < 3 {
+		return "", fmt.Errorf("failed to read stash: unexpected output %q", info)
+	}
+	objects := strings.Fields(lines[0]) // <tree> <parents>...
+	date, message := lines[1], appendTrailers(lines[2], meta.Trailers())
+
+	args := []string{"commit-tree", objects[0], "-m", message}
+	for _, parent := range objects[1:] {
+		args = append(args, "-p", parent)
+	}
+	cmd := exec.Command("git", args...)
+	cmd.Dir = g.Path
+	cmd.Env = append(os.Environ(), "GIT_COMMITTER_DATE="+date, "GIT_AUTHOR_DATE="+date)
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to record snapshot metadata: %w", err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// GetSnapshotMetadata reads the metadata recorded on a snapshot or backup commit
+func (g *GitRepo) GetSnapshotMetadata(hash string) (SnapshotMetadata, error) {
+	trailers, err := g.trailers(hash)
+	if err != nil {
+		return SnapshotMetadata{}, err
+	}
+	return ParseSnapshotMetadata(trailers), nil
+}
+
+// trailers returns the trailers of a commit message
+func (g *GitRepo) trailers(hash string) ([]string, error) {
+	output, err := g.gitOutput("log", "-1", "--format=%(trailers:only,unfold)", hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read commit trailers: %w", err)
+	}
+	return strings.Split(output, "\n"), nil
+}
+
+// appendTrailers adds trailers to a commit message, in its trailer block when it already has one,
+// so git and ParseSnapshotMetadata see all of them
+func appendTrailers(message string, trailers []string) string {
+	message = strings.TrimRight(message, "\n")
+	if len(trailers) == 0 {
+		return message
+	}
+
+	separator := "\n\n"
+	if i := strings.LastIndex(message, "\n\n"); i >= 0 {
+		isBlock := true
+		for _, line := range strings.Split(message[i+2:], "\n") {
+			if !trailerLine.MatchString(line) {
+				isBlock = false
+				break
+			}
+		}
+		if isBlock {
+			separator = "\n"
+		}
+	}
+	return message + separator + strings.Join(trailers, "\n")
+}
@@ -0,0 +1,112 @@
+package git
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAppendTrailers(t *testing.T) {
+	tests := []struct {
+		name     string
+		message  string
+		trailers []string
+		want     string
+	}{
+		{"subject only", "WIP on main: abc fix\n", []string{"A: 1"}, "WIP on main: abc fix\n\nA: 1"},
+		{"existing block", "WIP on main: abc fix\n\nA: 1", []string{"B: 2"}, "WIP on main: abc fix\n\nA: 1\nB: 2"},
+		{"body is not a block", "WIP on main: abc fix\n\nsome text", []string{"B: 2"}, "WIP on main: abc fix\n\nsome text\n\nB: 2"},
+		{"no trailers", "WIP on main: abc fix\n", nil, "WIP on main: abc fix"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := appendTrailers(tt.message, tt.trailers); got != tt.want {
+				t.Errorf("appendTrailers() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSnapshotMetadata_RoundTrip(t *testing.T) {
+	meta := SnapshotMetadata{
+		Host:     "laptop",
+		RepoPath: "/home/dev/project",
+		Base:     "0123456789abcdef",
+		Upstream: "origin/main",
+		Files:    3,
+		Trigger:  TriggerWatch,
+		Version:  "1.2.3",
+	}
+	trailers := append(meta.Trailers(), "Ghost-Backup-Excluded: .env (.env*)", "Signed-off-by: someone")
+
+	want := meta
+	want.Excluded = 1
+	if got := ParseSnapshotMetadata(trailers); !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseSnapshotMetadata() = %+v, want %+v", got, want)
+	}
+	if !ParseSnapshotMetadata(nil).IsZero() {
+		t.Error("ParseSnapshotMetadata(nil) should be zero")
+	}
+}
+
+func TestGitRepo_AddSnapshotMetadata(t *testing.T) {
+	tmpDir := setupTestRepoWithRemote(t)
+	repo := NewGitRepo(tmpDir)
+
+	if err := os.WriteFile(filepath.Join(tmpDir, "test.txt"), []byte("changed\n"), 0644); err != nil {
+		t.Fatalf("Failed to modify test file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "secret.txt"), []byte("secret\n"), 0644); err != nil {
+		t.Fatalf("Failed to write new file: %v", err)
+	}
+	if err := exec.Command("git", "-C", tmpDir, "add", "secret.txt").Run(); err != nil {
+		t.Fatalf("Failed to stage new file: %v", err)
+	}
+
+	stash, err := repo.CreateStash(false)
+	if err != nil {
+		t.Fatalf("CreateStash() error = %v", err)
+	}
+	filtered, err := repo.ExcludeFromStash(stash, []string{"secret.txt"}, []string{"Ghost-Backup-Excluded: secret.txt (rule)"})
+	if err != nil {
+		t.Fatalf("ExcludeFromStash() error = %v", err)
+	}
+
+	meta, err := repo.CollectSnapshotMetadata(filtered, TriggerManual)
+	if err != nil {
+		t.Fatalf("CollectSnapshotMetadata() error = %v", err)
+	}
+	head, _ := repo.revParse("HEAD")
+	if meta.Base != head || meta.RepoPath != tmpDir || meta.Files != 1 || meta.Excluded != 1 || meta.Trigger != TriggerManual || meta.Version != Version {
+		t.Errorf("CollectSnapshotMetadata() = %+v", meta)
+	}
+
+	hash, err := repo.AddSnapshotMetadata(filtered, meta)
+	if err != nil {
+		t.Fatalf("AddSnapshotMetadata() error = %v", err)
+	}
+	got, err := repo.GetSnapshotMetadata(hash)
+	if err != nil {
+		t.Fatalf("GetSnapshotMetadata() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, meta) {
+		t.Errorf("GetSnapshotMetadata() = %+v, want %+v", got, meta)
+	}
+
+	// Same tree, parents and date, so it restores like the original
+	for _, format := range []string{"%T %P", "%cI"} {
+		before, _ := repo.gitOutput("log", "-1", "--format="+format, filtered)
+		after, _ := repo.gitOutput("log", "-1", "--format="+format, hash)
+		if before != after {
+			t.Errorf("%s changed from %s to %s", format, before, after)
+		}
+	}
+	message, _ := repo.gitOutput("log", "-1", "--format=%B", hash)
+	if strings.Count(message, "\n\n") != 1 {
+		t.Errorf("Trailers should form a single block, message:\n%s", message)
+	}
+}
@@ -0,0 +1,237 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PendingRefPrefix is the local namespace holding snapshots that still have to be pushed
+// Pending refs follow the format: refs/ghost-backup/pending/<queued unix nanos>/@<remote>/<backup ref path>,
+// where the remote is percent-encoded like the components of the backup ref path, which is the backup
+// ref without its namespace (see BackupRefName). A snapshot pushed to several remotes has one pending
+// ref per remote.
+const PendingRefPrefix = "refs/ghost-backup/pending/"
+
+// PendingBackup is a snapshot queued locally until it can be pushed to its backup ref
+type PendingBackup struct {
+	Ref            string // Local pending ref holding the snapshot
+	Hash           string // Stash hash of the snapshot
+	Remote         string // Remote the snapshot is pushed to; empty when queued by an older version
+	UserIdentifier string
+	Machine        string // Empty when backup refs are shared between machines
+	Branch         string
+	QueuedAt       time.Time
+
+	legacyRef string // Legacy backup ref of a snapshot queued by an older version
+}
+
+// BackupRef returns the backup ref the snapshot will be pushed to
+func (p PendingBackup) BackupRef() string {
+	if p.legacyRef != "" {
+		return p.legacyRef
+	}
+	return BackupRefName(p.UserIdentifier, p.Machine, p.Branch)
+}
+
+// pendingRefName returns the local ref used to queue a snapshot for a remote
+func pendingRefName(queuedAt time.Time, remote, userIdentifier, machine, branch string) string {
+	return fmt.Sprintf("%s%d/@%s/%s", PendingRefPrefix, queuedAt.UnixNano(), encodeRefComponent(remote), backupRefPath(userIdentifier, machine, branch))
+}
+
+// parsePendingRef parses a pending ref name and the hash it points to
+func parsePendingRef(refName, hash string) (PendingBackup, bool) {
+	rest, ok := strings.CutPrefix(refName, PendingRefPrefix)
+	if !ok {
+		return PendingBackup{}, false
+	}
+
+	queuedAt, path, ok := strings.Cut(rest, "/")
+	if !ok {
+		return PendingBackup{}, false
+	}
+	nanos, err := strconv.ParseInt(queuedAt, 10, 64)
+	if err != nil {
+		return PendingBackup{}, false
+	}
+
+	p := PendingBackup{
+		Ref:      refName,
+		Hash:     hash,
+		QueuedAt: time.Unix(0, nanos),
+	}
+	if encoded, rest, ok := strings.Cut(path, "/"); ok && strings.HasPrefix(encoded, "@") {
+		if p.Remote, ok = decodeRefComponent(encoded[1:]); !ok {
+			return PendingBackup{}, false
+		}
+		path = rest
+	}
+	if p.UserIdentifier, p.Machine, p.Branch, ok = parseBackupRefPath(path); ok {
+		return p, true
+	}
+
+	// Queued by an older version; pushed to the legacy ref it was meant for
+	p.legacyRef = LegacyBackupRefPrefix + path
+	if p.UserIdentifier, p.Machine, p.Branch, ok = ParseLegacyBackupRefName(p.legacyRef); ok {
+		return p, true
+	}
+	return PendingBackup{}, false
+}
+
+// parsePendingRefs parses git for-each-ref output ("<hash> <ref>" per line), oldest first
+func parsePendingRefs(output string) []PendingBackup {
+	var pending []PendingBackup
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		hash, refName, ok := strings.Cut(line, " ")
+		if !ok {
+			continue
+		}
+		if p, ok := parsePendingRef(refName, hash); ok {
+			pending = append(pending, p)
+		}
+	}
+
+	sort.SliceStable(pending, func(i, j int) bool {
+		return pending[i].QueuedAt.Before(pending[j].QueuedAt)
+	})
+	return pending
+}
+
+// QueuePendingBackup stores a snapshot in local pending refs, one per remote, so it survives a
+// failed push or a restart. The machine is empty when backup refs are shared between machines
+func (g *GitRepo) QueuePendingBackup(hash, userIdentifier, machine, branch string, remotes []string) ([]PendingBackup, error) {
+	queuedAt := time.Now()
+
+	var queued []PendingBackup
+	for _, remote := range remotes {
+		refName := pendingRefName(queuedAt, remote, userIdentifier, machine, branch)
+		if _, err := g.gitOutput("update-ref", "-m", "ghost-backup: queue snapshot", refName, hash); err != nil {
+			return nil, fmt.Errorf("failed to queue snapshot: %w", err)
+		}
+
+		queued = append(queued, PendingBackup{
+			Ref:            refName,
+			Hash:           hash,
+			Remote:         remote,
+			UserIdentifier: userIdentifier,
+			Machine:        machine,
+			Branch:         branch,
+			QueuedAt:       time.Unix(0, queuedAt.UnixNano()),
+		})
+	}
+	return queued, nil
+}
+
+// ListPendingBackups returns the snapshots waiting to be pushed, oldest first
+func (g *GitRepo) ListPendingBackups() ([]PendingBackup, error) {
+	output, err := g.gitOutput("for-each-ref", "--format=%(objectname) %(refname)", PendingRefPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pending snapshots: %w", err)
+	}
+	return parsePendingRefs(output), nil
+}
+
+// RemovePendingBackup deletes a pending ref once its snapshot has been pushed
+func (g *GitRepo) RemovePendingBackup(p PendingBackup) error {
+	if _, err := g.gitOutput("update-ref", "-d", p.Ref, p.Hash); err != nil {
+		return fmt.Errorf("failed to remove pending snapshot: %w", err)
+	}
+	return nil
+}
+
+// RemotePush is the outcome of pushing queued snapshots to one remote
+type RemotePush struct {
+	Remote     string
+	Pushed     []PendingBackup // Snapshots pushed, oldest first
+	Err        error           // Why the other snapshots queued for the remote weren't pushed
+	CleanupErr error           // Queue refs of pushed snapshots that couldn't be removed; not a push failure
+}
+
+// PushedTo returns the remotes a snapshot was pushed to
+func PushedTo(pushes []RemotePush, hash string) []string {
+	var remotes []string
+	for _, push := range pushes {
+		for _, p := range push.Pushed {
+			if p.Hash == hash {
+				remotes = append(remotes, push.Remote)
+				break
+			}
+		}
+	}
+	return remotes
+}
+
+// PushPendingBackups pushes every queued snapshot to its backup ref on its remote, oldest first,
+// and reports the outcome per remote, in the order of remotes. Snapshots queued by older versions,
+// without a remote, go to the primary remote (the first one). Snapshots that fail stay queued;
+// later snapshots for the same backup ref and remote are held back so the history keeps its order.
+// Failing to dequeue a pushed snapshot is reported in CleanupErr, not in the returned error.
+func (g *GitRepo) PushPendingBackups(remotes []string) ([]RemotePush, error) {
+	pending, err := g.ListPendingBackups()
+	if err != nil {
+		return nil, err
+	}
+
+	byRemote := make(map[string]*RemotePush)
+	destinations := make(map[string]Destination)
+	var pushes []*RemotePush
+	for _, remote := range remotes {
+		byRemote[remote] = &RemotePush{Remote: remote}
+		pushes = append(pushes, byRemote[remote])
+	}
+
+	var errs []error
+	blocked := make(map[string]bool)
+
+	for _, p := range pending {
+		remote := p.Remote
+		if remote == "" && len(remotes) > 0 {
+			remote = remotes[0]
+		}
+		result := byRemote[remote]
+		if result == nil {
+			// Queued for a remote that is no longer configured; it's still pushed there
+			result = &RemotePush{Remote: remote}
+			byRemote[remote] = result
+			pushes = append(pushes, result)
+		}
+
+		refName := p.BackupRef()
+		if blocked[remote+" "+refName] {
+			continue
+		}
+
+		d := destinations[remote]
+		if d == nil {
+			if d, err = g.OpenDestination(remote); err != nil {
+				blocked[remote+" "+refName] = true
+				result.Err = errors.Join(result.Err, err)
+				errs = append(errs, fmt.Errorf("%s: %w", remote, err))
+				continue
+			}
+			destinations[remote] = d
+		}
+
+		if err := d.Store(p.Hash, refName); err != nil {
+			blocked[remote+" "+refName] = true
+			result.Err = errors.Join(result.Err, err)
+			errs = append(errs, fmt.Errorf("%s: %w", remote, err))
+			continue
+		}
+
+		if err := g.RemovePendingBackup(p); err != nil {
+			// The snapshot is on the remote, so retrying would only push it again
+			result.CleanupErr = errors.Join(result.CleanupErr, err)
+		}
+		result.Pushed = append(result.Pushed, p)
+	}
+
+	results := make([]RemotePush, len(pushes))
+	for i, result := range pushes {
+		results[i] = *result
+	}
+	return results, errors.Join(errs...)
+}
@@ -0,0 +1,201 @@
+package git
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParsePendingRefs(t *testing.T) {
+	output := "h2 refs/ghost-backup/pending/2000/@origin/user/feature/new-ui/@\n" +
+		"h1 refs/ghost-backup/pending/1000/user/main\n" + // Queued by an older version
+		"h3 refs/ghost-backup/pending/notanumber/user/main\n" +
+		"h4 refs/ghost-backup/pending/3000/user\n"
+
+	pending := parsePendingRefs(output)
+	if len(pending) != 2 {
+		t.Fatalf("parsePendingRefs() returned %d entries, want 2: %+v", len(pending), pending)
+	}
+
+	// Oldest first
+	if pending[0].Hash != "h1" || pending[0].Branch != "main" {
+		t.Errorf("pending[0] = %+v, want h1 on main", pending[0])
+	}
+	if pending[1].Hash != "h2" || pending[1].Branch != "feature/new-ui" || pending[1].UserIdentifier != "user" || pending[1].Remote != "origin" {
+		t.Errorf("pending[1] = %+v, want h2 on feature/new-ui for origin", pending[1])
+	}
+	if pending[0].Remote != "" {
+		t.Errorf("pending[0].Remote = %q, want none for a snapshot queued by an older version", pending[0].Remote)
+	}
+	if !pending[0].QueuedAt.Equal(time.Unix(0, 1000)) {
+		t.Errorf("pending[0].QueuedAt = %v, want %v", pending[0].QueuedAt, time.Unix(0, 1000))
+	}
+	if got := pending[1].BackupRef(); got != "refs/ghost-backup/backups/user/feature/new-ui/@" {
+		t.Errorf("BackupRef() = %s, want refs/ghost-backup/backups/user/feature/new-ui/@", got)
+	}
+	if got := pending[0].BackupRef(); got != "refs/backups/user/main" {
+		t.Errorf("BackupRef() = %s, want the legacy ref refs/backups/user/main", got)
+	}
+}
+
+func TestPendingRefName_Machine(t *testing.T) {
+	queuedAt := time.Unix(0, 1000)
+	refName := pendingRefName(queuedAt, "git@example.com:backups.git", "user@example.com", "laptop", "feature/new-ui")
+	if refName != "refs/ghost-backup/pending/1000/@git%40example.com%3Abackups.git/user%40example.com/@laptop/feature/new-ui/@" {
+		t.Fatalf("pendingRefName() = %s", refName)
+	}
+
+	p, ok := parsePendingRef(refName, "h1")
+	if !ok {
+		t.Fatalf("parsePendingRef(%q) failed", refName)
+	}
+	if p.Remote != "git@example.com:backups.git" || p.UserIdentifier != "user@example.com" || p.Machine != "laptop" || p.Branch != "feature/new-ui" {
+		t.Errorf("parsePendingRef() = %+v, want feature/new-ui on laptop for git@example.com:backups.git", p)
+	}
+	if got := p.BackupRef(); got != "refs/ghost-backup/backups/user%40example.com/@laptop/feature/new-ui/@" {
+		t.Errorf("BackupRef() = %s, want refs/ghost-backup/backups/user%%40example.com/@laptop/feature/new-ui/@", got)
+	}
+}
+
+func TestGitRepo_PushPendingBackups_RetryAfterFailure(t *testing.T) {
+	tmpDir := setupTestRepoWithRemote(t)
+	repo := NewGitRepo(tmpDir)
+	testFile := filepath.Join(tmpDir, "test.txt")
+	unreachable := filepath.Join(t.TempDir(), "missing.git")
+
+	// Queue two snapshots while the remote is unreachable
+	var stashes []string
+	for _, content := range []string{"offline one", "offline two"} {
+		if err := os.WriteFile(testFile, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to modify test file: %v", err)
+		}
+		hash, err := repo.CreateStash(false)
+		if err != nil {
+			t.Fatalf("CreateStash() error = %v", err)
+		}
+		if _, err := repo.QueuePendingBackup(hash, "test@example.com", "", "main", []string{unreachable}); err != nil {
+			t.Fatalf("QueuePendingBackup() error = %v", err)
+		}
+		stashes = append(stashes, hash)
+
+		pushes, err := repo.PushPendingBackups([]string{unreachable})
+		if err == nil {
+			t.Fatal("PushPendingBackups() to an unreachable remote should fail")
+		}
+		if len(pushes) != 1 || len(pushes[0].Pushed) != 0 || pushes[0].Err == nil {
+			t.Errorf("PushPendingBackups() = %+v, want a single failed push", pushes)
+		}
+	}
+
+	pending, err := repo.ListPendingBackups()
+	if err != nil {
+		t.Fatalf("ListPendingBackups() error = %v", err)
+	}
+	if len(pending) != 2 {
+		t.Fatalf("ListPendingBackups() returned %d snapshots, want 2", len(pending))
+	}
+
+	// Back online: both go out in order and the queue is emptied
+	if _, err := repo.gitOutput("init", "--bare", unreachable); err != nil {
+		t.Fatalf("Failed to create remote: %v", err)
+	}
+	pushes, err := repo.PushPendingBackups([]string{unreachable})
+	if err != nil {
+		t.Fatalf("PushPendingBackups() error = %v", err)
+	}
+	if len(pushes) != 1 || len(pushes[0].Pushed) != 2 {
+		t.Fatalf("PushPendingBackups() = %+v, want 2 snapshots pushed", pushes)
+	}
+
+	pending, err = repo.ListPendingBackups()
+	if err != nil {
+		t.Fatalf("ListPendingBackups() error = %v", err)
+	}
+	if len(pending) != 0 {
+		t.Errorf("ListPendingBackups() returned %d snapshots after push, want 0", len(pending))
+	}
+
+	snapshots, err := repo.ListBackupHistory(unreachable, BackupRefName("test@example.com", "", "main"), 0)
+	if err != nil {
+		t.Fatalf("ListBackupHistory() error = %v", err)
+	}
+	if len(snapshots) != 2 || snapshots[0].Hash != stashes[1] || snapshots[1].Hash != stashes[0] {
+		t.Errorf("ListBackupHistory() = %+v, want %v newest first", snapshots, stashes)
+	}
+}
+
+func TestGitRepo_PushPendingBackups_MultipleRemotes(t *testing.T) {
+	tmpDir := setupTestRepoWithRemote(t)
+	repo := NewGitRepo(tmpDir)
+	unreachable := filepath.Join(t.TempDir(), "missing.git")
+	remotes := []string{unreachable, "origin"}
+
+	if err := os.WriteFile(filepath.Join(tmpDir, "test.txt"), []byte("redundant"), 0644); err != nil {
+		t.Fatalf("Failed to modify test file: %v", err)
+	}
+	hash, err := repo.CreateStash(false)
+	if err != nil {
+		t.Fatalf("CreateStash() error = %v", err)
+	}
+	queued, err := repo.QueuePendingBackup(hash, "test@example.com", "", "main", remotes)
+	if err != nil {
+		t.Fatalf("QueuePendingBackup() error = %v", err)
+	}
+	if len(queued) != 2 {
+		t.Fatalf("QueuePendingBackup() queued %d refs, want one per remote", len(queued))
+	}
+
+	// The primary is down, the secondary still gets the snapshot
+	pushes, err := repo.PushPendingBackups(remotes)
+	if err == nil {
+		t.Fatal("PushPendingBackups() should report the unreachable remote")
+	}
+	if len(pushes) != 2 || pushes[0].Remote != unreachable || pushes[0].Err == nil || pushes[1].Err != nil {
+		t.Fatalf("PushPendingBackups() = %+v, want a failure for the primary only", pushes)
+	}
+	if got := PushedTo(pushes, hash); len(got) != 1 || got[0] != "origin" {
+		t.Errorf("PushedTo() = %v, want [origin]", got)
+	}
+
+	// Only the copy for the unreachable remote stays queued
+	pending, err := repo.ListPendingBackups()
+	if err != nil {
+		t.Fatalf("ListPendingBackups() error = %v", err)
+	}
+	if len(pending) != 1 || pending[0].Remote != unreachable {
+		t.Errorf("ListPendingBackups() = %+v, want the snapshot queued for %s", pending, unreachable)
+	}
+}
+
+func TestGitRepo_PushPendingBackups_CleanupFailure(t *testing.T) {
+	tmpDir := setupTestRepoWithRemote(t)
+	repo := NewGitRepo(tmpDir)
+
+	if err := os.WriteFile(filepath.Join(tmpDir, "test.txt"), []byte("pushed once"), 0644); err != nil {
+		t.Fatalf("Failed to modify test file: %v", err)
+	}
+	hash, err := repo.CreateStash(false)
+	if err != nil {
+		t.Fatalf("CreateStash() error = %v", err)
+	}
+	queued, err := repo.QueuePendingBackup(hash, "test@example.com", "", "main", []string{"origin"})
+	if err != nil {
+		t.Fatalf("QueuePendingBackup() error = %v", err)
+	}
+
+	// A stale lock keeps git from deleting the queue ref
+	lock := filepath.Join(tmpDir, ".git", filepath.FromSlash(queued[0].Ref)+".lock")
+	if err := os.WriteFile(lock, nil, 0644); err != nil {
+		t.Fatalf("Failed to lock pending ref: %v", err)
+	}
+
+	// The snapshot is on the remote, so this is not a push failure that needs a retry
+	pushes, err := repo.PushPendingBackups([]string{"origin"})
+	if err != nil {
+		t.Fatalf("PushPendingBackups() error = %v, want nil", err)
+	}
+	if len(pushes) != 1 || len(pushes[0].Pushed) != 1 || pushes[0].Err != nil || pushes[0].CleanupErr == nil {
+		t.Fatalf("PushPendingBackups() = %+v, want a pushed snapshot with a cleanup error", pushes)
+	}
+}
@@ -0,0 +1,188 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Backup ref layout
+// Backup refs follow the format: refs/ghost-backup/backups/<user>/[@<machine>/]<branch>/@
+// Every component is percent-encoded (see encodeRefComponent), so the mapping is reversible and
+// encoded components never contain "@": the machine segment and the terminal "@" leaf can't be
+// mistaken for a name. The leaf lets backups of "foo" and "foo/bar" coexist, which git would
+// otherwise refuse as a directory/file conflict.
+const (
+	// backupRefPrefix is the namespace of backup refs on the remote
+	backupRefPrefix = "refs/ghost-backup/backups/"
+	// machinePrefix marks the machine segment of a per-machine backup ref
+	machinePrefix = "@"
+	// backupRefLeaf terminates every backup ref
+	backupRefLeaf = "@"
+)
+
+// LegacyBackupRefPrefix is the namespace of backup refs written by older versions, in the
+// format refs/backups/<sanitized user>/[@<sanitized machine>/]<branch>. They can be moved to the
+// current layout with 'ghost-backup migrate-refs'.
+const LegacyBackupRefPrefix = "refs/backups/"
+
+// BackupRefName returns the backup ref for a user and branch, on a machine when machine is set
+func BackupRefName(userIdentifier, machine, branch string) string {
+	return backupRefPrefix + backupRefPath(userIdentifier, machine, branch)
+}
+
+// backupRefPath returns the part of a backup ref following the namespace
+func backupRefPath(userIdentifier, machine, branch string) string {
+	parts := []string{encodeRefComponent(userIdentifier)}
+	if machine != "" {
+		parts = append(parts, machinePrefix+encodeRefComponent(machine))
+	}
+	for _, component := range strings.Split(branch, "/") {
+		parts = append(parts, encodeRefComponent(component))
+	}
+	return strings.Join(append(parts, backupRefLeaf), "/")
+}
+
+// ParseBackupRefName splits a backup ref into its user, machine and branch
+// The machine is empty for refs shared between machines
+func ParseBackupRefName(refName string) (userIdentifier, machine, branch string, ok bool) {
+	path, ok := strings.CutPrefix(refName, backupRefPrefix)
+	if !ok {
+		return "", "", "", false
+	}
+	return parseBackupRefPath(path)
+}
+
+// parseBackupRefPath parses the part of a backup ref following the namespace
+func parseBackupRefPath(path string) (userIdentifier, machine, branch string, ok bool) {
+	path, ok = strings.CutSuffix(path, "/"+backupRefLeaf)
+	if !ok {
+		return "", "", "", false
+	}
+	parts := strings.Split(path, "/")
+	if len(parts) < 2 {
+		return "", "", "", false
+	}
+
+	userIdentifier, ok = decodeRefComponent(parts[0])
+	if !ok {
+		return "", "", "", false
+	}
+	parts = parts[1:]
+
+	if encoded, isMachine := strings.CutPrefix(parts[0], machinePrefix); isMachine {
+		if machine, ok = decodeRefComponent(encoded); !ok || len(parts) < 2 {
+			return "", "", "", false
+		}
+		parts = parts[1:]
+	}
+
+	components := make([]string, len(parts))
+	for i, part := range parts {
+		if components[i], ok = decodeRefComponent(part); !ok {
+			return "", "", "", false
+		}
+	}
+	return userIdentifier, machine, strings.Join(components, "/"), true
+}
+
+// ParseLegacyBackupRefName splits a legacy backup ref into its sanitized user, sanitized machine
+// and branch. The machine is empty for refs shared between machines
+func ParseLegacyBackupRefName(refName string) (userIdentifier, machine, branch string, ok bool) {
+	rest, ok := strings.CutPrefix(refName, LegacyBackupRefPrefix)
+	if !ok {
+		return "", "", "", false
+	}
+	userIdentifier, rest, ok = strings.Cut(rest, "/")
+	if !ok || userIdentifier == "" {
+		return "", "", "", false
+	}
+	machine, branch = splitLegacyMachine(rest)
+	if branch == "" {
+		return "", "", "", false
+	}
+	return userIdentifier, machine, branch, true
+}
+
+// splitLegacyMachine splits the "@<machine>/" segment off the part of a legacy ref following the user
+func splitLegacyMachine(rest string) (machine, branch string) {
+	if segment, branch, ok := strings.Cut(rest, "/"); ok && strings.HasPrefix(segment, machinePrefix) && len(segment) > len(machinePrefix) {
+		return strings.TrimPrefix(segment, machinePrefix), branch
+	}
+	return "", rest
+}
+
+// encodeRefComponent percent-encodes a string into a single ref component
+// Only ASCII letters, digits, "-", "_" and "." are kept, and dots are escaped where git would reject
+// them: at the start of a component, after another dot and in a ".lock" suffix
+func encodeRefComponent(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		keep := c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_'
+		if c == '.' {
+			keep = i > 0 && s[i-1] != '.' && s[i:] != ".lock"
+		}
+		if keep {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// decodeRefComponent reverses encodeRefComponent
+func decodeRefComponent(s string) (string, bool) {
+	if s == "" || strings.Contains(s, "@") {
+		return "", false
+	}
+	decoded, err := url.PathUnescape(s)
+	if err != nil {
+		return "", false
+	}
+	return decoded, true
+}
+
+// ListLegacyBackupRefs lists the backup refs in the legacy layout, across all users, machines and branches
+func (g *GitRepo) ListLegacyBackupRefs(remote string) ([]BackupRef, error) {
+	all, err := g.listBackupRefs(remote, LegacyBackupRefPrefix+"*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list legacy backup refs: %w", err)
+	}
+
+	var refs []BackupRef
+	for _, ref := range all {
+		var ok bool
+		ref.UserIdentifier, ref.Machine, ref.Branch, ok = ParseLegacyBackupRefName(ref.Ref)
+		if ok {
+			refs = append(refs, ref)
+		}
+	}
+	return refs, nil
+}
+
+// MoveBackupRef moves a backup ref on the remote to a new name, history included
+// The new ref is created and the old one deleted in a single atomic push, which fails if the old
+// ref changed since it was listed or the new one already exists
+func (g *GitRepo) MoveBackupRef(remote string, ref BackupRef, newRefName string) error {
+	if err := g.FetchBackupRef(remote, ref.Ref); err != nil {
+		return err
+	}
+
+	cmd := g.execGitCommand("push", "--atomic",
+		fmt.Sprintf("--force-with-lease=%s:%s", ref.Ref, ref.Hash),
+		remote, fmt.Sprintf("%s:%s", ref.Hash, newRefName), ":"+ref.Ref)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to move backup ref: %w, stderr: %s", err, stderr.String())
+	}
+
+	// The local copy was only needed for the push
+	_, _ = g.gitOutput("update-ref", "-d", ref.Ref)
+	return nil
+}
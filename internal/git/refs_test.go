@@ -0,0 +1,143 @@
+package git
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestBackupRefName_RoundTrip(t *testing.T) {
+	tests := []struct {
+		user    string
+		machine string
+		branch  string
+		want    string
+	}{
+		{"johndoe", "", "main", "refs/ghost-backup/backups/johndoe/main/@"},
+		{"user@example.com", "", "feature/new-ui", "refs/ghost-backup/backups/user%40example.com/feature/new-ui/@"},
+		{"user_at_example.com", "", "main", "refs/ghost-backup/backups/user_at_example.com/main/@"},
+		{"John Doe", "dev.box", "main", "refs/ghost-backup/backups/John%20Doe/@dev.box/main/@"},
+		{"a:b/c", "", "@wip/x%y", "refs/ghost-backup/backups/a%3Ab%2Fc/%40wip/x%25y/@"},
+		{".hidden", "..", "fix..typo/file.lock", "refs/ghost-backup/backups/%2Ehidden/@%2E%2E/fix.%2Etypo/file%2Elock/@"},
+		{"Jürgen", "", "main", "refs/ghost-backup/backups/J%C3%BCrgen/main/@"},
+	}
+
+	for _, tt := range tests {
+		got := BackupRefName(tt.user, tt.machine, tt.branch)
+		if got != tt.want {
+			t.Errorf("BackupRefName(%q, %q, %q) = %s, want %s", tt.user, tt.machine, tt.branch, got, tt.want)
+		}
+
+		user, machine, branch, ok := ParseBackupRefName(got)
+		if !ok || user != tt.user || machine != tt.machine || branch != tt.branch {
+			t.Errorf("ParseBackupRefName(%s) = %q, %q, %q, %v, want %q, %q, %q", got, user, machine, branch, ok, tt.user, tt.machine, tt.branch)
+		}
+
+		if err := exec.Command("git", "check-ref-format", got).Run(); err != nil {
+			t.Errorf("git check-ref-format %s failed: %v", got, err)
+		}
+	}
+}
+
+func TestBackupRefName_NoCollisions(t *testing.T) {
+	// These all mapped to the same legacy ref, or to refs git can't store side by side
+	names := []string{
+		BackupRefName("a@b", "", "main"),
+		BackupRefName("a_at_b", "", "main"),
+		BackupRefName("a:b", "", "main"),
+		BackupRefName("a/b", "", "main"),
+		BackupRefName("a b", "", "main"),
+		BackupRefName("a_b", "", "main"),
+		BackupRefName("a", "", "foo"),
+		BackupRefName("a", "", "foo/bar"),
+	}
+
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if seen[name] {
+			t.Errorf("BackupRefName() produced %s twice", name)
+		}
+		seen[name] = true
+		for other := range seen {
+			if strings.HasPrefix(name, other+"/") || strings.HasPrefix(other, name+"/") {
+				t.Errorf("%s and %s can't coexist", name, other)
+			}
+		}
+	}
+}
+
+func TestParseBackupRefName_Invalid(t *testing.T) {
+	for _, ref := range []string{
+		"refs/ghost-backup/backups/user/main",      // No leaf
+		"refs/ghost-backup/backups/user/@",         // No branch
+		"refs/ghost-backup/backups/user/@laptop/@", // Machine but no branch
+		"refs/ghost-backup/backups/user/%zz/@",     // Bad escape
+		"refs/ghost-backup/backups/user/a/@/b/@",   // Leaf in the middle
+		"refs/backups/user/main",                   // Legacy layout
+	} {
+		if _, _, _, ok := ParseBackupRefName(ref); ok {
+			t.Errorf("ParseBackupRefName(%q) should fail", ref)
+		}
+	}
+}
+
+func TestParseLegacyBackupRefName(t *testing.T) {
+	tests := []struct {
+		ref     string
+		user    string
+		machine string
+		branch  string
+		ok      bool
+	}{
+		{"refs/backups/user_at_example.com/main", "user_at_example.com", "", "main", true},
+		{"refs/backups/user/feature/new-ui", "user", "", "feature/new-ui", true},
+		{"refs/backups/user/@laptop/feature/new-ui", "user", "laptop", "feature/new-ui", true},
+		{"refs/backups/user", "", "", "", false},
+		{"refs/ghost-backup/backups/user/main/@", "", "", "", false},
+	}
+
+	for _, tt := range tests {
+		user, machine, branch, ok := ParseLegacyBackupRefName(tt.ref)
+		if user != tt.user || machine != tt.machine || branch != tt.branch || ok != tt.ok {
+			t.Errorf("ParseLegacyBackupRefName(%q) = %q, %q, %q, %v, want %q, %q, %q, %v",
+				tt.ref, user, machine, branch, ok, tt.user, tt.machine, tt.branch, tt.ok)
+		}
+	}
+}
+
+func TestGitRepo_MoveBackupRef(t *testing.T) {
+	tmpDir := setupTestRepoWithRemote(t)
+	repo := NewGitRepo(tmpDir)
+
+	head, err := repo.revParse("HEAD")
+	if err != nil {
+		t.Fatalf("revParse() error = %v", err)
+	}
+	legacyRef := "refs/backups/test_at_example.com/main"
+	if _, err := repo.gitOutput("push", "origin", head+":"+legacyRef); err != nil {
+		t.Fatalf("push legacy ref error = %v", err)
+	}
+
+	refs, err := repo.ListLegacyBackupRefs("origin")
+	if err != nil {
+		t.Fatalf("ListLegacyBackupRefs() error = %v", err)
+	}
+	if len(refs) != 1 || refs[0].UserIdentifier != "test_at_example.com" || refs[0].Branch != "main" {
+		t.Fatalf("ListLegacyBackupRefs() = %+v, want the legacy main ref", refs)
+	}
+
+	newRef := BackupRefName("test@example.com", "", "main")
+	if err := repo.MoveBackupRef("origin", refs[0], newRef); err != nil {
+		t.Fatalf("MoveBackupRef() error = %v", err)
+	}
+
+	if hash, _ := repo.GetRemoteRefHash("origin", newRef); hash != head {
+		t.Errorf("new ref points to %q, want %s", hash, head)
+	}
+	if hash, _ := repo.GetRemoteRefHash("origin", legacyRef); hash != "" {
+		t.Errorf("legacy ref should be deleted, points to %s", hash)
+	}
+	if refs, _ := repo.ListBackupRefs("origin", "test@example.com", "", "main"); len(refs) != 1 {
+		t.Errorf("ListBackupRefs() = %+v, want the moved ref", refs)
+	}
+}
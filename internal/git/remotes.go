@@ -0,0 +1,120 @@
+package git
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ResolveBackupRemotes returns the remotes backups are pushed to, primary first, from the
+// configured remote names and URLs. Without any, the repository's default remote is used.
+func (g *GitRepo) ResolveBackupRemotes(configured []string) ([]string, error) {
+	if len(configured) == 0 {
+		remote, err := g.GetRemote()
+		if err != nil {
+			return nil, err
+		}
+		return []string{remote}, nil
+	}
+
+	names, err := g.gitOutput("remote")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get remotes: %w", err)
+	}
+	known := strings.Split(names, "\n")
+
+	for _, remote := range configured {
+		d, err := g.OpenDestination(remote)
+		if err != nil {
+			return nil, err
+		}
+		if _, isRemote := d.(*gitRemote); isRemote && !isRemoteURL(remote) && !slices.Contains(known, remote) {
+			return nil, fmt.Errorf("backup remote %q is neither a configured remote nor a URL", remote)
+		}
+	}
+	return configured, nil
+}
+
+// isRemoteURL reports whether a backup remote is a URL or path rather than a remote name,
+// using the forms git accepts: scheme://..., user@host:path and local paths
+func isRemoteURL(remote string) bool {
+	return strings.Contains(remote, "://") || strings.Contains(remote, ":") ||
+		strings.HasPrefix(remote, "/") || strings.HasPrefix(remote, ".")
+}
+
+// FirstReachableRemote returns the first remote that answers, failing over from the primary to
+// the next ones in order. A single remote is returned as is, without contacting it.
+func (g *GitRepo) FirstReachableRemote(remotes []string) (string, error) {
+	if len(remotes) == 1 {
+		return remotes[0], nil
+	}
+
+	var errs []error
+	for _, remote := range remotes {
+		if _, err := g.listBackupRefs(remote, "HEAD"); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", remote, err))
+			continue
+		}
+		return remote, nil
+	}
+	return "", fmt.Errorf("no backup remote is reachable: %w", errors.Join(errs...))
+}
+
+// gitRemote is a Destination pushing backup refs to a git remote, by name or URL
+type gitRemote struct {
+	repo   *GitRepo
+	remote string
+}
+
+func (d *gitRemote) Name() string {
+	return d.remote
+}
+
+func (d *gitRemote) Store(hash, refName string) error {
+	commit, _, err := d.repo.chainSnapshot(d, hash, refName)
+	if err != nil {
+		return err
+	}
+
+	// Push the history commit; this is a fast-forward of the previous tip
+	// Format: git push <remote> <commit>:<ref>
+	cmd := d.repo.execGitCommand("push", d.remote, fmt.Sprintf("%s:%s", commit, refName))
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to push to backup ref: %w, stderr: %s", err, stderr.String())
+	}
+	return nil
+}
+
+func (d *gitRemote) List(pattern string) ([]BackupRef, error) {
+	output, err := d.repo.execGitCommand("ls-remote", d.remote, pattern).Output()
+	if err != nil {
+		return nil, err
+	}
+	return parseBackupRefs(string(output)), nil
+}
+
+func (d *gitRemote) Fetch(refName string) error {
+	cmd := d.repo.execGitCommand("fetch", d.remote, fmt.Sprintf("+%s:%s", refName, refName))
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to fetch backup ref: %w", err)
+	}
+	return nil
+}
+
+func (d *gitRemote) Delete(refName string) error {
+	cmd := d.repo.execGitCommand("push", d.remote, ":"+refName)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to delete backup ref: %w, stderr: %s", err, stderr.String())
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package git
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestGitRepo_ResolveBackupRemotes(t *testing.T) {
+	repo := NewGitRepo(setupTestRepoWithRemote(t))
+
+	remotes, err := repo.ResolveBackupRemotes(nil)
+	if err != nil {
+		t.Fatalf("ResolveBackupRemotes() error = %v", err)
+	}
+	if len(remotes) != 1 || remotes[0] != "origin" {
+		t.Errorf("ResolveBackupRemotes() = %v, want the default remote", remotes)
+	}
+
+	configured := []string{"origin", "git@example.com:backups.git", "https://example.com/backups.git", "/srv/backups.git", "bundle:///mnt/nas"}
+	remotes, err = repo.ResolveBackupRemotes(configured)
+	if err != nil {
+		t.Fatalf("ResolveBackupRemotes() error = %v", err)
+	}
+	if len(remotes) != len(configured) {
+		t.Errorf("ResolveBackupRemotes() = %v, want %v", remotes, configured)
+	}
+
+	if _, err := repo.ResolveBackupRemotes([]string{"missing"}); err == nil {
+		t.Error("ResolveBackupRemotes() should reject an unknown remote name")
+	}
+	if _, err := repo.ResolveBackupRemotes([]string{"bundle://relative/dir"}); err == nil {
+		t.Error("ResolveBackupRemotes() should reject a relative bundle directory")
+	}
+}
+
+func TestGitRepo_FirstReachableRemote(t *testing.T) {
+	repo := NewGitRepo(setupTestRepoWithRemote(t))
+	unreachable := filepath.Join(t.TempDir(), "missing.git")
+
+	remote, err := repo.FirstReachableRemote([]string{unreachable, "origin"})
+	if err != nil {
+		t.Fatalf("FirstReachableRemote() error = %v", err)
+	}
+	if remote != "origin" {
+		t.Errorf("FirstReachableRemote() = %s, want origin", remote)
+	}
+
+	if _, err := repo.FirstReachableRemote([]string{unreachable, unreachable + "2"}); err == nil {
+		t.Error("FirstReachableRemote() should fail when no remote is reachable")
+	}
+}
@@ -0,0 +1,458 @@
+package git
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/FmTod/ghost-backup/internal/s3"
+)
+
+// S3 buckets
+// A backup remote of the form s3://<bucket>[/<prefix>] is S3-compatible object storage (AWS S3,
+// MinIO, ...) reached through the endpoint and credentials set up with SetupS3. Like in a bundle
+// directory, each snapshot is uploaded as an incremental git bundle, keyed by the backup ref path
+// (user, machine and branch) and the time it was stored, next to a manifest of the backup ref:
+//
+//	<prefix>/<repository>/index.json
+//	<prefix>/<repository>/<backup ref path>/manifest.json
+//	<prefix>/<repository>/<backup ref path>/20060102T150405Z-<commit>.bundle
+//
+// The repository is identified by its root commit. A manifest is only replaced if it hasn't
+// changed since it was read (If-Match), so two stores racing on the same backup ref can't drop
+// each other's snapshots: the loser fails and stays queued. The index lists the backup refs of the
+// repository, so listing them takes a single request; it is updated with If-Match too, retrying
+// when another ref was stored meanwhile. Manifests stay authoritative: the tips in the index are
+// only used for listing, and a single backup ref is always read from its manifest.
+const (
+	// s3Scheme prefixes a backup remote that is an S3 bucket
+	s3Scheme = "s3://"
+	// s3IndexFile lists the backup refs of a repository in a bucket
+	s3IndexFile = "index.json"
+	// s3IndexAttempts is how many times an index update is retried when it races with another one
+	s3IndexAttempts = 5
+)
+
+// s3Index is the index of the backup refs of a repository in a bucket
+type s3Index struct {
+	Refs map[string]s3IndexEntry `json:"refs"` // Keyed by backup ref, as named by BackupRefName
+}
+
+// s3IndexEntry is a backup ref in the index
+type s3IndexEntry struct {
+	UserIdentifier string `json:"user"`
+	Machine        string `json:"machine,omitempty"`
+	Branch         string `json:"branch"`
+	Tip            string `json:"tip,omitempty"` // Empty until the first manifest of the ref is written
+}
+
+// s3Settings holds the endpoint and credentials used by s3:// backup remotes
+var s3Settings struct {
+	opts s3.Options
+	mu   sync.RWMutex
+}
+
+// SetupS3 configures the object storage used by s3:// backup remotes
+// Options left empty fall back to the standard AWS environment variables
+func SetupS3(opts s3.Options) {
+	s3Settings.mu.Lock()
+	defer s3Settings.mu.Unlock()
+	s3Settings.opts = opts
+}
+
+// S3Remote returns the backup remote for a bucket and an optional key prefix
+func S3Remote(bucket, prefix string) string {
+	if prefix = strings.Trim(prefix, "/"); prefix != "" {
+		return s3Scheme + bucket + "/" + prefix
+	}
+	return s3Scheme + bucket
+}
+
+// s3Location returns the bucket and key prefix of an S3 backup remote
+func s3Location(remote string) (bucket, prefix string, ok bool) {
+	location, ok := strings.CutPrefix(remote, s3Scheme)
+	if !ok {
+		return "", "", false
+	}
+	bucket, prefix, _ = strings.Cut(location, "/")
+	return bucket, strings.Trim(prefix, "/"), true
+}
+
+// openS3Destination returns the destination for an S3 backup remote
+func (g *GitRepo) openS3Destination(remote string) (*s3Destination, error) {
+	bucket, prefix, _ := s3Location(remote)
+	if bucket == "" {
+		return nil, fmt.Errorf("S3 backup remote %q has no bucket", remote)
+	}
+
+	s3Settings.mu.RLock()
+	opts := s3Settings.opts.WithEnvironment()
+	s3Settings.mu.RUnlock()
+
+	client, err := s3.NewClient(opts)
+	if err != nil {
+		return nil, fmt.Errorf("S3 backup remote %s: %w", remote, err)
+	}
+	return &s3Destination{repo: g, client: client, bucket: bucket, prefix: prefix}, nil
+}
+
+// s3Destination is a Destination storing backups as git bundles in an S3 bucket
+type s3Destination struct {
+	repo   *GitRepo
+	client *s3.Client
+	bucket string
+	prefix string
+}
+
+func (d *s3Destination) Name() string {
+	return S3Remote(d.bucket, d.prefix)
+}
+
+// root returns the key prefix of the repository's backups
+func (d *s3Destination) root() (string, error) {
+	root, err := d.repo.rootCommit()
+	if err != nil {
+		return "", err
+	}
+	return path.Join(d.prefix, root), nil
+}
+
+// refKey returns the key of an object of a backup ref
+func (d *s3Destination) refKey(root, refName, file string) (string, error) {
+	refPath, ok := strings.CutPrefix(refName, backupRefPrefix)
+	if !ok {
+		return "", fmt.Errorf("backup ref %s can't be stored in a bucket", refName)
+	}
+	return path.Join(root, refPath, file), nil
+}
+
+// readManifest reads the manifest of a backup ref and its ETag; it is empty, with no ETag, when
+// nothing was backed up to the ref yet
+func (d *s3Destination) readManifest(root, refName string) (*bundleManifest, string, error) {
+	key, err := d.refKey(root, refName, bundleManifestFile)
+	if err != nil {
+		return nil, "", err
+	}
+	data, etag, err := d.client.GetBytesWithETag(d.bucket, key)
+	if errors.Is(err, s3.ErrNotFound) {
+		return &bundleManifest{}, "", nil
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read bundle manifest: %w", err)
+	}
+
+	var manifest bundleManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, "", fmt.Errorf("failed to parse bundle manifest %s: %w", key, err)
+	}
+	return &manifest, etag, nil
+}
+
+// writeManifest replaces the manifest of a backup ref if it still has the ETag it was read with
+func (d *s3Destination) writeManifest(root, refName string, manifest *bundleManifest, etag string) error {
+	key, err := d.refKey(root, refName, bundleManifestFile)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode bundle manifest: %w", err)
+	}
+
+	err = d.client.PutBytesIfMatch(d.bucket, key, data, etag)
+	if errors.Is(err, s3.ErrPreconditionFailed) {
+		return fmt.Errorf("backup ref %s changed in the bucket while pushing", refName)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write bundle manifest: %w", err)
+	}
+	return nil
+}
+
+// readIndex reads the index of the repository and its ETag; it is empty, with no ETag, when
+// nothing was backed up yet
+func (d *s3Destination) readIndex(root string) (*s3Index, string, error) {
+	key := path.Join(root, s3IndexFile)
+	data, etag, err := d.client.GetBytesWithETag(d.bucket, key)
+	if errors.Is(err, s3.ErrNotFound) {
+		return &s3Index{Refs: make(map[string]s3IndexEntry)}, "", nil
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read backup index: %w", err)
+	}
+
+	index := s3Index{Refs: make(map[string]s3IndexEntry)}
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, "", fmt.Errorf("failed to parse backup index %s: %w", key, err)
+	}
+	if index.Refs == nil {
+		index.Refs = make(map[string]s3IndexEntry)
+	}
+	return &index, etag, nil
+}
+
+// updateIndex applies update to the index of the repository and writes it back if it changed,
+// starting over when another store replaced the index meanwhile
+func (d *s3Destination) updateIndex(root string, update func(index *s3Index) bool) error {
+	key := path.Join(root, s3IndexFile)
+	for attempt := 0; attempt < s3IndexAttempts; attempt++ {
+		index, etag, err := d.readIndex(root)
+		if err != nil {
+			return err
+		}
+		if !update(index) {
+			return nil
+		}
+
+		data, err := json.MarshalIndent(index, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to encode backup index: %w", err)
+		}
+		err = d.client.PutBytesIfMatch(d.bucket, key, data, etag)
+		if errors.Is(err, s3.ErrPreconditionFailed) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write backup index: %w", err)
+		}
+		return nil
+	}
+	return fmt.Errorf("failed to write backup index: it kept changing while updating it")
+}
+
+// indexRef records a backup ref and its tip in the index of the repository
+func (d *s3Destination) indexRef(root, refName, tip string) error {
+	userIdentifier, machine, branch, _ := ParseBackupRefName(refName)
+	entry := s3IndexEntry{UserIdentifier: userIdentifier, Machine: machine, Branch: branch, Tip: tip}
+	return d.updateIndex(root, func(index *s3Index) bool {
+		if current, ok := index.Refs[refName]; ok && current == entry {
+			return false
+		}
+		index.Refs[refName] = entry
+		return true
+	})
+}
+
+// List lists the backup refs of the repository from the index, or a single backup ref from its
+// manifest, sorted by ref
+func (d *s3Destination) List(pattern string) ([]BackupRef, error) {
+	root, err := d.root()
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.HasSuffix(pattern, "*") {
+		if !strings.HasPrefix(pattern, backupRefPrefix) {
+			return nil, nil
+		}
+		manifest, _, err := d.readManifest(root, pattern)
+		if err != nil || manifest.Tip == "" {
+			return nil, err
+		}
+		return []BackupRef{manifest.backupRef()}, nil
+	}
+
+	index, _, err := d.readIndex(root)
+	if err != nil {
+		return nil, err
+	}
+	var refs []BackupRef
+	for refName, entry := range index.Refs {
+		if !matchRefPattern(pattern, refName) {
+			continue
+		}
+		if entry.Tip == "" {
+			// Indexed right before its first manifest was written; the manifest tells if it was
+			manifest, _, err := d.readManifest(root, refName)
+			if err != nil {
+				return nil, err
+			}
+			entry.Tip = manifest.Tip
+		}
+		if entry.Tip != "" {
+			refs = append(refs, BackupRef{
+				Hash:           entry.Tip,
+				Ref:            refName,
+				UserIdentifier: entry.UserIdentifier,
+				Machine:        entry.Machine,
+				Branch:         entry.Branch,
+			})
+		}
+	}
+
+	slices.SortFunc(refs, func(a, b BackupRef) int { return strings.Compare(a.Ref, b.Ref) })
+	return refs, nil
+}
+
+// Store uploads the history commit of a snapshot as an incremental bundle on top of the previous
+// tip, then points the manifest of the backup ref at it
+func (d *s3Destination) Store(snapshot, refName string) error {
+	commit, parent, err := d.repo.chainSnapshot(d, snapshot, refName)
+	if err != nil {
+		return err
+	}
+
+	root, err := d.root()
+	if err != nil {
+		return err
+	}
+	manifest, etag, err := d.readManifest(root, refName)
+	if err != nil {
+		return err
+	}
+	if manifest.Tip != parent {
+		return fmt.Errorf("backup ref %s changed in the bucket while pushing", refName)
+	}
+
+	file := fmt.Sprintf("%s-%s.bundle", time.Now().UTC().Format("20060102T150405Z"), commit[:12])
+	key, err := d.refKey(root, refName, file)
+	if err != nil {
+		return err
+	}
+
+	// git replaces the file rather than writing to it, so it's reopened once the bundle is created
+	tmp, err := os.CreateTemp("", "ghost-backup-*.bundle")
+	if err != nil {
+		return fmt.Errorf("failed to create bundle: %w", err)
+	}
+	_ = tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	if err := d.repo.createBundle(tmp.Name(), refName, commit, parent); err != nil {
+		return err
+	}
+	bundle, err := os.Open(tmp.Name())
+	if err != nil {
+		return fmt.Errorf("failed to read bundle: %w", err)
+	}
+	defer bundle.Close()
+	info, err := bundle.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to read bundle: %w", err)
+	}
+	if err := d.client.PutObject(d.bucket, key, bundle, info.Size()); err != nil {
+		return err
+	}
+
+	// A new backup ref is indexed before its manifest exists, so it can never be left out of listings
+	if etag == "" {
+		if err := d.indexRef(root, refName, ""); err != nil {
+			_ = d.client.DeleteObject(d.bucket, key)
+			return err
+		}
+	}
+
+	manifest.record(refName, file, commit, snapshot)
+	if err := d.writeManifest(root, refName, manifest, etag); err != nil {
+		// The bundle isn't referenced by any manifest
+		_ = d.client.DeleteObject(d.bucket, key)
+		return err
+	}
+
+	// The snapshot is stored; a tip left behind in the index only shows in listings until the next store
+	_ = d.indexRef(root, refName, commit)
+	return nil
+}
+
+// Fetch downloads and unpacks the bundles of a backup ref that aren't in the repository yet,
+// oldest first, and points the local copy of the ref at the tip
+func (d *s3Destination) Fetch(refName string) error {
+	root, err := d.root()
+	if err != nil {
+		return err
+	}
+	manifest, _, err := d.readManifest(root, refName)
+	if err != nil {
+		return err
+	}
+	if manifest.Tip == "" {
+		return fmt.Errorf("failed to fetch backup ref: %s not found in %s", refName, d.Name())
+	}
+
+	for _, entry := range d.repo.missingBundles(manifest) {
+		if err := d.fetchBundle(root, refName, entry); err != nil {
+			return fmt.Errorf("failed to unpack bundle %s: %w", entry.File, err)
+		}
+	}
+
+	if _, err := d.repo.gitOutput("update-ref", refName, manifest.Tip); err != nil {
+		return fmt.Errorf("failed to fetch backup ref: %w", err)
+	}
+	return nil
+}
+
+// fetchBundle downloads one bundle to a temporary file and unpacks it
+func (d *s3Destination) fetchBundle(root, refName string, entry bundleEntry) error {
+	key, err := d.refKey(root, refName, entry.File)
+	if err != nil {
+		return err
+	}
+
+	bundle, err := os.CreateTemp("", "ghost-backup-*.bundle")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(bundle.Name())
+
+	err = d.client.GetObject(d.bucket, key, bundle)
+	if closeErr := bundle.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return d.repo.unbundle(bundle.Name())
+}
+
+// Delete removes the manifest and bundles of a backup ref
+func (d *s3Destination) Delete(refName string) error {
+	root, err := d.root()
+	if err != nil {
+		return err
+	}
+	manifest, _, err := d.readManifest(root, refName)
+	if err != nil {
+		return err
+	}
+	err = d.updateIndex(root, func(index *s3Index) bool {
+		if _, ok := index.Refs[refName]; !ok {
+			return false
+		}
+		delete(index.Refs, refName)
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete backup ref: %w", err)
+	}
+	if manifest.Tip == "" {
+		return nil
+	}
+
+	// Drop the manifest first, so a failure can't leave it pointing at deleted bundles
+	key, err := d.refKey(root, refName, bundleManifestFile)
+	if err != nil {
+		return err
+	}
+	if err := d.client.DeleteObject(d.bucket, key); err != nil {
+		return fmt.Errorf("failed to delete backup ref: %w", err)
+	}
+
+	var errs []error
+	for _, entry := range manifest.Bundles {
+		key, err := d.refKey(root, refName, entry.File)
+		if err == nil {
+			err = d.client.DeleteObject(d.bucket, key)
+		}
+		errs = append(errs, err)
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("failed to delete backup ref: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,254 @@
+package git
+
+import (
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/FmTod/ghost-backup/internal/s3"
+	"github.com/FmTod/ghost-backup/internal/s3/s3test"
+)
+
+// setupS3 starts an S3 stand-in with the given buckets and points s3:// backup remotes at it
+func setupS3(t *testing.T, buckets ...string) *s3test.Server {
+	t.Helper()
+	server := s3test.NewServer(buckets...)
+	SetupS3(s3.Options{Endpoint: server.URL, AccessKeyID: "test", SecretAccessKey: "test"})
+	t.Cleanup(func() {
+		SetupS3(s3.Options{})
+		server.Close()
+	})
+	return server
+}
+
+func TestS3Location(t *testing.T) {
+	tests := []struct {
+		remote, bucket, prefix string
+	}{
+		{remote: "s3://backups", bucket: "backups"},
+		{remote: "s3://backups/", bucket: "backups"},
+		{remote: "s3://backups/team/wip/", bucket: "backups", prefix: "team/wip"},
+	}
+	for _, tt := range tests {
+		bucket, prefix, ok := s3Location(tt.remote)
+		if !ok || bucket != tt.bucket || prefix != tt.prefix {
+			t.Errorf("s3Location(%q) = %q, %q, %v, want %q, %q", tt.remote, bucket, prefix, ok, tt.bucket, tt.prefix)
+		}
+	}
+}
+
+func TestGitRepo_S3Bucket(t *testing.T) {
+	server := setupS3(t, "backups")
+	tmpDir := setupTestRepoWithRemote(t)
+	repo := NewGitRepo(tmpDir)
+	remote := S3Remote("backups", "wip")
+	refName := BackupRefName("test@example.com", "", "main")
+
+	// Nothing backed up yet
+	refs, err := repo.ListAllBackupRefs(remote)
+	if err != nil {
+		t.Fatalf("ListAllBackupRefs() error = %v", err)
+	}
+	if len(refs) != 0 {
+		t.Fatalf("ListAllBackupRefs() = %+v, want none", refs)
+	}
+
+	var stashes []string
+	for _, content := range []string{"first", "second"} {
+		if err := os.WriteFile(filepath.Join(tmpDir, "test.txt"), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to modify test file: %v", err)
+		}
+		hash, err := repo.CreateStash(false)
+		if err != nil {
+			t.Fatalf("CreateStash() error = %v", err)
+		}
+		if err := repo.PushToBackupRef(hash, refName, remote); err != nil {
+			t.Fatalf("PushToBackupRef() error = %v", err)
+		}
+		stashes = append(stashes, hash)
+	}
+
+	// An index, a manifest and one bundle per snapshot, keyed by user, branch and time
+	var indexes, manifests, bundles int
+	for _, key := range server.Keys("backups") {
+		switch {
+		case !strings.HasPrefix(key, "wip/"):
+			t.Errorf("Unexpected object %s", key)
+		case path.Base(key) == s3IndexFile && path.Dir(path.Dir(key)) == "wip":
+			indexes++
+		case !strings.Contains(key, "/test%40example.com/main/@/"):
+			t.Errorf("Unexpected object %s", key)
+		case path.Base(key) == bundleManifestFile:
+			manifests++
+		case strings.HasSuffix(key, ".bundle"):
+			bundles++
+		default:
+			t.Errorf("Unexpected object %s", key)
+		}
+	}
+	if indexes != 1 || manifests != 1 || bundles != 2 {
+		t.Fatalf("Bucket holds %v, want an index, a manifest and 2 bundles", server.Keys("backups"))
+	}
+
+	// A fresh clone reads the backups back from the bucket alone
+	clone := filepath.Join(t.TempDir(), "clone")
+	if output, err := exec.Command("git", "clone", "-q", tmpDir, clone).CombinedOutput(); err != nil {
+		t.Fatalf("Failed to clone: %v, %s", err, output)
+	}
+	cloneRepo := NewGitRepo(clone)
+
+	refs, err = cloneRepo.ListAllBackupRefs(remote)
+	if err != nil {
+		t.Fatalf("ListAllBackupRefs() error = %v", err)
+	}
+	if len(refs) != 1 || refs[0].Ref != refName || refs[0].UserIdentifier != "test@example.com" {
+		t.Fatalf("ListAllBackupRefs() = %+v, want %s", refs, refName)
+	}
+
+	snapshots, err := cloneRepo.ListBackupHistory(remote, refName, 0)
+	if err != nil {
+		t.Fatalf("ListBackupHistory() error = %v", err)
+	}
+	if len(snapshots) != 2 || snapshots[0].Hash != stashes[1] || snapshots[1].Hash != stashes[0] {
+		t.Errorf("ListBackupHistory() = %+v, want %v newest first", snapshots, stashes)
+	}
+}
+
+func TestGitRepo_S3Bucket_Unavailable(t *testing.T) {
+	setupS3(t)
+	repo := NewGitRepo(setupTestRepoWithRemote(t))
+	remote := S3Remote("missing", "")
+
+	if _, err := repo.ListAllBackupRefs(remote); err == nil {
+		t.Error("ListAllBackupRefs() should fail when the bucket doesn't exist")
+	}
+	if _, err := repo.FirstReachableRemote([]string{remote, "origin"}); err != nil {
+		t.Errorf("FirstReachableRemote() error = %v, want a failover to origin", err)
+	}
+}
+
+// TestGitRepo_S3Bucket_RacingStores interleaves stores into one bucket: stores on different
+// backup refs both survive, and of two stores on the same ref, the last one to finish fails
+func TestGitRepo_S3Bucket_RacingStores(t *testing.T) {
+	server := setupS3(t, "backups")
+	tmpDir := setupTestRepoWithRemote(t)
+	repo := NewGitRepo(tmpDir)
+	remote := S3Remote("backups", "")
+	laptop := BackupRefName("test@example.com", "laptop", "main")
+	desktop := BackupRefName("test@example.com", "desktop", "main")
+
+	var stashes []string
+	for _, content := range []string{"laptop-1", "desktop-1", "laptop-2", "laptop-3", "ci-1", "laptop-4"} {
+		if err := os.WriteFile(filepath.Join(tmpDir, "test.txt"), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to modify test file: %v", err)
+		}
+		hash, err := repo.CreateStash(false)
+		if err != nil {
+			t.Fatalf("CreateStash() error = %v", err)
+		}
+		stashes = append(stashes, hash)
+	}
+
+	// interleave runs a store right before the manifest of a backup ref, or the index when the
+	// ref is empty, is written
+	interleave := func(refName string, store func()) {
+		suffix := "/" + s3IndexFile
+		if refName != "" {
+			suffix = strings.TrimPrefix(refName, backupRefPrefix) + "/" + bundleManifestFile
+		}
+		done := false
+		server.BeforePut = func(bucket, key string) {
+			if !done && strings.HasSuffix(key, suffix) {
+				done = true
+				store()
+			}
+		}
+		t.Cleanup(func() { server.BeforePut = nil })
+	}
+
+	// Another machine stores on its own ref while the laptop is storing
+	interleave(laptop, func() {
+		if err := repo.PushToBackupRef(stashes[1], desktop, remote); err != nil {
+			t.Errorf("PushToBackupRef(desktop) error = %v", err)
+		}
+	})
+	if err := repo.PushToBackupRef(stashes[0], laptop, remote); err != nil {
+		t.Fatalf("PushToBackupRef(laptop) error = %v", err)
+	}
+
+	refs, err := repo.ListAllBackupRefs(remote)
+	if err != nil {
+		t.Fatalf("ListAllBackupRefs() error = %v", err)
+	}
+	if len(refs) != 2 {
+		t.Fatalf("ListAllBackupRefs() = %+v, want the laptop and desktop refs", refs)
+	}
+
+	// Two stores on the same ref: the one finishing first wins, the other fails
+	interleave(laptop, func() {
+		if err := repo.PushToBackupRef(stashes[3], laptop, remote); err != nil {
+			t.Errorf("PushToBackupRef(laptop) error = %v", err)
+		}
+	})
+	if err := repo.PushToBackupRef(stashes[2], laptop, remote); err == nil || !strings.Contains(err.Error(), "changed in the bucket") {
+		t.Errorf("PushToBackupRef() racing on the same ref error = %v, want a conflict", err)
+	}
+
+	for refName, want := range map[string][]string{laptop: {stashes[3], stashes[0]}, desktop: {stashes[1]}} {
+		snapshots, err := repo.ListBackupHistory(remote, refName, 0)
+		if err != nil {
+			t.Fatalf("ListBackupHistory(%s) error = %v", refName, err)
+		}
+		var got []string
+		for _, snapshot := range snapshots {
+			got = append(got, snapshot.Hash)
+		}
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("ListBackupHistory(%s) = %v, want %v", refName, got, want)
+		}
+	}
+
+	// The bundle of the failed store isn't left behind
+	var bundles int
+	for _, key := range server.Keys("backups") {
+		if strings.HasSuffix(key, ".bundle") {
+			bundles++
+		}
+	}
+	if bundles != 3 {
+		t.Errorf("Bucket holds %v, want 3 bundles", server.Keys("backups"))
+	}
+
+	// A new ref is indexed while the laptop records its tip: the laptop retries on the new index
+	ci := BackupRefName("test@example.com", "ci", "main")
+	interleave("", func() {
+		if err := repo.PushToBackupRef(stashes[4], ci, remote); err != nil {
+			t.Errorf("PushToBackupRef(ci) error = %v", err)
+		}
+	})
+	if err := repo.PushToBackupRef(stashes[5], laptop, remote); err != nil {
+		t.Fatalf("PushToBackupRef(laptop) error = %v", err)
+	}
+	tip, err := repo.GetRemoteRefHash(remote, laptop)
+	if err != nil {
+		t.Fatalf("GetRemoteRefHash() error = %v", err)
+	}
+
+	refs, err = repo.ListAllBackupRefs(remote)
+	if err != nil {
+		t.Fatalf("ListAllBackupRefs() error = %v", err)
+	}
+	var got []string
+	for _, ref := range refs {
+		got = append(got, ref.Ref)
+		if ref.Ref == laptop && ref.Hash != tip {
+			t.Errorf("Indexed laptop tip = %s, want %s", ref.Hash, tip)
+		}
+	}
+	if want := []string{ci, desktop, laptop}; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("ListAllBackupRefs() = %v, want %v sorted", got, want)
+	}
+}
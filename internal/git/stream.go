@@ -0,0 +1,227 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// commandStream streams the stdout of git commands run one after the other, without buffering it
+type commandStream struct {
+	dir      string
+	commands [][]string // Commands not started yet
+	cmd      *exec.Cmd
+	stdout   io.ReadCloser
+	stderr   bytes.Buffer
+}
+
+// OpenSnapshotDiff streams everything a stash adds on top of its HEAD: the working tree changes,
+// followed by what the index commit adds beyond them. A combined diff of the stash would hide
+// changes that are only staged, which are still pushed as part of the index commit.
+// The caller must close the stream.
+func (g *GitRepo) OpenSnapshotDiff(stashHash string) (io.ReadCloser, error) {
+	if _, err := g.revParse(stashHash + "^2"); err != nil {
+		return nil, fmt.Errorf("failed to read stash index commit: %w", err)
+	}
+
+	return &commandStream{
+		dir: g.Path,
+		commands: [][]string{
+			{"diff", "--no-color", "--no-ext-diff", stashHash + "^1", stashHash},
+			{"diff", "--no-color", "--no-ext-diff", stashHash, stashHash + "^2"},
+		},
+	}, nil
+}
+
+// OpenSnapshotDelta streams what a stash changes compared to an earlier stash of the same HEAD:
+// the working tree delta, followed by the index delta. Files whose blobs didn't change are left out,
+// and renames are shown as additions so moved content is seen again.
+// It fails when the base stash is no longer available or HEAD moved since it was taken.
+// The caller must close the stream.
+func (g *GitRepo) OpenSnapshotDelta(baseHash, stashHash string) (io.ReadCloser, error) {
+	baseHead, err := g.revParse(baseHash + "^1")
+	if err != nil {
+		return nil, fmt.Errorf("base snapshot %s is not available: %w", baseHash, err)
+	}
+	if _, err := g.revParse(baseHash + "^2"); err != nil {
+		return nil, fmt.Errorf("base snapshot %s is not available: %w", baseHash, err)
+	}
+	head, err := g.revParse(stashHash + "^1")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stash HEAD: %w", err)
+	}
+	if head != baseHead {
+		return nil, fmt.Errorf("HEAD moved since base snapshot %s", baseHash)
+	}
+	if _, err := g.revParse(stashHash + "^2"); err != nil {
+		return nil, fmt.Errorf("failed to read stash index commit: %w", err)
+	}
+
+	return &commandStream{
+		dir: g.Path,
+		commands: [][]string{
+			{"diff", "--no-color", "--no-ext-diff", "--no-renames", baseHash, stashHash},
+			{"diff", "--no-color", "--no-ext-diff", "--no-renames", baseHash + "^2", stashHash + "^2"},
+		},
+	}, nil
+}
+
+// SnapshotPaths lists the paths a stash changes compared to its HEAD, in either its working tree
+// or its index commit, sorted and without duplicates
+func (g *GitRepo) SnapshotPaths(stashHash string) ([]string, error) {
+	seen := make(map[string]bool)
+	var paths []string
+	for _, commit := range []string{stashHash, stashHash + "^2"} {
+		output, err := g.gitOutput("diff", "--name-only", "-z", "--no-renames", stashHash+"^1", commit)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list snapshot paths: %w", err)
+		}
+		for _, path := range strings.Split(output, "\x00") {
+			if path != "" && !seen[path] {
+				seen[path] = true
+				paths = append(paths, path)
+			}
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// SnapshotFileSizes returns the size in bytes of every path a stash changes: the size of its
+// working tree version plus that of its index version when it's a different blob. Versions
+// identical to HEAD are not counted, so the total is about what pushing the stash uploads.
+func (g *GitRepo) SnapshotFileSizes(stashHash string) (map[string]int64, error) {
+	paths, err := g.SnapshotPaths(stashHash)
+	if err != nil {
+		return nil, err
+	}
+	sizes := make(map[string]int64, len(paths))
+	if len(paths) == 0 {
+		return sizes, nil
+	}
+
+	head, err := g.treeBlobs(stashHash+"^1", paths)
+	if err != nil {
+		return nil, err
+	}
+	counted := make(map[string]string) // Blob already counted for each path
+	for _, commit := range []string{stashHash, stashHash + "^2"} {
+		blobs, err := g.treeBlobs(commit, paths)
+		if err != nil {
+			return nil, err
+		}
+		for path, blob := range blobs {
+			if blob.hash == head[path].hash || blob.hash == counted[path] {
+				continue
+			}
+			counted[path] = blob.hash
+			sizes[path] += blob.size
+		}
+	}
+	for _, path := range paths {
+		if _, ok := sizes[path]; !ok {
+			sizes[path] = 0 // Deleted files
+		}
+	}
+	return sizes, nil
+}
+
+// treeBlob is a blob listed by ls-tree
+type treeBlob struct {
+	hash string
+	size int64
+}
+
+// treeBlobs lists the blobs of a commit at the given paths
+func (g *GitRepo) treeBlobs(commit string, paths []string) (map[string]treeBlob, error) {
+	output, err := g.gitOutput(append([]string{"ls-tree", "-r", "-l", "-z", commit, "--"}, paths...)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read blob sizes: %w", err)
+	}
+
+	blobs := make(map[string]treeBlob)
+	for _, entry := range strings.Split(output, "\x00") {
+		info, path, ok := strings.Cut(entry, "\t")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(info) // <mode> <type> <hash> <size>
+		if len(fields) != 4 || fields[1] != "blob" {
+			continue
+		}
+		size, err := strconv.ParseInt(fields[3], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid blob size %q for %s", fields[3], path)
+		}
+		blobs[path] = treeBlob{hash: fields[2], size: size}
+	}
+	return blobs, nil
+}
+
+// Read reads from the running command, starting the next one when it finishes
+func (s *commandStream) Read(p []byte) (int, error) {
+	for {
+		if s.cmd == nil {
+			if len(s.commands) == 0 {
+				return 0, io.EOF
+			}
+			if err := s.start(); err != nil {
+				return 0, err
+			}
+		}
+
+		n, err := s.stdout.Read(p)
+		if err != io.EOF {
+			return n, err
+		}
+
+		waitErr := s.cmd.Wait()
+		args := s.cmd.Args[1:]
+		s.cmd = nil
+		if waitErr != nil {
+			return n, fmt.Errorf("git %s failed: %w, stderr: %s", strings.Join(args, " "), waitErr, strings.TrimSpace(s.stderr.String()))
+		}
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+// start runs the next command
+func (s *commandStream) start() error {
+	args := s.commands[0]
+	s.commands = s.commands[1:]
+
+	cmd := exec.Command("git", args...)
+	cmd.Dir = s.dir
+	s.stderr.Reset()
+	cmd.Stderr = &s.stderr
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("failed to run git %s: %w", strings.Join(args, " "), err)
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to run git %s: %w", strings.Join(args, " "), err)
+	}
+
+	s.cmd, s.stdout = cmd, stdout
+	return nil
+}
+
+// Close stops the running command, if any, and skips the remaining ones
+func (s *commandStream) Close() error {
+	s.commands = nil
+	if s.cmd == nil {
+		return nil
+	}
+
+	_ = s.cmd.Process.Kill()
+	_ = s.cmd.Wait()
+	s.cmd = nil
+	return nil
+}
@@ -0,0 +1,349 @@
+// Package s3 is a minimal client for S3-compatible object storage (AWS S3, MinIO and other
+// stand-ins), covering the few object operations ghost-backup needs. Requests use path-style
+// addressing and are signed with AWS Signature Version 4.
+package s3
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned when an object doesn't exist
+var ErrNotFound = errors.New("object not found")
+
+// ErrPreconditionFailed is returned when a conditional write finds the object changed
+var ErrPreconditionFailed = errors.New("object changed since it was read")
+
+// Options configures a Client
+type Options struct {
+	Endpoint        string // Base URL, e.g. http://localhost:9000 for MinIO; defaults to AWS in Region
+	Region          string // Defaults to us-east-1
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string // Only for temporary credentials
+}
+
+// WithEnvironment fills the options that aren't set from the standard AWS environment variables
+func (o Options) WithEnvironment() Options {
+	fill := func(value *string, names ...string) {
+		for _, name := range names {
+			if *value == "" {
+				*value = os.Getenv(name)
+			}
+		}
+	}
+	fill(&o.Endpoint, "AWS_ENDPOINT_URL_S3", "AWS_ENDPOINT_URL")
+	fill(&o.Region, "AWS_REGION", "AWS_DEFAULT_REGION")
+	fill(&o.AccessKeyID, "AWS_ACCESS_KEY_ID")
+	fill(&o.SecretAccessKey, "AWS_SECRET_ACCESS_KEY")
+	fill(&o.SessionToken, "AWS_SESSION_TOKEN")
+	return o
+}
+
+// Client performs signed requests against an S3-compatible endpoint
+type Client struct {
+	opts Options
+	http *http.Client
+}
+
+// NewClient returns a client for the given options
+func NewClient(opts Options) (*Client, error) {
+	if opts.Region == "" {
+		opts.Region = "us-east-1"
+	}
+	if opts.Endpoint == "" {
+		opts.Endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", opts.Region)
+	}
+	if _, err := url.Parse(opts.Endpoint); err != nil {
+		return nil, fmt.Errorf("invalid S3 endpoint: %w", err)
+	}
+	if opts.AccessKeyID == "" || opts.SecretAccessKey == "" {
+		return nil, fmt.Errorf("S3 credentials are not configured")
+	}
+	opts.Endpoint = strings.TrimSuffix(opts.Endpoint, "/")
+
+	return &Client{opts: opts, http: &http.Client{Timeout: 10 * time.Minute}}, nil
+}
+
+// PutObject uploads an object of the given size from r, which is read twice: once to hash it
+// for the signature and once to upload it
+func (c *Client) PutObject(bucket, key string, r io.ReadSeeker, size int64) error {
+	return c.put(bucket, key, r, size, nil)
+}
+
+// PutBytes uploads a small object held in memory
+func (c *Client) PutBytes(bucket, key string, data []byte) error {
+	return c.put(bucket, key, bytes.NewReader(data), int64(len(data)), nil)
+}
+
+// PutBytesIfMatch uploads a small object only if it still has the given ETag, or doesn't exist
+// yet when the ETag is empty, returning ErrPreconditionFailed otherwise
+func (c *Client) PutBytesIfMatch(bucket, key string, data []byte, etag string) error {
+	header := http.Header{}
+	if etag != "" {
+		header.Set("If-Match", etag)
+	} else {
+		header.Set("If-None-Match", "*")
+	}
+	return c.put(bucket, key, bytes.NewReader(data), int64(len(data)), header)
+}
+
+func (c *Client) put(bucket, key string, r io.ReadSeeker, size int64, header http.Header) error {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, r); err != nil {
+		return fmt.Errorf("failed to read object: %w", err)
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to read object: %w", err)
+	}
+
+	req, err := c.newRequest(http.MethodPut, bucket, key, nil, io.NopCloser(r))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	resp, err := c.do(req, hex.EncodeToString(hash.Sum(nil)))
+	if err != nil {
+		if errors.Is(err, ErrPreconditionFailed) {
+			return err
+		}
+		return fmt.Errorf("failed to upload %s: %w", key, err)
+	}
+	return resp.Body.Close()
+}
+
+// GetObject downloads an object into w, returning ErrNotFound when it doesn't exist
+func (c *Client) GetObject(bucket, key string, w io.Writer) error {
+	_, err := c.get(bucket, key, w)
+	return err
+}
+
+// GetBytes downloads a small object into memory, returning ErrNotFound when it doesn't exist
+func (c *Client) GetBytes(bucket, key string) ([]byte, error) {
+	data, _, err := c.GetBytesWithETag(bucket, key)
+	return data, err
+}
+
+// GetBytesWithETag downloads a small object into memory along with its ETag, for a later
+// PutBytesIfMatch; it returns ErrNotFound when the object doesn't exist
+func (c *Client) GetBytesWithETag(bucket, key string) ([]byte, string, error) {
+	var buf bytes.Buffer
+	etag, err := c.get(bucket, key, &buf)
+	if err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), etag, nil
+}
+
+// get downloads an object into w and returns its ETag
+func (c *Client) get(bucket, key string, w io.Writer) (string, error) {
+	req, err := c.newRequest(http.MethodGet, bucket, key, nil, nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := c.do(req, emptyPayloadHash)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return "", err
+		}
+		return "", fmt.Errorf("failed to download %s: %w", key, err)
+	}
+	defer resp.Body.Close()
+
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return "", fmt.Errorf("failed to download %s: %w", key, err)
+	}
+	return resp.Header.Get("ETag"), nil
+}
+
+// DeleteObject deletes an object; deleting one that doesn't exist succeeds
+func (c *Client) DeleteObject(bucket, key string) error {
+	req, err := c.newRequest(http.MethodDelete, bucket, key, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.do(req, emptyPayloadHash)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("failed to delete %s: %w", key, err)
+	}
+	if resp != nil {
+		return resp.Body.Close()
+	}
+	return nil
+}
+
+// emptyPayloadHash is the SHA-256 of an empty request body
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// newRequest builds a path-style request for an object, or for the bucket when the key is empty;
+// it is signed when sent
+func (c *Client) newRequest(method, bucket, key string, query url.Values, body io.ReadCloser) (*http.Request, error) {
+	// Keys are used as is, a "%" in them is not an escape
+	u, err := url.Parse(c.opts.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid S3 endpoint: %w", err)
+	}
+	u.Path += "/" + bucket + "/" + key
+	u.RawPath = escapePath(u.Path)
+	u.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequest(method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Body = body
+	return req, nil
+}
+
+// errorResponse is the XML body of an S3 error
+type errorResponse struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// do signs and sends a request and turns error responses into errors, ErrNotFound for missing
+// objects and ErrPreconditionFailed for failed conditional writes
+func (c *Client) do(req *http.Request, payloadHash string) (*http.Response, error) {
+	signV4(req, c.opts, payloadHash, time.Now())
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	var body errorResponse
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	_ = xml.Unmarshal(data, &body)
+
+	if body.Code == "NoSuchKey" || (resp.StatusCode == http.StatusNotFound && body.Code == "") {
+		return nil, ErrNotFound
+	}
+	// AWS answers a conditional write racing with another one with a 409
+	if resp.StatusCode == http.StatusPreconditionFailed || body.Code == "ConditionalRequestConflict" {
+		return nil, ErrPreconditionFailed
+	}
+	if body.Code != "" {
+		return nil, fmt.Errorf("%s: %s (HTTP %d)", body.Code, body.Message, resp.StatusCode)
+	}
+	return nil, fmt.Errorf("unexpected response: %s", resp.Status)
+}
+
+// signV4 signs a request with AWS Signature Version 4, signing the host and every header already
+// set on the request
+func signV4(req *http.Request, opts Options, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if opts.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", opts.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + opts.Region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+opts.SecretAccessKey), date)
+	key = hmacSHA256(key, opts.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		opts.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// canonicalQuery encodes query parameters sorted by name, as SigV4 requires
+func canonicalQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	var parts []string
+	for _, name := range names {
+		values := slices.Clone(query[name])
+		slices.Sort(values)
+		for _, value := range values {
+			parts = append(parts, escape(name)+"="+escape(value))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// escapePath percent-encodes each segment of a path the way SigV4 expects
+func escapePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = escape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// escape percent-encodes everything but the unreserved characters of RFC 3986
+func escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
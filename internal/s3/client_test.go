@@ -0,0 +1,108 @@
+package s3_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/FmTod/ghost-backup/internal/s3"
+	"github.com/FmTod/ghost-backup/internal/s3/s3test"
+)
+
+func TestClient_Objects(t *testing.T) {
+	server := s3test.NewServer("backups")
+	defer server.Close()
+
+	client, err := s3.NewClient(s3.Options{Endpoint: server.URL, AccessKeyID: "key", SecretAccessKey: "secret"})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+
+	key := "wip/user%40example.com/feature/x/@/manifest.json"
+	if err := client.PutBytes("backups", key, []byte("content")); err != nil {
+		t.Fatalf("PutBytes() error = %v", err)
+	}
+	if keys := server.Keys("backups"); len(keys) != 1 || keys[0] != key {
+		t.Errorf("Keys() = %v, want [%s]", keys, key)
+	}
+
+	data, err := client.GetBytes("backups", key)
+	if err != nil {
+		t.Fatalf("GetBytes() error = %v", err)
+	}
+	if string(data) != "content" {
+		t.Errorf("GetBytes() = %q, want %q", data, "content")
+	}
+
+	if err := client.DeleteObject("backups", key); err != nil {
+		t.Fatalf("DeleteObject() error = %v", err)
+	}
+	if _, err := client.GetBytes("backups", key); !errors.Is(err, s3.ErrNotFound) {
+		t.Errorf("GetBytes() after delete error = %v, want ErrNotFound", err)
+	}
+	if err := client.DeleteObject("backups", key); err != nil {
+		t.Errorf("DeleteObject() of a missing object error = %v", err)
+	}
+
+	_, err = client.GetBytes("missing", key)
+	if err == nil || errors.Is(err, s3.ErrNotFound) || !strings.Contains(err.Error(), "NoSuchBucket") {
+		t.Errorf("GetBytes() from a missing bucket error = %v, want NoSuchBucket", err)
+	}
+}
+
+func TestClient_PutBytesIfMatch(t *testing.T) {
+	server := s3test.NewServer("backups")
+	defer server.Close()
+
+	client, err := s3.NewClient(s3.Options{Endpoint: server.URL, AccessKeyID: "key", SecretAccessKey: "secret"})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+
+	// Without an ETag, the object must not exist yet
+	if err := client.PutBytesIfMatch("backups", "manifest.json", []byte("v1"), ""); err != nil {
+		t.Fatalf("PutBytesIfMatch() error = %v", err)
+	}
+	if err := client.PutBytesIfMatch("backups", "manifest.json", []byte("v1"), ""); !errors.Is(err, s3.ErrPreconditionFailed) {
+		t.Errorf("PutBytesIfMatch() over an existing object error = %v, want ErrPreconditionFailed", err)
+	}
+
+	_, etag, err := client.GetBytesWithETag("backups", "manifest.json")
+	if err != nil || etag == "" {
+		t.Fatalf("GetBytesWithETag() = %q, %v, want an ETag", etag, err)
+	}
+	if err := client.PutBytes("backups", "manifest.json", []byte("v2")); err != nil {
+		t.Fatalf("PutBytes() error = %v", err)
+	}
+	if err := client.PutBytesIfMatch("backups", "manifest.json", []byte("v3"), etag); !errors.Is(err, s3.ErrPreconditionFailed) {
+		t.Errorf("PutBytesIfMatch() with a stale ETag error = %v, want ErrPreconditionFailed", err)
+	}
+
+	data, etag, err := client.GetBytesWithETag("backups", "manifest.json")
+	if err != nil || string(data) != "v2" {
+		t.Fatalf("GetBytesWithETag() = %q, %v, want v2", data, err)
+	}
+	if err := client.PutBytesIfMatch("backups", "manifest.json", []byte("v3"), etag); err != nil {
+		t.Errorf("PutBytesIfMatch() with the current ETag error = %v", err)
+	}
+}
+
+func TestNewClient(t *testing.T) {
+	if _, err := s3.NewClient(s3.Options{Endpoint: "http://localhost:9000"}); err == nil {
+		t.Error("NewClient() without credentials should fail")
+	}
+}
+
+func TestOptions_WithEnvironment(t *testing.T) {
+	t.Setenv("AWS_ENDPOINT_URL_S3", "")
+	t.Setenv("AWS_ENDPOINT_URL", "http://minio:9000")
+	t.Setenv("AWS_REGION", "eu-west-1")
+	t.Setenv("AWS_ACCESS_KEY_ID", "env-key")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "env-secret")
+
+	got := s3.Options{AccessKeyID: "config-key"}.WithEnvironment()
+	want := s3.Options{Endpoint: "http://minio:9000", Region: "eu-west-1", AccessKeyID: "config-key", SecretAccessKey: "env-secret"}
+	if got != want {
+		t.Errorf("WithEnvironment() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,188 @@
+package security
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/FmTod/ghost-backup/internal/config"
+)
+
+// AllowlistFileName is the per-repository allowlist, stored beside .ghost-backup.json
+const AllowlistFileName = ".ghost-backup-allowlist.json"
+
+// GitleaksConfigFileName is a repository's own gitleaks configuration, passed through to gitleaks
+const GitleaksConfigFileName = ".gitleaks.toml"
+
+// Allowlist lists findings accepted as false positives
+type Allowlist struct {
+	Fingerprints []string `json:"fingerprints,omitempty"` // Individual findings, usually recorded by 'ghost-backup secrets baseline'
+	Paths        []string `json:"paths,omitempty"`        // Path globs; '*' stays within a directory, '**' crosses directories
+	Rules        []string `json:"rules,omitempty"`        // Rule IDs ignored everywhere
+}
+
+// ScanOptions holds the repository-specific settings of a scan
+type ScanOptions struct {
+	Allowlist      *Allowlist             // Accepted findings, nil for none
+	GitleaksConfig string                 // gitleaks config file, empty for gitleaks' defaults
+	Scanners       []config.ScannerConfig // Scanner chain, empty for the default
+	MaxDiffSize    int64                  // Maximum diff size in bytes, 0 for no limit
+	OnLargeDiff    string                 // config.OnLargeDiff* behavior past MaxDiffSize
+	Base           *ScanBase              // Snapshot that last passed, to scan only what changed since; nil for a full scan
+}
+
+// GetAllowlistPath returns the path to the allowlist of a repository
+func GetAllowlistPath(repoPath string) string {
+	return filepath.Join(repoPath, AllowlistFileName)
+}
+
+// LoadAllowlist loads the allowlist of a repository, returning an empty one if it doesn't exist
+func LoadAllowlist(repoPath string) (*Allowlist, error) {
+	allowlist := &Allowlist{}
+
+	data, err := os.ReadFile(GetAllowlistPath(repoPath))
+	if os.IsNotExist(err) {
+		return allowlist, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secret allowlist: %w", err)
+	}
+
+	if err := json.Unmarshal(data, allowlist); err != nil {
+		return nil, fmt.Errorf("failed to parse secret allowlist: %w", err)
+	}
+
+	return allowlist, nil
+}
+
+// SaveAllowlist saves the allowlist of a repository
+func SaveAllowlist(repoPath string, allowlist *Allowlist) error {
+	data, err := json.MarshalIndent(allowlist, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal secret allowlist: %w", err)
+	}
+
+	if err := os.WriteFile(GetAllowlistPath(repoPath), append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write secret allowlist: %w", err)
+	}
+
+	return nil
+}
+
+// LoadScanOptions loads the allowlist and gitleaks config of a repository alongside
+// the scanner chain and diff size policy of its configuration
+func LoadScanOptions(repoPath string, cfg *config.LocalConfig) (ScanOptions, error) {
+	allowlist, err := LoadAllowlist(repoPath)
+	if err != nil {
+		return ScanOptions{}, err
+	}
+
+	opts := ScanOptions{
+		Allowlist:   allowlist,
+		Scanners:    cfg.Scanners,
+		MaxDiffSize: int64(cfg.MaxDiffSize) * 1024 * 1024,
+		OnLargeDiff: cfg.OnLargeDiff,
+	}
+	gitleaksConfig := filepath.Join(repoPath, GitleaksConfigFileName)
+	if _, err := os.Stat(gitleaksConfig); err == nil {
+		opts.GitleaksConfig = gitleaksConfig
+	}
+
+	return opts, nil
+}
+
+// Allows reports whether a finding is accepted by the allowlist
+func (a *Allowlist) Allows(f Finding) bool {
+	if a == nil {
+		return false
+	}
+	if slices.Contains(a.Fingerprints, f.Fingerprint) || slices.Contains(a.Rules, f.RuleID) {
+		return true
+	}
+	for _, pattern := range a.Paths {
+		if matchPathGlob(pattern, f.File) {
+			return true
+		}
+	}
+	return false
+}
+
+// AddFingerprints records the fingerprints of findings and returns how many were new
+func (a *Allowlist) AddFingerprints(findings []Finding) int {
+	added := 0
+	for _, f := range findings {
+		if f.Fingerprint == "" || slices.Contains(a.Fingerprints, f.Fingerprint) {
+			continue
+		}
+		a.Fingerprints = append(a.Fingerprints, f.Fingerprint)
+		added++
+	}
+	sort.Strings(a.Fingerprints)
+	return added
+}
+
+// Apply moves the findings accepted by the allowlist out of a result
+// A result that reports secrets without individual findings is left alone, so it still blocks
+func (a *Allowlist) Apply(result *ScanResult) {
+	if a == nil || len(result.Findings) == 0 {
+		return
+	}
+
+	var remaining []Finding
+	for _, f := range result.Findings {
+		if a.Allows(f) {
+			result.Allowed = append(result.Allowed, f)
+		} else {
+			remaining = append(remaining, f)
+		}
+	}
+
+	result.Findings = remaining
+	result.HasSecrets = len(remaining) > 0
+	result.Output = formatFindings(remaining)
+}
+
+// matchPathGlob matches a slash-separated path against a glob
+// Like .gitignore, patterns without a slash match the file name in any directory
+// and a trailing slash matches everything below a directory; a leading slash anchors to the root
+func matchPathGlob(pattern, name string) bool {
+	if strings.HasSuffix(pattern, "/") {
+		pattern += "**"
+	}
+	if anchored, ok := strings.CutPrefix(pattern, "/"); ok {
+		pattern = anchored
+	} else if !strings.Contains(pattern, "/") {
+		pattern = "**/" + pattern
+	}
+	re, err := regexp.Compile(globToRegexp(pattern))
+	return err == nil && re.MatchString(name)
+}
+
+// globToRegexp converts a glob with '*', '?' and '**' into an anchored regular expression
+func globToRegexp(pattern string) string {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return b.String()
+}
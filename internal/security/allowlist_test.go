@@ -0,0 +1,100 @@
+package security
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMatchPathGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"*.env", "config.env", true},
+		{"*.env", "deploy/prod.env", true},
+		{"*.env", "config.env.example", false},
+		{"testdata/", "testdata/keys/id_rsa", true},
+		{"testdata/", "pkg/testdata/id_rsa", false},
+		{"testdata/*.pem", "testdata/key.pem", true},
+		{"testdata/*.pem", "testdata/sub/key.pem", false},
+		{"testdata/**/*.pem", "testdata/sub/key.pem", true},
+		{"testdata/**/*.pem", "testdata/key.pem", true},
+		{"**/fixtures/**", "a/b/fixtures/c.json", true},
+		{"key?.txt", "key1.txt", true},
+		{"key?.txt", "key10.txt", false},
+		{"a.b", "axb", false},
+	}
+
+	for _, tt := range tests {
+		if got := matchPathGlob(tt.pattern, tt.name); got != tt.want {
+			t.Errorf("matchPathGlob(%q, %q) = %v, want %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAllowlist_Apply(t *testing.T) {
+	findings := []Finding{
+		{RuleID: "aws-access-key-id", File: "app/config.go", Fingerprint: "app/config.go:aws-access-key-id:aaaa"},
+		{RuleID: "private-key", File: "testdata/id_rsa", Fingerprint: "testdata/id_rsa:private-key:bbbb"},
+		{RuleID: "generic-high-entropy-assignment", File: "main.go", Fingerprint: "main.go:generic-high-entropy-assignment:cccc"},
+		{RuleID: "github-token", File: "main.go", Fingerprint: "main.go:github-token:dddd"},
+	}
+	allowlist := &Allowlist{
+		Fingerprints: []string{"app/config.go:aws-access-key-id:aaaa"},
+		Paths:        []string{"testdata/"},
+		Rules:        []string{"generic-high-entropy-assignment"},
+	}
+
+	result := &ScanResult{HasSecrets: true, Findings: findings}
+	allowlist.Apply(result)
+
+	if !result.HasSecrets || !reflect.DeepEqual(result.Findings, findings[3:]) {
+		t.Errorf("Findings = %+v, want only the github token", result.Findings)
+	}
+	if len(result.Allowed) != 3 {
+		t.Errorf("Allowed = %d findings, want 3", len(result.Allowed))
+	}
+
+	// Without details there is nothing to match, so the result still blocks
+	opaque := &ScanResult{HasSecrets: true, Output: "gitleaks failed"}
+	allowlist.Apply(opaque)
+	if !opaque.HasSecrets {
+		t.Error("A result without findings should keep blocking")
+	}
+
+	// A nil allowlist accepts nothing
+	var none *Allowlist
+	none.Apply(result)
+	if len(result.Findings) != 1 {
+		t.Errorf("nil allowlist changed findings: %+v", result.Findings)
+	}
+}
+
+func TestAllowlist_SaveLoad(t *testing.T) {
+	repoDir := t.TempDir()
+
+	empty, err := LoadAllowlist(repoDir)
+	if err != nil {
+		t.Fatalf("LoadAllowlist() error = %v", err)
+	}
+	if len(empty.Fingerprints)+len(empty.Paths)+len(empty.Rules) != 0 {
+		t.Errorf("Missing allowlist should be empty, got %+v", empty)
+	}
+
+	added := empty.AddFingerprints([]Finding{{Fingerprint: "b"}, {Fingerprint: "a"}, {Fingerprint: "b"}})
+	if added != 2 {
+		t.Errorf("AddFingerprints() = %d, want 2", added)
+	}
+	if err := SaveAllowlist(repoDir, empty); err != nil {
+		t.Fatalf("SaveAllowlist() error = %v", err)
+	}
+
+	loaded, err := LoadAllowlist(repoDir)
+	if err != nil {
+		t.Fatalf("LoadAllowlist() error = %v", err)
+	}
+	if !reflect.DeepEqual(loaded.Fingerprints, []string{"a", "b"}) {
+		t.Errorf("Fingerprints = %v, want [a b]", loaded.Fingerprints)
+	}
+}
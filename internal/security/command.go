@@ -0,0 +1,181 @@
+package security
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/FmTod/ghost-backup/internal/config"
+)
+
+// diffPlaceholder is replaced in a command scanner's arguments by the path of a file holding the diff
+const diffPlaceholder = "{diff}"
+
+// commandWaitDelay bounds how long a timed out scanner's output is drained after it was killed
+const commandWaitDelay = time.Second
+
+// commandScanner runs an external secret scanner such as trufflehog or an in-house tool
+type commandScanner struct {
+	name              string
+	argv              []string
+	findingsExitCodes []int  // Exit codes meaning secrets were found
+	format            string // Findings format on stdout, config.FindingsFormatNone to rely on the exit code
+}
+
+// Name returns the scanner name
+func (s *commandScanner) Name() string {
+	return s.name
+}
+
+// Scan runs the command on the diff, passed on stdin or as a file in place of {diff}
+func (s *commandScanner) Scan(ctx context.Context, diff *Diff) (*ScanResult, error) {
+	result := &ScanResult{Scanner: s.name}
+
+	stream, err := diff.Open()
+	if err != nil {
+		return result, err
+	}
+	defer stream.Close()
+
+	args := slices.Clone(s.argv)
+	var stdin io.Reader = stream
+	if slices.ContainsFunc(args, func(arg string) bool { return strings.Contains(arg, diffPlaceholder) }) {
+		// The file holds unredacted secrets; it's only readable by the user and removed right after
+		diffFile, err := os.CreateTemp("", "ghost-backup-diff-*.patch")
+		if err != nil {
+			return result, fmt.Errorf("failed to create diff file for %s: %w", s.name, err)
+		}
+		defer os.Remove(diffFile.Name())
+		_, err = io.Copy(diffFile, stream)
+		if closeErr := diffFile.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return result, fmt.Errorf("failed to write diff file for %s: %w", s.name, err)
+		}
+
+		for i, arg := range args {
+			args[i] = strings.ReplaceAll(arg, diffPlaceholder, diffFile.Name())
+		}
+		stdin = strings.NewReader("")
+	}
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdin = stdin
+	// Children of a killed scanner may keep its output open; don't wait for them past the timeout
+	cmd.WaitDelay = commandWaitDelay
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return result, ctx.Err()
+		}
+
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) || !slices.Contains(s.findingsExitCodes, exitErr.ExitCode()) {
+			return result, fmt.Errorf("%s scan failed: %w, stderr: %s", s.name, err, strings.TrimSpace(stderr.String()))
+		}
+		result.HasSecrets = true
+	}
+
+	if s.format != config.FindingsFormatNone && len(bytes.TrimSpace(stdout.Bytes())) > 0 {
+		raw, err := parseFindings(s.format, stdout.Bytes())
+		var findings []Finding
+		if err == nil {
+			findings, err = diff.resolve(raw)
+		}
+		if err != nil {
+			if !result.HasSecrets {
+				return result, fmt.Errorf("%s scan failed: %w", s.name, err)
+			}
+			// Still block on the exit code; the findings are only needed for details
+			result.Output = fmt.Sprintf("%v\n%s", err, stderr.String())
+			return result, nil
+		}
+		result.Findings = findings
+	}
+
+	switch {
+	case len(result.Findings) > 0:
+		result.HasSecrets = true
+		result.Output = formatFindings(result.Findings)
+	case result.HasSecrets:
+		// Only the exit code tells, so show whatever the scanner printed
+		result.Output = strings.TrimSpace(stdout.String() + "\n" + stderr.String())
+		if result.Output == "" {
+			result.Output = "secrets reported by exit code"
+		}
+	}
+
+	return result, nil
+}
+
+// parseFindings converts a scanner's JSON output into findings
+func parseFindings(format string, output []byte) ([]rawFinding, error) {
+	switch format {
+	case config.FindingsFormatGitleaks:
+		return parseGitleaksReport(output)
+	case config.FindingsFormatTrufflehog:
+		return parseTrufflehogOutput(output)
+	default:
+		return nil, fmt.Errorf("unknown findings format %q", format)
+	}
+}
+
+// trufflehogFinding is a result line of trufflehog --json
+type trufflehogFinding struct {
+	DetectorName   string `json:"DetectorName"`
+	Raw            string `json:"Raw"`
+	SourceMetadata struct {
+		// Keyed by source type, e.g. "Filesystem"
+		Data map[string]struct {
+			File string `json:"file"`
+			Line int    `json:"line"`
+		} `json:"Data"`
+	} `json:"SourceMetadata"`
+}
+
+// parseTrufflehogOutput reads the findings of trufflehog's JSON lines
+// trufflehog only sees the diff, so its line numbers point into the diff
+func parseTrufflehogOutput(output []byte) ([]rawFinding, error) {
+	var findings []rawFinding
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 || line[0] != '{' {
+			continue // Log lines
+		}
+
+		var raw trufflehogFinding
+		if err := json.Unmarshal(line, &raw); err != nil {
+			return nil, fmt.Errorf("failed to parse trufflehog output: %w", err)
+		}
+
+		finding := rawFinding{RuleID: strings.ToLower(raw.DetectorName), Secret: raw.Raw}
+		for _, source := range raw.SourceMetadata.Data {
+			if source.Line > 0 {
+				finding.File, finding.DiffLine = source.File, source.Line
+				break
+			}
+		}
+		findings = append(findings, finding)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read trufflehog output: %w", err)
+	}
+
+	return findings, nil
+}
@@ -0,0 +1,205 @@
+package security
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/FmTod/ghost-backup/internal/config"
+)
+
+// maxDiffLineLength caps how much of a single diff line is kept in memory; the rest is not scanned
+const maxDiffLineLength = 1024 * 1024
+
+// errDiffTooLarge stops a diff stream that went past the maximum diff size
+var errDiffTooLarge = errors.New("diff exceeds the maximum size")
+
+// DiffSource opens a new stream of the diff to scan
+// Every scanner reads its own stream, so the diff is never held in memory as a whole
+type DiffSource func() (io.ReadCloser, error)
+
+// StringDiff returns a DiffSource serving a diff already in memory
+func StringDiff(diff string) DiffSource {
+	return func() (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader(diff)), nil
+	}
+}
+
+// Diff is the diff handed to scanners, with the maximum diff size policy applied to its streams
+type Diff struct {
+	source DiffSource
+	limit  int64  // Maximum size in bytes, 0 for no limit
+	policy string // config.OnLargeDiff* value applied past the limit
+
+	mu       sync.Mutex
+	exceeded bool
+}
+
+// newDiff creates a Diff with the size policy of the scan options
+func newDiff(source DiffSource, opts ScanOptions) *Diff {
+	return &Diff{source: source, limit: opts.MaxDiffSize, policy: opts.OnLargeDiff}
+}
+
+// Open opens a new stream of the diff
+// Past the limit, the stream ends (truncate) or fails (abort, skip)
+func (d *Diff) Open() (io.ReadCloser, error) {
+	rc, err := d.source()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get diff: %w", err)
+	}
+	if d.limit <= 0 {
+		return rc, nil
+	}
+	return &limitedDiff{rc: rc, diff: d, remaining: d.limit}, nil
+}
+
+// Exceeded reports whether a stream of the diff went past the limit
+func (d *Diff) Exceeded() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.exceeded
+}
+
+// limitedDiff is a diff stream enforcing the maximum diff size
+type limitedDiff struct {
+	rc        io.ReadCloser
+	diff      *Diff
+	remaining int64
+	done      bool
+}
+
+// Read reads up to the limit, then checks whether anything was left out
+func (l *limitedDiff) Read(p []byte) (int, error) {
+	if l.done {
+		return 0, io.EOF
+	}
+
+	if l.remaining <= 0 {
+		l.done = true
+		var next [1]byte
+		if n, _ := io.ReadFull(l.rc, next[:]); n == 0 {
+			return 0, io.EOF
+		}
+
+		l.diff.mu.Lock()
+		l.diff.exceeded = true
+		l.diff.mu.Unlock()
+		if l.diff.policy == config.OnLargeDiffTruncate {
+			return 0, io.EOF
+		}
+		return 0, errDiffTooLarge
+	}
+
+	if int64(len(p)) > l.remaining {
+		p = p[:l.remaining]
+	}
+	n, err := l.rc.Read(p)
+	l.remaining -= int64(n)
+	return n, err
+}
+
+// Close closes the underlying stream
+func (l *limitedDiff) Close() error {
+	return l.rc.Close()
+}
+
+// forEachDiffLine calls fn with every line of a diff and its 1-based line number
+// Lines longer than maxDiffLineLength are cut, keeping memory bounded
+func forEachDiffLine(r io.Reader, fn func(lineNo int, text string)) error {
+	br := bufio.NewReaderSize(r, 64*1024)
+	var line []byte
+	lineNo := 0
+
+	for {
+		chunk, err := br.ReadSlice('\n')
+		if room := maxDiffLineLength - len(line); room > 0 {
+			line = append(line, chunk[:min(len(chunk), room)]...)
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+
+		if len(chunk) > 0 || len(line) > 0 {
+			lineNo++
+			fn(lineNo, strings.TrimSuffix(string(line), "\n"))
+		}
+		line = line[:0]
+
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// rawFinding is a finding as reported by a scanner that only saw the diff
+type rawFinding struct {
+	RuleID   string
+	File     string // File reported by the scanner, used when the line can't be mapped
+	DiffLine int    // Line number within the diff
+	Secret   string
+}
+
+// resolve maps raw findings back to the changed files and lines, then redacts and fingerprints them
+// The diff is streamed again, keeping only the lines the findings point at
+func (d *Diff) resolve(raw []rawFinding) ([]Finding, error) {
+	wanted := make(map[int]bool, len(raw))
+	for _, f := range raw {
+		wanted[f.DiffLine] = true
+	}
+
+	locations := make(map[int]addedLine, len(wanted))
+	if len(wanted) > 0 {
+		stream, err := d.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer stream.Close()
+
+		parser := &diffParser{}
+		err = forEachDiffLine(stream, func(lineNo int, text string) {
+			if line, ok := parser.next(lineNo, text); ok && wanted[lineNo] {
+				line.Text = ""
+				locations[lineNo] = line
+			}
+		})
+		if err != nil && !errors.Is(err, errDiffTooLarge) {
+			return nil, fmt.Errorf("failed to read diff: %w", err)
+		}
+	}
+
+	findings := make([]Finding, 0, len(raw))
+	for _, f := range raw {
+		file, line := f.File, f.DiffLine
+		if loc, ok := locations[f.DiffLine]; ok {
+			file, line = loc.File, loc.Line
+		}
+
+		findings = append(findings, Finding{
+			RuleID:      f.RuleID,
+			File:        file,
+			Line:        line,
+			Match:       redact(f.Secret),
+			Fingerprint: fingerprint(file, f.RuleID, f.Secret),
+		})
+	}
+	return findings, nil
+}
+
+// LargeDiffNotice describes the max_diff_size behavior applied to a scan, or returns "" if the diff fit
+func LargeDiffNotice(result *ScanResult, opts ScanOptions) string {
+	size := opts.MaxDiffSize / (1024 * 1024)
+	switch result.LargeDiff {
+	case config.OnLargeDiffSkip:
+		return fmt.Sprintf("Diff exceeds max_diff_size (%d MB), secret scan skipped (on_large_diff=skip)", size)
+	case config.OnLargeDiffTruncate:
+		return fmt.Sprintf("Diff exceeds max_diff_size (%d MB), only the first %d MB were scanned (on_large_diff=truncate)", size, size)
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,144 @@
+package security
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/FmTod/ghost-backup/internal/config"
+)
+
+// IgnoreFileName lists more exclude globs for a repository, one per line in .gitignore syntax
+const IgnoreFileName = ".ghostbackupignore"
+
+// OutsideInclude is the rule of files left out of a snapshot because they match no include glob
+const OutsideInclude = "outside include"
+
+// PathFilter selects the paths of a repository that are backed up
+type PathFilter struct {
+	Include []string // Globs of the paths backed up, empty for everything
+	Exclude []string // Globs never backed up; the last match wins and "!" re-includes
+}
+
+// LoadPathFilter returns the path filter of a repository: its include globs, and the exclude globs
+// of config.ExcludePatterns followed by those of its .ghostbackupignore
+func LoadPathFilter(repoPath string, global *config.GlobalConfig, local *config.LocalConfig) (PathFilter, error) {
+	filter := PathFilter{Exclude: config.ExcludePatterns(global, local)}
+	if local != nil {
+		filter.Include = local.Include
+	}
+
+	data, err := os.ReadFile(filepath.Join(repoPath, IgnoreFileName))
+	if os.IsNotExist(err) {
+		return filter, nil
+	}
+	if err != nil {
+		return PathFilter{}, fmt.Errorf("failed to read %s: %w", IgnoreFileName, err)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		filter.Exclude = append(filter.Exclude, line)
+	}
+	return filter, scanner.Err()
+}
+
+// Match returns why a path is left out of backups: the exclude glob it matches or OutsideInclude.
+// An empty string means the path is backed up.
+func (f PathFilter) Match(path string) string {
+	if pattern, ok := matchExcludes(f.Exclude, path); ok {
+		return pattern
+	}
+	if len(f.Include) == 0 {
+		return ""
+	}
+	for _, pattern := range f.Include {
+		if matchPathOrParent(pattern, path) {
+			return ""
+		}
+	}
+	return OutsideInclude
+}
+
+// Keeps reports whether a path is backed up
+func (f PathFilter) Keeps(path string) bool {
+	return f.Match(path) == ""
+}
+
+// ExcludePaths drops the changes to paths left out by the filter from a snapshot, before it is
+// scanned or pushed. Excluded files keep their HEAD version (or are left out if they are new).
+// Files matching an exclude glob are recorded as trailers like files excluded for secrets, with
+// the glob as the rule; files outside the include globs are not, as they are out of scope by design.
+// It returns the snapshot to use, which is hash itself when nothing was left out; when only
+// excluded paths changed, the error wraps git.ErrNoChangesLeft.
+func ExcludePaths(src SnapshotSource, hash string, filter PathFilter) (string, []ExcludedFile, error) {
+	paths, err := src.SnapshotPaths(hash)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var excluded []ExcludedFile
+	for _, path := range paths {
+		if rule := filter.Match(path); rule != "" {
+			excluded = append(excluded, ExcludedFile{Path: path, Rules: []string{rule}})
+		}
+	}
+	if len(excluded) == 0 {
+		return hash, nil, nil
+	}
+
+	excludedPaths := make([]string, len(excluded))
+	var trailers []string
+	for i, f := range excluded {
+		excludedPaths[i] = f.Path
+		if f.Rules[0] != OutsideInclude {
+			trailers = append(trailers, f.Trailer())
+		}
+	}
+
+	filtered, err := src.ExcludeFromStash(hash, excludedPaths, trailers)
+	if err != nil {
+		return "", excluded, fmt.Errorf("failed to exclude paths: %w", err)
+	}
+	return filtered, excluded, nil
+}
+
+// matchExcludes returns the glob excluding a path, following .gitignore precedence:
+// the last matching pattern wins and a "!" pattern re-includes the path.
+// A pattern matching one of the parent directories excludes everything below it.
+func matchExcludes(patterns []string, path string) (string, bool) {
+	matched := ""
+	for _, pattern := range patterns {
+		negated, negate := strings.CutPrefix(pattern, "!")
+		if !matchPathOrParent(negated, path) {
+			continue
+		}
+		if negate {
+			matched = ""
+		} else {
+			matched = pattern
+		}
+	}
+	return matched, matched != ""
+}
+
+// matchPathOrParent matches a path or any of its parent directories against a glob
+func matchPathOrParent(pattern, path string) bool {
+	for {
+		if matchPathGlob(pattern, path) {
+			return true
+		}
+		i := strings.LastIndex(path, "/")
+		if i < 0 {
+			return false
+		}
+		path = path[:i]
+	}
+}
@@ -0,0 +1,133 @@
+package security
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/FmTod/ghost-backup/internal/config"
+)
+
+func TestMatchExcludes(t *testing.T) {
+	patterns := append(config.ExcludePatterns(nil, nil), "build/", "!.env.example", "/secrets")
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{".env", ".env*"},
+		{"app/.env.local", ".env*"},
+		{"certs/server.pem", "*.pem"},
+		{"home/.ssh/id_rsa.pub", "id_rsa*"},
+		{"passwords.kdbx", "*.kdbx"},
+		{"infra/terraform.tfstate", "*.tfstate"},
+		{"infra/terraform.tfstate.backup", "*.tfstate.*"},
+		{"build/out/app.js", "build/"},
+		{"secrets/token.txt", "/secrets"},
+		{".env.example", ""},
+		{"app/secrets/token.txt", ""},
+		{"main.go", ""},
+		{"environment.go", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, ok := matchExcludes(patterns, tt.path)
+			if got != tt.want || ok != (tt.want != "") {
+				t.Errorf("matchExcludes(%q) = %q, %v, want %q", tt.path, got, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestExcludePaths(t *testing.T) {
+	src := &fakeSnapshots{paths: map[string][]string{
+		"stash": {".env", "main.go", "deploy/prod.pem"},
+		"clean": {"main.go"},
+	}}
+
+	hash, excluded, err := ExcludePaths(src, "stash", PathFilter{Exclude: config.DefaultExcludes})
+	if err != nil {
+		t.Fatalf("ExcludePaths() error = %v", err)
+	}
+	if hash != "filtered" {
+		t.Errorf("hash = %s, want filtered", hash)
+	}
+	if want := []string{".env", "deploy/prod.pem"}; !reflect.DeepEqual(src.excluded, want) {
+		t.Errorf("excluded paths = %v, want %v", src.excluded, want)
+	}
+	if want := []string{"Ghost-Backup-Excluded: .env (.env*)", "Ghost-Backup-Excluded: deploy/prod.pem (*.pem)"}; !reflect.DeepEqual(src.trailers, want) {
+		t.Errorf("trailers = %v, want %v", src.trailers, want)
+	}
+	if len(excluded) != 2 {
+		t.Errorf("ExcludePaths() returned %d excluded files, want 2", len(excluded))
+	}
+
+	src.excluded = nil
+	hash, excluded, err = ExcludePaths(src, "clean", PathFilter{Exclude: config.DefaultExcludes})
+	if err != nil || hash != "clean" || len(excluded) != 0 || src.excluded != nil {
+		t.Errorf("ExcludePaths() = %s, %v, %v, want the snapshot untouched", hash, excluded, err)
+	}
+}
+
+func TestPathFilter_Include(t *testing.T) {
+	filter := PathFilter{Include: []string{"services/api/", "/docs"}, Exclude: []string{"services/api/generated/"}}
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"services/api/main.go", ""},
+		{"docs/index.md", ""},
+		{"services/api/generated/client.go", "services/api/generated/"},
+		{"services/web/main.go", OutsideInclude},
+		{"vendor/lib/lib.go", OutsideInclude},
+		{"other/docs/index.md", OutsideInclude},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := filter.Match(tt.path); got != tt.want {
+				t.Errorf("Match(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExcludePaths_OutsideIncludeHasNoTrailer(t *testing.T) {
+	src := &fakeSnapshots{paths: map[string][]string{"stash": {"mine/a.go", "theirs/b.go", "mine/.env"}}}
+
+	_, excluded, err := ExcludePaths(src, "stash", PathFilter{Include: []string{"mine/"}, Exclude: config.DefaultExcludes})
+	if err != nil {
+		t.Fatalf("ExcludePaths() error = %v", err)
+	}
+	if len(excluded) != 2 {
+		t.Errorf("ExcludePaths() excluded %v, want mine/.env and theirs/b.go", excluded)
+	}
+	if want := []string{"Ghost-Backup-Excluded: mine/.env (.env*)"}; !reflect.DeepEqual(src.trailers, want) {
+		t.Errorf("trailers = %v, want %v", src.trailers, want)
+	}
+}
+
+func TestLoadPathFilter_IgnoreFile(t *testing.T) {
+	repoDir := t.TempDir()
+	content := "# generated code\ngen/\n\n*.min.js  \n"
+	if err := os.WriteFile(filepath.Join(repoDir, IgnoreFileName), []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", IgnoreFileName, err)
+	}
+
+	local := &config.LocalConfig{Include: []string{"src/"}, Exclude: []string{"*.log"}}
+	filter, err := LoadPathFilter(repoDir, &config.GlobalConfig{Exclude: []string{"*.sqlite"}}, local)
+	if err != nil {
+		t.Fatalf("LoadPathFilter() error = %v", err)
+	}
+
+	if !reflect.DeepEqual(filter.Include, []string{"src/"}) {
+		t.Errorf("Include = %v, want [src/]", filter.Include)
+	}
+	tail := filter.Exclude[len(config.DefaultExcludes):]
+	if want := []string{"*.sqlite", "*.log", "gen/", "*.min.js"}; !reflect.DeepEqual(tail, want) {
+		t.Errorf("Exclude after the defaults = %v, want %v", tail, want)
+	}
+}
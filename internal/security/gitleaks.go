@@ -3,80 +3,164 @@ package security
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"os"
 	"os/exec"
-	"strings"
-	"time"
+
+	"github.com/FmTod/ghost-backup/internal/config"
 )
 
+// Scanners that can produce a ScanResult
 const (
-	// GitleaksTimeout is the maximum time to wait for gitleaks to complete
-	GitleaksTimeout = 60 * time.Second
+	ScannerGitleaks = config.ScannerGitleaks
+	ScannerNative   = config.ScannerNative // Built-in ruleset, also the fallback when gitleaks is not installed
 )
 
-// ScanResult represents the result of a gitleaks scan
+// ScanResult represents the result of a secret scan
 type ScanResult struct {
 	HasSecrets bool
-	Output     string
+	Output     string // Human-readable findings, one per line
 	Error      error
+	Scanner    string    // Scanner(s) that produced the result
+	Findings   []Finding // Individual findings, when the scanner reports them
+	Allowed    []Finding // Findings accepted by the repository's allowlist
+	Notes      []string  // Output of scanners that reported secrets without individual findings
+	Fallback   bool      // The built-in scanner ran because gitleaks is not installed
+	LargeDiff  string    // config.OnLargeDiff* behavior applied because the diff exceeded max_diff_size, "" if it fit
+}
+
+// Finding is a single detected secret
+type Finding struct {
+	RuleID      string
+	File        string
+	Line        int
+	Match       string // Redacted match
+	Fingerprint string // Stable identifier: <file>:<rule>:<hash of the secret>
+}
+
+// ScanDiff scans a git diff for secrets using gitleaks alone
+// Findings are read from gitleaks' JSON report, mapped back to files and lines of the diff
+// and filtered through the allowlist
+func ScanDiff(source DiffSource, opts ScanOptions) (*ScanResult, error) {
+	chain := &Chain{steps: []chainStep{{
+		scanner: &gitleaksScanner{config: opts.GitleaksConfig},
+		timeout: DefaultScannerTimeout,
+	}}}
+	return chain.Scan(newDiff(source, opts), opts.Allowlist)
+}
+
+// gitleaksScanner runs gitleaks on the diff passed through stdin
+type gitleaksScanner struct {
+	config string // gitleaks config file, empty for gitleaks' defaults
 }
 
-// ScanDiff scans a git diff for secrets using gitleaks
-// Returns true if secrets are found
-func ScanDiff(diff string) (*ScanResult, error) {
-	result := &ScanResult{}
+// Name returns the scanner name
+func (s *gitleaksScanner) Name() string {
+	return ScannerGitleaks
+}
+
+// Scan runs gitleaks and reads its JSON report
+func (s *gitleaksScanner) Scan(ctx context.Context, diff *Diff) (*ScanResult, error) {
+	result := &ScanResult{Scanner: ScannerGitleaks}
 
 	// Check if gitleaks is installed
 	if !isGitleaksInstalled() {
-		result.Error = fmt.Errorf("gitleaks not found in PATH")
-		return result, result.Error
+		return result, fmt.Errorf("gitleaks not found in PATH")
 	}
 
-	// Create context with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), GitleaksTimeout)
-	defer cancel()
+	// The report holds unredacted secrets; it's only read here and removed right after
+	reportFile, err := os.CreateTemp("", "ghost-backup-gitleaks-*.json")
+	if err != nil {
+		return result, fmt.Errorf("failed to create gitleaks report: %w", err)
+	}
+	reportPath := reportFile.Name()
+	_ = reportFile.Close()
+	defer os.Remove(reportPath)
 
-	// Run gitleaks with --no-git flag to treat input as raw text
-	// Use stdin to pass the diff
-	cmd := exec.CommandContext(ctx, "gitleaks", "detect", "--no-git", "--verbose", "--redact")
-	cmd.Stdin = strings.NewReader(diff)
+	// Run gitleaks on the diff passed through stdin (--pipe)
+	args := []string{"detect", "--pipe", "--no-git", "--no-banner", "--report-format", "json", "--report-path", reportPath}
+	if s.config != "" {
+		// gitleaks only looks for .gitleaks.toml in its working directory, so pass the repository's explicitly
+		args = append(args, "--config", s.config)
+	}
+	stream, err := diff.Open()
+	if err != nil {
+		return result, err
+	}
+	defer stream.Close()
 
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
+	cmd := exec.CommandContext(ctx, "gitleaks", args...)
+	cmd.Stdin = stream
+	cmd.WaitDelay = commandWaitDelay
+
+	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
 
-	err := cmd.Run()
+	err = cmd.Run()
 
 	// gitleaks returns exit code 1 if secrets are found
 	if err != nil {
-		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
-			result.Error = fmt.Errorf("gitleaks scan timed out after %v", GitleaksTimeout)
-			return result, result.Error
+		if ctx.Err() != nil {
+			return result, ctx.Err()
 		}
 
 		// Check if the error is due to secrets being found
 		var exitErr *exec.ExitError
-		if errors.As(err, &exitErr) {
-			if exitErr.ExitCode() == 1 {
-				// Secrets found
-				result.HasSecrets = true
-				result.Output = stderr.String()
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+			result.HasSecrets = true
+
+			report, readErr := os.ReadFile(reportPath)
+			var raw []rawFinding
+			if readErr == nil {
+				raw, readErr = parseGitleaksReport(report)
+			}
+			if readErr == nil {
+				result.Findings, readErr = diff.resolve(raw)
+			}
+			if readErr != nil {
+				// Still block on the exit code; the report is only needed for details
+				result.Output = fmt.Sprintf("failed to read gitleaks report: %v\n%s", readErr, stderr.String())
 				return result, nil
 			}
+
+			result.Output = formatFindings(result.Findings)
+			return result, nil
 		}
 
 		// Other error
-		result.Error = fmt.Errorf("gitleaks scan failed: %w, stderr: %s", err, stderr.String())
-		return result, result.Error
+		return result, fmt.Errorf("gitleaks scan failed: %w, stderr: %s", err, stderr.String())
 	}
 
 	// No secrets found
 	result.HasSecrets = false
-	result.Output = stderr.String()
 	return result, nil
 }
 
+// gitleaksFinding is a finding in gitleaks' JSON report
+type gitleaksFinding struct {
+	RuleID    string `json:"RuleID"`
+	File      string `json:"File"`
+	StartLine int    `json:"StartLine"`
+	Secret    string `json:"Secret"`
+}
+
+// parseGitleaksReport reads the findings of a gitleaks JSON report
+// gitleaks only sees the diff on stdin, so its line numbers point into the diff
+func parseGitleaksReport(report []byte) ([]rawFinding, error) {
+	var findings []gitleaksFinding
+	if err := json.Unmarshal(report, &findings); err != nil {
+		return nil, fmt.Errorf("failed to parse gitleaks report: %w", err)
+	}
+
+	raw := make([]rawFinding, 0, len(findings))
+	for _, f := range findings {
+		raw = append(raw, rawFinding{RuleID: f.RuleID, File: f.File, DiffLine: f.StartLine, Secret: f.Secret})
+	}
+	return raw, nil
+}
+
 // isGitleaksInstalled checks if gitleaks is available in PATH
 func isGitleaksInstalled() bool {
 	cmd := exec.Command("gitleaks", "version")
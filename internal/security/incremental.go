@@ -0,0 +1,84 @@
+package security
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ScanBase is a snapshot that passed scanning, so later snapshots of the same HEAD only need
+// what changed since to be scanned
+type ScanBase struct {
+	Snapshot string // Stash that passed
+	Options  string // Fingerprint of the scan options it passed with
+}
+
+// Fingerprint identifies what a scan checks for: the scanner chain, allowlist, gitleaks config
+// and diff size policy. A scan base only applies to scans with the same fingerprint.
+func (o ScanOptions) Fingerprint() string {
+	key := struct {
+		Scanners       any
+		Gitleaks       bool
+		GitleaksConfig string
+		Allowlist      *Allowlist
+		MaxDiffSize    int64
+		OnLargeDiff    string
+	}{
+		Scanners:    o.Scanners,
+		Allowlist:   o.Allowlist,
+		MaxDiffSize: o.MaxDiffSize,
+		OnLargeDiff: o.OnLargeDiff,
+	}
+	if len(o.Scanners) == 0 {
+		// The default chain depends on whether gitleaks is installed
+		key.Gitleaks = IsGitleaksAvailable()
+	}
+	if o.GitleaksConfig != "" {
+		data, err := os.ReadFile(o.GitleaksConfig)
+		if err != nil {
+			key.GitleaksConfig = fmt.Sprintf("%s: %v", o.GitleaksConfig, err)
+		} else {
+			key.GitleaksConfig = string(data)
+		}
+	}
+
+	data, _ := json.Marshal(key)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Passed reports whether the whole scanned snapshot was found clean, making it a valid scan base
+// Snapshots pushed despite findings, or scanned only partially, don't qualify
+func (s *SnapshotScan) Passed() bool {
+	return s.Result != nil && !s.Result.HasSecrets && s.Result.LargeDiff == ""
+}
+
+// snapshotDiff returns the diff of a snapshot to scan: its delta from the scan base when one applies,
+// otherwise its full diff along with why a given base was not used
+func snapshotDiff(src SnapshotSource, hash string, opts ScanOptions) (diff DiffSource, incremental bool, reason string) {
+	full := func() (io.ReadCloser, error) {
+		return src.OpenSnapshotDiff(hash)
+	}
+
+	base := opts.Base
+	if base == nil || base.Snapshot == "" {
+		return full, false, ""
+	}
+	if base.Options != opts.Fingerprint() {
+		return full, false, "scan options changed since the last clean scan"
+	}
+
+	// Opening the delta checks the base is still usable; the diff itself only runs when read
+	probe, err := src.OpenSnapshotDelta(base.Snapshot, hash)
+	if err != nil {
+		return full, false, err.Error()
+	}
+	_ = probe.Close()
+
+	return func() (io.ReadCloser, error) {
+		return src.OpenSnapshotDelta(base.Snapshot, hash)
+	}, true, ""
+}
@@ -0,0 +1,240 @@
+package security
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// nativeRule is a single pattern of the built-in scanner
+type nativeRule struct {
+	ID         string
+	Pattern    *regexp.Regexp
+	Group      int     // Capture group holding the secret, 0 for the whole match
+	MinEntropy float64 // Minimum Shannon entropy (bits per character) of the secret, 0 to disable
+}
+
+// nativeRules is the curated ruleset of the built-in scanner
+// Specific token formats come first so they win over the generic assignment rule
+var nativeRules = []nativeRule{
+	{
+		ID:      "private-key",
+		Pattern: regexp.MustCompile(`-----BEGIN[ A-Z0-9]*PRIVATE KEY( BLOCK)?-----`),
+	},
+	{
+		ID:      "aws-access-key-id",
+		Pattern: regexp.MustCompile(`\b((?:AKIA|ASIA|ABIA|ACCA)[0-9A-Z]{16})\b`),
+		Group:   1,
+	},
+	{
+		ID:      "aws-secret-access-key",
+		Pattern: regexp.MustCompile(`(?i)aws_?secret_?access_?key["']?\s*[:=]\s*["']?([A-Za-z0-9/+=]{40})(?:[^A-Za-z0-9/+=]|$)`),
+		Group:   1,
+	},
+	{
+		ID:      "gcp-api-key",
+		Pattern: regexp.MustCompile(`\b(AIza[0-9A-Za-z_\-]{35})(?:[^0-9A-Za-z_\-]|$)`),
+		Group:   1,
+	},
+	{
+		ID:      "gcp-service-account-key",
+		Pattern: regexp.MustCompile(`"private_key_id"\s*:\s*"([a-f0-9]{40})"`),
+		Group:   1,
+	},
+	{
+		ID:      "github-token",
+		Pattern: regexp.MustCompile(`\b(gh[pousr]_[0-9A-Za-z]{36})\b`),
+		Group:   1,
+	},
+	{
+		ID:      "github-fine-grained-pat",
+		Pattern: regexp.MustCompile(`\b(github_pat_[0-9A-Za-z_]{82})\b`),
+		Group:   1,
+	},
+	{
+		ID:      "slack-token",
+		Pattern: regexp.MustCompile(`\b(xox[baprs]-[0-9A-Za-z-]{10,})\b`),
+		Group:   1,
+	},
+	{
+		ID:      "slack-webhook-url",
+		Pattern: regexp.MustCompile(`https://hooks\.slack\.com/services/T[0-9A-Za-z]+/B[0-9A-Za-z]+/[0-9A-Za-z]+`),
+	},
+	{
+		ID:         "generic-high-entropy-assignment",
+		Pattern:    regexp.MustCompile(`(?i)[\w.-]*(?:secret|token|passw(?:or)?d|pwd|api[_-]?key|access[_-]?key|auth[_-]?key|credential|private[_-]?key)[\w.-]*["']?\s*(?::=|=>|[:=])\s*["'` + "`" + `]?([A-Za-z0-9+/=_\-.~]{16,})`),
+		Group:      1,
+		MinEntropy: 3.5,
+	},
+}
+
+// ScanDiffNative scans the lines added by a diff using the built-in ruleset
+func ScanDiffNative(diff string) *ScanResult {
+	result, _ := scanNative(strings.NewReader(diff))
+	return result
+}
+
+// scanNative scans the lines added by a streamed diff using the built-in ruleset
+// Only read errors of the stream can make it fail
+func scanNative(r io.Reader) (*ScanResult, error) {
+	result := &ScanResult{Scanner: ScannerNative}
+
+	parser := &diffParser{}
+	err := forEachDiffLine(r, func(lineNo int, text string) {
+		line, ok := parser.next(lineNo, text)
+		if !ok {
+			return
+		}
+
+		seen := make(map[string]bool)
+		for _, rule := range nativeRules {
+			for _, m := range rule.Pattern.FindAllStringSubmatch(line.Text, -1) {
+				secret := m[rule.Group]
+				if seen[secret] {
+					continue
+				}
+				if rule.MinEntropy > 0 && shannonEntropy(secret) < rule.MinEntropy {
+					continue
+				}
+				seen[secret] = true
+
+				result.Findings = append(result.Findings, Finding{
+					RuleID:      rule.ID,
+					File:        line.File,
+					Line:        line.Line,
+					Match:       redact(secret),
+					Fingerprint: fingerprint(line.File, rule.ID, secret),
+				})
+			}
+		}
+	})
+
+	result.HasSecrets = len(result.Findings) > 0
+	result.Output = formatFindings(result.Findings)
+	return result, err
+}
+
+// addedLine is a line added by a diff
+type addedLine struct {
+	File     string
+	Line     int // Line number in the new version of the file
+	Text     string
+	DiffLine int // Line number within the diff itself
+}
+
+// diffParser tracks the position in a unified or combined (merge/stash) diff fed line by line
+type diffParser struct {
+	file, prev  string
+	lineNo      int // Line number in the new version of the current file
+	prefixWidth int // Number of +/- columns: 1 for plain diffs, one per parent for combined diffs
+}
+
+// next consumes a diff line and returns it if it's an added line
+func (p *diffParser) next(diffLine int, text string) (addedLine, bool) {
+	isHeader := strings.HasPrefix(p.prev, "--- ")
+	p.prev = text
+	if p.prefixWidth == 0 {
+		p.prefixWidth = 1
+	}
+
+	switch {
+	case strings.HasPrefix(text, "diff "):
+		p.file, p.lineNo = "", 0
+	case isHeader && strings.HasPrefix(text, "+++ "):
+		p.file = strings.TrimPrefix(strings.TrimPrefix(text, "+++ "), "b/")
+		if p.file == "/dev/null" {
+			p.file = ""
+		}
+	case strings.HasPrefix(text, "--- "):
+		// Old file name, not needed
+	case strings.HasPrefix(text, "@@"):
+		p.prefixWidth, p.lineNo = parseHunkHeader(text)
+	case strings.HasPrefix(text, "\\"):
+		// "\ No newline at end of file"
+	case p.file == "" || p.lineNo == 0 || len(text) < p.prefixWidth:
+		// Outside a hunk
+	default:
+		prefix := text[:p.prefixWidth]
+		if strings.Contains(prefix, "-") {
+			return addedLine{}, false // Removed line
+		}
+		p.lineNo++
+		if strings.Contains(prefix, "+") {
+			return addedLine{File: p.file, Line: p.lineNo - 1, Text: text[p.prefixWidth:], DiffLine: diffLine}, true
+		}
+	}
+
+	return addedLine{}, false
+}
+
+// parseHunkHeader returns the +/- column width and first new line number of a hunk header
+// like "@@ -1,2 +3,4 @@" or "@@@ -1,2 -1,2 +3,4 @@@"
+func parseHunkHeader(header string) (int, int) {
+	markerLen := strings.IndexFunc(header, func(r rune) bool { return r != '@' })
+	if markerLen < 0 {
+		markerLen = len(header)
+	}
+	width := max(markerLen-1, 1)
+
+	for _, field := range strings.Fields(header) {
+		if !strings.HasPrefix(field, "+") {
+			continue
+		}
+		start, _, _ := strings.Cut(field[1:], ",")
+		n, err := strconv.Atoi(start)
+		if err != nil {
+			return width, 0
+		}
+		return width, max(n, 1)
+	}
+
+	return width, 0
+}
+
+// shannonEntropy returns the Shannon entropy of s in bits per character
+func shannonEntropy(s string) float64 {
+	if s == "" {
+		return 0
+	}
+
+	counts := make(map[rune]int)
+	for _, r := range s {
+		counts[r]++
+	}
+
+	var entropy float64
+	n := float64(len([]rune(s)))
+	for _, c := range counts {
+		p := float64(c) / n
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
+
+// fingerprint identifies a finding independently of its line, so it stays stable while the file is edited
+func fingerprint(file, ruleID, secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return fmt.Sprintf("%s:%s:%s", file, ruleID, hex.EncodeToString(sum[:6]))
+}
+
+// redact hides all but the first few characters of a secret
+func redact(secret string) string {
+	if len(secret) <= 8 {
+		return "REDACTED"
+	}
+	return secret[:4] + "...REDACTED"
+}
+
+// formatFindings renders findings as one line each for logs and terminal output
+func formatFindings(findings []Finding) string {
+	var b strings.Builder
+	for _, f := range findings {
+		fmt.Fprintf(&b, "%s:%d: %s (%s) [%s]\n", f.File, f.Line, f.RuleID, f.Match, f.Fingerprint)
+	}
+	return b.String()
+}
@@ -0,0 +1,179 @@
+package security
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/FmTod/ghost-backup/internal/config"
+)
+
+// DefaultScannerTimeout is the maximum time a scanner may run unless configured otherwise
+const DefaultScannerTimeout = config.DefaultScannerTimeout * time.Second
+
+// Scanner detects secrets in a diff
+// Scan streams the diff through Diff.Open rather than reading it whole, and returns a result
+// with HasSecrets set when secrets were found and an error only when the scan itself failed
+type Scanner interface {
+	Name() string
+	Scan(ctx context.Context, diff *Diff) (*ScanResult, error)
+}
+
+// Chain runs several scanners over the same diff, each with its own timeout
+type Chain struct {
+	steps    []chainStep
+	fallback bool // The chain is the built-in scanner standing in for a missing gitleaks
+}
+
+type chainStep struct {
+	scanner Scanner
+	timeout time.Duration
+}
+
+// nativeScanner runs the built-in ruleset
+type nativeScanner struct{}
+
+// Name returns the scanner name
+func (nativeScanner) Name() string {
+	return ScannerNative
+}
+
+// Scan scans the added lines of the diff
+func (nativeScanner) Scan(_ context.Context, diff *Diff) (*ScanResult, error) {
+	stream, err := diff.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+
+	result, err := scanNative(stream)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read diff: %w", err)
+	}
+	return result, nil
+}
+
+// NewScanner creates the scanner described by a repository's configuration
+func NewScanner(cfg config.ScannerConfig, opts ScanOptions) (Scanner, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	switch cfg.Type {
+	case config.ScannerGitleaks:
+		return &gitleaksScanner{config: opts.GitleaksConfig}, nil
+	case config.ScannerNative:
+		return nativeScanner{}, nil
+	default:
+		name := cfg.Name
+		if name == "" {
+			name = filepath.Base(cfg.Command[0])
+		}
+		exitCodes := cfg.FindingsExitCodes
+		if len(exitCodes) == 0 {
+			exitCodes = []int{1}
+		}
+		return &commandScanner{name: name, argv: cfg.Command, findingsExitCodes: exitCodes, format: cfg.Format}, nil
+	}
+}
+
+// NewChain creates the scanner chain of a repository
+// Without configured scanners it runs gitleaks, or the built-in scanner when gitleaks is not installed
+func NewChain(opts ScanOptions) (*Chain, error) {
+	if len(opts.Scanners) == 0 {
+		if !IsGitleaksAvailable() {
+			return &Chain{steps: []chainStep{{scanner: nativeScanner{}, timeout: DefaultScannerTimeout}}, fallback: true}, nil
+		}
+		return &Chain{steps: []chainStep{{scanner: &gitleaksScanner{config: opts.GitleaksConfig}, timeout: DefaultScannerTimeout}}}, nil
+	}
+
+	chain := &Chain{}
+	for i, cfg := range opts.Scanners {
+		scanner, err := NewScanner(cfg, opts)
+		if err != nil {
+			return nil, fmt.Errorf("invalid scanners[%d]: %w", i, err)
+		}
+
+		timeout := DefaultScannerTimeout
+		if cfg.Timeout > 0 {
+			timeout = time.Duration(cfg.Timeout) * time.Second
+		}
+		chain.steps = append(chain.steps, chainStep{scanner: scanner, timeout: timeout})
+	}
+	return chain, nil
+}
+
+// Scan scans a diff with the repository's scanner chain and filters the findings through its allowlist
+// The diff is streamed to each scanner with the max_diff_size policy of the options applied
+func Scan(source DiffSource, opts ScanOptions) (*ScanResult, error) {
+	chain, err := NewChain(opts)
+	if err != nil {
+		return &ScanResult{Error: err}, err
+	}
+	return chain.Scan(newDiff(source, opts), opts.Allowlist)
+}
+
+// Scan runs every scanner of the chain and merges their results
+// Any scanner failing or timing out fails the whole scan, so a broken scanner never lets secrets through.
+// A diff past its maximum size aborts the scan, skips it with a clean result or, when truncated,
+// only scans what fits, depending on its on_large_diff policy.
+func (c *Chain) Scan(diff *Diff, allowlist *Allowlist) (*ScanResult, error) {
+	combined := &ScanResult{Fallback: c.fallback}
+	var names, outputs []string
+
+	for _, step := range c.steps {
+		result, err := c.runStep(step, diff)
+		if diff.Exceeded() {
+			switch diff.policy {
+			case config.OnLargeDiffSkip:
+				return &ScanResult{Scanner: step.scanner.Name(), Fallback: c.fallback, LargeDiff: config.OnLargeDiffSkip}, nil
+			case config.OnLargeDiffTruncate:
+				combined.LargeDiff = config.OnLargeDiffTruncate
+			default:
+				err = fmt.Errorf("diff exceeds max_diff_size (%d MB), backup aborted (on_large_diff=%s)", diff.limit/(1024*1024), config.OnLargeDiffAbort)
+				combined.Error = err
+				return combined, err
+			}
+		}
+		if err != nil {
+			combined.Error = err
+			return combined, err
+		}
+		allowlist.Apply(result)
+
+		names = append(names, step.scanner.Name())
+		combined.Findings = append(combined.Findings, result.Findings...)
+		combined.Allowed = append(combined.Allowed, result.Allowed...)
+		if !result.HasSecrets {
+			continue
+		}
+
+		combined.HasSecrets = true
+		outputs = append(outputs, strings.TrimRight(result.Output, "\n")+"\n")
+		if len(result.Findings) == 0 {
+			combined.Notes = append(combined.Notes, fmt.Sprintf("%s: %s", step.scanner.Name(), strings.TrimSpace(result.Output)))
+		}
+	}
+
+	combined.Scanner = strings.Join(names, ", ")
+	combined.Output = strings.Join(outputs, "")
+	return combined, nil
+}
+
+// runStep runs a single scanner within its timeout
+func (c *Chain) runStep(step chainStep, diff *Diff) (*ScanResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), step.timeout)
+	defer cancel()
+
+	result, err := step.scanner.Scan(ctx, diff)
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return nil, fmt.Errorf("%s scan timed out after %v", step.scanner.Name(), step.timeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
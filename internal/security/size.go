@@ -0,0 +1,151 @@
+package security
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/FmTod/ghost-backup/internal/config"
+)
+
+// LargestFilesReported is how many of the largest files a size check reports
+const LargestFilesReported = 5
+
+// Rules of files excluded by the size limits, as recorded in their trailers
+const (
+	RuleMaxFileSize     = "max_file_size"
+	RuleMaxSnapshotSize = "max_snapshot_size"
+)
+
+// ErrSnapshotTooLarge is wrapped by the error of a snapshot past the size limits with on_oversize=abort
+var ErrSnapshotTooLarge = errors.New("snapshot exceeds the size limits")
+
+// SizeLimits caps what a snapshot may push
+type SizeLimits struct {
+	MaxFileSize     int64  // Largest file in bytes, 0 for no limit
+	MaxSnapshotSize int64  // Largest snapshot in bytes, 0 for no limit
+	OnOversize      string // config.OnOversize* value applied past either limit
+}
+
+// LoadSizeLimits returns the size limits of a repository's config
+func LoadSizeLimits(cfg *config.LocalConfig) SizeLimits {
+	return SizeLimits{
+		MaxFileSize:     int64(cfg.MaxFileSize) * 1024 * 1024,
+		MaxSnapshotSize: int64(cfg.MaxSnapshotSize) * 1024 * 1024,
+		OnOversize:      cfg.OnOversize,
+	}
+}
+
+// FileSize is the size of a file in a snapshot
+type FileSize struct {
+	Path string
+	Size int64 // Bytes of its blobs pushed with the snapshot
+}
+
+// SizeCheck is the outcome of checking a snapshot against the size limits
+type SizeCheck struct {
+	Hash     string         // Snapshot to push; differs from the checked one when files were excluded
+	Size     int64          // Size of the checked snapshot in bytes
+	Pushed   int64          // Size of the snapshot to push in bytes
+	Largest  []FileSize     // Largest files of the checked snapshot, biggest first
+	Excluded []ExcludedFile // Files dropped from the snapshot
+	Exceeded bool           // The checked snapshot went past a limit
+}
+
+// CheckSnapshotSize computes the size of the blobs a snapshot pushes and applies the size limits:
+// files past max_file_size are oversized, then the largest remaining files are too until the
+// snapshot fits in max_snapshot_size. With on_oversize=exclude, oversized files are dropped from
+// the snapshot and recorded as trailers; with abort, the error wraps ErrSnapshotTooLarge.
+// The check is returned along with that error so the largest files can be reported.
+// When every change is oversized, the error wraps git.ErrNoChangesLeft.
+func CheckSnapshotSize(src SnapshotSource, hash string, limits SizeLimits) (*SizeCheck, error) {
+	sizes, err := src.SnapshotFileSizes(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]FileSize, 0, len(sizes))
+	check := &SizeCheck{Hash: hash}
+	for path, size := range sizes {
+		files = append(files, FileSize{Path: path, Size: size})
+		check.Size += size
+	}
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].Size != files[j].Size {
+			return files[i].Size > files[j].Size
+		}
+		return files[i].Path < files[j].Path
+	})
+	check.Largest = files[:min(len(files), LargestFilesReported)]
+
+	excluded := make(map[string]string)
+	total := check.Size
+	if limits.MaxFileSize > 0 {
+		for _, f := range files {
+			if f.Size > limits.MaxFileSize {
+				excluded[f.Path] = RuleMaxFileSize
+				total -= f.Size
+			}
+		}
+	}
+	if limits.MaxSnapshotSize > 0 {
+		for _, f := range files {
+			if total <= limits.MaxSnapshotSize {
+				break
+			}
+			if _, ok := excluded[f.Path]; !ok {
+				excluded[f.Path] = RuleMaxSnapshotSize
+				total -= f.Size
+			}
+		}
+	}
+	if len(excluded) == 0 {
+		check.Pushed = check.Size
+		return check, nil
+	}
+	check.Exceeded = true
+
+	if limits.OnOversize == config.OnOversizeAbort {
+		if check.Size > limits.MaxSnapshotSize && limits.MaxSnapshotSize > 0 {
+			return check, fmt.Errorf("%w: snapshot is %s, over max_snapshot_size (%s), backup aborted (on_oversize=%s)",
+				ErrSnapshotTooLarge, FormatSize(check.Size), FormatSize(limits.MaxSnapshotSize), config.OnOversizeAbort)
+		}
+		return check, fmt.Errorf("%w: %d files over max_file_size (%s), backup aborted (on_oversize=%s)",
+			ErrSnapshotTooLarge, len(excluded), FormatSize(limits.MaxFileSize), config.OnOversizeAbort)
+	}
+
+	var paths, trailers []string
+	for _, f := range files {
+		rule, ok := excluded[f.Path]
+		if !ok {
+			continue
+		}
+		file := ExcludedFile{Path: f.Path, Rules: []string{rule}}
+		check.Excluded = append(check.Excluded, file)
+		paths = append(paths, file.Path)
+		trailers = append(trailers, file.Trailer())
+	}
+
+	filtered, err := src.ExcludeFromStash(hash, paths, trailers)
+	if err != nil {
+		return check, fmt.Errorf("failed to exclude oversized files: %w", err)
+	}
+	check.Hash, check.Pushed = filtered, total
+	return check, nil
+}
+
+// FormatSize renders a size in bytes for logs and terminal output
+func FormatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	value, suffix := float64(size)/unit, "KB"
+	for _, next := range []string{"MB", "GB", "TB"} {
+		if value < unit {
+			break
+		}
+		value, suffix = value/unit, next
+	}
+	return fmt.Sprintf("%.1f %s", value, suffix)
+}
@@ -0,0 +1,116 @@
+package security
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/FmTod/ghost-backup/internal/config"
+)
+
+func TestCheckSnapshotSize(t *testing.T) {
+	const mb = 1024 * 1024
+	sizes := map[string]int64{
+		"dump.sql":   300 * mb,
+		"assets.zip": 40 * mb,
+		"logo.png":   20 * mb,
+		"main.go":    1024,
+	}
+
+	tests := []struct {
+		name         string
+		limits       SizeLimits
+		wantExcluded []ExcludedFile
+	}{
+		{"no limits", SizeLimits{OnOversize: config.OnOversizeExclude}, nil},
+		{"within limits", SizeLimits{MaxFileSize: 500 * mb, MaxSnapshotSize: 500 * mb, OnOversize: config.OnOversizeExclude}, nil},
+		{
+			"file too large",
+			SizeLimits{MaxFileSize: 100 * mb, OnOversize: config.OnOversizeExclude},
+			[]ExcludedFile{{Path: "dump.sql", Rules: []string{RuleMaxFileSize}}},
+		},
+		{
+			"snapshot too large drops the largest files",
+			SizeLimits{MaxSnapshotSize: 30 * mb, OnOversize: config.OnOversizeExclude},
+			[]ExcludedFile{
+				{Path: "dump.sql", Rules: []string{RuleMaxSnapshotSize}},
+				{Path: "assets.zip", Rules: []string{RuleMaxSnapshotSize}},
+			},
+		},
+		{
+			"both limits",
+			SizeLimits{MaxFileSize: 100 * mb, MaxSnapshotSize: 50 * mb, OnOversize: config.OnOversizeExclude},
+			[]ExcludedFile{
+				{Path: "dump.sql", Rules: []string{RuleMaxFileSize}},
+				{Path: "assets.zip", Rules: []string{RuleMaxSnapshotSize}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := &fakeSnapshots{sizes: map[string]map[string]int64{"stash": sizes}}
+			check, err := CheckSnapshotSize(src, "stash", tt.limits)
+			if err != nil {
+				t.Fatalf("CheckSnapshotSize() error = %v", err)
+			}
+
+			if check.Size != 360*mb+1024 {
+				t.Errorf("Size = %d, want %d", check.Size, 360*mb+1024)
+			}
+			if len(check.Largest) != 4 || check.Largest[0].Path != "dump.sql" || check.Largest[3].Path != "main.go" {
+				t.Errorf("Largest = %+v, want files sorted by size", check.Largest)
+			}
+			if !reflect.DeepEqual(check.Excluded, tt.wantExcluded) {
+				t.Errorf("Excluded = %+v, want %+v", check.Excluded, tt.wantExcluded)
+			}
+			if check.Exceeded != (tt.wantExcluded != nil) {
+				t.Errorf("Exceeded = %v, want %v", check.Exceeded, tt.wantExcluded != nil)
+			}
+
+			wantHash := "stash"
+			if tt.wantExcluded != nil {
+				wantHash = "filtered"
+				if len(src.trailers) != len(tt.wantExcluded) || src.trailers[0] != tt.wantExcluded[0].Trailer() {
+					t.Errorf("Trailers = %v, want one per excluded file", src.trailers)
+				}
+			}
+			if check.Hash != wantHash {
+				t.Errorf("Hash = %s, want %s", check.Hash, wantHash)
+			}
+			if tt.limits.MaxSnapshotSize > 0 && check.Pushed > tt.limits.MaxSnapshotSize {
+				t.Errorf("Pushed = %d, want at most %d", check.Pushed, tt.limits.MaxSnapshotSize)
+			}
+		})
+	}
+}
+
+func TestCheckSnapshotSize_Abort(t *testing.T) {
+	src := &fakeSnapshots{sizes: map[string]map[string]int64{"stash": {"dump.sql": 3 * 1024 * 1024, "main.go": 10}}}
+
+	check, err := CheckSnapshotSize(src, "stash", SizeLimits{MaxFileSize: 1024 * 1024, OnOversize: config.OnOversizeAbort})
+	if !errors.Is(err, ErrSnapshotTooLarge) {
+		t.Fatalf("CheckSnapshotSize() error = %v, want ErrSnapshotTooLarge", err)
+	}
+	if check == nil || !check.Exceeded || check.Largest[0].Path != "dump.sql" {
+		t.Errorf("CheckSnapshotSize() = %+v, want the largest files reported", check)
+	}
+	if src.excluded != nil {
+		t.Errorf("Nothing should be excluded when aborting, got %v", src.excluded)
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	tests := map[int64]string{
+		0:                      "0 B",
+		1023:                   "1023 B",
+		1536:                   "1.5 KB",
+		100 * 1024 * 1024:      "100.0 MB",
+		2 * 1024 * 1024 * 1024: "2.0 GB",
+	}
+	for size, want := range tests {
+		if got := FormatSize(size); got != want {
+			t.Errorf("FormatSize(%d) = %q, want %q", size, got, want)
+		}
+	}
+}
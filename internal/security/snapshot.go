@@ -0,0 +1,128 @@
+package security
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// ExcludedTrailer is the commit trailer recording a file dropped from a snapshot, for secrets, an
+// exclude glob or the size limits
+const ExcludedTrailer = "Ghost-Backup-Excluded"
+
+// SnapshotSource is the repository a snapshot is read from and rewritten in
+type SnapshotSource interface {
+	OpenSnapshotDiff(hash string) (io.ReadCloser, error)
+	OpenSnapshotDelta(base, hash string) (io.ReadCloser, error)
+	SnapshotPaths(hash string) ([]string, error)
+	SnapshotFileSizes(hash string) (map[string]int64, error)
+	ExcludeFromStash(hash string, paths []string, trailers []string) (string, error)
+}
+
+// ExcludedFile is a file dropped from a snapshot and the rules it triggered
+type ExcludedFile struct {
+	Path  string
+	Rules []string
+}
+
+// SnapshotScan is the outcome of scanning a snapshot
+type SnapshotScan struct {
+	Hash     string         // Snapshot to push; differs from the scanned one when files were excluded
+	Result   *ScanResult    // Result of the last scan, over the snapshot that will be pushed
+	Excluded []ExcludedFile // Files dropped from the snapshot
+
+	Incremental    bool   // Only the changes since ScanOptions.Base were scanned
+	FullScanReason string // Why a given base was not used, "" when it was
+}
+
+// ScanSnapshot scans a snapshot for secrets
+// With a scan base in the options, only what changed since that snapshot is scanned, unless HEAD
+// moved, the base is gone or the options changed, in which case the whole snapshot is.
+// With exclude set, files with findings are dropped from the snapshot and the remainder is
+// scanned again, so the result always describes what would actually be pushed
+func ScanSnapshot(src SnapshotSource, hash string, opts ScanOptions, exclude bool) (*SnapshotScan, error) {
+	scan := &SnapshotScan{Hash: hash}
+
+	result, err := scanSnapshotDiff(src, hash, opts, scan)
+	if err != nil {
+		return nil, err
+	}
+	scan.Result = result
+
+	if !exclude || !result.HasSecrets {
+		return scan, nil
+	}
+
+	excluded, ok := excludedFiles(result.Findings)
+	if !ok {
+		// Some findings can't be attributed to a file, nothing can be safely excluded
+		return scan, nil
+	}
+
+	paths := make([]string, len(excluded))
+	trailers := make([]string, len(excluded))
+	for i, f := range excluded {
+		paths[i] = f.Path
+		trailers[i] = f.Trailer()
+	}
+
+	filtered, err := src.ExcludeFromStash(hash, paths, trailers)
+	if err != nil {
+		return nil, fmt.Errorf("failed to exclude files with secrets: %w", err)
+	}
+
+	result, err = scanSnapshotDiff(src, filtered, opts, scan)
+	if err != nil {
+		return nil, err
+	}
+
+	scan.Hash = filtered
+	scan.Result = result
+	scan.Excluded = excluded
+	return scan, nil
+}
+
+// Trailer renders the exclusion as a commit trailer
+func (f ExcludedFile) Trailer() string {
+	return fmt.Sprintf("%s: %s (%s)", ExcludedTrailer, f.Path, strings.Join(f.Rules, ", "))
+}
+
+// scanSnapshotDiff scans the diff of a single snapshot, recording in scan whether it was incremental
+func scanSnapshotDiff(src SnapshotSource, hash string, opts ScanOptions, scan *SnapshotScan) (*ScanResult, error) {
+	diff, incremental, reason := snapshotDiff(src, hash, opts)
+	scan.Incremental, scan.FullScanReason = incremental, reason
+
+	result, err := Scan(diff, opts)
+	if err != nil {
+		return nil, fmt.Errorf("secret scan failed: %w", err)
+	}
+	return result, nil
+}
+
+// excludedFiles groups findings by file, sorted by path with unique rule IDs
+// It reports false if any finding lacks a file
+func excludedFiles(findings []Finding) ([]ExcludedFile, bool) {
+	if len(findings) == 0 {
+		return nil, false
+	}
+
+	rules := make(map[string][]string)
+	for _, f := range findings {
+		if f.File == "" {
+			return nil, false
+		}
+		if !slices.Contains(rules[f.File], f.RuleID) {
+			rules[f.File] = append(rules[f.File], f.RuleID)
+		}
+	}
+
+	files := make([]ExcludedFile, 0, len(rules))
+	for path, ids := range rules {
+		sort.Strings(ids)
+		files = append(files, ExcludedFile{Path: path, Rules: ids})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files, true
+}
@@ -7,6 +7,7 @@ import (
 	"path/filepath"
 
 	"github.com/FmTod/ghost-backup/internal/config"
+	"github.com/FmTod/ghost-backup/internal/control"
 	"github.com/FmTod/ghost-backup/internal/git"
 	"github.com/FmTod/ghost-backup/internal/worker"
 	"github.com/kardianos/service"
@@ -16,6 +17,7 @@ import (
 type Program struct {
 	logger  service.Logger
 	manager *worker.Manager
+	control *control.Server
 	logFile *os.File
 }
 
@@ -31,8 +33,18 @@ func (p *Program) Start(service.Service) error {
 
 	// Setup Git credentials if token is configured
 	if globalConfig.GitToken != "" {
-		git.SetupGitCredentials(globalConfig.GitUser, globalConfig.GitToken)
-		_ = p.logger.Info("Git credentials configured from global config")
+		if err := git.SetupGitCredentials(globalConfig.GitUser, globalConfig.GitToken); err != nil {
+			_ = p.logger.Warningf("Failed to configure Git credentials: %v", err)
+		} else {
+			_ = p.logger.Info("Git credentials configured from global config")
+		}
+	}
+
+	// Object storage used by s3:// backup remotes, and the keys snapshots are encrypted with
+	git.SetupS3(globalConfig.S3Options())
+	git.SetupEncryption(globalConfig.EncryptionOptions())
+	if globalConfig.EncryptionOptions().Enabled() {
+		_ = p.logger.Info("Snapshots are encrypted before being stored")
 	}
 
 	// Setup file logging
@@ -70,6 +82,18 @@ func (p *Program) Start(service.Service) error {
 		return fmt.Errorf("failed to start workers: %w", err)
 	}
 
+	// Expose the control socket so CLI commands can talk to the running workers
+	socketPath, err := control.GetSocketPath()
+	if err == nil {
+		p.control = control.NewServer(p.manager, fileLogger)
+		err = p.control.Start(socketPath)
+	}
+	if err != nil {
+		// Non-fatal, the CLI falls back to restarting the service
+		p.control = nil
+		fileLogger.Printf("WARNING: Control socket unavailable: %v\n", err)
+	}
+
 	_ = p.logger.Infof("Service started with %d workers", p.manager.GetWorkerCount())
 
 	// The kardianos/service library keeps the process alive
@@ -81,6 +105,10 @@ func (p *Program) Start(service.Service) error {
 func (p *Program) Stop(service.Service) error {
 	_ = p.logger.Info("Stopping ghost-backup service...")
 
+	if p.control != nil {
+		p.control.Stop()
+	}
+
 	if p.manager != nil {
 		p.manager.StopWorkers()
 	}
@@ -95,17 +123,11 @@ func (p *Program) Stop(service.Service) error {
 
 // getLogFilePath returns the path to the log file
 func getLogFilePath() (string, error) {
-	// If running as a systemd service with StateDirectory, use that
-	if stateDir := os.Getenv("STATE_DIRECTORY"); stateDir != "" {
-		return filepath.Join(stateDir, "ghost-backup.log"), nil
-	}
-
-	// Otherwise use XDG state directory (~/.local/state/ghost-backup)
-	homeDir, err := os.UserHomeDir()
+	// $STATE_DIRECTORY under systemd, otherwise the XDG state directory (~/.local/state/ghost-backup)
+	stateDir, err := config.GetStateDir()
 	if err != nil {
-		return "", fmt.Errorf("failed to get home directory: %w", err)
+		return "", err
 	}
-	stateDir := filepath.Join(homeDir, ".local", "state", "ghost-backup")
 	return filepath.Join(stateDir, "ghost-backup.log"), nil
 }
 
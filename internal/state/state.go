@@ -0,0 +1,205 @@
+package state
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/FmTod/ghost-backup/internal/config"
+)
+
+// Backup results recorded in RepoState.LastResult
+const (
+	ResultSuccess   = "success"
+	ResultNoChanges = "no_changes"
+	ResultFailed    = "failed"
+)
+
+// mu serializes read-modify-write cycles on state files within this process
+var mu sync.Mutex
+
+// RepoState is the persisted backup state of a single repository
+type RepoState struct {
+	RepoPath            string    `json:"repo_path"`
+	LastRun             time.Time `json:"last_run"`             // Start of the most recent backup attempt
+	LastResult          string    `json:"last_result"`          // One of the Result* constants
+	LastSuccess         time.Time `json:"last_success"`         // Most recent successful push
+	LastHash            string    `json:"last_hash"`            // Snapshot hash of the most recent successful push
+	LastRef             string    `json:"last_ref"`             // Backup ref of the most recent successful push
+	LastError           string    `json:"last_error"`           // Error of the most recent failed attempt
+	ConsecutiveFailures int       `json:"consecutive_failures"` // Failed attempts since the last success or clean run
+	NextRun             time.Time `json:"next_run"`             // Next scheduled interval backup
+	RetryAttempts       int       `json:"retry_attempts"`       // Failed pushes of queued snapshots since the last successful one
+	NextRetry           time.Time `json:"next_retry"`           // Next retry of queued snapshots, zero when none is scheduled
+	ScannedSnapshot     string    `json:"scanned_snapshot"`     // Most recent snapshot that passed the secret scan
+	ScannedOptions      string    `json:"scanned_options"`      // Fingerprint of the scan options ScannedSnapshot passed with
+
+	Remotes map[string]*RemoteState `json:"remotes,omitempty"` // Push outcome per backup remote
+}
+
+// RemoteState is the persisted push state of one backup remote
+type RemoteState struct {
+	LastPush            time.Time `json:"last_push"`            // Most recent push attempt
+	LastSuccess         time.Time `json:"last_success"`         // Most recent push that went through
+	LastHash            string    `json:"last_hash"`            // Newest snapshot pushed by it
+	LastError           string    `json:"last_error"`           // Error of the most recent failed push
+	ConsecutiveFailures int       `json:"consecutive_failures"` // Failed pushes since the last one that went through
+}
+
+// GetStateDir returns the directory holding per-repository state files
+func GetStateDir() (string, error) {
+	stateDir, err := config.GetStateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(stateDir, "repos"), nil
+}
+
+// GetStatePath returns the state file for a repository
+// Files are named after a hash of the absolute repository path
+func GetStatePath(repoPath string) (string, error) {
+	dir, err := GetStateDir()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(repoPath))
+	return filepath.Join(dir, hex.EncodeToString(sum[:8])+".json"), nil
+}
+
+// Load reads the state of a repository, returning an empty state if none was recorded yet
+func Load(repoPath string) (*RepoState, error) {
+	statePath, err := GetStatePath(repoPath)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &RepoState{RepoPath: repoPath}
+
+	data, err := os.ReadFile(statePath)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state: %w", err)
+	}
+
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("failed to parse state: %w", err)
+	}
+
+	return s, nil
+}
+
+// Save writes the state to disk atomically
+func (s *RepoState) Save() error {
+	statePath, err := GetStatePath(s.RepoPath)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(statePath), 0755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal state: %w", err)
+	}
+
+	// The CLI and the service may save the same state at once, so each write gets its own temporary file
+	tmp, err := os.CreateTemp(filepath.Dir(statePath), filepath.Base(statePath)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write state: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), statePath)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write state: %w", err)
+	}
+
+	return nil
+}
+
+// Update loads the state of a repository, applies fn and saves the result
+func Update(repoPath string, fn func(*RepoState)) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	s, err := Load(repoPath)
+	if err != nil {
+		// A corrupt state file shouldn't block recording new state
+		s = &RepoState{RepoPath: repoPath}
+	}
+
+	fn(s)
+	return s.Save()
+}
+
+// RecordSuccess records a successful push
+func (s *RepoState) RecordSuccess(at time.Time, hash, ref string) {
+	s.LastRun = at
+	s.LastResult = ResultSuccess
+	s.LastSuccess = at
+	s.LastHash = hash
+	s.LastRef = ref
+	s.LastError = ""
+	s.ConsecutiveFailures = 0
+}
+
+// RecordNoChanges records a run that found nothing to back up
+// A clean working tree means nothing is at risk, so the failure streak is reset
+func (s *RepoState) RecordNoChanges(at time.Time) {
+	s.LastRun = at
+	s.LastResult = ResultNoChanges
+	s.LastError = ""
+	s.ConsecutiveFailures = 0
+}
+
+// RecordFailure records a failed attempt
+func (s *RepoState) RecordFailure(at time.Time, err error) {
+	s.LastRun = at
+	s.LastResult = ResultFailed
+	s.LastError = err.Error()
+	s.ConsecutiveFailures++
+}
+
+// RecordPush records a push to a backup remote; hash is the newest snapshot pushed, if any, and
+// err why the remaining queued snapshots weren't
+func (s *RepoState) RecordPush(remote string, at time.Time, hash string, err error) {
+	if s.Remotes == nil {
+		s.Remotes = make(map[string]*RemoteState)
+	}
+	r := s.Remotes[remote]
+	if r == nil {
+		r = &RemoteState{}
+		s.Remotes[remote] = r
+	}
+
+	r.LastPush = at
+	if hash != "" {
+		r.LastSuccess = at
+		r.LastHash = hash
+	}
+	if err != nil {
+		r.LastError = err.Error()
+		r.ConsecutiveFailures++
+	} else {
+		r.LastError = ""
+		r.ConsecutiveFailures = 0
+	}
+}
@@ -0,0 +1,187 @@
+package state
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLoad_NoState(t *testing.T) {
+	t.Setenv("STATE_DIRECTORY", t.TempDir())
+
+	s, err := Load("/repo/a")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if s.RepoPath != "/repo/a" {
+		t.Errorf("RepoPath = %s, want /repo/a", s.RepoPath)
+	}
+	if !s.LastRun.IsZero() || s.ConsecutiveFailures != 0 {
+		t.Errorf("Load() should return an empty state, got %+v", s)
+	}
+}
+
+func TestGetStatePath_PerRepository(t *testing.T) {
+	stateDir := t.TempDir()
+	t.Setenv("STATE_DIRECTORY", stateDir)
+
+	a, err := GetStatePath("/repo/a")
+	if err != nil {
+		t.Fatalf("GetStatePath() error = %v", err)
+	}
+	b, _ := GetStatePath("/repo/b")
+
+	if a == b {
+		t.Errorf("GetStatePath() should differ per repository, both got %s", a)
+	}
+	if filepath.Dir(a) != filepath.Join(stateDir, "repos") {
+		t.Errorf("GetStatePath() = %s, want a file in %s", a, filepath.Join(stateDir, "repos"))
+	}
+}
+
+func TestUpdate_Roundtrip(t *testing.T) {
+	t.Setenv("STATE_DIRECTORY", t.TempDir())
+
+	at := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	err := Update("/repo/a", func(s *RepoState) {
+		s.RecordSuccess(at, "abc123", "refs/backups/user/main")
+		s.NextRun = at.Add(time.Hour)
+	})
+	if err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+
+	s, err := Load("/repo/a")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if !s.LastSuccess.Equal(at) || s.LastHash != "abc123" || s.LastRef != "refs/backups/user/main" {
+		t.Errorf("Load() = %+v, want recorded success", s)
+	}
+	if !s.NextRun.Equal(at.Add(time.Hour)) {
+		t.Errorf("NextRun = %v, want %v", s.NextRun, at.Add(time.Hour))
+	}
+}
+
+// TestRepoState_Save_Concurrent saves the same state from several writers without the
+// in-process lock, like the CLI and the service do
+func TestRepoState_Save_Concurrent(t *testing.T) {
+	stateDir := t.TempDir()
+	t.Setenv("STATE_DIRECTORY", stateDir)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s := &RepoState{RepoPath: "/repo/a", LastHash: strings.Repeat(string(rune('a'+i)), 40*(i+1))}
+			errs <- s.Save()
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("Save() error = %v", err)
+		}
+	}
+
+	if _, err := Load("/repo/a"); err != nil {
+		t.Errorf("Load() after concurrent saves error = %v", err)
+	}
+	entries, err := os.ReadDir(filepath.Join(stateDir, "repos"))
+	if err != nil {
+		t.Fatalf("ReadDir() error = %v", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("State directory holds %d files, want only the state file", len(entries))
+	}
+}
+
+func TestUpdate_CorruptState(t *testing.T) {
+	t.Setenv("STATE_DIRECTORY", t.TempDir())
+
+	statePath, _ := GetStatePath("/repo/a")
+	if err := os.MkdirAll(filepath.Dir(statePath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(statePath, []byte("{not json"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Load("/repo/a"); err == nil {
+		t.Error("Load() should fail on a corrupt state file")
+	}
+
+	if err := Update("/repo/a", func(s *RepoState) { s.RecordNoChanges(time.Now()) }); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	s, err := Load("/repo/a")
+	if err != nil {
+		t.Fatalf("Load() after Update() error = %v", err)
+	}
+	if s.LastResult != ResultNoChanges {
+		t.Errorf("LastResult = %s, want %s", s.LastResult, ResultNoChanges)
+	}
+}
+
+func TestRepoState_Record(t *testing.T) {
+	start := time.Date(2024, 1, 2, 15, 0, 0, 0, time.UTC)
+	s := &RepoState{RepoPath: "/repo/a"}
+
+	s.RecordSuccess(start, "abc123", "refs/backups/user/main")
+	s.RecordFailure(start.Add(time.Minute), errors.New("push failed"))
+	s.RecordFailure(start.Add(2*time.Minute), errors.New("push failed again"))
+
+	if s.ConsecutiveFailures != 2 {
+		t.Errorf("ConsecutiveFailures = %d, want 2", s.ConsecutiveFailures)
+	}
+	if s.LastError != "push failed again" {
+		t.Errorf("LastError = %s, want last failure", s.LastError)
+	}
+	if !s.LastSuccess.Equal(start) || s.LastHash != "abc123" {
+		t.Errorf("failures should keep the last success, got %v %s", s.LastSuccess, s.LastHash)
+	}
+
+	s.RecordNoChanges(start.Add(3 * time.Minute))
+	if s.ConsecutiveFailures != 0 || s.LastError != "" {
+		t.Errorf("RecordNoChanges() should reset the failure streak, got %d %q", s.ConsecutiveFailures, s.LastError)
+	}
+	if !s.LastSuccess.Equal(start) {
+		t.Errorf("RecordNoChanges() should not change LastSuccess, got %v", s.LastSuccess)
+	}
+
+	s.RecordFailure(start.Add(4*time.Minute), errors.New("boom"))
+	s.RecordSuccess(start.Add(5*time.Minute), "def456", "refs/backups/user/main")
+	if s.ConsecutiveFailures != 0 || s.LastResult != ResultSuccess || s.LastHash != "def456" {
+		t.Errorf("RecordSuccess() = %+v, want reset streak and new hash", s)
+	}
+}
+
+func TestRepoState_RecordPush(t *testing.T) {
+	start := time.Date(2024, 1, 2, 15, 0, 0, 0, time.UTC)
+	s := &RepoState{RepoPath: "/repo/a"}
+
+	s.RecordPush("origin", start, "abc123", nil)
+	s.RecordPush("backup", start, "", errors.New("unreachable"))
+	s.RecordPush("backup", start.Add(time.Minute), "", errors.New("still unreachable"))
+
+	origin, backup := s.Remotes["origin"], s.Remotes["backup"]
+	if origin == nil || !origin.LastSuccess.Equal(start) || origin.LastHash != "abc123" || origin.ConsecutiveFailures != 0 {
+		t.Errorf("origin = %+v, want a success", origin)
+	}
+	if backup == nil || backup.ConsecutiveFailures != 2 || backup.LastError != "still unreachable" || !backup.LastSuccess.IsZero() {
+		t.Errorf("backup = %+v, want two failures", backup)
+	}
+
+	// Snapshots pushed before a later one failed still count as a success
+	s.RecordPush("backup", start.Add(2*time.Minute), "def456", errors.New("rejected"))
+	if backup.LastHash != "def456" || !backup.LastSuccess.Equal(start.Add(2*time.Minute)) || backup.ConsecutiveFailures != 3 {
+		t.Errorf("backup = %+v, want the pushed snapshot and the failure recorded", backup)
+	}
+}
@@ -0,0 +1,211 @@
+package worker
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"github.com/FmTod/ghost-backup/internal/config"
+	"github.com/FmTod/ghost-backup/internal/git"
+	"github.com/FmTod/ghost-backup/internal/state"
+)
+
+// Backoff bounds for retrying snapshots whose push failed
+var (
+	retryBaseDelay = 30 * time.Second
+	retryMaxDelay  = 30 * time.Minute
+)
+
+// retryDelay returns the delay before retry attempt n (starting at 1): exponential growth capped
+// at retryMaxDelay, with jitter so workers that went offline together don't retry in lockstep
+func retryDelay(attempt int) time.Duration {
+	d := retryMaxDelay
+	if attempt < 1 {
+		attempt = 1
+	}
+	if attempt <= 16 {
+		if exp := retryBaseDelay << (attempt - 1); exp < retryMaxDelay {
+			d = exp
+		}
+	}
+
+	// Half of the delay is fixed, the other half is random
+	return d/2 + rand.N(d/2+1)
+}
+
+// pushQueued pushes all queued snapshots to their remotes and records the outcome of each remote.
+// Snapshots that fail to push stay queued and a retry is scheduled.
+func (w *Worker) pushQueued(repo *git.GitRepo, remotes []string) ([]git.RemotePush, error) {
+	startedAt := time.Now()
+	pushes, err := repo.PushPendingBackups(remotes)
+
+	pushed := 0
+	for _, push := range pushes {
+		pushed += len(push.Pushed)
+		if push.Err != nil {
+			w.logger.Printf("[%s] Push to %s failed: %v\n", w.repoPath, push.Remote, push.Err)
+		}
+		if push.CleanupErr != nil {
+			w.logger.Printf("[%s] Pushed to %s but failed to dequeue: %v\n", w.repoPath, push.Remote, push.CleanupErr)
+		}
+	}
+	if pushed > 1 {
+		w.logger.Printf("[%s] Pushed %d queued snapshots\n", w.repoPath, pushed)
+	}
+	w.recordPushes(startedAt, pushes)
+
+	if err != nil {
+		w.scheduleRetry()
+		return pushes, err
+	}
+
+	w.clearRetry()
+	return pushes, nil
+}
+
+// recordPushes saves the outcome of each remote in the repository state
+func (w *Worker) recordPushes(at time.Time, pushes []git.RemotePush) {
+	if err := state.Update(w.repoPath, func(s *state.RepoState) {
+		for _, push := range pushes {
+			hash := ""
+			if len(push.Pushed) > 0 {
+				hash = push.Pushed[len(push.Pushed)-1].Hash
+			}
+			s.RecordPush(push.Remote, at, hash, push.Err)
+		}
+	}); err != nil {
+		w.logger.Printf("[%s] Failed to save backup state: %v\n", w.repoPath, err)
+	}
+}
+
+// lastPushed returns the most recently queued snapshot that was pushed to any remote, or nil
+func lastPushed(pushes []git.RemotePush) *git.PendingBackup {
+	var last *git.PendingBackup
+	for _, push := range pushes {
+		for i := range push.Pushed {
+			if last == nil || !push.Pushed[i].QueuedAt.Before(last.QueuedAt) {
+				last = &push.Pushed[i]
+			}
+		}
+	}
+	return last
+}
+
+// flushQueue pushes snapshots left in the queue by earlier failed pushes
+// It succeeds when at least one snapshot reached a remote; the others stay queued for the next retry
+func (w *Worker) flushQueue(repo *git.GitRepo) (*backupResult, error) {
+	pending, err := repo.ListPendingBackups()
+	if err != nil {
+		return nil, err
+	}
+	if len(pending) == 0 {
+		w.clearRetry()
+		return &backupResult{NoChanges: true}, nil
+	}
+
+	remotes, err := w.backupRemotes(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	pushes, err := w.pushQueued(repo, remotes)
+	last := lastPushed(pushes)
+	if last == nil {
+		return nil, fmt.Errorf("failed to push queued snapshots (%d still queued): %w", len(pending), err)
+	}
+
+	w.logger.Printf("[%s] Queued snapshots pushed successfully: %s\n", w.repoPath, last.BackupRef())
+	return &backupResult{Hash: last.Hash, Ref: last.BackupRef()}, nil
+}
+
+// backupRemotes returns the remotes the repository is backed up to, primary first
+func (w *Worker) backupRemotes(repo *git.GitRepo) ([]string, error) {
+	// Without the global config, its backup remotes would silently fall back to origin
+	globalConfig, err := config.LoadGlobalConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load global config: %w", err)
+	}
+	localConfig, err := config.LoadLocalConfig(w.repoPath)
+	if err != nil {
+		localConfig = nil
+	}
+	return repo.ResolveBackupRemotes(config.BackupRemotes(globalConfig, localConfig))
+}
+
+// retryQueued retries pushing queued snapshots and records the outcome
+func (w *Worker) retryQueued() {
+	startedAt := time.Now()
+	w.logger.Printf("[%s] Retrying queued snapshots...\n", w.repoPath)
+
+	result, err := w.flushQueue(git.NewGitRepo(w.repoPath))
+	w.recordResult(startedAt, result, err)
+}
+
+// scheduleRetry arms the retry timer with the next backoff delay and persists it
+func (w *Worker) scheduleRetry() {
+	w.mu.Lock()
+	w.retryAttempts++
+	attempts := w.retryAttempts
+	delay := retryDelay(attempts)
+	if w.retryTimer != nil {
+		w.retryTimer.Stop()
+	}
+	w.retryTimer = time.NewTimer(delay)
+	w.mu.Unlock()
+
+	nextRetry := time.Now().Add(delay)
+	w.logger.Printf("[%s] Snapshot queued, retrying push in %s (attempt %d)\n", w.repoPath, delay.Round(time.Second), attempts)
+
+	if err := state.Update(w.repoPath, func(s *state.RepoState) {
+		s.RetryAttempts = attempts
+		s.NextRetry = nextRetry
+	}); err != nil {
+		w.logger.Printf("[%s] Failed to save backup state: %v\n", w.repoPath, err)
+	}
+}
+
+// clearRetry resets the backoff once the queue is empty
+func (w *Worker) clearRetry() {
+	w.mu.Lock()
+	wasRetrying := w.retryAttempts > 0 || w.retryTimer != nil
+	w.retryAttempts = 0
+	if w.retryTimer != nil {
+		w.retryTimer.Stop()
+		w.retryTimer = nil
+	}
+	w.mu.Unlock()
+
+	if !wasRetrying {
+		return
+	}
+
+	if err := state.Update(w.repoPath, func(s *state.RepoState) {
+		s.RetryAttempts = 0
+		s.NextRetry = time.Time{}
+	}); err != nil {
+		w.logger.Printf("[%s] Failed to save backup state: %v\n", w.repoPath, err)
+	}
+}
+
+// restoreRetry re-arms the retry timer for snapshots queued before the worker started,
+// continuing the backoff recorded in the persisted state
+func (w *Worker) restoreRetry() {
+	pending, err := git.NewGitRepo(w.repoPath).ListPendingBackups()
+	if err != nil || len(pending) == 0 {
+		return
+	}
+
+	var attempts int
+	delay := time.Duration(0)
+	if s, err := state.Load(w.repoPath); err == nil {
+		attempts = s.RetryAttempts
+		delay = max(time.Until(s.NextRetry), 0)
+	}
+
+	w.mu.Lock()
+	w.retryAttempts = attempts
+	w.retryTimer = time.NewTimer(delay)
+	w.mu.Unlock()
+
+	w.logger.Printf("[%s] Found %d queued snapshots, retrying push in %s\n", w.repoPath, len(pending), delay.Round(time.Second))
+}
@@ -0,0 +1,209 @@
+package worker
+
+import (
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/FmTod/ghost-backup/internal/git"
+	"github.com/fsnotify/fsnotify"
+)
+
+// fsWatcher watches a repository's working tree and signals once writes have settled
+type fsWatcher struct {
+	repoPath  string
+	repo      *git.GitRepo
+	fsw       *fsnotify.Watcher
+	debounce  time.Duration
+	logger    *log.Logger
+	triggerCh chan struct{}
+	stopCh    chan struct{}
+	stoppedCh chan struct{}
+	changed   map[string]struct{}
+	mu        sync.Mutex
+}
+
+// newFSWatcher creates a watcher for every directory in the working tree,
+// skipping .git/ and directories excluded by .gitignore
+func newFSWatcher(repoPath string, debounce time.Duration, logger *log.Logger) (*fsWatcher, error) {
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	fw := &fsWatcher{
+		repoPath:  repoPath,
+		repo:      git.NewGitRepo(repoPath),
+		fsw:       fsw,
+		debounce:  debounce,
+		logger:    logger,
+		triggerCh: make(chan struct{}, 1),
+		stopCh:    make(chan struct{}),
+		stoppedCh: make(chan struct{}),
+		changed:   make(map[string]struct{}),
+	}
+
+	ignored, err := fw.repo.ListIgnoredDirectories()
+	if err != nil {
+		_ = fsw.Close()
+		return nil, err
+	}
+	fw.addTree(repoPath, ignored)
+
+	go fw.run()
+	return fw, nil
+}
+
+// Triggers returns a channel that receives a value after the quiet period following the last write
+func (fw *fsWatcher) Triggers() <-chan struct{} {
+	return fw.triggerCh
+}
+
+// Close stops watching and releases the underlying watches
+func (fw *fsWatcher) Close() {
+	select {
+	case <-fw.stopCh:
+		return
+	default:
+		close(fw.stopCh)
+	}
+	_ = fw.fsw.Close()
+	<-fw.stoppedCh
+}
+
+// addTree adds watches for root and all directories below it
+func (fw *fsWatcher) addTree(root string, ignored []string) {
+	ignoredSet := make(map[string]struct{}, len(ignored))
+	for _, dir := range ignored {
+		ignoredSet[dir] = struct{}{}
+	}
+
+	_ = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return nil
+		}
+		rel := fw.relPath(path)
+		if d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if _, skip := ignoredSet[rel]; skip {
+			return filepath.SkipDir
+		}
+		if err := fw.fsw.Add(path); err != nil {
+			fw.logger.Printf("[%s] Failed to watch %s: %v\n", fw.repoPath, rel, err)
+			return filepath.SkipDir
+		}
+		return nil
+	})
+}
+
+// addNewTree adds watches for a directory created in the working tree, unless it is ignored
+// A whole tree can appear at once (mv, cp -r, checkout, unpacking an archive), so the ignored
+// directories inside it are skipped too
+func (fw *fsWatcher) addNewTree(path, rel string) {
+	if kept, err := fw.repo.FilterIgnoredPaths([]string{rel}); err != nil || len(kept) == 0 {
+		return
+	}
+	ignored, err := fw.repo.ListIgnoredDirectories(rel)
+	if err != nil {
+		// Watching an ignored node_modules/ could exhaust the watch limit; the interval still runs
+		fw.logger.Printf("[%s] Failed to watch %s: %v\n", fw.repoPath, rel, err)
+		return
+	}
+	fw.addTree(path, ignored)
+}
+
+// relPath returns a slash-separated path relative to the repository root
+func (fw *fsWatcher) relPath(path string) string {
+	rel, err := filepath.Rel(fw.repoPath, path)
+	if err != nil {
+		return path
+	}
+	return filepath.ToSlash(rel)
+}
+
+// isGitPath reports whether a relative path is inside the .git directory
+func isGitPath(rel string) bool {
+	return rel == ".git" || strings.HasPrefix(rel, ".git/")
+}
+
+// run processes filesystem events and fires a trigger once no write happened for the debounce period
+func (fw *fsWatcher) run() {
+	defer close(fw.stoppedCh)
+
+	timer := time.NewTimer(fw.debounce)
+	timer.Stop()
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-fw.stopCh:
+			return
+
+		case event, ok := <-fw.fsw.Events:
+			if !ok {
+				return
+			}
+			if event.Op == fsnotify.Chmod {
+				continue
+			}
+
+			rel := fw.relPath(event.Name)
+			if isGitPath(rel) {
+				continue
+			}
+
+			// Newly created directories need their own watches
+			if event.Has(fsnotify.Create) {
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					fw.addNewTree(event.Name, rel)
+				}
+			}
+
+			fw.mu.Lock()
+			fw.changed[rel] = struct{}{}
+			fw.mu.Unlock()
+			timer.Reset(fw.debounce)
+
+		case err, ok := <-fw.fsw.Errors:
+			if !ok {
+				return
+			}
+			fw.logger.Printf("[%s] Watcher error: %v\n", fw.repoPath, err)
+
+		case <-timer.C:
+			fw.fire()
+		}
+	}
+}
+
+// fire signals a trigger if any of the changed paths since the last trigger are not ignored
+func (fw *fsWatcher) fire() {
+	fw.mu.Lock()
+	paths := make([]string, 0, len(fw.changed))
+	for path := range fw.changed {
+		paths = append(paths, path)
+	}
+	fw.changed = make(map[string]struct{})
+	fw.mu.Unlock()
+
+	kept, err := fw.repo.FilterIgnoredPaths(paths)
+	if err != nil {
+		// Fall back to triggering; the backup itself only picks up real changes
+		fw.logger.Printf("[%s] Failed to filter ignored paths: %v\n", fw.repoPath, err)
+		kept = paths
+	}
+	if len(kept) == 0 {
+		return
+	}
+
+	select {
+	case fw.triggerCh <- struct{}{}:
+	default:
+		// A trigger is already pending
+	}
+}
@@ -1,26 +1,40 @@
 package worker
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/FmTod/ghost-backup/internal/config"
 	"github.com/FmTod/ghost-backup/internal/git"
 	"github.com/FmTod/ghost-backup/internal/security"
+	"github.com/FmTod/ghost-backup/internal/state"
 )
 
 // Worker manages backup operations for a single repository
 type Worker struct {
-	repoPath    string
-	stopCh      chan struct{}
-	stoppedCh   chan struct{}
-	logger      *log.Logger
-	lastModTime time.Time
-	ticker      *time.Ticker
-	mu          sync.RWMutex
+	repoPath      string
+	stopCh        chan struct{}
+	stoppedCh     chan struct{}
+	logger        *log.Logger
+	lastModTime   time.Time
+	ticker        *time.Ticker
+	watcher       *fsWatcher // Set when watch mode is enabled
+	minSpacing    time.Duration
+	lastRun       time.Time   // Start time of the most recent backup attempt
+	spacingWait   *time.Timer // Pending watch-triggered backup delayed by minSpacing
+	triggerCh     chan struct{}
+	paused        bool // Scheduled backups are skipped while paused
+	interval      time.Duration
+	nextRun       time.Time   // Expected time of the next interval tick
+	retryTimer    *time.Timer // Pending retry of queued snapshots after a failed push
+	retryAttempts int         // Failed pushes since the queue was last emptied
+	mu            sync.RWMutex
 }
 
 // NewWorker creates a new worker for a repository
@@ -29,18 +43,55 @@ func NewWorker(repoPath string, logger *log.Logger) *Worker {
 		repoPath:  repoPath,
 		stopCh:    make(chan struct{}),
 		stoppedCh: make(chan struct{}),
+		triggerCh: make(chan struct{}, 1),
 		logger:    logger,
 	}
 }
 
+// Trigger requests an immediate backup, even while the worker is paused
+func (w *Worker) Trigger() {
+	select {
+	case w.triggerCh <- struct{}{}:
+	default:
+		// A backup is already pending
+	}
+}
+
+// Pause stops scheduled (interval and watch) backups until Resume is called
+func (w *Worker) Pause() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.paused = true
+}
+
+// Resume re-enables scheduled backups
+func (w *Worker) Resume() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.paused = false
+}
+
+// IsPaused returns whether scheduled backups are paused
+func (w *Worker) IsPaused() bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.paused
+}
+
 // Start begins the worker's backup loop
 func (w *Worker) Start() {
 	defer close(w.stoppedCh)
 
 	w.logger.Printf("[%s] Worker started\n", w.repoPath)
 
+	// Pick up snapshots queued before a restart; the initial backup pushes them too
+	w.restoreRetry()
+	defer w.stopRetry()
+
 	// Initial backup
-	w.performBackup()
+	if !w.IsPaused() {
+		w.performBackup(git.TriggerStartup)
+	}
 
 	// Start ticker with the initial config
 	cfg, err := w.loadConfig()
@@ -50,24 +101,147 @@ func (w *Worker) Start() {
 	}
 
 	w.updateTicker(time.Duration(cfg.Interval) * time.Minute)
+	w.configureWatcher(cfg)
+	defer w.closeWatcher()
 
 	for {
 		// Get ticker channel under mutex protection.
 		// Note: Even if the ticker is replaced after we read the channel,
 		// the cached channel remains valid (it just stops receiving ticks).
 		// The new ticker's channel will be picked up on the next iteration.
+		// Watch channels are nil (never ready) while watch mode is disabled.
 		w.mu.RLock()
 		tickerCh := w.ticker.C
+		var watchCh <-chan struct{}
+		if w.watcher != nil {
+			watchCh = w.watcher.Triggers()
+		}
+		var spacingCh <-chan time.Time
+		if w.spacingWait != nil {
+			spacingCh = w.spacingWait.C
+		}
+		var retryCh <-chan time.Time
+		if w.retryTimer != nil {
+			retryCh = w.retryTimer.C
+		}
 		w.mu.RUnlock()
 
 		select {
 		case <-w.stopCh:
 			w.logger.Printf("[%s] Worker stopped\n", w.repoPath)
 			return
+		case <-w.triggerCh:
+			w.logger.Printf("[%s] Backup triggered manually\n", w.repoPath)
+			w.performBackup(git.TriggerManual)
+			w.checkConfigReload()
 		case <-tickerCh:
-			w.performBackup()
+			if !w.IsPaused() {
+				w.performBackup(git.TriggerInterval)
+			}
+			w.checkConfigReload()
+		case <-watchCh:
+			if !w.IsPaused() {
+				w.handleWatchTrigger()
+			}
+		case <-spacingCh:
+			w.mu.Lock()
+			w.spacingWait = nil
+			w.mu.Unlock()
+			if !w.IsPaused() {
+				w.performBackup(git.TriggerWatch)
+			}
 			w.checkConfigReload()
+		case <-retryCh:
+			w.mu.Lock()
+			w.retryTimer = nil
+			w.mu.Unlock()
+			// While paused, queued snapshots go out with the next backup after resuming
+			if !w.IsPaused() {
+				w.retryQueued()
+			}
+		}
+	}
+}
+
+// handleWatchTrigger runs a backup for settled filesystem changes, delaying it
+// when the previous backup happened less than minSpacing ago
+func (w *Worker) handleWatchTrigger() {
+	w.mu.Lock()
+	wait := w.minSpacing - time.Since(w.lastRun)
+	if wait > 0 {
+		if w.spacingWait == nil {
+			w.spacingWait = time.NewTimer(wait)
 		}
+		w.mu.Unlock()
+		return
+	}
+	w.mu.Unlock()
+
+	w.performBackup(git.TriggerWatch)
+	w.checkConfigReload()
+}
+
+// configureWatcher starts, restarts or stops the filesystem watcher to match the config
+func (w *Worker) configureWatcher(cfg *config.LocalConfig) {
+	debounce := time.Duration(cfg.WatchDebounce) * time.Second
+	if debounce <= 0 {
+		debounce = config.DefaultWatchDebounce * time.Second
+	}
+
+	w.mu.Lock()
+	w.minSpacing = time.Duration(cfg.WatchMinSpacing) * time.Second
+	current := w.watcher
+	w.mu.Unlock()
+
+	if current != nil && cfg.Watch && current.debounce == debounce {
+		return
+	}
+
+	w.closeWatcher()
+	if !cfg.Watch {
+		if current != nil {
+			w.logger.Printf("[%s] Watch mode disabled\n", w.repoPath)
+		}
+		return
+	}
+
+	watcher, err := newFSWatcher(w.repoPath, debounce, w.logger)
+	if err != nil {
+		w.logger.Printf("[%s] Failed to start watcher, using interval only: %v\n", w.repoPath, err)
+		return
+	}
+
+	w.mu.Lock()
+	w.watcher = watcher
+	w.mu.Unlock()
+
+	w.logger.Printf("[%s] Watching for changes (debounce=%s, min_spacing=%s)\n",
+		w.repoPath, debounce, time.Duration(cfg.WatchMinSpacing)*time.Second)
+}
+
+// closeWatcher stops the filesystem watcher and any pending watch-triggered backup
+func (w *Worker) closeWatcher() {
+	w.mu.Lock()
+	watcher := w.watcher
+	w.watcher = nil
+	if w.spacingWait != nil {
+		w.spacingWait.Stop()
+		w.spacingWait = nil
+	}
+	w.mu.Unlock()
+
+	if watcher != nil {
+		watcher.Close()
+	}
+}
+
+// stopRetry stops the retry timer; queued snapshots stay in their pending refs
+func (w *Worker) stopRetry() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.retryTimer != nil {
+		w.retryTimer.Stop()
+		w.retryTimer = nil
 	}
 }
 
@@ -100,12 +274,35 @@ func (w *Worker) Stop() {
 // updateTicker updates the ticker with a new interval
 func (w *Worker) updateTicker(interval time.Duration) {
 	w.mu.Lock()
-	defer w.mu.Unlock()
-
 	if w.ticker != nil {
 		w.ticker.Stop()
 	}
 	w.ticker = time.NewTicker(interval)
+	w.interval = interval
+	w.nextRun = time.Now().Add(interval)
+	nextRun := w.nextRun
+	w.mu.Unlock()
+
+	if err := state.Update(w.repoPath, func(s *state.RepoState) {
+		s.NextRun = nextRun
+	}); err != nil {
+		w.logger.Printf("[%s] Failed to save backup state: %v\n", w.repoPath, err)
+	}
+}
+
+// nextTick returns the expected time of the next interval backup
+// The ticker fires every interval from when it was created, so a past value is advanced
+func (w *Worker) nextTick() time.Time {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.interval <= 0 {
+		return time.Time{}
+	}
+	for now := time.Now(); !w.nextRun.After(now); {
+		w.nextRun = w.nextRun.Add(w.interval)
+	}
+	return w.nextRun
 }
 
 // checkConfigReload checks if the config file has been modified and reloads if necessary
@@ -132,9 +329,10 @@ func (w *Worker) checkConfigReload() {
 			return
 		}
 
-		w.logger.Printf("[%s] Config reloaded: interval=%dm, scan_secrets=%v, only_staged=%v\n",
-			w.repoPath, cfg.Interval, cfg.ScanSecrets, cfg.OnlyStaged)
+		w.logger.Printf("[%s] Config reloaded: interval=%dm, scan_secrets=%v, on_secret=%s, only_staged=%v, include_untracked=%v, watch=%v\n",
+			w.repoPath, cfg.Interval, cfg.ScanSecrets, cfg.OnSecret, cfg.OnlyStaged, cfg.IncludeUntracked, cfg.Watch)
 		w.updateTicker(time.Duration(cfg.Interval) * time.Minute)
+		w.configureWatcher(cfg)
 	}
 }
 
@@ -156,15 +354,54 @@ func (w *Worker) loadConfig() (*config.LocalConfig, error) {
 	return cfg, nil
 }
 
-// performBackup executes the backup logic for this repository
-func (w *Worker) performBackup() {
+// backupResult describes the outcome of a successful backup run
+type backupResult struct {
+	Hash      string // Pushed snapshot hash
+	Ref       string // Backup ref the snapshot was pushed to
+	NoChanges bool   // Nothing to back up
+}
+
+// performBackup executes the backup logic for this repository and records the outcome
+// trigger is the git.Trigger* value recorded in the snapshot metadata
+func (w *Worker) performBackup(trigger string) {
+	startedAt := time.Now()
+	w.mu.Lock()
+	w.lastRun = startedAt
+	w.mu.Unlock()
+
 	w.logger.Printf("[%s] Starting backup...\n", w.repoPath)
 
+	result, err := w.backup(trigger)
+	w.recordResult(startedAt, result, err)
+}
+
+// recordResult saves the outcome of a backup run to the persisted state and logs failures
+func (w *Worker) recordResult(startedAt time.Time, result *backupResult, err error) {
+	if stateErr := state.Update(w.repoPath, func(s *state.RepoState) {
+		switch {
+		case err != nil:
+			s.RecordFailure(startedAt, err)
+		case result.NoChanges:
+			s.RecordNoChanges(startedAt)
+		default:
+			s.RecordSuccess(startedAt, result.Hash, result.Ref)
+		}
+		s.NextRun = w.nextTick()
+	}); stateErr != nil {
+		w.logger.Printf("[%s] Failed to save backup state: %v\n", w.repoPath, stateErr)
+	}
+
+	if err != nil {
+		w.logger.Printf("[%s] Backup failed: %v\n", w.repoPath, err)
+	}
+}
+
+// backup creates a snapshot and pushes it to the backup ref
+func (w *Worker) backup(trigger string) (*backupResult, error) {
 	// Load config
 	cfg, err := w.loadConfig()
 	if err != nil {
-		w.logger.Printf("[%s] Failed to load config: %v\n", w.repoPath, err)
-		return
+		return nil, fmt.Errorf("failed to load config: %w", err)
 	}
 
 	// Create git repo instance
@@ -173,58 +410,149 @@ func (w *Worker) performBackup() {
 	// Verify it's a git repo
 	isGitRepo, err := repo.IsGitRepo()
 	if err != nil {
-		w.logger.Printf("[%s] Git validation error: %v\n", w.repoPath, err)
-		return
+		return nil, fmt.Errorf("git validation error: %w", err)
 	}
 	if !isGitRepo {
-		w.logger.Printf("[%s] Not a valid git repository\n", w.repoPath)
-		return
+		return nil, fmt.Errorf("not a valid git repository")
 	}
 
 	// Check if there are changes
 	hasChanges, err := repo.HasChanges()
 	if err != nil {
-		w.logger.Printf("[%s] Failed to check for changes: %v\n", w.repoPath, err)
-		return
+		return nil, fmt.Errorf("failed to check for changes: %w", err)
 	}
 
 	if !hasChanges {
 		w.logger.Printf("[%s] No changes to backup\n", w.repoPath)
-		return
+		// Snapshots queued by earlier failed pushes still need to go out
+		return w.flushQueue(repo)
 	}
 
-	// Create stash
-	hash, err := repo.CreateStash(cfg.OnlyStaged)
+	// Load global config to get git_user and exclude globs if configured
+	globalConfig, err := config.LoadGlobalConfig()
 	if err != nil {
-		w.logger.Printf("[%s] Failed to create stash: %v\n", w.repoPath, err)
-		return
+		return nil, fmt.Errorf("failed to load global config: %w", err)
+	}
+	filter, err := security.LoadPathFilter(w.repoPath, globalConfig, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create stash, including untracked files when configured (only_staged takes precedence)
+	hash, err := repo.CreateSnapshot(cfg.OnlyStaged, cfg.IncludeUntracked, filter.Keeps)
+	if errors.Is(err, git.ErrNoChangesLeft) {
+		w.logger.Printf("[%s] Only excluded paths changed, nothing to back up\n", w.repoPath)
+		return w.flushQueue(repo)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stash: %w", err)
 	}
 
 	w.logger.Printf("[%s] Created stash: %s\n", w.repoPath, hash)
 
+	// Sensitive and out of scope paths never leave the machine, whatever the scanners say
+	hash, excluded, err := security.ExcludePaths(repo, hash, filter)
+	if errors.Is(err, git.ErrNoChangesLeft) {
+		w.logger.Printf("[%s] Only excluded paths changed, nothing to back up\n", w.repoPath)
+		return w.flushQueue(repo)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(excluded) > 0 {
+		outside := 0
+		for _, f := range excluded {
+			if f.Rules[0] == security.OutsideInclude {
+				outside++
+				continue
+			}
+			w.logger.Printf("[%s] Excluded %s (%s)\n", w.repoPath, f.Path, f.Rules[0])
+		}
+		if outside > 0 {
+			w.logger.Printf("[%s] Left out %d files outside the include patterns\n", w.repoPath, outside)
+		}
+		w.logger.Printf("[%s] Created filtered stash: %s\n", w.repoPath, hash)
+	}
+
+	// Keep datasets and build artifacts dropped in the tree from blowing up the push
+	sizeCheck, err := security.CheckSnapshotSize(repo, hash, security.LoadSizeLimits(cfg))
+	if sizeCheck != nil && sizeCheck.Exceeded {
+		w.logger.Printf("[%s] Snapshot is %s, over the size limits; largest files:\n", w.repoPath, security.FormatSize(sizeCheck.Size))
+		for _, f := range sizeCheck.Largest {
+			w.logger.Printf("[%s]   %s %s\n", w.repoPath, security.FormatSize(f.Size), f.Path)
+		}
+	}
+	if errors.Is(err, git.ErrNoChangesLeft) {
+		w.logger.Printf("[%s] Only oversized files changed, nothing to back up\n", w.repoPath)
+		return w.flushQueue(repo)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(sizeCheck.Excluded) > 0 {
+		for _, f := range sizeCheck.Excluded {
+			w.logger.Printf("[%s] Excluded %s (%s)\n", w.repoPath, f.Path, f.Rules[0])
+		}
+		hash = sizeCheck.Hash
+		w.logger.Printf("[%s] Created filtered stash: %s (%s)\n", w.repoPath, hash, security.FormatSize(sizeCheck.Pushed))
+	}
+
 	// If secret scanning is enabled, scan the diff
 	if cfg.ScanSecrets {
-		if !security.IsGitleaksAvailable() {
-			w.logger.Printf("[%s] WARNING: gitleaks not available, skipping secret scan\n", w.repoPath)
-		} else {
-			diff, err := repo.GetDiff(hash)
-			if err != nil {
-				w.logger.Printf("[%s] Failed to get diff: %v\n", w.repoPath, err)
-				return
+		opts, err := security.LoadScanOptions(w.repoPath, cfg)
+		if err != nil {
+			return nil, err
+		}
+		// Only scan what changed since the last snapshot that passed, as long as HEAD didn't move
+		if repoState, err := state.Load(w.repoPath); err == nil && repoState.ScannedSnapshot != "" {
+			opts.Base = &security.ScanBase{Snapshot: repoState.ScannedSnapshot, Options: repoState.ScannedOptions}
+		}
+
+		scan, err := security.ScanSnapshot(repo, hash, opts, cfg.OnSecret == config.OnSecretExclude)
+		if err != nil {
+			return nil, err
+		}
+		if scan.Incremental {
+			w.logger.Printf("[%s] Scanned only the changes since clean snapshot %s\n", w.repoPath, opts.Base.Snapshot)
+		} else if scan.FullScanReason != "" {
+			w.logger.Printf("[%s] Scanned the full snapshot: %s\n", w.repoPath, scan.FullScanReason)
+		}
+		if scan.Passed() {
+			if err := state.Update(w.repoPath, func(s *state.RepoState) {
+				s.ScannedSnapshot, s.ScannedOptions = scan.Hash, opts.Fingerprint()
+			}); err != nil {
+				w.logger.Printf("[%s] Failed to save scan state: %v\n", w.repoPath, err)
 			}
+		}
+		result := scan.Result
+		if result.Fallback {
+			w.logger.Printf("[%s] gitleaks not available, using built-in secret scanner\n", w.repoPath)
+		}
+		if notice := security.LargeDiffNotice(result, opts); notice != "" {
+			w.logger.Printf("[%s] %s\n", w.repoPath, notice)
+		}
+		if len(result.Allowed) > 0 {
+			w.logger.Printf("[%s] Ignoring %d findings accepted by %s\n", w.repoPath, len(result.Allowed), security.AllowlistFileName)
+		}
 
-			result, err := security.ScanDiff(diff)
-			if err != nil {
-				w.logger.Printf("[%s] Secret scan failed: %v\n", w.repoPath, err)
-				return
+		if len(scan.Excluded) > 0 {
+			w.logger.Printf("[%s] Secrets detected, excluding %d files from the snapshot (on_secret=exclude):\n", w.repoPath, len(scan.Excluded))
+			for _, f := range scan.Excluded {
+				w.logger.Printf("[%s]   %s (%s)\n", w.repoPath, f.Path, strings.Join(f.Rules, ", "))
 			}
+			w.logger.Printf("[%s] Created filtered stash: %s\n", w.repoPath, scan.Hash)
+			hash = scan.Hash
+		}
 
-			if result.HasSecrets {
+		if result.HasSecrets {
+			if cfg.OnSecret != config.OnSecretWarn {
 				w.logger.Printf("[%s] SECRETS DETECTED! Aborting backup.\n", w.repoPath)
-				w.logger.Printf("[%s] Gitleaks output:\n%s\n", w.repoPath, result.Output)
-				return
+				w.logger.Printf("[%s] %s findings:\n%s\n", w.repoPath, result.Scanner, result.Output)
+				return nil, fmt.Errorf("secrets detected in snapshot %s", hash)
 			}
-
+			w.logger.Printf("[%s] WARNING: Secrets detected, pushing anyway (on_secret=warn)\n", w.repoPath)
+			w.logger.Printf("[%s] %s findings:\n%s\n", w.repoPath, result.Scanner, result.Output)
+		} else if result.LargeDiff != config.OnLargeDiffSkip {
 			w.logger.Printf("[%s] Secret scan passed\n", w.repoPath)
 		}
 	}
@@ -232,51 +560,70 @@ func (w *Worker) performBackup() {
 	// Get user email and branch
 	userEmail, err := repo.GetUserEmail()
 	if err != nil {
-		w.logger.Printf("[%s] Failed to get user email: %v\n", w.repoPath, err)
-		return
+		return nil, fmt.Errorf("failed to get user email: %w", err)
 	}
 
 	// Get user name for identifier generation
 	userName, _ := repo.GetUserName()
 
-	// Load global config to get git_user if configured
-	globalConfig, err := config.LoadGlobalConfig()
-	if err != nil {
-		w.logger.Printf("[%s] Warning: Failed to load global config: %v\n", w.repoPath, err)
-		globalConfig = &config.GlobalConfig{} // Use empty config
-	}
-
 	// Generate user identifier
 	userIdentifier := git.GenerateUserIdentifier(globalConfig.GitUser, userName, userEmail)
 
 	branch, err := repo.GetCurrentBranch()
 	if err != nil {
-		w.logger.Printf("[%s] Failed to get current branch: %v\n", w.repoPath, err)
-		return
+		return nil, fmt.Errorf("failed to get current branch: %w", err)
 	}
 
-	// Get remote
-	remote, err := repo.GetRemote()
+	// Empty unless backup refs are namespaced per machine
+	machine, err := globalConfig.Machine()
 	if err != nil {
-		w.logger.Printf("[%s] Failed to get remote: %v\n", w.repoPath, err)
-		return
+		return nil, err
 	}
 
-	// Push to back up ref
-	err = repo.PushToBackupRef(hash, userIdentifier, branch, remote)
+	// Get the remotes to push to, primary first
+	remotes, err := repo.ResolveBackupRemotes(config.BackupRemotes(globalConfig, cfg))
 	if err != nil {
-		w.logger.Printf("[%s] Failed to push backup: %v\n", w.repoPath, err)
-		return
+		return nil, err
+	}
+
+	// Record where and why the snapshot was taken, for 'list' and 'inspect'
+	metadata, err := repo.CollectSnapshotMetadata(hash, trigger)
+	if err != nil {
+		return nil, err
+	}
+	hash, err = repo.AddSnapshotMetadata(hash, metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	// Queue the snapshot locally first so a failed push or a restart doesn't lose it
+	queued, err := repo.QueuePendingBackup(hash, userIdentifier, machine, branch, remotes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to queue backup: %w", err)
+	}
+
+	// Push it to the backup ref on every remote, together with snapshots queued by earlier failed pushes
+	// The backup succeeds once any remote has it; the others keep it queued and are retried
+	pushes, err := w.pushQueued(repo, remotes)
+	pushedTo := git.PushedTo(pushes, hash)
+	if len(pushedTo) == 0 {
+		return nil, fmt.Errorf("failed to push backup, snapshot kept in %s: %w", queued[0].Ref, err)
 	}
+	if len(pushedTo) < len(remotes) {
+		w.logger.Printf("[%s] Backup pushed to %d of %d remotes, retrying the others\n", w.repoPath, len(pushedTo), len(remotes))
+	}
+
+	refName := queued[0].BackupRef()
+	w.logger.Printf("[%s] Backup completed successfully: %s\n", w.repoPath, refName)
 
-	w.logger.Printf("[%s] Backup completed successfully: refs/backups/%s/%s\n",
-		w.repoPath, userIdentifier, branch)
+	return &backupResult{Hash: hash, Ref: refName}, nil
 }
 
 // Manager manages multiple workers
 type Manager struct {
 	workers map[string]*Worker
 	logger  *log.Logger
+	paused  bool // Whole daemon paused; applies to workers started later too
 	mu      sync.Mutex
 }
 
@@ -308,6 +655,9 @@ func (m *Manager) StartWorkers(registry *config.Registry) error {
 		}
 
 		worker := NewWorker(repoPath, m.logger)
+		if m.paused {
+			worker.Pause()
+		}
 		m.workers[repoPath] = worker
 		go worker.Start()
 	}
@@ -330,12 +680,109 @@ func (m *Manager) StopWorkers() {
 	m.workers = make(map[string]*Worker)
 }
 
-// ReloadWorkers stops current workers and starts new ones based on the registry
+// ReloadWorkers brings the running workers in line with the registry:
+// workers for removed repositories are stopped and new repositories get a worker.
+// Workers for repositories that are still registered keep running.
 func (m *Manager) ReloadWorkers(registry *config.Registry) error {
-	m.StopWorkers()
+	registered := make(map[string]struct{})
+	for _, repoPath := range registry.GetRepositories() {
+		registered[repoPath] = struct{}{}
+	}
+
+	m.mu.Lock()
+	for repoPath, worker := range m.workers {
+		if _, ok := registered[repoPath]; !ok {
+			m.logger.Printf("Stopping worker for %s\n", repoPath)
+			worker.Stop()
+			delete(m.workers, repoPath)
+		}
+	}
+	m.mu.Unlock()
+
 	return m.StartWorkers(registry)
 }
 
+// getWorker returns the worker for a repository
+func (m *Manager) getWorker(repoPath string) (*Worker, error) {
+	worker, ok := m.workers[repoPath]
+	if !ok {
+		return nil, fmt.Errorf("repository is not monitored: %s", repoPath)
+	}
+	return worker, nil
+}
+
+// TriggerBackup requests an immediate backup for a repository
+func (m *Manager) TriggerBackup(repoPath string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	worker, err := m.getWorker(repoPath)
+	if err != nil {
+		return err
+	}
+	worker.Trigger()
+	return nil
+}
+
+// PauseWorker pauses scheduled backups for a repository
+func (m *Manager) PauseWorker(repoPath string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	worker, err := m.getWorker(repoPath)
+	if err != nil {
+		return err
+	}
+	worker.Pause()
+	m.logger.Printf("[%s] Worker paused\n", repoPath)
+	return nil
+}
+
+// ResumeWorker resumes scheduled backups for a repository
+func (m *Manager) ResumeWorker(repoPath string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	worker, err := m.getWorker(repoPath)
+	if err != nil {
+		return err
+	}
+	worker.Resume()
+	m.logger.Printf("[%s] Worker resumed\n", repoPath)
+	return nil
+}
+
+// PauseAll pauses scheduled backups for every repository, including ones added later
+func (m *Manager) PauseAll() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.paused = true
+	for _, worker := range m.workers {
+		worker.Pause()
+	}
+	m.logger.Printf("All workers paused\n")
+}
+
+// ResumeAll resumes scheduled backups for every repository
+func (m *Manager) ResumeAll() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.paused = false
+	for _, worker := range m.workers {
+		worker.Resume()
+	}
+	m.logger.Printf("All workers resumed\n")
+}
+
+// IsPaused returns whether the whole daemon is paused
+func (m *Manager) IsPaused() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.paused
+}
+
 // GetWorkerCount returns the number of active workers
 func (m *Manager) GetWorkerCount() int {
 	m.mu.Lock()
@@ -349,12 +796,16 @@ func (m *Manager) GetWorkerStatus() []WorkerStatus {
 	defer m.mu.Unlock()
 
 	var statuses []WorkerStatus
-	for repoPath := range m.workers {
+	for repoPath, worker := range m.workers {
 		statuses = append(statuses, WorkerStatus{
 			RepoPath: repoPath,
 			Running:  true,
+			Paused:   worker.IsPaused(),
 		})
 	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].RepoPath < statuses[j].RepoPath
+	})
 	return statuses
 }
 
@@ -362,14 +813,17 @@ func (m *Manager) GetWorkerStatus() []WorkerStatus {
 //
 //goland:noinspection GoNameStartsWithPackageName
 type WorkerStatus struct {
-	RepoPath string
-	Running  bool
+	RepoPath string `json:"repo_path"`
+	Running  bool   `json:"running"`
+	Paused   bool   `json:"paused"`
 }
 
 // String returns a string representation of worker status
 func (ws WorkerStatus) String() string {
 	status := "stopped"
-	if ws.Running {
+	if ws.Running && ws.Paused {
+		status = "paused"
+	} else if ws.Running {
 		status = "running"
 	}
 	return fmt.Sprintf("%s: %s", ws.RepoPath, status)
@@ -1,15 +1,42 @@
 package worker
 
 import (
+	"errors"
+	"io"
 	"log"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/FmTod/ghost-backup/internal/config"
+	"github.com/FmTod/ghost-backup/internal/git"
+	"github.com/FmTod/ghost-backup/internal/state"
 )
 
+// TestMain keeps backup state written by workers out of the real state directory, and the
+// developer's global config (backup remotes, machine_id, excludes) out of the workers
+func TestMain(m *testing.M) {
+	stateDir, err := os.MkdirTemp("", "ghost-backup-state-")
+	if err != nil {
+		panic(err)
+	}
+	os.Setenv("STATE_DIRECTORY", stateDir)
+	homeDir, err := os.MkdirTemp("", "ghost-backup-home-")
+	if err != nil {
+		panic(err)
+	}
+	os.Setenv("HOME", homeDir)
+
+	code := m.Run()
+	os.RemoveAll(stateDir)
+	os.RemoveAll(homeDir)
+	os.Exit(code)
+}
+
 func TestNewWorker(t *testing.T) {
 	logger := log.New(os.Stdout, "", log.LstdFlags)
 	repoPath := "/test/repo"
@@ -169,6 +196,11 @@ func TestWorkerStatus_String(t *testing.T) {
 			status:   WorkerStatus{RepoPath: "/test/repo", Running: false},
 			contains: "stopped",
 		},
+		{
+			name:     "paused worker",
+			status:   WorkerStatus{RepoPath: "/test/repo", Running: true, Paused: true},
+			contains: "paused",
+		},
 	}
 
 	for _, tt := range tests {
@@ -395,3 +427,569 @@ func TestManager_ConcurrentAccess(t *testing.T) {
 		<-done
 	}
 }
+
+// setupWatchRepo creates a git repository with an ignored directory for watcher tests
+func setupWatchRepo(t *testing.T) string {
+	tmpDir := t.TempDir()
+
+	cmd := exec.Command("git", "init")
+	cmd.Dir = tmpDir
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("Failed to initialize test repo: %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(tmpDir, ".gitignore"), []byte("build/\n*.log\n"), 0644); err != nil {
+		t.Fatalf("Failed to write .gitignore: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(tmpDir, "build"), 0755); err != nil {
+		t.Fatalf("Failed to create build dir: %v", err)
+	}
+
+	return tmpDir
+}
+
+// waitForTrigger reports whether the watcher fired within the timeout
+func waitForTrigger(fw *fsWatcher, timeout time.Duration) bool {
+	select {
+	case <-fw.Triggers():
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func TestFSWatcher_TriggersAfterQuietPeriod(t *testing.T) {
+	tmpDir := setupWatchRepo(t)
+	logger := log.New(os.Stdout, "", log.LstdFlags)
+
+	fw, err := newFSWatcher(tmpDir, 50*time.Millisecond, logger)
+	if err != nil {
+		t.Fatalf("newFSWatcher() error = %v", err)
+	}
+	defer fw.Close()
+
+	if err := os.WriteFile(filepath.Join(tmpDir, "main.go"), []byte("package main"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	if !waitForTrigger(fw, 2*time.Second) {
+		t.Fatal("watcher did not trigger after a write")
+	}
+
+	// Files created in new directories are picked up too
+	subDir := filepath.Join(tmpDir, "pkg")
+	if err := os.MkdirAll(subDir, 0755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+	if !waitForTrigger(fw, 2*time.Second) {
+		t.Fatal("watcher did not trigger after creating a directory")
+	}
+	if err := os.WriteFile(filepath.Join(subDir, "pkg.go"), []byte("package pkg"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if !waitForTrigger(fw, 2*time.Second) {
+		t.Fatal("watcher did not trigger after a write in a new directory")
+	}
+}
+
+func TestFSWatcher_IgnoresGitAndIgnoredPaths(t *testing.T) {
+	tmpDir := setupWatchRepo(t)
+	logger := log.New(os.Stdout, "", log.LstdFlags)
+
+	fw, err := newFSWatcher(tmpDir, 50*time.Millisecond, logger)
+	if err != nil {
+		t.Fatalf("newFSWatcher() error = %v", err)
+	}
+	defer fw.Close()
+
+	writes := []string{
+		filepath.Join(tmpDir, ".git", "scratch"),
+		filepath.Join(tmpDir, "build", "output.bin"),
+		filepath.Join(tmpDir, "debug.log"),
+	}
+	for _, path := range writes {
+		if err := os.WriteFile(path, []byte("ignored"), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", path, err)
+		}
+	}
+
+	if waitForTrigger(fw, 300*time.Millisecond) {
+		t.Error("watcher triggered for .git or ignored paths")
+	}
+}
+
+func TestFSWatcher_SkipsIgnoredDirsInNewTrees(t *testing.T) {
+	tmpDir := setupWatchRepo(t)
+	logger := log.New(os.Stdout, "", log.LstdFlags)
+
+	fw, err := newFSWatcher(tmpDir, 50*time.Millisecond, logger)
+	if err != nil {
+		t.Fatalf("newFSWatcher() error = %v", err)
+	}
+	defer fw.Close()
+
+	// A tree with an ignored child is moved into the working tree at once
+	src := filepath.Join(t.TempDir(), "pkg")
+	for _, dir := range []string{"src", "build/deep"} {
+		if err := os.MkdirAll(filepath.Join(src, dir), 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+	}
+	pkg := filepath.Join(tmpDir, "pkg")
+	if err := os.Rename(src, pkg); err != nil {
+		t.Fatalf("Failed to move tree: %v", err)
+	}
+
+	watched := func() map[string]bool {
+		paths := make(map[string]bool)
+		for _, path := range fw.fsw.WatchList() {
+			paths[path] = true
+		}
+		return paths
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for !watched()[filepath.Join(pkg, "src")] && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	paths := watched()
+	if !paths[pkg] || !paths[filepath.Join(pkg, "src")] {
+		t.Errorf("WatchList() = %v, want %s and its src directory", fw.fsw.WatchList(), pkg)
+	}
+	for _, dir := range []string{"build", "build/deep"} {
+		if paths[filepath.Join(pkg, dir)] {
+			t.Errorf("Ignored directory pkg/%s is watched", dir)
+		}
+	}
+}
+
+func TestWorker_HandleWatchTrigger_RespectsMinSpacing(t *testing.T) {
+	logger := log.New(os.Stdout, "", log.LstdFlags)
+	worker := NewWorker(t.TempDir(), logger)
+
+	worker.minSpacing = time.Hour
+	worker.lastRun = time.Now()
+	lastRun := worker.lastRun
+
+	worker.handleWatchTrigger()
+
+	if worker.spacingWait == nil {
+		t.Fatal("handleWatchTrigger() should delay the backup when within min spacing")
+	}
+	if !worker.lastRun.Equal(lastRun) {
+		t.Error("handleWatchTrigger() should not run a backup within min spacing")
+	}
+
+	worker.closeWatcher()
+	if worker.spacingWait != nil {
+		t.Error("closeWatcher() should clear the pending backup")
+	}
+}
+
+func TestWorker_PauseResume(t *testing.T) {
+	logger := log.New(os.Stdout, "", log.LstdFlags)
+	worker := NewWorker("/test/repo", logger)
+
+	if worker.IsPaused() {
+		t.Error("new worker should not be paused")
+	}
+
+	worker.Pause()
+	if !worker.IsPaused() {
+		t.Error("IsPaused() = false after Pause()")
+	}
+
+	worker.Resume()
+	if worker.IsPaused() {
+		t.Error("IsPaused() = true after Resume()")
+	}
+}
+
+func TestWorker_Trigger_DoesNotBlock(t *testing.T) {
+	logger := log.New(os.Stdout, "", log.LstdFlags)
+	worker := NewWorker("/test/repo", logger)
+
+	// Repeated triggers on an idle worker coalesce instead of blocking
+	worker.Trigger()
+	worker.Trigger()
+
+	if len(worker.triggerCh) != 1 {
+		t.Errorf("pending triggers = %d, want 1", len(worker.triggerCh))
+	}
+}
+
+func TestManager_PauseAll_AppliesToNewWorkers(t *testing.T) {
+	logger := log.New(os.Stdout, "", log.LstdFlags)
+	manager := NewManager(logger)
+	manager.workers["/test/repo1"] = NewWorker("/test/repo1", logger)
+
+	manager.PauseAll()
+
+	repoDir := t.TempDir()
+	if err := manager.StartWorkers(&config.Registry{Repositories: []string{repoDir}}); err != nil {
+		t.Fatalf("StartWorkers() error = %v", err)
+	}
+	defer manager.StopWorkers()
+
+	for _, status := range manager.GetWorkerStatus() {
+		if !status.Paused {
+			t.Errorf("worker %s should be paused", status.RepoPath)
+		}
+	}
+
+	if err := manager.PauseWorker("/not/monitored"); err == nil {
+		t.Error("PauseWorker() should fail for unknown repository")
+	}
+	if err := manager.TriggerBackup("/not/monitored"); err == nil {
+		t.Error("TriggerBackup() should fail for unknown repository")
+	}
+}
+
+func TestManager_ReloadWorkers_KeepsExisting(t *testing.T) {
+	logger := log.New(os.Stdout, "", log.LstdFlags)
+	manager := NewManager(logger)
+
+	kept := NewWorker("/test/kept", logger)
+	manager.workers["/test/kept"] = kept
+	manager.workers["/test/removed"] = NewWorker("/test/removed", logger)
+
+	// Nonexistent paths are not started, so only the removal and retention are observable
+	if err := manager.ReloadWorkers(&config.Registry{Repositories: []string{"/test/kept"}}); err != nil {
+		t.Fatalf("ReloadWorkers() error = %v", err)
+	}
+
+	if manager.workers["/test/kept"] != kept {
+		t.Error("ReloadWorkers() should keep workers for registered repositories")
+	}
+	if _, ok := manager.workers["/test/removed"]; ok {
+		t.Error("ReloadWorkers() should stop workers for removed repositories")
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempt int
+		max     time.Duration
+	}{
+		{0, retryBaseDelay},
+		{1, retryBaseDelay},
+		{2, 2 * retryBaseDelay},
+		{3, 4 * retryBaseDelay},
+		{20, retryMaxDelay},
+		{1000, retryMaxDelay},
+	}
+
+	for _, tt := range tests {
+		for i := 0; i < 20; i++ {
+			d := retryDelay(tt.attempt)
+			if d < tt.max/2 || d > tt.max {
+				t.Errorf("retryDelay(%d) = %s, want between %s and %s", tt.attempt, d, tt.max/2, tt.max)
+			}
+		}
+	}
+}
+
+// runGit runs a git command in dir and fails the test on error
+func runGit(t *testing.T, dir string, args ...string) {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git %v failed: %v\n%s", args, err, output)
+	}
+}
+
+func TestWorker_PerformBackup_QueuesAndRetries(t *testing.T) {
+	tmpDir := t.TempDir()
+	runGit(t, tmpDir, "init")
+	runGit(t, tmpDir, "config", "user.email", "test@example.com")
+	runGit(t, tmpDir, "config", "user.name", "Test User")
+	if err := os.WriteFile(filepath.Join(tmpDir, "test.txt"), []byte("initial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, tmpDir, "add", ".")
+	runGit(t, tmpDir, "commit", "-m", "Initial commit")
+
+	// Remote is unreachable at first
+	remoteDir := filepath.Join(t.TempDir(), "remote.git")
+	runGit(t, tmpDir, "remote", "add", "origin", remoteDir)
+
+	if err := os.WriteFile(filepath.Join(tmpDir, "test.txt"), []byte("offline change"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	logger := log.New(os.Stdout, "", log.LstdFlags)
+	worker := NewWorker(tmpDir, logger)
+	defer worker.stopRetry()
+
+	worker.performBackup(git.TriggerInterval)
+
+	repo := git.NewGitRepo(tmpDir)
+	pending, err := repo.ListPendingBackups()
+	if err != nil {
+		t.Fatalf("ListPendingBackups() error = %v", err)
+	}
+	if len(pending) != 1 {
+		t.Fatalf("performBackup() queued %d snapshots, want 1", len(pending))
+	}
+	if worker.retryTimer == nil {
+		t.Error("performBackup() should schedule a retry after a failed push")
+	}
+
+	s, err := state.Load(tmpDir)
+	if err != nil {
+		t.Fatalf("state.Load() error = %v", err)
+	}
+	if s.RetryAttempts != 1 || s.NextRetry.IsZero() || s.ConsecutiveFailures != 1 {
+		t.Errorf("state after failed push = %+v, want one retry scheduled and one failure", s)
+	}
+
+	// Remote comes back
+	runGit(t, tmpDir, "init", "--bare", remoteDir)
+	worker.retryQueued()
+
+	pending, err = repo.ListPendingBackups()
+	if err != nil {
+		t.Fatalf("ListPendingBackups() error = %v", err)
+	}
+	if len(pending) != 0 {
+		t.Errorf("retryQueued() left %d snapshots queued, want 0", len(pending))
+	}
+	if worker.retryTimer != nil {
+		t.Error("retryQueued() should clear the retry timer once the queue is empty")
+	}
+
+	s, err = state.Load(tmpDir)
+	if err != nil {
+		t.Fatalf("state.Load() error = %v", err)
+	}
+	if s.LastResult != state.ResultSuccess || s.RetryAttempts != 0 || !s.NextRetry.IsZero() {
+		t.Errorf("state after retry = %+v, want success with retry cleared", s)
+	}
+}
+
+func TestWorker_PerformBackup_BrokenGlobalConfig(t *testing.T) {
+	tmpDir := t.TempDir()
+	runGit(t, tmpDir, "init")
+	runGit(t, tmpDir, "config", "user.email", "test@example.com")
+	runGit(t, tmpDir, "config", "user.name", "Test User")
+	if err := os.WriteFile(filepath.Join(tmpDir, "test.txt"), []byte("initial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, tmpDir, "add", ".")
+	runGit(t, tmpDir, "commit", "-m", "Initial commit")
+	remoteDir := filepath.Join(t.TempDir(), "remote.git")
+	runGit(t, tmpDir, "init", "--bare", remoteDir)
+	runGit(t, tmpDir, "remote", "add", "origin", remoteDir)
+
+	// The global config, e.g. with backup_remote set to a private server, can't be read
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	configDir := filepath.Join(home, ".config", "ghost-backup")
+	if err := os.MkdirAll(configDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(configDir, "config.json"), []byte(`{"backup_remote": `), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "test.txt"), []byte("change"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	worker := NewWorker(tmpDir, log.New(io.Discard, "", 0))
+	defer worker.stopRetry()
+	worker.performBackup(git.TriggerInterval)
+
+	// Nothing falls back to origin
+	refs, err := git.NewGitRepo(remoteDir).ListAllBackupRefs(remoteDir)
+	if err != nil {
+		t.Fatalf("ListAllBackupRefs() error = %v", err)
+	}
+	if len(refs) != 0 {
+		t.Errorf("origin received %+v, want no backups without the global config", refs)
+	}
+
+	s, err := state.Load(tmpDir)
+	if err != nil {
+		t.Fatalf("state.Load() error = %v", err)
+	}
+	if s.LastResult != state.ResultFailed || !strings.Contains(s.LastError, "global config") {
+		t.Errorf("state = %+v, want a failure loading the global config", s)
+	}
+
+	// Queued snapshots aren't pushed to origin either
+	if _, err := worker.backupRemotes(git.NewGitRepo(tmpDir)); err == nil {
+		t.Error("backupRemotes() should fail without the global config")
+	}
+}
+
+func TestWorker_PerformBackup_IncrementalScan(t *testing.T) {
+	tmpDir := t.TempDir()
+	runGit(t, tmpDir, "init")
+	runGit(t, tmpDir, "config", "user.email", "test@example.com")
+	runGit(t, tmpDir, "config", "user.name", "Test User")
+	if err := os.WriteFile(filepath.Join(tmpDir, "test.txt"), []byte("initial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, tmpDir, "add", ".")
+	runGit(t, tmpDir, "commit", "-m", "Initial commit")
+	remoteDir := filepath.Join(t.TempDir(), "remote.git")
+	runGit(t, tmpDir, "init", "--bare", remoteDir)
+	runGit(t, tmpDir, "remote", "add", "origin", remoteDir)
+
+	var logs strings.Builder
+	worker := NewWorker(tmpDir, log.New(&logs, "", 0))
+	defer worker.stopRetry()
+
+	change := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(tmpDir, "test.txt"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	change("first change")
+	worker.performBackup(git.TriggerInterval)
+	s, err := state.Load(tmpDir)
+	if err != nil {
+		t.Fatalf("state.Load() error = %v", err)
+	}
+	if s.ScannedSnapshot == "" || s.ScannedOptions == "" {
+		t.Fatalf("state after clean scan = %+v, want the scanned snapshot recorded", s)
+	}
+
+	change("second change")
+	worker.performBackup(git.TriggerInterval)
+	if !strings.Contains(logs.String(), "Scanned only the changes since clean snapshot "+s.ScannedSnapshot) {
+		t.Errorf("second backup should scan incrementally, logs:\n%s", logs.String())
+	}
+
+	// Moving HEAD invalidates the base
+	runGit(t, tmpDir, "commit", "-am", "Second commit")
+	change("third change")
+	logs.Reset()
+	worker.performBackup(git.TriggerInterval)
+	if !strings.Contains(logs.String(), "Scanned the full snapshot: HEAD moved") {
+		t.Errorf("backup after a commit should scan the full snapshot, logs:\n%s", logs.String())
+	}
+}
+
+func TestWorker_PerformBackup_ExcludesOversizedFiles(t *testing.T) {
+	tmpDir := t.TempDir()
+	runGit(t, tmpDir, "init")
+	runGit(t, tmpDir, "config", "user.email", "test@example.com")
+	runGit(t, tmpDir, "config", "user.name", "Test User")
+	if err := os.WriteFile(filepath.Join(tmpDir, "test.txt"), []byte("initial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, tmpDir, "add", ".")
+	runGit(t, tmpDir, "commit", "-m", "Initial commit")
+	remoteDir := filepath.Join(t.TempDir(), "remote.git")
+	runGit(t, tmpDir, "init", "--bare", remoteDir)
+	runGit(t, tmpDir, "remote", "add", "origin", remoteDir)
+
+	cfg := `{"interval": 60, "scan_secrets": false, "max_file_size": 1}`
+	if err := os.WriteFile(config.GetLocalConfigPath(tmpDir), []byte(cfg), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "dump.bin"), make([]byte, 2*1024*1024), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "test.txt"), []byte("change"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, tmpDir, "add", "dump.bin")
+
+	var logs strings.Builder
+	worker := NewWorker(tmpDir, log.New(&logs, "", 0))
+	defer worker.stopRetry()
+	worker.performBackup(git.TriggerInterval)
+
+	for _, want := range []string{"Snapshot is 2.0 MB, over the size limits", "2.0 MB dump.bin", "Excluded dump.bin (max_file_size)"} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("logs should contain %q, got:\n%s", want, logs.String())
+		}
+	}
+
+	s, err := state.Load(tmpDir)
+	if err != nil {
+		t.Fatalf("state.Load() error = %v", err)
+	}
+	if s.LastResult != state.ResultSuccess {
+		t.Fatalf("state = %+v, want the rest of the snapshot pushed", s)
+	}
+	paths, err := git.NewGitRepo(tmpDir).SnapshotPaths(s.LastHash)
+	if err != nil {
+		t.Fatalf("SnapshotPaths() error = %v", err)
+	}
+	if !slices.Equal(paths, []string{"test.txt"}) {
+		t.Errorf("pushed snapshot changes %v, want [test.txt]", paths)
+	}
+}
+
+func TestWorker_PerformBackup_Destinations(t *testing.T) {
+	tmpDir := t.TempDir()
+	runGit(t, tmpDir, "init")
+	runGit(t, tmpDir, "config", "user.email", "test@example.com")
+	runGit(t, tmpDir, "config", "user.name", "Test User")
+	if err := os.WriteFile(filepath.Join(tmpDir, "test.txt"), []byte("initial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, tmpDir, "add", ".")
+	runGit(t, tmpDir, "commit", "-m", "Initial commit")
+
+	// No git remote at all: backups go to two in-memory destinations, the second one unreachable
+	primary := git.NewMemoryDestination(t.Name() + "/primary")
+	secondary := git.NewMemoryDestination(t.Name() + "/secondary")
+	secondary.SetError(errors.New("destination offline"))
+	git.SetDestinationFactory(git.MemoryDestinations(primary, secondary))
+	defer git.SetDestinationFactory(nil)
+	cfg := &config.LocalConfig{
+		Interval:      60,
+		BackupRemote:  primary.Remote(),
+		BackupRemotes: []string{secondary.Remote()},
+	}
+	if err := config.SaveLocalConfig(tmpDir, cfg); err != nil {
+		t.Fatalf("SaveLocalConfig() error = %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "test.txt"), []byte("change"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	worker := NewWorker(tmpDir, log.New(io.Discard, "", 0))
+	defer worker.stopRetry()
+	worker.performBackup(git.TriggerInterval)
+
+	branch, err := git.NewGitRepo(tmpDir).GetCurrentBranch()
+	if err != nil {
+		t.Fatalf("GetCurrentBranch() error = %v", err)
+	}
+	refName := git.BackupRefName("Test User", "", branch)
+	if _, ok := primary.Refs()[refName]; !ok {
+		t.Fatalf("primary destination refs = %v, want %s", primary.Refs(), refName)
+	}
+	if len(secondary.Refs()) != 0 {
+		t.Fatalf("unreachable destination refs = %v, want none", secondary.Refs())
+	}
+	if worker.retryTimer == nil {
+		t.Error("performBackup() should schedule a retry for the unreachable destination")
+	}
+
+	s, err := state.Load(tmpDir)
+	if err != nil {
+		t.Fatalf("state.Load() error = %v", err)
+	}
+	if s.LastResult != state.ResultSuccess {
+		t.Errorf("state.LastResult = %s, want success once any destination has the snapshot", s.LastResult)
+	}
+	if r := s.Remotes[secondary.Remote()]; r == nil || r.ConsecutiveFailures != 1 {
+		t.Errorf("state of the unreachable destination = %+v, want one failure", r)
+	}
+
+	// The destination comes back and receives the queued snapshot
+	secondary.SetError(nil)
+	worker.retryQueued()
+	if secondary.Refs()[refName] == "" {
+		t.Errorf("retryQueued() didn't push the queued snapshot, refs = %v", secondary.Refs())
+	}
+}
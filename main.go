@@ -1,9 +1,17 @@
 package main
 
 import (
+	"os"
+
 	"github.com/FmTod/ghost-backup/cmd"
+	"github.com/FmTod/ghost-backup/internal/git"
 )
 
 func main() {
+	// git runs this binary as its askpass helper to answer credential prompts
+	if git.IsAskpassInvocation() {
+		os.Exit(git.RunAskpass(os.Args[1:], os.Stdout))
+	}
+
 	cmd.Execute()
 }